@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/knowledge"
+	"github.com/itswryu/telegpt/pkg/lock"
 	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/mcp"
+	"github.com/itswryu/telegpt/pkg/memory"
+	"github.com/itswryu/telegpt/pkg/moderation"
 	"github.com/itswryu/telegpt/pkg/openai"
+	"github.com/itswryu/telegpt/pkg/recall"
+	"github.com/itswryu/telegpt/pkg/redact"
 	"github.com/itswryu/telegpt/pkg/telegram"
+	"github.com/itswryu/telegpt/pkg/tools"
+	"github.com/itswryu/telegpt/pkg/usage"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -25,16 +37,146 @@ func main() {
 	}
 	defer logger.Close()
 
+	// Maintenance commands run instead of the bot
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		code := reencrypt(cfg)
+		logger.Close()
+		os.Exit(code)
+	}
+
 	// Log startup
 	logger.Info("TeleGPT starting up...")
 	logger.Info("Configuration loaded successfully")
 
+	// Connect to Redis for state shared between replicas
+	redisClient := connectRedis(cfg)
+	locker := lock.Locker(lock.NewLocalLocker())
+	if redisClient != nil {
+		defer redisClient.Close()
+		locker = lock.NewRedisLocker(redisClient, cfg.Redis.KeyPrefix)
+	}
+
 	// Create OpenAI client
 	openaiClient := openai.NewClient(cfg)
 	logger.Info("OpenAI client initialized")
 
+	// Open the conversation store
+	conversationStore, err := openai.NewConversationStore(&cfg.Conversations, redisClient, cfg.Redis.KeyPrefix)
+	if err != nil {
+		logger.Fatal("Failed to open conversation store: %v", err)
+	}
+	openaiClient.SetConversationStore(conversationStore)
+	logger.Info("Conversation store initialized (%s store)", cfg.Conversations.Store)
+	if cfg.Conversations.Encryption.Enabled() && cfg.Conversations.Store != "memory" {
+		logger.Info("Conversations are encrypted with key %s", cfg.Conversations.Encryption.ActiveKey)
+	}
+
+	// Open the long-term memory
+	if cfg.Memory.Enabled {
+		memoryStore, err := memory.NewStore(&cfg.Memory, redisClient, cfg.Redis.KeyPrefix)
+		if err != nil {
+			logger.Fatal("Failed to open memory store: %v", err)
+		}
+		memories := memory.NewManager(memoryStore, &cfg.Memory)
+		defer func() {
+			if err := memories.Close(); err != nil {
+				logger.Error("Failed to flush memory store: %v", err)
+			}
+		}()
+		openaiClient.SetMemory(memories)
+		logger.Info("Long-term memory initialized (%s store)", cfg.Memory.Store)
+	}
+
+	// Open the recall archive of past exchanges
+	if cfg.Recall.Enabled {
+		archive, err := recall.NewArchive(&cfg.Recall, &cfg.Conversations.Encryption)
+		if err != nil {
+			logger.Fatal("Failed to open recall archive: %v", err)
+		}
+		openaiClient.SetRecall(archive)
+		logger.Info("Semantic recall initialized (%s store, embeddings by %s)", cfg.Recall.Store, openaiClient.EmbeddingModel())
+	}
+
+	// Open the knowledge bases and keep them in sync with their directories
+	if len(cfg.Knowledge.Bases) > 0 {
+		library, err := knowledge.NewLibrary(&cfg.Knowledge, openaiClient.EmbedDocuments)
+		if err != nil {
+			logger.Fatal("Failed to open knowledge bases: %v", err)
+		}
+		openaiClient.SetKnowledge(library)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go library.Run(ctx)
+		logger.Info("Knowledge bases initialized (%d bases, reindexed every %v)", len(cfg.Knowledge.Bases), cfg.Knowledge.ReindexInterval)
+	}
+
+	// Check messages and answers
+	if cfg.Moderation.Enabled {
+		moderator, err := moderation.New(&cfg.Moderation)
+		if err != nil {
+			logger.Fatal("Failed to create moderator: %v", err)
+		}
+		openaiClient.SetModerator(moderator)
+		logger.Info("Moderation enabled (%s, api: %v, %d rules)", cfg.Moderation.Check, cfg.Moderation.API, len(cfg.Moderation.Rules))
+	}
+
+	// Set up redaction of personal data and secrets
+	if cfg.Redaction.Enabled {
+		redactor, err := redact.New(&cfg.Redaction)
+		if err != nil {
+			logger.Fatal("Failed to create redactor: %v", err)
+		}
+		openaiClient.SetRedactor(redactor)
+		logger.Info("Redaction enabled (restore: %v, %d custom patterns)", cfg.Redaction.Restore, len(cfg.Redaction.Patterns))
+		if cfg.Redaction.Restore && cfg.Redaction.Salt == "" {
+			logger.Warn("No redaction.salt is set, so placeholders in stored conversations cannot be restored after a restart or by other replicas")
+		}
+	}
+	if len(cfg.Personas.List) > 0 {
+		logger.Info("%d personas configured (default: %q)", len(cfg.Personas.List), cfg.Personas.Default)
+	}
+
+	// Register built-in tools
+	if cfg.Tools.Enabled {
+		builtins, err := tools.Builtins(&cfg.Tools, &cfg.Timezones)
+		if err != nil {
+			logger.Fatal("Failed to create built-in tools: %v", err)
+		}
+		for _, tool := range builtins {
+			openaiClient.RegisterTool(tool)
+		}
+		logger.Info("Registered %d built-in tools", len(builtins))
+	}
+
+	// Connect to MCP servers and expose their tools
+	if len(cfg.MCP.Servers) > 0 {
+		mcpManager := mcp.Connect(context.Background(), &cfg.MCP)
+		defer mcpManager.Close()
+		for _, tool := range mcpManager.Tools() {
+			openaiClient.RegisterTool(tool)
+		}
+		logger.Info("Registered %d MCP tools", len(mcpManager.Tools()))
+	}
+
+	// Create usage tracker
+	usageStore, err := usage.NewStore(&cfg.Usage, redisClient, cfg.Redis.KeyPrefix)
+	if err != nil {
+		logger.Fatal("Failed to open usage store: %v", err)
+	}
+	defer func() {
+		if err := usageStore.Close(); err != nil {
+			logger.Error("Failed to flush usage store: %v", err)
+		}
+	}()
+
+	usageTracker, err := usage.NewTracker(usageStore, &cfg.Usage)
+	if err != nil {
+		logger.Fatal("Failed to create usage tracker: %v", err)
+	}
+	logger.Info("Usage tracker initialized (%s store)", cfg.Usage.Store)
+
 	// Create Telegram bot
-	bot, err := telegram.NewBot(cfg, openaiClient)
+	bot, err := telegram.NewBot(cfg, openaiClient, usageTracker, locker)
 	if err != nil {
 		logger.Fatal("Failed to create Telegram bot: %v", err)
 	}
@@ -56,7 +198,34 @@ func main() {
 	sig := <-sigChan
 	logger.Info("Received signal: %v, shutting down...", sig)
 
-	// Stop the bot gracefully
+	// Stop intake and drain in-flight replies, then release the remaining resources.
+	// Deferred calls flush the usage and memory stores, disconnect MCP servers and close the log.
 	bot.Stop()
+	if err := openaiClient.Close(); err != nil {
+		logger.Error("Failed to close conversation store: %v", err)
+	}
 	logger.Info("Shutdown complete")
 }
+
+// connectRedis connects to the configured Redis, exiting if it is unreachable.
+// It returns nil when Redis is not configured so that the stores can tell.
+func connectRedis(cfg *config.Config) redis.UniversalClient {
+	if !cfg.Redis.Enabled() {
+		return nil
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	err := client.Ping(ctx).Err()
+	cancel()
+	if err != nil {
+		logger.Fatal("Failed to connect to Redis at %s: %v", cfg.Redis.Addr, err)
+	}
+	logger.Info("Connected to Redis at %s", cfg.Redis.Addr)
+	return client
+}
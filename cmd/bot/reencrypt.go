@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/openai"
+)
+
+// reencrypt rewrites the stored conversations with the active encryption key
+// and returns the exit code. The file store is locked by a running bot, so the
+// bot has to be stopped first; the redis store can be migrated while it runs.
+func reencrypt(cfg *config.Config) int {
+	if !cfg.Conversations.Encryption.Enabled() {
+		logger.Error("No encryption keys are configured, set ENCRYPTION_KEYS or encryption.key_file")
+		return 1
+	}
+
+	redisClient := connectRedis(cfg)
+	if redisClient != nil {
+		defer redisClient.Close()
+	}
+
+	store, err := openai.NewConversationStore(&cfg.Conversations, redisClient, cfg.Redis.KeyPrefix)
+	if err != nil {
+		logger.Error("Failed to open conversation store: %v", err)
+		return 1
+	}
+	defer store.Close()
+
+	reencrypter, ok := store.(openai.Reencrypter)
+	if !ok {
+		logger.Error("The %s conversation store does not persist conversations", cfg.Conversations.Store)
+		return 1
+	}
+
+	logger.Info("Re-encrypting conversations with key %s", cfg.Conversations.Encryption.ActiveKey)
+	count, err := reencrypter.Reencrypt()
+	if err != nil {
+		logger.Error("Re-encrypted %d conversations before failing: %v", count, err)
+		return 1
+	}
+	logger.Info("Re-encrypted %d conversations", count)
+	return 0
+}
@@ -3,7 +3,18 @@ module github.com/itswryu/telegpt
 go 1.20
 
 require (
+	github.com/alicebob/miniredis/v2 v2.33.0
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/joho/godotenv v1.5.1
+	github.com/redis/go-redis/v9 v9.7.0
+	go.etcd.io/bbolt v1.3.9
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+)
@@ -6,31 +6,201 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/itswryu/telegpt/pkg/encryption"
+	"github.com/itswryu/telegpt/pkg/prompt"
 	"github.com/joho/godotenv"
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds the application configuration
 type Config struct {
-	Telegram TelegramConfig `yaml:"telegram"`
-	OpenAI   OpenAIConfig   `yaml:"openai"`
-	Auth     AuthConfig     `yaml:"auth"`
-	Logging  LoggingConfig  `yaml:"logging"`
+	Telegram      TelegramConfig     `yaml:"telegram"`
+	OpenAI        OpenAIConfig       `yaml:"openai"`
+	Auth          AuthConfig         `yaml:"auth"`
+	Logging       LoggingConfig      `yaml:"logging"`
+	Timezones     TimezoneConfig     `yaml:"timezones"`
+	Tools         ToolsConfig        `yaml:"tools"`
+	MCP           MCPConfig          `yaml:"mcp"`
+	Usage         UsageConfig        `yaml:"usage"`
+	Quotas        QuotaConfig        `yaml:"quotas"`
+	Conversations ConversationConfig `yaml:"conversations"`
+	Memory        MemoryConfig       `yaml:"memory"`
+	Recall        RecallConfig       `yaml:"recall"`
+	Knowledge     KnowledgeConfig    `yaml:"knowledge"`
+	Moderation    ModerationConfig   `yaml:"moderation"`
+	Redaction     RedactionConfig    `yaml:"redaction"`
+	Personas      PersonasConfig     `yaml:"personas"`
+	Redis         RedisConfig        `yaml:"redis"`
 }
 
 // TelegramConfig holds Telegram-specific configuration
 type TelegramConfig struct {
 	BotToken string `yaml:"bot_token"`
+	// ShutdownGracePeriod is how long in-flight replies may take to finish on shutdown
+	ShutdownGracePeriod time.Duration `yaml:"shutdown_grace_period,omitempty"`
 }
 
+// DefaultEmbeddingModel computes embeddings unless openai.embedding_model is set
+const DefaultEmbeddingModel = "text-embedding-3-small"
+
 // OpenAIConfig holds OpenAI-specific configuration
 type OpenAIConfig struct {
 	APIKey          string           `yaml:"api_key"`
 	Model           string           `yaml:"model"`
+	BaseURL         string           `yaml:"base_url,omitempty"`
 	SystemPrompt    string           `yaml:"system_prompt,omitempty"`
 	FewShotEnabled  bool             `yaml:"few_shot_enabled"`
 	FewShotExamples []FewShotExample `yaml:"few_shot_examples,omitempty"`
+	Fallbacks       []FallbackModel  `yaml:"fallbacks,omitempty"`
+	LatencyBudget   time.Duration    `yaml:"latency_budget,omitempty"`
+	RequestTimeout  time.Duration    `yaml:"request_timeout,omitempty"`
+	// EmbeddingModel computes the embeddings of semantic search
+	EmbeddingModel string `yaml:"embedding_model,omitempty"`
+	// PromptVars are custom variables of the system prompt template, used as {{.Vars.name}}
+	PromptVars     map[string]string `yaml:"prompt_vars,omitempty"`
+	SamplingConfig `yaml:",inline"`
+}
+
+// SamplingConfig holds the sampling parameters sent with each request.
+// Nil values are omitted so that the provider defaults apply.
+type SamplingConfig struct {
+	Temperature      *float64 `yaml:"temperature,omitempty" json:"temperature,omitempty"`
+	TopP             *float64 `yaml:"top_p,omitempty" json:"top_p,omitempty"`
+	MaxTokens        *int     `yaml:"max_tokens,omitempty" json:"max_tokens,omitempty"`
+	PresencePenalty  *float64 `yaml:"presence_penalty,omitempty" json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `yaml:"frequency_penalty,omitempty" json:"frequency_penalty,omitempty"`
+	Seed             *int64   `yaml:"seed,omitempty" json:"seed,omitempty"`
+	Stop             []string `yaml:"stop,omitempty" json:"stop,omitempty"`
+}
+
+// SamplingParameters lists the names accepted by SamplingConfig.Set
+var SamplingParameters = []string{"temperature", "top_p", "max_tokens", "presence_penalty", "frequency_penalty", "seed", "stop"}
+
+// maxStopSequences is the number of stop sequences accepted by the API
+const maxStopSequences = 4
+
+// Validate checks that the parameters are within the ranges accepted by the API
+func (s SamplingConfig) Validate() error {
+	if s.Temperature != nil && (*s.Temperature < 0 || *s.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if s.TopP != nil && (*s.TopP <= 0 || *s.TopP > 1) {
+		return fmt.Errorf("top_p must be greater than 0 and at most 1")
+	}
+	if s.MaxTokens != nil && *s.MaxTokens < 1 {
+		return fmt.Errorf("max_tokens must be at least 1")
+	}
+	if s.PresencePenalty != nil && (*s.PresencePenalty < -2 || *s.PresencePenalty > 2) {
+		return fmt.Errorf("presence_penalty must be between -2 and 2")
+	}
+	if s.FrequencyPenalty != nil && (*s.FrequencyPenalty < -2 || *s.FrequencyPenalty > 2) {
+		return fmt.Errorf("frequency_penalty must be between -2 and 2")
+	}
+	if len(s.Stop) > maxStopSequences {
+		return fmt.Errorf("at most %d stop sequences are allowed", maxStopSequences)
+	}
+	return nil
+}
+
+// Merge returns s with every parameter that is set in override replaced
+func (s SamplingConfig) Merge(override SamplingConfig) SamplingConfig {
+	if override.Temperature != nil {
+		s.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		s.TopP = override.TopP
+	}
+	if override.MaxTokens != nil {
+		s.MaxTokens = override.MaxTokens
+	}
+	if override.PresencePenalty != nil {
+		s.PresencePenalty = override.PresencePenalty
+	}
+	if override.FrequencyPenalty != nil {
+		s.FrequencyPenalty = override.FrequencyPenalty
+	}
+	if override.Seed != nil {
+		s.Seed = override.Seed
+	}
+	if override.Stop != nil {
+		s.Stop = override.Stop
+	}
+	return s
+}
+
+// Set parses and sets a parameter by name. The values "default" and "off" unset it.
+// The result is not validated; call Validate afterwards.
+func (s *SamplingConfig) Set(name, value string) error {
+	value = strings.TrimSpace(value)
+	unset := value == "default" || value == "off" || value == ""
+
+	parseFloat := func(target **float64) error {
+		if unset {
+			*target = nil
+			return nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", name)
+		}
+		*target = &f
+		return nil
+	}
+
+	switch name {
+	case "temperature":
+		return parseFloat(&s.Temperature)
+	case "top_p":
+		return parseFloat(&s.TopP)
+	case "presence_penalty":
+		return parseFloat(&s.PresencePenalty)
+	case "frequency_penalty":
+		return parseFloat(&s.FrequencyPenalty)
+	case "max_tokens":
+		if unset {
+			s.MaxTokens = nil
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("max_tokens must be an integer")
+		}
+		s.MaxTokens = &n
+	case "seed":
+		if unset {
+			s.Seed = nil
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("seed must be an integer")
+		}
+		s.Seed = &n
+	case "stop":
+		if unset {
+			s.Stop = nil
+			return nil
+		}
+		s.Stop = nil
+		for _, stop := range strings.Split(value, ",") {
+			if stop = strings.TrimSpace(stop); stop != "" {
+				s.Stop = append(s.Stop, stop)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown parameter %q", name)
+	}
+	return nil
+}
+
+// FallbackModel defines a model that is tried when the primary model fails.
+// Empty BaseURL and APIKey inherit the primary model's values.
+type FallbackModel struct {
+	Model   string `yaml:"model"`
+	BaseURL string `yaml:"base_url,omitempty"`
+	APIKey  string `yaml:"api_key,omitempty"`
 }
 
 // FewShotExample defines a single example for few-shot prompting
@@ -39,10 +209,27 @@ type FewShotExample struct {
 	BotResponse  string `yaml:"bot_response"`
 }
 
+// Roles a chat can have
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
 // AuthConfig holds authentication configuration
 type AuthConfig struct {
 	AllowedChatIDs    []int64 `yaml:"allowed_chat_ids,omitempty"`
 	AllowedChatIDsStr string  `yaml:"allowed_chat_ids_str,omitempty"`
+	AdminChatIDs      []int64 `yaml:"admin_chat_ids,omitempty"`
+}
+
+// RoleOf returns the role of a chat
+func (a *AuthConfig) RoleOf(chatID int64) string {
+	for _, id := range a.AdminChatIDs {
+		if id == chatID {
+			return RoleAdmin
+		}
+	}
+	return RoleUser
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface to handle both string and array formats
@@ -59,6 +246,15 @@ func (a *AuthConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		a.AllowedChatIDsStr = arrayConfig.AllowedChatIDsStr
 	}
 
+	// 관리자 목록은 항상 배열 방식
+	var adminConfig struct {
+		AdminChatIDs []int64 `yaml:"admin_chat_ids"`
+	}
+	if err := unmarshal(&adminConfig); err != nil {
+		return err
+	}
+	a.AdminChatIDs = adminConfig.AdminChatIDs
+
 	// 2. 문자열 방식 시도 (우선순위 높음)
 	var stringConfig struct {
 		AllowedChatIDs    string `yaml:"allowed_chat_ids"`
@@ -113,6 +309,534 @@ func (a *AuthConfig) ParseAllowedChatIDs() error {
 	return nil
 }
 
+// TimezoneConfig holds the timezones of chats, used for the dates in system
+// prompts, exports and the date/time tool
+type TimezoneConfig struct {
+	// Default applies to chats without their own timezone
+	Default string           `yaml:"default,omitempty"`
+	Chats   map[int64]string `yaml:"chats,omitempty"`
+
+	// The timezones are loaded once when the configuration is validated
+	defaultLocation *time.Location
+	locations       map[int64]*time.Location
+}
+
+// load loads the configured timezones, defaulting to UTC
+func (t *TimezoneConfig) load() error {
+	if t.Default == "" {
+		t.Default = "UTC"
+	}
+	loc, err := time.LoadLocation(t.Default)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", t.Default, err)
+	}
+	t.defaultLocation = loc
+
+	t.locations = make(map[int64]*time.Location, len(t.Chats))
+	for chatID, name := range t.Chats {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %q for chat %d: %w", name, chatID, err)
+		}
+		t.locations[chatID] = loc
+	}
+	return nil
+}
+
+// LocationFor returns the timezone of a chat, UTC if none was loaded
+func (t *TimezoneConfig) LocationFor(chatID int64) *time.Location {
+	if loc, ok := t.locations[chatID]; ok {
+		return loc
+	}
+	if t.defaultLocation != nil {
+		return t.defaultLocation
+	}
+	return time.UTC
+}
+
+// ToolsConfig holds function calling configuration
+type ToolsConfig struct {
+	Enabled       bool                  `yaml:"enabled"`
+	MaxIterations int                   `yaml:"max_iterations,omitempty"`
+	Builtin       []string              `yaml:"builtin,omitempty"`
+	Roles         map[string]ToolPolicy `yaml:"roles,omitempty"`
+	Chats         map[int64]ToolPolicy  `yaml:"chats,omitempty"`
+}
+
+// ToolPolicy restricts the tools available to a role or chat.
+// An empty Allow list permits every tool that is not denied.
+type ToolPolicy struct {
+	Disabled bool     `yaml:"disabled,omitempty"`
+	Allow    []string `yaml:"allow,omitempty"`
+	Deny     []string `yaml:"deny,omitempty"`
+}
+
+// Permits reports whether the policy allows the named tool
+func (p ToolPolicy) Permits(name string) bool {
+	if p.Disabled {
+		return false
+	}
+	for _, denied := range p.Deny {
+		if denied == name {
+			return false
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, allowed := range p.Allow {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Permits reports whether a tool may be used in a chat with the given role
+func (t *ToolsConfig) Permits(chatID int64, role, name string) bool {
+	if !t.Enabled {
+		return false
+	}
+	if policy, ok := t.Roles[role]; ok && !policy.Permits(name) {
+		return false
+	}
+	if policy, ok := t.Chats[chatID]; ok && !policy.Permits(name) {
+		return false
+	}
+	return true
+}
+
+// MCPConfig holds the Model Context Protocol servers whose tools are exposed to the model
+type MCPConfig struct {
+	Servers        []MCPServerConfig `yaml:"servers,omitempty"`
+	ConfirmTimeout time.Duration     `yaml:"confirm_timeout,omitempty"`
+}
+
+// MCPServerConfig describes a single MCP server. Either Command (stdio) or URL
+// (streamable HTTP) must be set.
+type MCPServerConfig struct {
+	Name     string            `yaml:"name"`
+	Command  string            `yaml:"command,omitempty"`
+	Args     []string          `yaml:"args,omitempty"`
+	Env      map[string]string `yaml:"env,omitempty"`
+	URL      string            `yaml:"url,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	Allow    []string          `yaml:"allow,omitempty"`
+	Mutating []string          `yaml:"mutating,omitempty"`
+}
+
+var mcpServerNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// ConversationConfig holds conversation history storage configuration
+type ConversationConfig struct {
+	Store string `yaml:"store,omitempty"` // memory, file or redis
+	Path  string `yaml:"path,omitempty"`
+	// Retention is how long unused sessions are archived before they are deleted
+	Retention time.Duration `yaml:"retention,omitempty"`
+	// ExpireInterval is how often the memory and file stores delete sessions
+	// past their retention. Redis deletes them itself.
+	ExpireInterval  time.Duration `yaml:"expire_interval,omitempty"`
+	RetentionPolicy `yaml:",inline"`
+	// CarrySummary seeds a session that replaces an expired one with a summary
+	// of it. Chats can opt out with /settings.
+	CarrySummary bool `yaml:"carry_summary,omitempty"`
+	// Chats override the retention policy of individual chats
+	Chats      map[int64]RetentionPolicy `yaml:"chats,omitempty"`
+	Encryption EncryptionConfig          `yaml:"encryption,omitempty"`
+}
+
+// Defaults of the retention policy
+const (
+	DefaultMaxHistory  = 10
+	DefaultIdleTimeout = 30 * time.Minute
+)
+
+// DefaultExpireInterval is how often expired sessions are deleted unless
+// conversations.expire_interval is set
+const DefaultExpireInterval = 10 * time.Minute
+
+// RetentionPolicy limits how much of a session and for how long it provides
+// context. In a per-chat entry zero inherits the global value.
+type RetentionPolicy struct {
+	// MaxHistory is the number of messages kept per session
+	MaxHistory int `yaml:"max_history,omitempty"`
+	// IdleTimeout is how long a session may be unused before the next message starts a new one
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty"`
+	// MaxSessionAge is how long after its start a session is replaced by a new one, zero for no limit
+	MaxSessionAge time.Duration `yaml:"max_session_age,omitempty"`
+}
+
+// merge returns p with every value that is set in override replaced
+func (p RetentionPolicy) merge(override RetentionPolicy) RetentionPolicy {
+	if override.MaxHistory != 0 {
+		p.MaxHistory = override.MaxHistory
+	}
+	if override.IdleTimeout != 0 {
+		p.IdleTimeout = override.IdleTimeout
+	}
+	if override.MaxSessionAge != 0 {
+		p.MaxSessionAge = override.MaxSessionAge
+	}
+	return p
+}
+
+// validate checks that no value is negative
+func (p RetentionPolicy) validate() error {
+	if p.MaxHistory < 0 {
+		return fmt.Errorf("max_history must not be negative")
+	}
+	if p.IdleTimeout < 0 {
+		return fmt.Errorf("idle_timeout must not be negative")
+	}
+	if p.MaxSessionAge < 0 {
+		return fmt.Errorf("max_session_age must not be negative")
+	}
+	return nil
+}
+
+// PolicyFor returns the retention policy of a chat. Unset lengths and timeouts
+// fall back to the defaults.
+func (c *ConversationConfig) PolicyFor(chatID int64) RetentionPolicy {
+	policy := RetentionPolicy{MaxHistory: DefaultMaxHistory, IdleTimeout: DefaultIdleTimeout}.merge(c.RetentionPolicy)
+	if override, ok := c.Chats[chatID]; ok {
+		policy = policy.merge(override)
+	}
+	return policy
+}
+
+// MaxStoredHistory is the number of messages the store keeps per session: the
+// largest history length of any chat
+func (c *ConversationConfig) MaxStoredHistory() int {
+	longest := c.PolicyFor(0).MaxHistory
+	for chatID := range c.Chats {
+		if n := c.PolicyFor(chatID).MaxHistory; n > longest {
+			longest = n
+		}
+	}
+	return longest
+}
+
+// EncryptionConfig holds the keys that encrypt conversations in persistent stores
+type EncryptionConfig struct {
+	// KeyFile is a file such as a mounted secret with one id:base64-key per line
+	KeyFile   string `yaml:"key_file,omitempty"`
+	ActiveKey string `yaml:"active_key,omitempty"`
+	// Keys are read from ENCRYPTION_KEYS and KeyFile, never from the YAML file
+	Keys map[string][]byte `yaml:"-"`
+}
+
+// Enabled reports whether encryption keys are configured
+func (e *EncryptionConfig) Enabled() bool {
+	return len(e.Keys) > 0
+}
+
+// Defaults of the long-term memory
+const (
+	DefaultMaxMemories      = 50
+	DefaultInjectedMemories = 10
+)
+
+// MemoryConfig holds the configuration of long-term memories, the facts about
+// each user that are kept across conversations
+type MemoryConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Store   string `yaml:"store,omitempty"` // memory, file or redis
+	Path    string `yaml:"path,omitempty"`
+	// MaxEntries is the number of memories kept per user
+	MaxEntries int `yaml:"max_entries,omitempty"`
+	// MaxInjected is the number of memories added to the system message of a request
+	MaxInjected int `yaml:"max_injected,omitempty"`
+	// Extract asks the model for new memories after each exchange
+	Extract bool `yaml:"extract,omitempty"`
+}
+
+// Defaults of semantic recall
+const (
+	DefaultRecallResults     = 5
+	DefaultAutoRecallResults = 3
+	DefaultRecallMinScore    = 0.4
+	DefaultRecallExchanges   = 1000
+)
+
+// RecallConfig holds semantic search over the archived turns of each user
+type RecallConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Store   string `yaml:"store,omitempty"` // memory or file
+	// Path is the directory of the per-user index files
+	Path string `yaml:"path,omitempty"`
+	// MaxExchanges is the number of exchanges kept per user, the oldest are dropped
+	MaxExchanges int `yaml:"max_exchanges,omitempty"`
+	// MaxResults is the number of exchanges /recall returns
+	MaxResults int `yaml:"max_results,omitempty"`
+	// Auto adds relevant past exchanges to the prompt of every message
+	Auto bool `yaml:"auto,omitempty"`
+	// AutoResults is the number of past exchanges added to a prompt
+	AutoResults int `yaml:"auto_results,omitempty"`
+	// MinScore is the cosine similarity a past exchange needs to be added to a prompt
+	MinScore float64 `yaml:"min_score,omitempty"`
+}
+
+// Defaults of knowledge bases
+const (
+	DefaultChunkSize       = 1000
+	DefaultChunkOverlap    = 150
+	DefaultKnowledgeChunks = 4
+	DefaultKnowledgeScore  = 0.3
+	DefaultReindexInterval = 5 * time.Minute
+)
+
+// KnowledgeConfig holds the document directories the bot answers questions from
+type KnowledgeConfig struct {
+	Bases []KnowledgeBaseConfig `yaml:"bases,omitempty"`
+	// IndexDir holds one vector index file per knowledge base
+	IndexDir string `yaml:"index_dir,omitempty"`
+	// ChunkSize and ChunkOverlap are measured in characters
+	ChunkSize    int `yaml:"chunk_size,omitempty"`
+	ChunkOverlap int `yaml:"chunk_overlap,omitempty"`
+	// TopK is the number of chunks added to the prompt of a question
+	TopK int `yaml:"top_k,omitempty"`
+	// MinScore is the cosine similarity a chunk needs to be added to a prompt
+	MinScore float64 `yaml:"min_score,omitempty"`
+	// ReindexInterval is how often the directories are checked for changed files
+	ReindexInterval time.Duration `yaml:"reindex_interval,omitempty"`
+}
+
+// menuNameRegex matches the names that are sent back in inline button data,
+// which Telegram limits to 64 bytes
+var menuNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// KnowledgeBaseConfig describes a directory of Markdown, text and PDF files
+type KnowledgeBaseConfig struct {
+	Name        string `yaml:"name"`
+	Dir         string `yaml:"dir"`
+	Description string `yaml:"description,omitempty"`
+	// Chats use the base unless they choose otherwise with /kb; empty means every chat
+	Chats []int64 `yaml:"chats,omitempty"`
+}
+
+// UsedBy reports whether a chat uses the base by default
+func (k *KnowledgeBaseConfig) UsedBy(chatID int64) bool {
+	if len(k.Chats) == 0 {
+		return true
+	}
+	for _, id := range k.Chats {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// Moderation actions
+const (
+	ModerationBlock = "block"
+	ModerationWarn  = "warn"
+	ModerationLog   = "log"
+)
+
+// DefaultModerationModel classifies content unless moderation.model is set
+const DefaultModerationModel = "omni-moderation-latest"
+
+// ModerationConfig holds the checks of messages before they reach the model
+// and of answers before they reach the chat
+type ModerationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// API classifies content with the /moderations endpoint of the primary API
+	API   bool   `yaml:"api,omitempty"`
+	Model string `yaml:"model,omitempty"`
+	// Check is input, output or both
+	Check string           `yaml:"check,omitempty"`
+	Rules []ModerationRule `yaml:"rules,omitempty"`
+	// Actions maps a category, or the part of it before "/", to block, warn or log
+	Actions       map[string]string `yaml:"actions,omitempty"`
+	DefaultAction string            `yaml:"default_action,omitempty"`
+	// FailClosed blocks content when the moderation API cannot be reached
+	FailClosed bool `yaml:"fail_closed,omitempty"`
+	// AlertAfter violations of a user within AlertWindow notify the admins
+	AlertAfter  int           `yaml:"alert_after,omitempty"`
+	AlertWindow time.Duration `yaml:"alert_window,omitempty"`
+}
+
+// ModerationRule flags content matching any of its regular expressions or
+// containing any of its keywords, ignoring case, as Category
+type ModerationRule struct {
+	Category string   `yaml:"category"`
+	Patterns []string `yaml:"patterns,omitempty"`
+	Keywords []string `yaml:"keywords,omitempty"`
+}
+
+// ChecksInput reports whether messages are checked before they reach the model
+func (m *ModerationConfig) ChecksInput() bool {
+	return m.Enabled && m.Check != "output"
+}
+
+// ChecksOutput reports whether answers are checked before they reach the chat
+func (m *ModerationConfig) ChecksOutput() bool {
+	return m.Enabled && m.Check != "input"
+}
+
+// ActionFor returns the action of a category
+func (m *ModerationConfig) ActionFor(category string) string {
+	if action, ok := m.Actions[category]; ok {
+		return action
+	}
+	if parent, _, found := strings.Cut(category, "/"); found {
+		if action, ok := m.Actions[parent]; ok {
+			return action
+		}
+	}
+	return m.DefaultAction
+}
+
+// RedactionDetectors lists the built-in detectors of personal data and secrets
+var RedactionDetectors = []string{"email", "phone", "iban", "card", "aws", "github", "openai", "slack", "private_key"}
+
+// RedactionConfig holds the replacement of personal data and secrets with
+// placeholders before messages leave for the API
+type RedactionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Detectors are the built-in detectors to use, all of them if empty
+	Detectors []string           `yaml:"detectors,omitempty"`
+	Patterns  []RedactionPattern `yaml:"patterns,omitempty"`
+	// Restore puts the original values back into answers that repeat placeholders
+	Restore bool `yaml:"restore,omitempty"`
+	// Salt keys the placeholders so that they stay the same across restarts and
+	// replicas. A random salt is used on every start if it is empty.
+	Salt string `yaml:"salt,omitempty"`
+}
+
+// RedactionPattern is a custom detector. With a capture group only the group is replaced.
+type RedactionPattern struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+}
+
+var redactionNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// PersonasConfig holds the personas chats can switch between with /persona
+type PersonasConfig struct {
+	List []PersonaConfig `yaml:"list,omitempty"`
+	// Default is the persona of chats without one in Chats, the openai settings if empty
+	Default string `yaml:"default,omitempty"`
+	// Chats assigns personas to chats by ID
+	Chats map[int64]string `yaml:"chats,omitempty"`
+}
+
+// DefaultFor returns the persona a chat uses until it picks one, if any
+func (p *PersonasConfig) DefaultFor(chatID int64) string {
+	if name, ok := p.Chats[chatID]; ok {
+		return name
+	}
+	return p.Default
+}
+
+// Find returns the persona called name, or nil if there is none
+func (p *PersonasConfig) Find(name string) *PersonaConfig {
+	for i := range p.List {
+		if p.List[i].Name == name {
+			return &p.List[i]
+		}
+	}
+	return nil
+}
+
+// PersonaConfig describes a persona. Unset fields fall back to the openai settings.
+type PersonaConfig struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	// SystemPrompt is a template like openai.system_prompt
+	SystemPrompt    string           `yaml:"system_prompt,omitempty"`
+	FewShotExamples []FewShotExample `yaml:"few_shot_examples,omitempty"`
+	Model           string           `yaml:"model,omitempty"`
+	// Tools limits the tools to the listed names; nil allows every permitted tool and [] none
+	Tools          []string `yaml:"tools,omitempty"`
+	SamplingConfig `yaml:",inline"`
+}
+
+// RedisConfig holds the connection used by the redis stores and locks
+type RedisConfig struct {
+	Addr      string `yaml:"addr,omitempty"`
+	Password  string `yaml:"password,omitempty"`
+	DB        int    `yaml:"db,omitempty"`
+	KeyPrefix string `yaml:"key_prefix,omitempty"`
+}
+
+// Enabled reports whether a Redis server is configured
+func (r *RedisConfig) Enabled() bool {
+	return r.Addr != ""
+}
+
+// UsageConfig holds token usage accounting configuration
+type UsageConfig struct {
+	Store    string                `yaml:"store,omitempty"` // memory, file or redis
+	Path     string                `yaml:"path,omitempty"`
+	Timezone string                `yaml:"timezone,omitempty"`
+	Prices   map[string]ModelPrice `yaml:"prices,omitempty"`
+}
+
+// ModelPrice is the price of a model in USD per million tokens.
+// A zero CachedPrompt price falls back to the Prompt price.
+type ModelPrice struct {
+	Prompt       float64 `yaml:"prompt"`
+	CachedPrompt float64 `yaml:"cached_prompt,omitempty"`
+	Completion   float64 `yaml:"completion"`
+}
+
+// QuotaConfig holds usage limits per role and per user
+type QuotaConfig struct {
+	Roles map[string]QuotaLimits `yaml:"roles,omitempty"`
+	Users map[int64]QuotaLimits  `yaml:"users,omitempty"`
+}
+
+// QuotaLimits are daily and monthly limits. Zero means no limit; in a per-user
+// entry zero inherits the role's limit and -1 lifts it.
+type QuotaLimits struct {
+	DailyTokens     int64   `yaml:"daily_tokens,omitempty"`
+	MonthlyTokens   int64   `yaml:"monthly_tokens,omitempty"`
+	DailyRequests   int64   `yaml:"daily_requests,omitempty"`
+	MonthlyRequests int64   `yaml:"monthly_requests,omitempty"`
+	DailyCost       float64 `yaml:"daily_cost,omitempty"`
+	MonthlyCost     float64 `yaml:"monthly_cost,omitempty"`
+}
+
+// Merge returns l with every limit that is set in override replaced.
+// A negative override removes the limit.
+func (l QuotaLimits) Merge(override QuotaLimits) QuotaLimits {
+	mergeInt := func(base *int64, value int64) {
+		switch {
+		case value < 0:
+			*base = 0
+		case value > 0:
+			*base = value
+		}
+	}
+	mergeFloat := func(base *float64, value float64) {
+		switch {
+		case value < 0:
+			*base = 0
+		case value > 0:
+			*base = value
+		}
+	}
+
+	mergeInt(&l.DailyTokens, override.DailyTokens)
+	mergeInt(&l.MonthlyTokens, override.MonthlyTokens)
+	mergeInt(&l.DailyRequests, override.DailyRequests)
+	mergeInt(&l.MonthlyRequests, override.MonthlyRequests)
+	mergeFloat(&l.DailyCost, override.DailyCost)
+	mergeFloat(&l.MonthlyCost, override.MonthlyCost)
+	return l
+}
+
+// LimitsFor returns the effective limits of a user with the given role
+func (q *QuotaConfig) LimitsFor(userID int64, role string) QuotaLimits {
+	limits := q.Roles[role]
+	if override, ok := q.Users[userID]; ok {
+		limits = limits.Merge(override)
+	}
+	return limits
+}
+
 // LoggingConfig holds logging configuration
 type LoggingConfig struct {
 	Level   string `yaml:"level"`
@@ -183,6 +907,14 @@ func loadFromEnv(cfg *Config) error {
 		cfg.Telegram.BotToken = token
 	}
 
+	if grace := os.Getenv("TELEGRAM_SHUTDOWN_GRACE_PERIOD"); grace != "" {
+		d, err := time.ParseDuration(grace)
+		if err != nil {
+			return fmt.Errorf("failed to parse TELEGRAM_SHUTDOWN_GRACE_PERIOD: %w", err)
+		}
+		cfg.Telegram.ShutdownGracePeriod = d
+	}
+
 	// OpenAI API Key
 	if apiKey := os.Getenv("OPENAI_API_KEY"); apiKey != "" {
 		cfg.OpenAI.APIKey = apiKey
@@ -193,6 +925,51 @@ func loadFromEnv(cfg *Config) error {
 		cfg.OpenAI.Model = model
 	}
 
+	// OpenAI Base URL
+	if baseURL := os.Getenv("OPENAI_BASE_URL"); baseURL != "" {
+		cfg.OpenAI.BaseURL = baseURL
+	}
+
+	// Fallback models (comma separated, sharing the primary base URL and API key)
+	if fallbacks := os.Getenv("OPENAI_FALLBACK_MODELS"); fallbacks != "" {
+		cfg.OpenAI.Fallbacks = nil
+		for _, model := range strings.Split(fallbacks, ",") {
+			if model = strings.TrimSpace(model); model != "" {
+				cfg.OpenAI.Fallbacks = append(cfg.OpenAI.Fallbacks, FallbackModel{Model: model})
+			}
+		}
+	}
+
+	// Latency budget before falling back to the next model
+	if budget := os.Getenv("OPENAI_LATENCY_BUDGET"); budget != "" {
+		d, err := time.ParseDuration(budget)
+		if err != nil {
+			return fmt.Errorf("failed to parse OPENAI_LATENCY_BUDGET: %w", err)
+		}
+		cfg.OpenAI.LatencyBudget = d
+	}
+
+	if embeddingModel := os.Getenv("OPENAI_EMBEDDING_MODEL"); embeddingModel != "" {
+		cfg.OpenAI.EmbeddingModel = embeddingModel
+	}
+
+	if requestTimeout := os.Getenv("OPENAI_REQUEST_TIMEOUT"); requestTimeout != "" {
+		d, err := time.ParseDuration(requestTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to parse OPENAI_REQUEST_TIMEOUT: %w", err)
+		}
+		cfg.OpenAI.RequestTimeout = d
+	}
+
+	// Sampling parameters
+	for _, name := range SamplingParameters {
+		if value := os.Getenv("OPENAI_" + strings.ToUpper(name)); value != "" {
+			if err := cfg.OpenAI.SamplingConfig.Set(name, value); err != nil {
+				return fmt.Errorf("failed to parse OPENAI_%s: %w", strings.ToUpper(name), err)
+			}
+		}
+	}
+
 	// OpenAI System Prompt
 	if systemPrompt := os.Getenv("OPENAI_SYSTEM_PROMPT"); systemPrompt != "" {
 		cfg.OpenAI.SystemPrompt = systemPrompt
@@ -211,6 +988,188 @@ func loadFromEnv(cfg *Config) error {
 		}
 	}
 
+	// Admin Chat IDs
+	if adminIDs := os.Getenv("ADMIN_CHAT_IDS"); adminIDs != "" {
+		cfg.Auth.AdminChatIDs = nil
+		for _, id := range strings.Split(adminIDs, ",") {
+			trimmed := strings.TrimSpace(id)
+			if trimmed == "" {
+				continue
+			}
+			chatID, err := strconv.ParseInt(trimmed, 10, 64)
+			if err != nil {
+				return fmt.Errorf("failed to parse ADMIN_CHAT_IDS: invalid chat ID %q: %w", trimmed, err)
+			}
+			cfg.Auth.AdminChatIDs = append(cfg.Auth.AdminChatIDs, chatID)
+		}
+	}
+
+	// Tools
+	if toolsEnabled := os.Getenv("TOOLS_ENABLED"); toolsEnabled != "" {
+		cfg.Tools.Enabled = toolsEnabled == "true" || toolsEnabled == "1" || toolsEnabled == "yes"
+	}
+
+	// Timezones
+	if timezone := os.Getenv("TIMEZONE"); timezone != "" {
+		cfg.Timezones.Default = timezone
+	}
+
+	// Usage accounting
+	if usageStore := os.Getenv("USAGE_STORE"); usageStore != "" {
+		cfg.Usage.Store = usageStore
+	}
+
+	if usagePath := os.Getenv("USAGE_PATH"); usagePath != "" {
+		cfg.Usage.Path = usagePath
+	}
+
+	// Conversation store
+	if conversationStore := os.Getenv("CONVERSATION_STORE"); conversationStore != "" {
+		cfg.Conversations.Store = conversationStore
+	}
+
+	if conversationPath := os.Getenv("CONVERSATION_PATH"); conversationPath != "" {
+		cfg.Conversations.Path = conversationPath
+	}
+
+	if retention := os.Getenv("CONVERSATION_RETENTION"); retention != "" {
+		d, err := time.ParseDuration(retention)
+		if err != nil {
+			return fmt.Errorf("failed to parse CONVERSATION_RETENTION: %w", err)
+		}
+		cfg.Conversations.Retention = d
+	}
+
+	if expireInterval := os.Getenv("CONVERSATION_EXPIRE_INTERVAL"); expireInterval != "" {
+		d, err := time.ParseDuration(expireInterval)
+		if err != nil {
+			return fmt.Errorf("failed to parse CONVERSATION_EXPIRE_INTERVAL: %w", err)
+		}
+		cfg.Conversations.ExpireInterval = d
+	}
+
+	if maxHistory := os.Getenv("CONVERSATION_MAX_HISTORY"); maxHistory != "" {
+		n, err := strconv.Atoi(maxHistory)
+		if err != nil {
+			return fmt.Errorf("failed to parse CONVERSATION_MAX_HISTORY: %w", err)
+		}
+		cfg.Conversations.MaxHistory = n
+	}
+
+	if idleTimeout := os.Getenv("CONVERSATION_IDLE_TIMEOUT"); idleTimeout != "" {
+		d, err := time.ParseDuration(idleTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to parse CONVERSATION_IDLE_TIMEOUT: %w", err)
+		}
+		cfg.Conversations.IdleTimeout = d
+	}
+
+	if maxSessionAge := os.Getenv("CONVERSATION_MAX_SESSION_AGE"); maxSessionAge != "" {
+		d, err := time.ParseDuration(maxSessionAge)
+		if err != nil {
+			return fmt.Errorf("failed to parse CONVERSATION_MAX_SESSION_AGE: %w", err)
+		}
+		cfg.Conversations.MaxSessionAge = d
+	}
+
+	if carrySummary := os.Getenv("CONVERSATION_CARRY_SUMMARY"); carrySummary != "" {
+		cfg.Conversations.CarrySummary = carrySummary == "true" || carrySummary == "1" || carrySummary == "yes"
+	}
+
+	// Long-term memory
+	if enabled := os.Getenv("MEMORY_ENABLED"); enabled != "" {
+		cfg.Memory.Enabled = enabled == "true" || enabled == "1" || enabled == "yes"
+	}
+
+	if memoryStore := os.Getenv("MEMORY_STORE"); memoryStore != "" {
+		cfg.Memory.Store = memoryStore
+	}
+
+	if memoryPath := os.Getenv("MEMORY_PATH"); memoryPath != "" {
+		cfg.Memory.Path = memoryPath
+	}
+
+	if extract := os.Getenv("MEMORY_EXTRACT"); extract != "" {
+		cfg.Memory.Extract = extract == "true" || extract == "1" || extract == "yes"
+	}
+
+	// Semantic recall
+	if enabled := os.Getenv("RECALL_ENABLED"); enabled != "" {
+		cfg.Recall.Enabled = enabled == "true" || enabled == "1" || enabled == "yes"
+	}
+
+	if recallStore := os.Getenv("RECALL_STORE"); recallStore != "" {
+		cfg.Recall.Store = recallStore
+	}
+
+	if recallPath := os.Getenv("RECALL_PATH"); recallPath != "" {
+		cfg.Recall.Path = recallPath
+	}
+
+	if auto := os.Getenv("RECALL_AUTO"); auto != "" {
+		cfg.Recall.Auto = auto == "true" || auto == "1" || auto == "yes"
+	}
+
+	// Moderation
+	if enabled := os.Getenv("MODERATION_ENABLED"); enabled != "" {
+		cfg.Moderation.Enabled = enabled == "true" || enabled == "1" || enabled == "yes"
+	}
+
+	if api := os.Getenv("MODERATION_API"); api != "" {
+		cfg.Moderation.API = api == "true" || api == "1" || api == "yes"
+	}
+
+	// Redaction
+	if enabled := os.Getenv("REDACTION_ENABLED"); enabled != "" {
+		cfg.Redaction.Enabled = enabled == "true" || enabled == "1" || enabled == "yes"
+	}
+
+	if restore := os.Getenv("REDACTION_RESTORE"); restore != "" {
+		cfg.Redaction.Restore = restore == "true" || restore == "1" || restore == "yes"
+	}
+
+	if salt := os.Getenv("REDACTION_SALT"); salt != "" {
+		cfg.Redaction.Salt = salt
+	}
+
+	// Encryption
+	if keys := os.Getenv("ENCRYPTION_KEYS"); keys != "" {
+		parsed, err := encryption.ParseKeys(keys)
+		if err != nil {
+			return fmt.Errorf("failed to parse ENCRYPTION_KEYS: %w", err)
+		}
+		cfg.Conversations.Encryption.Keys = parsed
+	}
+
+	if keyFile := os.Getenv("ENCRYPTION_KEY_FILE"); keyFile != "" {
+		cfg.Conversations.Encryption.KeyFile = keyFile
+	}
+
+	if activeKey := os.Getenv("ENCRYPTION_ACTIVE_KEY"); activeKey != "" {
+		cfg.Conversations.Encryption.ActiveKey = activeKey
+	}
+
+	// Redis
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		cfg.Redis.Addr = addr
+	}
+
+	if password := os.Getenv("REDIS_PASSWORD"); password != "" {
+		cfg.Redis.Password = password
+	}
+
+	if db := os.Getenv("REDIS_DB"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return fmt.Errorf("failed to parse REDIS_DB: %w", err)
+		}
+		cfg.Redis.DB = n
+	}
+
+	if prefix := os.Getenv("REDIS_KEY_PREFIX"); prefix != "" {
+		cfg.Redis.KeyPrefix = prefix
+	}
+
 	// Logging configuration
 	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
 		cfg.Logging.Level = logLevel
@@ -234,6 +1193,13 @@ func validateConfig(cfg *Config) error {
 		return fmt.Errorf("telegram bot token is required")
 	}
 
+	if cfg.Telegram.ShutdownGracePeriod < 0 {
+		return fmt.Errorf("shutdown grace period must not be negative")
+	}
+	if cfg.Telegram.ShutdownGracePeriod == 0 {
+		cfg.Telegram.ShutdownGracePeriod = 30 * time.Second
+	}
+
 	if cfg.OpenAI.APIKey == "" {
 		return fmt.Errorf("OpenAI API key is required")
 	}
@@ -242,6 +1208,33 @@ func validateConfig(cfg *Config) error {
 		// Set default model if not specified
 		cfg.OpenAI.Model = "gpt-4.1-nano"
 	}
+	if cfg.OpenAI.EmbeddingModel == "" {
+		cfg.OpenAI.EmbeddingModel = DefaultEmbeddingModel
+	}
+
+	for i, fallback := range cfg.OpenAI.Fallbacks {
+		if fallback.Model == "" {
+			return fmt.Errorf("fallback model #%d has no model name", i+1)
+		}
+	}
+
+	if cfg.OpenAI.LatencyBudget < 0 {
+		return fmt.Errorf("latency budget must not be negative")
+	}
+
+	if cfg.OpenAI.RequestTimeout < 0 {
+		return fmt.Errorf("request timeout must not be negative")
+	}
+	if cfg.OpenAI.RequestTimeout == 0 {
+		cfg.OpenAI.RequestTimeout = 2 * time.Minute
+	}
+
+	if err := cfg.OpenAI.SamplingConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid sampling parameters: %w", err)
+	}
+	if _, err := prompt.Parse("system_prompt", cfg.OpenAI.SystemPrompt, cfg.OpenAI.PromptVars); err != nil {
+		return fmt.Errorf("invalid system prompt: %w", err)
+	}
 
 	// Parse allowed chat IDs from string if present
 	if cfg.Auth.AllowedChatIDsStr != "" {
@@ -255,6 +1248,336 @@ func validateConfig(cfg *Config) error {
 		return fmt.Errorf("at least one allowed chat ID is required")
 	}
 
+	// Default tools configuration
+	if cfg.Tools.MaxIterations == 0 {
+		cfg.Tools.MaxIterations = 5
+	}
+	if cfg.Tools.MaxIterations < 0 {
+		return fmt.Errorf("tools max_iterations must not be negative")
+	}
+
+	// Timezones of chats
+	if err := cfg.Timezones.load(); err != nil {
+		return err
+	}
+
+	// MCP servers
+	serverNames := make(map[string]bool)
+	for i, server := range cfg.MCP.Servers {
+		if !mcpServerNameRegex.MatchString(server.Name) {
+			return fmt.Errorf("MCP server #%d needs a name of letters, digits, '_' or '-'", i+1)
+		}
+		if serverNames[server.Name] {
+			return fmt.Errorf("duplicate MCP server name %q", server.Name)
+		}
+		serverNames[server.Name] = true
+
+		if (server.Command == "") == (server.URL == "") {
+			return fmt.Errorf("MCP server %q needs exactly one of command or url", server.Name)
+		}
+	}
+	// Confirmations are waited for within the request timeout, which has to
+	// leave time to finish the reply
+	if cfg.MCP.ConfirmTimeout < 0 {
+		return fmt.Errorf("MCP confirm timeout must not be negative")
+	}
+	if cfg.MCP.ConfirmTimeout == 0 {
+		cfg.MCP.ConfirmTimeout = time.Minute
+		if cfg.MCP.ConfirmTimeout >= cfg.OpenAI.RequestTimeout {
+			cfg.MCP.ConfirmTimeout = cfg.OpenAI.RequestTimeout / 2
+		}
+	}
+	if cfg.MCP.ConfirmTimeout >= cfg.OpenAI.RequestTimeout {
+		return fmt.Errorf("MCP confirm timeout %v must be shorter than the request timeout %v",
+			cfg.MCP.ConfirmTimeout, cfg.OpenAI.RequestTimeout)
+	}
+
+	// Default conversation store configuration
+	switch cfg.Conversations.Store {
+	case "":
+		cfg.Conversations.Store = "file"
+	case "memory", "file":
+	case "redis":
+		if !cfg.Redis.Enabled() {
+			return fmt.Errorf("conversation store redis requires redis.addr")
+		}
+	default:
+		return fmt.Errorf("unknown conversation store %q", cfg.Conversations.Store)
+	}
+	if cfg.Redis.KeyPrefix == "" {
+		cfg.Redis.KeyPrefix = "telegpt:"
+	}
+	if cfg.Redis.DB < 0 {
+		return fmt.Errorf("redis db must not be negative")
+	}
+
+	if cfg.Conversations.Path == "" {
+		cfg.Conversations.Path = "data/conversations.db"
+	}
+	if cfg.Conversations.Retention < 0 {
+		return fmt.Errorf("conversation retention must not be negative")
+	}
+	if cfg.Conversations.Retention == 0 {
+		cfg.Conversations.Retention = 30 * 24 * time.Hour
+	}
+	if cfg.Conversations.ExpireInterval < 0 {
+		return fmt.Errorf("conversation expire_interval must not be negative")
+	}
+	if cfg.Conversations.ExpireInterval == 0 {
+		cfg.Conversations.ExpireInterval = DefaultExpireInterval
+	}
+	if err := cfg.Conversations.RetentionPolicy.validate(); err != nil {
+		return fmt.Errorf("conversations: %w", err)
+	}
+	if cfg.Conversations.MaxHistory == 0 {
+		cfg.Conversations.MaxHistory = DefaultMaxHistory
+	}
+	if cfg.Conversations.IdleTimeout == 0 {
+		cfg.Conversations.IdleTimeout = DefaultIdleTimeout
+	}
+	// The store deletes sessions after the retention, so a longer idle timeout would never apply
+	if cfg.Conversations.IdleTimeout > cfg.Conversations.Retention {
+		return fmt.Errorf("conversation idle_timeout %v exceeds the retention of %v", cfg.Conversations.IdleTimeout, cfg.Conversations.Retention)
+	}
+	for chatID, policy := range cfg.Conversations.Chats {
+		if err := policy.validate(); err != nil {
+			return fmt.Errorf("conversations of chat %d: %w", chatID, err)
+		}
+		if idle := cfg.Conversations.PolicyFor(chatID).IdleTimeout; idle > cfg.Conversations.Retention {
+			return fmt.Errorf("conversations of chat %d: idle_timeout %v exceeds the retention of %v", chatID, idle, cfg.Conversations.Retention)
+		}
+	}
+	if err := validateEncryption(&cfg.Conversations.Encryption); err != nil {
+		return err
+	}
+
+	// Default usage accounting configuration
+	switch cfg.Usage.Store {
+	case "":
+		cfg.Usage.Store = "file"
+	case "memory", "file":
+	case "redis":
+		if !cfg.Redis.Enabled() {
+			return fmt.Errorf("usage store redis requires redis.addr")
+		}
+	default:
+		return fmt.Errorf("unknown usage store %q", cfg.Usage.Store)
+	}
+	if cfg.Usage.Path == "" {
+		cfg.Usage.Path = "data/usage.json"
+	}
+	if cfg.Usage.Timezone == "" {
+		cfg.Usage.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(cfg.Usage.Timezone); err != nil {
+		return fmt.Errorf("invalid usage timezone %q: %w", cfg.Usage.Timezone, err)
+	}
+	for model, price := range cfg.Usage.Prices {
+		if price.Prompt < 0 || price.CachedPrompt < 0 || price.Completion < 0 {
+			return fmt.Errorf("price of model %q must not be negative", model)
+		}
+	}
+
+	switch cfg.Memory.Store {
+	case "":
+		cfg.Memory.Store = "file"
+	case "memory", "file":
+	case "redis":
+		if cfg.Memory.Enabled && !cfg.Redis.Enabled() {
+			return fmt.Errorf("memory store redis requires redis.addr")
+		}
+	default:
+		return fmt.Errorf("unknown memory store %q", cfg.Memory.Store)
+	}
+	if cfg.Memory.Path == "" {
+		cfg.Memory.Path = "data/memories.json"
+	}
+	if cfg.Memory.MaxEntries < 0 || cfg.Memory.MaxInjected < 0 {
+		return fmt.Errorf("memory max_entries and max_injected must not be negative")
+	}
+	if cfg.Memory.MaxEntries == 0 {
+		cfg.Memory.MaxEntries = DefaultMaxMemories
+	}
+	if cfg.Memory.MaxInjected == 0 {
+		cfg.Memory.MaxInjected = DefaultInjectedMemories
+	}
+
+	switch cfg.Recall.Store {
+	case "":
+		cfg.Recall.Store = "file"
+	case "memory", "file":
+	default:
+		return fmt.Errorf("unknown recall store %q", cfg.Recall.Store)
+	}
+	if cfg.Recall.Path == "" {
+		cfg.Recall.Path = "data/recall"
+	}
+	if cfg.Recall.MaxExchanges < 0 || cfg.Recall.MaxResults < 0 || cfg.Recall.AutoResults < 0 {
+		return fmt.Errorf("recall max_exchanges, max_results and auto_results must not be negative")
+	}
+	if cfg.Recall.MaxExchanges == 0 {
+		cfg.Recall.MaxExchanges = DefaultRecallExchanges
+	}
+	if cfg.Recall.MaxResults == 0 {
+		cfg.Recall.MaxResults = DefaultRecallResults
+	}
+	if cfg.Recall.AutoResults == 0 {
+		cfg.Recall.AutoResults = DefaultAutoRecallResults
+	}
+	if cfg.Recall.MinScore < 0 || cfg.Recall.MinScore > 1 {
+		return fmt.Errorf("recall min_score must be between 0 and 1")
+	}
+	if cfg.Recall.MinScore == 0 {
+		cfg.Recall.MinScore = DefaultRecallMinScore
+	}
+
+	// Knowledge bases
+	baseNames := make(map[string]bool)
+	for i, base := range cfg.Knowledge.Bases {
+		if !menuNameRegex.MatchString(base.Name) {
+			return fmt.Errorf("knowledge base #%d needs a name of up to 32 letters, digits, '_' or '-'", i+1)
+		}
+		if baseNames[base.Name] {
+			return fmt.Errorf("duplicate knowledge base name %q", base.Name)
+		}
+		baseNames[base.Name] = true
+		if base.Dir == "" {
+			return fmt.Errorf("knowledge base %q needs a dir", base.Name)
+		}
+	}
+	if cfg.Knowledge.IndexDir == "" {
+		cfg.Knowledge.IndexDir = "data/knowledge"
+	}
+	if cfg.Knowledge.ChunkSize < 0 || cfg.Knowledge.ChunkOverlap < 0 || cfg.Knowledge.TopK < 0 || cfg.Knowledge.ReindexInterval < 0 {
+		return fmt.Errorf("knowledge chunk_size, chunk_overlap, top_k and reindex_interval must not be negative")
+	}
+	if cfg.Knowledge.ChunkSize == 0 {
+		cfg.Knowledge.ChunkSize = DefaultChunkSize
+	}
+	if cfg.Knowledge.ChunkOverlap == 0 {
+		cfg.Knowledge.ChunkOverlap = DefaultChunkOverlap
+	}
+	if cfg.Knowledge.ChunkOverlap >= cfg.Knowledge.ChunkSize {
+		return fmt.Errorf("knowledge chunk_overlap must be smaller than chunk_size")
+	}
+	if cfg.Knowledge.TopK == 0 {
+		cfg.Knowledge.TopK = DefaultKnowledgeChunks
+	}
+	if cfg.Knowledge.MinScore < 0 || cfg.Knowledge.MinScore > 1 {
+		return fmt.Errorf("knowledge min_score must be between 0 and 1")
+	}
+	if cfg.Knowledge.MinScore == 0 {
+		cfg.Knowledge.MinScore = DefaultKnowledgeScore
+	}
+	if cfg.Knowledge.ReindexInterval == 0 {
+		cfg.Knowledge.ReindexInterval = DefaultReindexInterval
+	}
+
+	// Moderation
+	switch cfg.Moderation.Check {
+	case "":
+		cfg.Moderation.Check = "both"
+	case "input", "output", "both":
+	default:
+		return fmt.Errorf("moderation check must be input, output or both, got %q", cfg.Moderation.Check)
+	}
+	if cfg.Moderation.Model == "" {
+		cfg.Moderation.Model = DefaultModerationModel
+	}
+	if cfg.Moderation.DefaultAction == "" {
+		cfg.Moderation.DefaultAction = ModerationBlock
+	}
+	for category, action := range cfg.Moderation.Actions {
+		if !validModerationAction(action) {
+			return fmt.Errorf("moderation action of %q must be block, warn or log, got %q", category, action)
+		}
+	}
+	if !validModerationAction(cfg.Moderation.DefaultAction) {
+		return fmt.Errorf("moderation default_action must be block, warn or log, got %q", cfg.Moderation.DefaultAction)
+	}
+	for i, rule := range cfg.Moderation.Rules {
+		if rule.Category == "" {
+			return fmt.Errorf("moderation rule #%d needs a category", i+1)
+		}
+		if len(rule.Patterns) == 0 && len(rule.Keywords) == 0 {
+			return fmt.Errorf("moderation rule %q needs patterns or keywords", rule.Category)
+		}
+		for _, pattern := range rule.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("invalid pattern in moderation rule %q: %w", rule.Category, err)
+			}
+		}
+	}
+	if cfg.Moderation.Enabled && !cfg.Moderation.API && len(cfg.Moderation.Rules) == 0 {
+		return fmt.Errorf("moderation needs api or rules")
+	}
+	if cfg.Moderation.AlertAfter < 0 || cfg.Moderation.AlertWindow < 0 {
+		return fmt.Errorf("moderation alert_after and alert_window must not be negative")
+	}
+	if cfg.Moderation.AlertAfter == 0 {
+		cfg.Moderation.AlertAfter = 3
+	}
+	if cfg.Moderation.AlertWindow == 0 {
+		cfg.Moderation.AlertWindow = 24 * time.Hour
+	}
+
+	// Redaction
+	for _, name := range cfg.Redaction.Detectors {
+		known := false
+		for _, detector := range RedactionDetectors {
+			known = known || name == detector
+		}
+		if !known {
+			return fmt.Errorf("unknown redaction detector %q, expected one of %s", name, strings.Join(RedactionDetectors, ", "))
+		}
+	}
+	for i, pattern := range cfg.Redaction.Patterns {
+		if !redactionNameRegex.MatchString(pattern.Name) {
+			return fmt.Errorf("redaction pattern #%d needs a name of letters, digits or '_'", i+1)
+		}
+		re, err := regexp.Compile(pattern.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %w", pattern.Name, err)
+		}
+		if re.NumSubexp() > 1 {
+			return fmt.Errorf("redaction pattern %q may have at most one capture group", pattern.Name)
+		}
+	}
+
+	// Personas
+	personaNames := make(map[string]bool)
+	for i, persona := range cfg.Personas.List {
+		if !menuNameRegex.MatchString(persona.Name) {
+			return fmt.Errorf("persona #%d needs a name of up to 32 letters, digits, '_' or '-'", i+1)
+		}
+		if personaNames[persona.Name] {
+			return fmt.Errorf("duplicate persona name %q", persona.Name)
+		}
+		personaNames[persona.Name] = true
+		if _, err := prompt.Parse(persona.Name, persona.SystemPrompt, cfg.OpenAI.PromptVars); err != nil {
+			return fmt.Errorf("invalid system prompt of persona %q: %w", persona.Name, err)
+		}
+		if err := cfg.OpenAI.SamplingConfig.Merge(persona.SamplingConfig).Validate(); err != nil {
+			return fmt.Errorf("invalid sampling parameters of persona %q: %w", persona.Name, err)
+		}
+	}
+	if cfg.Personas.Default != "" && !personaNames[cfg.Personas.Default] {
+		return fmt.Errorf("unknown default persona %q", cfg.Personas.Default)
+	}
+	for chatID, name := range cfg.Personas.Chats {
+		if !personaNames[name] {
+			return fmt.Errorf("unknown persona %q for chat %d", name, chatID)
+		}
+	}
+
+	// Quotas
+	for role, limits := range cfg.Quotas.Roles {
+		if limits.DailyTokens < 0 || limits.MonthlyTokens < 0 || limits.DailyRequests < 0 ||
+			limits.MonthlyRequests < 0 || limits.DailyCost < 0 || limits.MonthlyCost < 0 {
+			return fmt.Errorf("quota limits of role %q must not be negative", role)
+		}
+	}
+
 	// Default logging configuration
 	if cfg.Logging.Level == "" {
 		cfg.Logging.Level = "info"
@@ -267,3 +1590,50 @@ func validateConfig(cfg *Config) error {
 
 	return nil
 }
+
+// validateEncryption adds the keys of the key file and checks that the active key is one of them
+func validateEncryption(e *EncryptionConfig) error {
+	if e.KeyFile != "" {
+		data, err := os.ReadFile(e.KeyFile)
+		if err != nil {
+			return fmt.Errorf("error reading encryption key file: %w", err)
+		}
+		keys, err := encryption.ParseKeys(string(data))
+		if err != nil {
+			return fmt.Errorf("encryption key file %s: %w", e.KeyFile, err)
+		}
+		if e.Keys == nil {
+			e.Keys = make(map[string][]byte)
+		}
+		for id, key := range keys {
+			if _, ok := e.Keys[id]; ok {
+				return fmt.Errorf("encryption key %q is set in ENCRYPTION_KEYS and the key file", id)
+			}
+			e.Keys[id] = key
+		}
+	}
+
+	if !e.Enabled() {
+		if e.ActiveKey != "" {
+			return fmt.Errorf("encryption active_key %q is set but no encryption keys are configured", e.ActiveKey)
+		}
+		return nil
+	}
+	if e.ActiveKey == "" {
+		if len(e.Keys) > 1 {
+			return fmt.Errorf("encryption active_key must name one of the %d configured keys", len(e.Keys))
+		}
+		for id := range e.Keys {
+			e.ActiveKey = id
+		}
+	}
+	if _, ok := e.Keys[e.ActiveKey]; !ok {
+		return fmt.Errorf("encryption active_key %q is not one of the configured keys", e.ActiveKey)
+	}
+	return nil
+}
+
+// validModerationAction reports whether action is a moderation action
+func validModerationAction(action string) bool {
+	return action == ModerationBlock || action == ModerationWarn || action == ModerationLog
+}
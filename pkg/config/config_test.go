@@ -1,9 +1,15 @@
 package config
 
 import (
+	"bytes"
+	"encoding/base64"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/itswryu/telegpt/pkg/encryption"
 	"gopkg.in/yaml.v3"
 )
 
@@ -246,22 +252,6 @@ func TestAuthConfigUnmarshalYAML(t *testing.T) {
 }
 
 func TestLoadConfigWithStringAllowedChatIDs(t *testing.T) {
-	// Save original config file
-	origFile := "config.yaml"
-	backupFile := "config.yaml.bak"
-	configExists := false
-
-	if _, err := os.Stat(origFile); err == nil {
-		configExists = true
-		if err := os.Rename(origFile, backupFile); err != nil {
-			t.Fatalf("Failed to backup config: %v", err)
-		}
-		defer func() {
-			os.Remove(origFile)
-			os.Rename(backupFile, origFile)
-		}()
-	}
-
 	// Create test config
 	testConfig := []byte(`
 telegram:
@@ -275,14 +265,8 @@ logging:
   level: "debug"
 `)
 
-	if err := os.WriteFile(origFile, testConfig, 0644); err != nil {
-		t.Fatalf("Failed to write test config: %v", err)
-	}
-	defer func() {
-		if !configExists {
-			os.Remove(origFile)
-		}
-	}()
+	cleanup := createTempConfigFile(t, testConfig)
+	defer cleanup()
 
 	// Load config and test
 	cfg, err := LoadConfig()
@@ -300,31 +284,26 @@ logging:
 	}
 }
 
-// 설정 파일을 임시로 생성하는 헬퍼 함수
+// 설정 파일을 임시 디렉터리에 생성하고 그 디렉터리로 이동하는 헬퍼 함수.
+// 패키지 디렉터리에는 파일을 남기지 않습니다.
 func createTempConfigFile(t *testing.T, content []byte) func() {
-	origFile := "config.yaml"
-	backupFile := "config.yaml.bak"
-	configExists := false
-
-	// 기존 설정 파일 백업
-	if _, err := os.Stat(origFile); err == nil {
-		configExists = true
-		if err := os.Rename(origFile, backupFile); err != nil {
-			t.Fatalf("Failed to backup config: %v", err)
-		}
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), content, 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
 	}
 
-	// 테스트용 설정 파일 생성
-	if err := os.WriteFile(origFile, content, 0644); err != nil {
-		t.Fatalf("Failed to write test config: %v", err)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
 	}
 
-	// 설정 파일 정리를 위한 클린업 함수 반환
+	// 원래 작업 디렉터리로 돌아가는 클린업 함수 반환
 	return func() {
-		os.Remove(origFile)
-		if configExists {
-			os.Rename(backupFile, origFile)
-		}
+		os.Chdir(wd)
 	}
 }
 
@@ -405,24 +384,6 @@ func validateChatIDs(t *testing.T, expected, actual []int64) {
 }
 
 func TestLoadFewShotConfig(t *testing.T) {
-	// 원본 config 파일 백업
-	origFile := "config.yaml"
-	backupFile := "config.yaml.bak"
-	configExists := false
-
-	if _, err := os.Stat(origFile); err == nil {
-		configExists = true
-		if err := os.Rename(origFile, backupFile); err != nil {
-			t.Fatalf("Failed to backup config: %v", err)
-		}
-		defer func() {
-			os.Remove(origFile)
-			if configExists {
-				os.Rename(backupFile, origFile)
-			}
-		}()
-	}
-
 	// 퓨샷 설정이 있는 설정 파일 생성
 	testConfig := []byte(`
 openai:
@@ -441,9 +402,8 @@ auth:
   allowed_chat_ids: "123456789"
 `)
 
-	if err := os.WriteFile(origFile, testConfig, 0644); err != nil {
-		t.Fatalf("Failed to write test config: %v", err)
-	}
+	cleanup := createTempConfigFile(t, testConfig)
+	defer cleanup()
 
 	// 설정 로드 테스트
 	cfg, err := LoadConfig()
@@ -486,24 +446,6 @@ auth:
 }
 
 func TestFewShotEnvironmentVariables(t *testing.T) {
-	// 원본 config 파일 백업
-	origFile := "config.yaml"
-	backupFile := "config.yaml.bak"
-	configExists := false
-
-	if _, err := os.Stat(origFile); err == nil {
-		configExists = true
-		if err := os.Rename(origFile, backupFile); err != nil {
-			t.Fatalf("Failed to backup config: %v", err)
-		}
-		defer func() {
-			os.Remove(origFile)
-			if configExists {
-				os.Rename(backupFile, origFile)
-			}
-		}()
-	}
-
 	// 최소한의 설정 파일 생성
 	minimalConfig := []byte(`
 openai:
@@ -515,9 +457,8 @@ auth:
   allowed_chat_ids: "123456789"
 `)
 
-	if err := os.WriteFile(origFile, minimalConfig, 0644); err != nil {
-		t.Fatalf("Failed to write test config: %v", err)
-	}
+	cleanup := createTempConfigFile(t, minimalConfig)
+	defer cleanup()
 
 	// 환경 변수 설정
 	os.Setenv("OPENAI_SYSTEM_PROMPT", "환경 변수 테스트 프롬프트")
@@ -543,3 +484,785 @@ auth:
 		t.Error("환경 변수에서 설정된 퓨샷 활성화 설정이 적용되지 않았습니다")
 	}
 }
+
+func TestFallbackEnvironmentVariables(t *testing.T) {
+	os.Setenv("OPENAI_FALLBACK_MODELS", "gpt-4o-mini, gpt-3.5-turbo")
+	os.Setenv("OPENAI_LATENCY_BUDGET", "15s")
+	defer func() {
+		os.Unsetenv("OPENAI_FALLBACK_MODELS")
+		os.Unsetenv("OPENAI_LATENCY_BUDGET")
+	}()
+
+	cfg := &Config{}
+	if err := loadFromEnv(cfg); err != nil {
+		t.Fatalf("loadFromEnv() error = %v", err)
+	}
+
+	if len(cfg.OpenAI.Fallbacks) != 2 ||
+		cfg.OpenAI.Fallbacks[0].Model != "gpt-4o-mini" ||
+		cfg.OpenAI.Fallbacks[1].Model != "gpt-3.5-turbo" {
+		t.Errorf("loadFromEnv() fallbacks = %+v, expected [gpt-4o-mini gpt-3.5-turbo]", cfg.OpenAI.Fallbacks)
+	}
+
+	if cfg.OpenAI.LatencyBudget != 15*time.Second {
+		t.Errorf("loadFromEnv() latency budget = %v, expected %v", cfg.OpenAI.LatencyBudget, 15*time.Second)
+	}
+}
+
+func TestLoadFallbackConfig(t *testing.T) {
+	cleanup := createTempConfigFile(t, []byte(`
+telegram:
+  bot_token: "test-token"
+openai:
+  api_key: "test-key"
+  model: "gpt-4.1-nano"
+  latency_budget: 20s
+  fallbacks:
+    - model: "gpt-4o-mini"
+    - model: "llama-3.1-70b"
+      base_url: "https://api.groq.com/openai/v1"
+      api_key: "groq-key"
+auth:
+  allowed_chat_ids: "123456789"
+`))
+	defer cleanup()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	if cfg.OpenAI.LatencyBudget != 20*time.Second {
+		t.Errorf("Expected latency budget %v, got %v", 20*time.Second, cfg.OpenAI.LatencyBudget)
+	}
+
+	if len(cfg.OpenAI.Fallbacks) != 2 {
+		t.Fatalf("Expected 2 fallbacks, got %d", len(cfg.OpenAI.Fallbacks))
+	}
+
+	groq := cfg.OpenAI.Fallbacks[1]
+	if groq.Model != "llama-3.1-70b" || groq.BaseURL != "https://api.groq.com/openai/v1" || groq.APIKey != "groq-key" {
+		t.Errorf("Unexpected second fallback: %+v", groq)
+	}
+}
+
+func TestLoadToolsConfig(t *testing.T) {
+	cleanup := createTempConfigFile(t, []byte(`
+telegram:
+  bot_token: "test-token"
+openai:
+  api_key: "test-key"
+auth:
+  allowed_chat_ids: "111,222"
+  admin_chat_ids: [111]
+timezones:
+  default: "Asia/Seoul"
+  chats:
+    222: "Europe/Berlin"
+tools:
+  enabled: true
+  roles:
+    user:
+      deny: ["calculate"]
+`))
+	defer cleanup()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	if cfg.Auth.RoleOf(111) != RoleAdmin || cfg.Auth.RoleOf(222) != RoleUser {
+		t.Errorf("Unexpected roles: 111=%s, 222=%s", cfg.Auth.RoleOf(111), cfg.Auth.RoleOf(222))
+	}
+
+	if cfg.Tools.MaxIterations != 5 {
+		t.Errorf("Expected default max iterations 5, got %d", cfg.Tools.MaxIterations)
+	}
+
+	if !cfg.Tools.Permits(111, RoleAdmin, "calculate") {
+		t.Error("Admin should be permitted to use calculate")
+	}
+	if cfg.Tools.Permits(222, RoleUser, "calculate") {
+		t.Error("User role should not be permitted to use calculate")
+	}
+
+	if tz := cfg.Timezones.LocationFor(222).String(); tz != "Europe/Berlin" {
+		t.Errorf("Expected chat timezone Europe/Berlin, got %s", tz)
+	}
+	if tz := cfg.Timezones.LocationFor(111).String(); tz != "Asia/Seoul" {
+		t.Errorf("Expected default timezone Asia/Seoul, got %s", tz)
+	}
+}
+
+func TestValidateConfigRejectsInvalidTimezone(t *testing.T) {
+	for _, timezones := range []TimezoneConfig{
+		{Default: "Mars/Olympus"},
+		{Chats: map[int64]string{222: "Mars/Olympus"}},
+	} {
+		cfg := &Config{
+			Telegram:  TelegramConfig{BotToken: testToken},
+			OpenAI:    OpenAIConfig{APIKey: testKey},
+			Auth:      AuthConfig{AllowedChatIDsStr: testChatID},
+			Timezones: timezones,
+		}
+
+		if err := validateConfig(cfg); err == nil {
+			t.Errorf("validateConfig() expected an error for the invalid timezone in %+v", timezones)
+		}
+	}
+}
+
+func TestValidateMCPServers(t *testing.T) {
+	base := func(servers ...MCPServerConfig) *Config {
+		return &Config{
+			Telegram: TelegramConfig{BotToken: testToken},
+			OpenAI:   OpenAIConfig{APIKey: testKey},
+			Auth:     AuthConfig{AllowedChatIDsStr: testChatID},
+			MCP:      MCPConfig{Servers: servers},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		cfg         *Config
+		expectError bool
+	}{
+		{"Stdio server", base(MCPServerConfig{Name: "jira", Command: "jira-mcp"}), false},
+		{"HTTP server", base(MCPServerConfig{Name: "wiki", URL: "http://localhost:8080/mcp"}), false},
+		{"Missing name", base(MCPServerConfig{Command: "jira-mcp"}), true},
+		{"Invalid name", base(MCPServerConfig{Name: "my server", Command: "jira-mcp"}), true},
+		{"Both command and url", base(MCPServerConfig{Name: "jira", Command: "jira-mcp", URL: "http://localhost"}), true},
+		{"Neither command nor url", base(MCPServerConfig{Name: "jira"}), true},
+		{"Duplicate names", base(MCPServerConfig{Name: "jira", Command: "a"}, MCPServerConfig{Name: "jira", Command: "b"}), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfig(tt.cfg)
+			if (err != nil) != tt.expectError {
+				t.Errorf("validateConfig() error = %v, expectError %v", err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestValidateConfirmTimeout(t *testing.T) {
+	base := func(request, confirm time.Duration) *Config {
+		return &Config{
+			Telegram: TelegramConfig{BotToken: testToken},
+			OpenAI:   OpenAIConfig{APIKey: testKey, RequestTimeout: request},
+			Auth:     AuthConfig{AllowedChatIDsStr: testChatID},
+			MCP:      MCPConfig{ConfirmTimeout: confirm},
+		}
+	}
+
+	cfg := base(0, 0)
+	if err := validateConfig(cfg); err != nil || cfg.MCP.ConfirmTimeout != time.Minute {
+		t.Errorf("기본 승인 대기 시간 = %v, %v", cfg.MCP.ConfirmTimeout, err)
+	}
+	cfg = base(30*time.Second, 0)
+	if err := validateConfig(cfg); err != nil || cfg.MCP.ConfirmTimeout != 15*time.Second {
+		t.Errorf("짧은 요청 제한 시간의 승인 대기 시간 = %v, %v", cfg.MCP.ConfirmTimeout, err)
+	}
+
+	for _, cfg := range []*Config{base(0, -time.Second), base(time.Minute, time.Minute), base(time.Minute, 2*time.Minute)} {
+		if err := validateConfig(cfg); err == nil {
+			t.Errorf("승인 대기 시간 %v (요청 제한 %v)은 거부되어야 합니다", cfg.MCP.ConfirmTimeout, cfg.OpenAI.RequestTimeout)
+		}
+	}
+}
+
+func TestSamplingConfig(t *testing.T) {
+	var s SamplingConfig
+	for name, value := range map[string]string{
+		"temperature":       "0.5",
+		"top_p":             "0.9",
+		"max_tokens":        "512",
+		"presence_penalty":  "-1",
+		"frequency_penalty": "1.5",
+		"seed":              "42",
+		"stop":              "END, ###",
+	} {
+		if err := s.Set(name, value); err != nil {
+			t.Fatalf("Set(%q, %q) error = %v", name, value, err)
+		}
+	}
+	if err := s.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if *s.MaxTokens != 512 || *s.Seed != 42 || len(s.Stop) != 2 || s.Stop[1] != "###" {
+		t.Errorf("Unexpected parsed values: %+v", s)
+	}
+
+	if err := s.Set("temperature", "default"); err != nil || s.Temperature != nil {
+		t.Errorf("Set(temperature, default) should unset the value, got %v (err %v)", s.Temperature, err)
+	}
+	if err := s.Set("temperature", "hot"); err == nil {
+		t.Error("Set() expected an error for a non-numeric value")
+	}
+	if err := s.Set("creativity", "1"); err == nil {
+		t.Error("Set() expected an error for an unknown parameter")
+	}
+
+	invalid := []func(*SamplingConfig){
+		func(s *SamplingConfig) { s.Set("temperature", "2.5") },
+		func(s *SamplingConfig) { s.Set("top_p", "0") },
+		func(s *SamplingConfig) { s.Set("max_tokens", "0") },
+		func(s *SamplingConfig) { s.Set("presence_penalty", "-3") },
+		func(s *SamplingConfig) { s.Set("stop", "a,b,c,d,e") },
+	}
+	for i, apply := range invalid {
+		var s SamplingConfig
+		apply(&s)
+		if err := s.Validate(); err == nil {
+			t.Errorf("Validate() expected an error for invalid case #%d: %+v", i, s)
+		}
+	}
+
+	low, high := 0.1, 0.9
+	merged := SamplingConfig{Temperature: &low, Stop: []string{"a"}}.Merge(SamplingConfig{Temperature: &high})
+	if *merged.Temperature != 0.9 || len(merged.Stop) != 1 {
+		t.Errorf("Merge() = %+v, expected the override temperature and the base stop", merged)
+	}
+}
+
+func TestLoadSamplingConfig(t *testing.T) {
+	cleanup := createTempConfigFile(t, []byte(`
+telegram:
+  bot_token: "test-token"
+openai:
+  api_key: "test-key"
+  temperature: 0.3
+  max_tokens: 800
+  stop: ["END"]
+auth:
+  allowed_chat_ids: "123456789"
+`))
+	defer cleanup()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.OpenAI.Temperature == nil || *cfg.OpenAI.Temperature != 0.3 {
+		t.Errorf("Expected temperature 0.3, got %v", cfg.OpenAI.Temperature)
+	}
+	if cfg.OpenAI.MaxTokens == nil || *cfg.OpenAI.MaxTokens != 800 {
+		t.Errorf("Expected max_tokens 800, got %v", cfg.OpenAI.MaxTokens)
+	}
+	if cfg.OpenAI.TopP != nil {
+		t.Errorf("Expected top_p to be unset, got %v", *cfg.OpenAI.TopP)
+	}
+}
+
+func TestLoadQuotaConfig(t *testing.T) {
+	cleanup := createTempConfigFile(t, []byte(`
+telegram:
+  bot_token: "test-token"
+openai:
+  api_key: "test-key"
+auth:
+  allowed_chat_ids: "123456789"
+quotas:
+  roles:
+    user:
+      daily_tokens: 50000
+      monthly_cost: 5
+  users:
+    123456789:
+      daily_tokens: 100000
+      monthly_cost: -1
+      daily_requests: 30
+`))
+	defer cleanup()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	limits := cfg.Quotas.LimitsFor(123456789, RoleUser)
+	expected := QuotaLimits{DailyTokens: 100000, DailyRequests: 30}
+	if limits != expected {
+		t.Errorf("LimitsFor() = %+v, expected %+v", limits, expected)
+	}
+	if limits := cfg.Quotas.LimitsFor(1, RoleUser); limits.MonthlyCost != 5 || limits.DailyTokens != 50000 {
+		t.Errorf("LimitsFor() = %+v, expected the user role limits", limits)
+	}
+	if limits := cfg.Quotas.LimitsFor(1, RoleAdmin); limits != (QuotaLimits{}) {
+		t.Errorf("LimitsFor() = %+v, expected no limits for admins", limits)
+	}
+
+	cfg.Quotas.Roles[RoleUser] = QuotaLimits{DailyTokens: -1}
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig() expected an error for a negative role limit")
+	}
+}
+
+func TestLoadRetentionConfig(t *testing.T) {
+	cleanup := createTempConfigFile(t, []byte(`
+telegram:
+  bot_token: "test-token"
+openai:
+  api_key: "test-key"
+auth:
+  allowed_chat_ids: "123456789"
+conversations:
+  idle_timeout: 1h
+  max_session_age: 24h
+  chats:
+    123456789:
+      max_history: 40
+      idle_timeout: 4h
+`))
+	defer cleanup()
+	t.Setenv("CONVERSATION_MAX_HISTORY", "6")
+	t.Setenv("CONVERSATION_CARRY_SUMMARY", "true")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.Conversations.CarrySummary {
+		t.Error("CarrySummary = false, expected true from CONVERSATION_CARRY_SUMMARY")
+	}
+
+	expected := RetentionPolicy{MaxHistory: 6, IdleTimeout: time.Hour, MaxSessionAge: 24 * time.Hour}
+	if policy := cfg.Conversations.PolicyFor(1); policy != expected {
+		t.Errorf("PolicyFor(1) = %+v, expected %+v", policy, expected)
+	}
+	expected = RetentionPolicy{MaxHistory: 40, IdleTimeout: 4 * time.Hour, MaxSessionAge: 24 * time.Hour}
+	if policy := cfg.Conversations.PolicyFor(123456789); policy != expected {
+		t.Errorf("PolicyFor(123456789) = %+v, expected %+v", policy, expected)
+	}
+	if n := cfg.Conversations.MaxStoredHistory(); n != 40 {
+		t.Errorf("MaxStoredHistory() = %d, expected the longest history of 40", n)
+	}
+
+	// 설정하지 않은 값은 기본값을 사용
+	var empty ConversationConfig
+	if policy := empty.PolicyFor(1); policy.MaxHistory != DefaultMaxHistory || policy.IdleTimeout != DefaultIdleTimeout || policy.MaxSessionAge != 0 {
+		t.Errorf("PolicyFor() without configuration = %+v", policy)
+	}
+
+	cfg.Conversations.Chats[123456789] = RetentionPolicy{MaxHistory: -1}
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig() expected an error for a negative per-chat history length")
+	}
+	cfg.Conversations.Chats[123456789] = RetentionPolicy{IdleTimeout: 1000 * time.Hour}
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig() expected an error for an idle timeout longer than the retention")
+	}
+}
+
+func TestValidateRedisStores(t *testing.T) {
+	cfg := &Config{
+		Telegram: TelegramConfig{BotToken: testToken},
+		OpenAI:   OpenAIConfig{APIKey: testKey},
+		Auth:     AuthConfig{AllowedChatIDs: []int64{123456789}},
+	}
+	cfg.Conversations.Store = "redis"
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig() expected an error for the redis store without redis.addr")
+	}
+
+	cfg.Redis.Addr = "localhost:6379"
+	cfg.Usage.Store = "redis"
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("validateConfig() error = %v", err)
+	}
+	if cfg.Redis.KeyPrefix != "telegpt:" {
+		t.Errorf("Expected default key prefix telegpt:, got %q", cfg.Redis.KeyPrefix)
+	}
+	if cfg.Conversations.Retention != 30*24*time.Hour {
+		t.Errorf("Expected default retention of 30 days, got %v", cfg.Conversations.Retention)
+	}
+
+	cfg.Conversations.Retention = -time.Hour
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig() expected an error for a negative retention")
+	}
+}
+
+func TestValidateEncryption(t *testing.T) {
+	key := func(b byte) string {
+		return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, encryption.KeySize))
+	}
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keyFile, []byte("# 키 파일\nold:"+key(1)+"\nnew:"+key(2)+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	// 키가 하나뿐이면 자동으로 활성 키가 됨
+	single := EncryptionConfig{Keys: map[string][]byte{"only": bytes.Repeat([]byte{1}, encryption.KeySize)}}
+	if err := validateEncryption(&single); err != nil || single.ActiveKey != "only" {
+		t.Errorf("validateEncryption() = %v, active key %q", err, single.ActiveKey)
+	}
+
+	// 키가 여러 개면 활성 키를 지정해야 함
+	multiple := EncryptionConfig{KeyFile: keyFile}
+	if err := validateEncryption(&multiple); err == nil {
+		t.Error("validateEncryption() expected an error without an active key")
+	}
+	multiple = EncryptionConfig{KeyFile: keyFile, ActiveKey: "new"}
+	if err := validateEncryption(&multiple); err != nil || len(multiple.Keys) != 2 {
+		t.Errorf("validateEncryption() = %v, keys %d", err, len(multiple.Keys))
+	}
+
+	for name, cfg := range map[string]EncryptionConfig{
+		"unknown active key":  {KeyFile: keyFile, ActiveKey: "missing"},
+		"active key only":     {ActiveKey: "new"},
+		"missing key file":    {KeyFile: filepath.Join(t.TempDir(), "missing")},
+		"key in env and file": {KeyFile: keyFile, ActiveKey: "new", Keys: map[string][]byte{"old": bytes.Repeat([]byte{3}, encryption.KeySize)}},
+	} {
+		if err := validateEncryption(&cfg); err == nil {
+			t.Errorf("validateEncryption(%s) expected an error", name)
+		}
+	}
+
+	if (&EncryptionConfig{}).Enabled() {
+		t.Error("Enabled() without keys = true")
+	}
+}
+
+func TestLoadMemoryConfig(t *testing.T) {
+	cleanup := createTempConfigFile(t, []byte(`
+telegram:
+  bot_token: "test-token"
+openai:
+  api_key: "test-key"
+auth:
+  allowed_chat_ids: "123456789"
+memory:
+  enabled: true
+  max_entries: 20
+`))
+	defer cleanup()
+	t.Setenv("MEMORY_EXTRACT", "yes")
+	t.Setenv("MEMORY_PATH", "/tmp/memories.json")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	expected := MemoryConfig{
+		Enabled:     true,
+		Store:       "file",
+		Path:        "/tmp/memories.json",
+		MaxEntries:  20,
+		MaxInjected: DefaultInjectedMemories,
+		Extract:     true,
+	}
+	if cfg.Memory != expected {
+		t.Errorf("Memory = %+v, expected %+v", cfg.Memory, expected)
+	}
+
+	cfg.Memory.Store = "redis"
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig() expected an error for the redis memory store without redis.addr")
+	}
+	cfg.Memory.Store = "file"
+	cfg.Memory.MaxInjected = -1
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig() expected an error for a negative max_injected")
+	}
+}
+
+func TestLoadRecallConfig(t *testing.T) {
+	cleanup := createTempConfigFile(t, []byte(`
+telegram:
+  bot_token: "test-token"
+openai:
+  api_key: "test-key"
+auth:
+  allowed_chat_ids: "123456789"
+recall:
+  enabled: true
+  max_results: 8
+`))
+	defer cleanup()
+	t.Setenv("RECALL_AUTO", "1")
+	t.Setenv("RECALL_PATH", "/tmp/recall")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	expected := RecallConfig{
+		Enabled:      true,
+		Store:        "file",
+		Path:         "/tmp/recall",
+		MaxExchanges: DefaultRecallExchanges,
+		MaxResults:   8,
+		Auto:         true,
+		AutoResults:  DefaultAutoRecallResults,
+		MinScore:     DefaultRecallMinScore,
+	}
+	if cfg.Recall != expected {
+		t.Errorf("Recall = %+v, expected %+v", cfg.Recall, expected)
+	}
+	if cfg.OpenAI.EmbeddingModel != DefaultEmbeddingModel {
+		t.Errorf("EmbeddingModel = %q, expected %q", cfg.OpenAI.EmbeddingModel, DefaultEmbeddingModel)
+	}
+
+	cfg.Recall.Store = "redis"
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig() expected an error for the unsupported redis recall store")
+	}
+	cfg.Recall.Store = "file"
+	cfg.Recall.MinScore = 1.5
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig() expected an error for a min_score above 1")
+	}
+}
+
+func TestLoadKnowledgeConfig(t *testing.T) {
+	cleanup := createTempConfigFile(t, []byte(`
+telegram:
+  bot_token: "test-token"
+openai:
+  api_key: "test-key"
+auth:
+  allowed_chat_ids: "123456789"
+knowledge:
+  top_k: 6
+  bases:
+    - name: runbooks
+      dir: docs/runbooks
+      chats: [123456789]
+`))
+	defer cleanup()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.Knowledge.IndexDir != "data/knowledge" || cfg.Knowledge.ChunkSize != DefaultChunkSize ||
+		cfg.Knowledge.ChunkOverlap != DefaultChunkOverlap || cfg.Knowledge.TopK != 6 ||
+		cfg.Knowledge.MinScore != DefaultKnowledgeScore || cfg.Knowledge.ReindexInterval != DefaultReindexInterval {
+		t.Errorf("Knowledge = %+v, expected defaults", cfg.Knowledge)
+	}
+	base := cfg.Knowledge.Bases[0]
+	if !base.UsedBy(123456789) || base.UsedBy(1) {
+		t.Errorf("UsedBy() of %+v", base)
+	}
+	if everyone := (KnowledgeBaseConfig{Name: "all", Dir: "docs"}); !everyone.UsedBy(1) {
+		t.Error("UsedBy() without chats should include every chat")
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*KnowledgeConfig)
+	}{
+		{"duplicate name", func(k *KnowledgeConfig) { k.Bases = append(k.Bases, k.Bases[0]) }},
+		{"invalid name", func(k *KnowledgeConfig) { k.Bases[0].Name = "run books" }},
+		{"long name", func(k *KnowledgeConfig) { k.Bases[0].Name = strings.Repeat("r", 33) }},
+		{"missing dir", func(k *KnowledgeConfig) { k.Bases[0].Dir = "" }},
+		{"overlap not below chunk size", func(k *KnowledgeConfig) { k.ChunkOverlap = k.ChunkSize }},
+		{"negative top_k", func(k *KnowledgeConfig) { k.TopK = -1 }},
+	}
+	for _, tt := range tests {
+		invalid := *cfg
+		invalid.Knowledge.Bases = append([]KnowledgeBaseConfig(nil), cfg.Knowledge.Bases...)
+		tt.modify(&invalid.Knowledge)
+		if err := validateConfig(&invalid); err == nil {
+			t.Errorf("validateConfig() expected an error for %s", tt.name)
+		}
+	}
+}
+
+func TestLoadModerationConfig(t *testing.T) {
+	cleanup := createTempConfigFile(t, []byte(`
+telegram:
+  bot_token: "test-token"
+openai:
+  api_key: "test-key"
+auth:
+  allowed_chat_ids: "123456789"
+moderation:
+  rules:
+    - category: secrets
+      patterns: ['(?i)password\s*[:=]']
+  actions:
+    harassment: warn
+`))
+	defer cleanup()
+	t.Setenv("MODERATION_ENABLED", "true")
+	t.Setenv("MODERATION_API", "1")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	m := cfg.Moderation
+	if !m.Enabled || !m.API || m.Check != "both" || m.Model != DefaultModerationModel ||
+		m.DefaultAction != ModerationBlock || m.AlertAfter != 3 || m.AlertWindow != 24*time.Hour {
+		t.Errorf("Moderation = %+v, expected defaults", m)
+	}
+	if !m.ChecksInput() || !m.ChecksOutput() {
+		t.Error("check: both는 입력과 출력을 모두 검사해야 함")
+	}
+	if m.ActionFor("harassment/threatening") != ModerationWarn || m.ActionFor("violence") != ModerationBlock {
+		t.Errorf("ActionFor() = %q, %q", m.ActionFor("harassment/threatening"), m.ActionFor("violence"))
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*ModerationConfig)
+	}{
+		{"invalid check", func(m *ModerationConfig) { m.Check = "sometimes" }},
+		{"invalid action", func(m *ModerationConfig) { m.Actions = map[string]string{"hate": "ban"} }},
+		{"invalid pattern", func(m *ModerationConfig) {
+			m.Rules = []ModerationRule{{Category: "bad", Patterns: []string{"("}}}
+		}},
+		{"rule without matchers", func(m *ModerationConfig) { m.Rules = []ModerationRule{{Category: "empty"}} }},
+		{"nothing to check with", func(m *ModerationConfig) { m.API, m.Rules = false, nil }},
+	}
+	for _, tt := range tests {
+		invalid := *cfg
+		tt.modify(&invalid.Moderation)
+		if err := validateConfig(&invalid); err == nil {
+			t.Errorf("validateConfig() expected an error for %s", tt.name)
+		}
+	}
+}
+
+func TestLoadRedactionConfig(t *testing.T) {
+	cleanup := createTempConfigFile(t, []byte(`
+telegram:
+  bot_token: "test-token"
+openai:
+  api_key: "test-key"
+auth:
+  allowed_chat_ids: "123456789"
+redaction:
+  detectors: [email, card]
+  patterns:
+    - name: employee_id
+      pattern: 'EMP-(\d{6})'
+`))
+	defer cleanup()
+	t.Setenv("REDACTION_ENABLED", "true")
+	t.Setenv("REDACTION_RESTORE", "yes")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	r := cfg.Redaction
+	if !r.Enabled || !r.Restore || len(r.Detectors) != 2 || len(r.Patterns) != 1 || r.Patterns[0].Name != "employee_id" {
+		t.Errorf("Redaction = %+v", r)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*RedactionConfig)
+	}{
+		{"unknown detector", func(r *RedactionConfig) { r.Detectors = []string{"ssn"} }},
+		{"invalid name", func(r *RedactionConfig) { r.Patterns = []RedactionPattern{{Name: "employee id", Pattern: `\d+`}} }},
+		{"invalid pattern", func(r *RedactionConfig) { r.Patterns = []RedactionPattern{{Name: "bad", Pattern: "("}} }},
+		{"two capture groups", func(r *RedactionConfig) { r.Patterns = []RedactionPattern{{Name: "two", Pattern: `(a)(b)`}} }},
+	}
+	for _, tt := range tests {
+		invalid := *cfg
+		tt.modify(&invalid.Redaction)
+		if err := validateConfig(&invalid); err == nil {
+			t.Errorf("validateConfig() expected an error for %s", tt.name)
+		}
+	}
+}
+
+func TestValidateSystemPromptTemplate(t *testing.T) {
+	cfg := &Config{
+		Telegram: TelegramConfig{BotToken: testToken},
+		OpenAI: OpenAIConfig{
+			APIKey:       testKey,
+			SystemPrompt: "{{.FirstName}}님, 오늘은 {{.Date}}입니다. 팀: {{.Vars.team}}",
+			PromptVars:   map[string]string{"team": "플랫폼"},
+		},
+		Auth: AuthConfig{AllowedChatIDsStr: testChatID},
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("validateConfig() error = %v", err)
+	}
+
+	cfg.OpenAI.PromptVars = nil
+	if err := validateConfig(cfg); err == nil {
+		t.Error("정의되지 않은 사용자 변수는 설정 로드 시 오류여야 함")
+	}
+	cfg.OpenAI.SystemPrompt = "{{.FirstName"
+	if err := validateConfig(cfg); err == nil {
+		t.Error("템플릿 문법 오류는 설정 로드 시 오류여야 함")
+	}
+}
+
+func TestLoadPersonasConfig(t *testing.T) {
+	cleanup := createTempConfigFile(t, []byte(`
+telegram:
+  bot_token: "test-token"
+openai:
+  api_key: "test-key"
+auth:
+  allowed_chat_ids: "123456789"
+personas:
+  default: assistant
+  chats:
+    -1001234: reviewer
+  list:
+    - name: assistant
+    - name: reviewer
+      description: "Code reviewer"
+      system_prompt: "Review the code of {{.FirstName}}."
+      model: gpt-4.1
+      temperature: 0.2
+      tools: []
+      few_shot_examples:
+        - user_question: "x := 1"
+          bot_response: "LGTM"
+`))
+	defer cleanup()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	p := cfg.Personas
+	if len(p.List) != 2 || p.DefaultFor(1) != "assistant" || p.DefaultFor(-1001234) != "reviewer" {
+		t.Errorf("Personas = %+v", p)
+	}
+	reviewer := p.Find("reviewer")
+	if reviewer == nil || reviewer.Model != "gpt-4.1" || *reviewer.Temperature != 0.2 || len(reviewer.FewShotExamples) != 1 {
+		t.Errorf("Find(reviewer) = %+v", reviewer)
+	}
+	if reviewer.Tools == nil || len(reviewer.Tools) != 0 {
+		t.Errorf("tools: []는 도구를 모두 끄는 빈 목록이어야 함, got %#v", reviewer.Tools)
+	}
+	if p.Find("assistant").Tools != nil || p.Find("poet") != nil {
+		t.Error("tools를 생략하면 nil이어야 하고 없는 페르소나는 nil이어야 함")
+	}
+
+	invalidTemperature := 3.0
+	tests := []struct {
+		name   string
+		modify func(*PersonasConfig)
+	}{
+		{"invalid name", func(p *PersonasConfig) { p.List = []PersonaConfig{{Name: "code reviewer"}} }},
+		{"long name", func(p *PersonasConfig) { p.List = []PersonaConfig{{Name: strings.Repeat("p", 33)}} }},
+		{"duplicate name", func(p *PersonasConfig) { p.List = append(p.List, PersonaConfig{Name: "assistant"}) }},
+		{"invalid prompt", func(p *PersonasConfig) { p.List = []PersonaConfig{{Name: "bad", SystemPrompt: "{{.Nickname}}"}} }},
+		{"invalid sampling", func(p *PersonasConfig) {
+			p.List = []PersonaConfig{{Name: "hot", SamplingConfig: SamplingConfig{Temperature: &invalidTemperature}}}
+		}},
+		{"unknown default", func(p *PersonasConfig) { p.Default = "poet" }},
+		{"unknown chat persona", func(p *PersonasConfig) { p.Chats = map[int64]string{1: "poet"} }},
+	}
+	for _, tt := range tests {
+		invalid := *cfg
+		invalid.Personas.List = append([]PersonaConfig(nil), cfg.Personas.List...)
+		tt.modify(&invalid.Personas)
+		if err := validateConfig(&invalid); err == nil {
+			t.Errorf("validateConfig() expected an error for %s", tt.name)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package encryption seals data at rest with AES-GCM envelope encryption: every
+// record gets its own random data key, which is sealed with a named master key.
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// KeySize is the size of master and data keys (AES-256)
+const KeySize = 32
+
+// ErrUnknownKey is returned when a record was sealed with a key that is not in the keyring
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// Envelope is data sealed with a data key, together with that data key sealed
+// with the master key KeyID
+type Envelope struct {
+	KeyID string `json:"key_id"`
+	// DataKey is the nonce followed by the sealed data key
+	DataKey []byte `json:"data_key"`
+	Nonce   []byte `json:"nonce"`
+	// Ciphertext is the sealed data including the GCM tag
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Keyring holds the master keys by ID. New records are sealed with the active
+// key; records sealed with any other key in the ring can still be opened.
+type Keyring struct {
+	keys   map[string]cipher.AEAD
+	active string
+}
+
+// NewKeyring creates a keyring from master keys of KeySize bytes
+func NewKeyring(keys map[string][]byte, active string) (*Keyring, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active encryption key %q is not configured", active)
+	}
+
+	ring := &Keyring{keys: make(map[string]cipher.AEAD, len(keys)), active: active}
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		ring.keys[id] = aead
+	}
+	return ring, nil
+}
+
+// ActiveKey returns the ID of the key new records are sealed with
+func (k *Keyring) ActiveKey() string {
+	return k.active
+}
+
+// Seal encrypts plaintext with a new data key. The additional data is
+// authenticated but not stored, so the same value must be passed to Open.
+func (k *Keyring) Seal(plaintext, additionalData []byte) (*Envelope, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("error generating data key: %w", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomNonce(data)
+	if err != nil {
+		return nil, err
+	}
+	master := k.keys[k.active]
+	keyNonce, err := randomNonce(master)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		KeyID:      k.active,
+		DataKey:    master.Seal(keyNonce, keyNonce, dataKey, []byte(k.active)),
+		Nonce:      nonce,
+		Ciphertext: data.Seal(nil, nonce, plaintext, additionalData),
+	}, nil
+}
+
+// Open decrypts an envelope sealed with any key of the keyring
+func (k *Keyring) Open(env *Envelope, additionalData []byte) ([]byte, error) {
+	master, ok := k.keys[env.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, env.KeyID)
+	}
+
+	size := master.NonceSize()
+	if len(env.DataKey) < size {
+		return nil, errors.New("sealed data key is too short")
+	}
+	dataKey, err := master.Open(nil, env.DataKey[:size], env.DataKey[size:], []byte(env.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("error opening data key: %w", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := data.Open(nil, env.Nonce, env.Ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting: %w", err)
+	}
+	return plaintext, nil
+}
+
+// ParseKeys parses master keys written as id:base64-key, separated by commas
+// or newlines. Blank lines and lines starting with # are ignored.
+func ParseKeys(text string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	scanner := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(text, ",", "\n")))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.New("encryption keys must be written as id:base64-key")
+		}
+		id := strings.TrimSpace(parts[0])
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q is not valid base64: %w", id, err)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("encryption key %q must be %d bytes, got %d", id, KeySize, len(key))
+		}
+		if _, ok := keys[id]; ok {
+			return nil, fmt.Errorf("encryption key %q is listed twice", id)
+		}
+		keys[id] = key
+	}
+	return keys, scanner.Err()
+}
+
+// newAEAD creates an AES-GCM cipher
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// randomNonce returns a random nonce for aead
+func randomNonce(aead cipher.AEAD) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	return nonce, nil
+}
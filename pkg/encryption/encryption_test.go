@@ -0,0 +1,95 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// testKey returns a key of KeySize bytes filled with b
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestSealAndOpen(t *testing.T) {
+	ring, err := NewKeyring(map[string][]byte{"2024": testKey(1)}, "2024")
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	env, err := ring.Seal([]byte("secret"), []byte("chat:1"))
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if env.KeyID != "2024" || bytes.Contains(env.Ciphertext, []byte("secret")) {
+		t.Errorf("Seal() = %+v", env)
+	}
+
+	plaintext, err := ring.Open(env, []byte("chat:1"))
+	if err != nil || string(plaintext) != "secret" {
+		t.Fatalf("Open() = %q, %v", plaintext, err)
+	}
+
+	// The additional data binds the envelope to its record
+	if _, err := ring.Open(env, []byte("chat:2")); err == nil {
+		t.Error("Open() with other additional data expected an error")
+	}
+
+	// Every envelope has its own data key
+	again, _ := ring.Seal([]byte("secret"), []byte("chat:1"))
+	if bytes.Equal(again.DataKey, env.DataKey) || bytes.Equal(again.Ciphertext, env.Ciphertext) {
+		t.Error("Seal() reused a data key")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old, _ := NewKeyring(map[string][]byte{"old": testKey(1)}, "old")
+	env, _ := old.Seal([]byte("secret"), nil)
+
+	rotated, err := NewKeyring(map[string][]byte{"old": testKey(1), "new": testKey(2)}, "new")
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	if plaintext, err := rotated.Open(env, nil); err != nil || string(plaintext) != "secret" {
+		t.Errorf("Open() of a record sealed with the old key = %q, %v", plaintext, err)
+	}
+	if resealed, _ := rotated.Seal([]byte("secret"), nil); resealed.KeyID != "new" {
+		t.Errorf("Seal() used key %q, expected new", resealed.KeyID)
+	}
+
+	retired, _ := NewKeyring(map[string][]byte{"new": testKey(2)}, "new")
+	if _, err := retired.Open(env, nil); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open() without the old key error = %v, expected ErrUnknownKey", err)
+	}
+
+	if _, err := NewKeyring(map[string][]byte{"old": testKey(1)}, "missing"); err == nil {
+		t.Error("NewKeyring() with a missing active key expected an error")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(testKey(1))
+	keys, err := ParseKeys("# rotated 2024-05\nold:" + encoded + "\n\nnew: " + base64.StdEncoding.EncodeToString(testKey(2)))
+	if err != nil {
+		t.Fatalf("ParseKeys() error = %v", err)
+	}
+	if len(keys) != 2 || !bytes.Equal(keys["old"], testKey(1)) || !bytes.Equal(keys["new"], testKey(2)) {
+		t.Errorf("ParseKeys() = %v", keys)
+	}
+
+	if keys, err := ParseKeys("a:" + encoded + ",b:" + encoded); err != nil || len(keys) != 2 {
+		t.Errorf("ParseKeys() with commas = %v, %v", keys, err)
+	}
+
+	for _, text := range []string{
+		"no-separator",
+		"a:not-base64!",
+		"a:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"a:" + encoded + ",a:" + encoded,
+	} {
+		if _, err := ParseKeys(text); err == nil {
+			t.Errorf("ParseKeys(%q) expected an error", text)
+		}
+	}
+}
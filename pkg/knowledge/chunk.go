@@ -0,0 +1,110 @@
+package knowledge
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Chunk is a passage of a document that is embedded on its own
+type Chunk struct {
+	// Section is the Markdown heading the passage belongs to, if any
+	Section string
+	Text    string
+}
+
+// split cuts text into chunks of at most size characters. Paragraphs are kept
+// whole where they fit, consecutive chunks share up to overlap characters of
+// trailing paragraphs, and in Markdown every heading starts a new chunk.
+func split(text string, markdown bool, size, overlap int) []Chunk {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	var (
+		chunks  []Chunk
+		section string
+		current []string
+		length  int
+	)
+	emit := func(carry bool) {
+		if len(current) == 0 {
+			return
+		}
+		chunks = append(chunks, Chunk{Section: section, Text: strings.Join(current, "\n\n")})
+
+		// Start the next chunk with the trailing paragraphs that fit the overlap
+		var kept []string
+		keptLength := 0
+		for i := len(current) - 1; carry && i >= 0; i-- {
+			n := utf8.RuneCountInString(current[i])
+			if keptLength+n > overlap {
+				break
+			}
+			kept = append([]string{current[i]}, kept...)
+			keptLength += n + 2
+		}
+		current, length = kept, keptLength
+	}
+
+	for _, paragraph := range paragraphs(text, size) {
+		if markdown && strings.HasPrefix(paragraph, "#") {
+			emit(false)
+			heading, _, _ := strings.Cut(paragraph, "\n")
+			section = strings.TrimSpace(strings.TrimLeft(heading, "#"))
+		}
+		n := utf8.RuneCountInString(paragraph)
+		if length > 0 && length+n > size {
+			emit(true)
+			// Drop the overlap if it leaves no room for the paragraph
+			if length+n > size {
+				current, length = nil, 0
+			}
+		}
+		current = append(current, paragraph)
+		length += n + 2
+	}
+	emit(false)
+	return chunks
+}
+
+// paragraphs returns the non-empty paragraphs of text, cutting those longer
+// than size characters at word boundaries
+func paragraphs(text string, size int) []string {
+	var result []string
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if utf8.RuneCountInString(paragraph) <= size {
+			result = append(result, paragraph)
+			continue
+		}
+
+		var piece strings.Builder
+		pieceLength := 0
+		for _, word := range strings.Fields(paragraph) {
+			n := utf8.RuneCountInString(word)
+			if pieceLength > 0 && pieceLength+1+n > size {
+				result = append(result, piece.String())
+				piece.Reset()
+				pieceLength = 0
+			}
+			// A single word longer than a chunk is cut anywhere
+			for n > size {
+				runes := []rune(word)
+				result = append(result, string(runes[:size]))
+				word = string(runes[size:])
+				n -= size
+			}
+			if pieceLength > 0 {
+				piece.WriteByte(' ')
+				pieceLength++
+			}
+			piece.WriteString(word)
+			pieceLength += n
+		}
+		if pieceLength > 0 {
+			result = append(result, piece.String())
+		}
+	}
+	return result
+}
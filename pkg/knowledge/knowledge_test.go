@@ -0,0 +1,247 @@
+package knowledge
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/itswryu/telegpt/pkg/config"
+)
+
+func TestSplit(t *testing.T) {
+	text := "Intro line.\r\n\r\n# Deploy\n\nStep one.\n\nStep two.\n\n## Rollback\n\n" + strings.Repeat("word ", 30)
+	chunks := split(text, true, 25, 12)
+
+	var sections []string
+	for _, chunk := range chunks {
+		if n := utf8.RuneCountInString(chunk.Text); n > 25 {
+			t.Errorf("청크 길이 %d > 25: %q", n, chunk.Text)
+		}
+		sections = append(sections, chunk.Section)
+	}
+	if chunks[0].Text != "Intro line." || chunks[0].Section != "" {
+		t.Errorf("첫 청크 = %+v", chunks[0])
+	}
+	if chunks[1].Section != "Deploy" || !strings.HasPrefix(chunks[1].Text, "# Deploy") {
+		t.Errorf("두 번째 청크 = %+v, 제목에서 새 청크가 시작되어야 함", chunks[1])
+	}
+	if last := chunks[len(chunks)-1]; last.Section != "Rollback" {
+		t.Errorf("마지막 청크 섹션 = %q", last.Section)
+	}
+	if !strings.Contains(fmt.Sprint(sections), "Deploy Deploy") {
+		t.Errorf("섹션 = %v, Deploy 섹션이 여러 청크로 나뉘어야 함", sections)
+	}
+	// 겹치는 부분: 이전 청크의 마지막 문단이 다음 청크에서 반복됨
+	if !strings.HasPrefix(chunks[2].Text, "Step one.") {
+		t.Errorf("세 번째 청크 = %q, 이전 문단이 겹쳐야 함", chunks[2].Text)
+	}
+
+	if chunks := split("plain # not a heading", false, 100, 10); len(chunks) != 1 || chunks[0].Section != "" {
+		t.Errorf("split() of plain text = %+v", chunks)
+	}
+	if chunks := split(strings.Repeat("가", 25), false, 10, 2); len(chunks) != 3 {
+		t.Errorf("split() of a long word = %+v", chunks)
+	}
+}
+
+// testPDF builds a minimal PDF whose page content shows lines
+func testPDF(t *testing.T, compressed bool, content string) []byte {
+	t.Helper()
+	stream, filter := []byte(content), ""
+	if compressed {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(stream)
+		w.Close()
+		stream, filter = buf.Bytes(), " /Filter /FlateDecode"
+	}
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	pdf.WriteString("3 0 obj\n<< /Type /XObject /Subtype /Image /Length 4 >>\nstream\n(no)Tj\nendstream\nendobj\n")
+	fmt.Fprintf(&pdf, "4 0 obj\n<< /Length %d%s >>\nstream\n", len(stream), filter)
+	pdf.Write(stream)
+	pdf.WriteString("\nendstream\nendobj\ntrailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func TestPDFText(t *testing.T) {
+	content := "BT /F1 12 Tf 72 720 Td (Restart the \\(primary\\) node) Tj 0 -14 Td " +
+		"[(Check)-250(the)-300(logs)] TJ T* <FEFF00E9> Tj ET"
+	for _, compressed := range []bool{false, true} {
+		text, skipped := pdfText(testPDF(t, compressed, content))
+		expected := "Restart the (primary) node\nCheck the logs\né"
+		if text != expected || skipped {
+			t.Errorf("pdfText(compressed=%v) = %q, %v, expected %q", compressed, text, skipped, expected)
+		}
+	}
+	if text, _ := pdfText([]byte("not a pdf")); text != "" {
+		t.Errorf("pdfText() of garbage = %q", text)
+	}
+}
+
+// testCIDPDF builds a PDF whose page shows a line in a simple font F1 and one
+// in a composite font F2, packing the font dictionaries into an object stream
+// if packed is set
+func testCIDPDF(t *testing.T, packed bool) []byte {
+	t.Helper()
+	fonts := map[string]string{
+		"5": "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"6": "<< /Type /Font /Subtype /Type0 /BaseFont /NanumGothic /Encoding /Identity-H /DescendantFonts [7 0 R] >>",
+	}
+	content := "BT /F1 12 Tf 72 720 Td (Restart the node) Tj /F2 12 Tf 0 -14 Td <B0A1C5D7> Tj [<AC00D55C>] TJ " +
+		"/F1 12 Tf 0 -14 Td (Check the logs) Tj ET"
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.5\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	pdf.WriteString("3 0 obj\n<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> /Contents 4 0 R >>\nendobj\n")
+	fmt.Fprintf(&pdf, "4 0 obj\n<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", len(content), content)
+	if packed {
+		header := fmt.Sprintf("5 0 6 %d ", len(fonts["5"])+1)
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write([]byte(header + fonts["5"] + "\n" + fonts["6"]))
+		w.Close()
+		fmt.Fprintf(&pdf, "8 0 obj\n<< /Type /ObjStm /N 2 /First %d /Length %d /Filter /FlateDecode >>\nstream\n", len(header), buf.Len())
+		pdf.Write(buf.Bytes())
+		pdf.WriteString("\nendstream\nendobj\n")
+	} else {
+		fmt.Fprintf(&pdf, "5 0 obj\n%s\nendobj\n6 0 obj\n%s\nendobj\n", fonts["5"], fonts["6"])
+	}
+	pdf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func TestPDFTextSkipsCIDFonts(t *testing.T) {
+	for _, packed := range []bool{false, true} {
+		text, skipped := pdfText(testCIDPDF(t, packed))
+		// CID 코드를 Latin-1로 읽으면 깨진 문자가 나오므로 건너뛰어야 함
+		if expected := "Restart the node\nCheck the logs"; text != expected || !skipped {
+			t.Errorf("pdfText(packed=%v) = %q, %v, expected %q with skipped text", packed, text, skipped, expected)
+		}
+	}
+}
+
+// fakeEmbedder embeds texts by the keywords they contain and counts the texts it embedded
+type fakeEmbedder struct {
+	texts []string
+}
+
+func (f *fakeEmbedder) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	f.texts = append(f.texts, texts...)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = keywordVector(text)
+	}
+	return vectors, nil
+}
+
+func keywordVector(text string) []float32 {
+	vector := []float32{0.01, 0, 0}
+	for i, keyword := range []string{"deploy", "vacation"} {
+		if strings.Contains(strings.ToLower(text), keyword) {
+			vector[i+1] = 1
+		}
+	}
+	return vector
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLibrarySync(t *testing.T) {
+	docs, indexDir := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(docs, "runbooks", "deploy.md"), "# Deploy\n\nRun make deploy.")
+	writeFile(t, filepath.Join(docs, "hr.txt"), "Vacation requests go to HR.")
+	writeFile(t, filepath.Join(docs, "image.png"), "binary")
+	writeFile(t, filepath.Join(docs, ".git", "notes.md"), "deploy secrets")
+
+	cfg := &config.KnowledgeConfig{
+		Bases:        []config.KnowledgeBaseConfig{{Name: "docs", Dir: docs}},
+		IndexDir:     indexDir,
+		ChunkSize:    200,
+		ChunkOverlap: 20,
+		TopK:         2,
+		MinScore:     0.5,
+	}
+	embedder := &fakeEmbedder{}
+	library, err := NewLibrary(cfg, embedder.embed)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+
+	stats, err := library.Sync(context.Background())
+	if err != nil || stats.Indexed != 2 || stats.Chunks != 2 {
+		t.Fatalf("Sync() = %+v, %v, 파일 두 개가 색인되어야 함", stats, err)
+	}
+	matches := library.Search([]string{"docs", "unknown"}, keywordVector("how do I deploy"))
+	if len(matches) != 1 || matches[0].Source() != "runbooks/deploy.md › Deploy" || matches[0].Base != "docs" {
+		t.Fatalf("Search() = %+v", matches)
+	}
+
+	// 바뀌지 않은 파일은 다시 임베딩하지 않음
+	embedder.texts = nil
+	if stats, _ := library.Sync(context.Background()); stats.Unchanged != 2 || len(embedder.texts) != 0 {
+		t.Errorf("Sync() without changes = %+v, embedded %d", stats, len(embedder.texts))
+	}
+
+	// 바뀐 파일은 다시 색인하고 지운 파일의 청크는 제거
+	writeFile(t, filepath.Join(docs, "runbooks", "deploy.md"), "# Deploy\n\nRun make release, then check the vacation calendar.")
+	os.Remove(filepath.Join(docs, "hr.txt"))
+	stats, err = library.Sync(context.Background())
+	if err != nil || stats.Indexed != 1 || stats.Removed != 1 {
+		t.Fatalf("Sync() after changes = %+v, %v", stats, err)
+	}
+	matches = library.Search([]string{"docs"}, keywordVector("vacation"))
+	if len(matches) != 1 || !strings.Contains(matches[0].Text, "make release") {
+		t.Errorf("Search() after changes = %+v", matches)
+	}
+
+	// 색인은 파일에 남아 있어 다시 열어도 임베딩하지 않음
+	embedder.texts = nil
+	reopened, _ := NewLibrary(cfg, embedder.embed)
+	if stats, _ := reopened.Sync(context.Background()); stats.Unchanged != 1 || len(embedder.texts) != 0 {
+		t.Errorf("Sync() after reopening = %+v, embedded %d", stats, len(embedder.texts))
+	}
+}
+
+func TestLibrarySyncMissingDir(t *testing.T) {
+	cfg := &config.KnowledgeConfig{
+		Bases:     []config.KnowledgeBaseConfig{{Name: "gone", Dir: filepath.Join(t.TempDir(), "missing")}},
+		ChunkSize: 100,
+		TopK:      1,
+	}
+	library, _ := NewLibrary(cfg, (&fakeEmbedder{}).embed)
+	if _, err := library.Sync(context.Background()); err == nil || !strings.Contains(err.Error(), "gone") {
+		t.Errorf("Sync() error = %v, expected an error naming the base", err)
+	}
+}
+
+func TestCited(t *testing.T) {
+	matches := make([]Match, 3)
+	tests := []struct {
+		answer   string
+		expected []int
+	}{
+		{"Run make deploy [2], then restart [1][2].", []int{1, 2}},
+		{"No citations here.", nil},
+		{"Out of range [4] and [0].", nil},
+	}
+	for _, tt := range tests {
+		if cited := Cited(tt.answer, matches); fmt.Sprint(cited) != fmt.Sprint(tt.expected) {
+			t.Errorf("Cited(%q) = %v, expected %v", tt.answer, cited, tt.expected)
+		}
+	}
+}
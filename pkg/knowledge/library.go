@@ -0,0 +1,375 @@
+// Package knowledge answers questions from directories of documents. Files are
+// split into chunks whose embeddings are kept in a vector index per knowledge
+// base, and re-indexed when they change.
+package knowledge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/vector"
+)
+
+// embedBatch is the number of chunks embedded per request
+const embedBatch = 64
+
+// maxFileSize skips files too large to be documents
+const maxFileSize = 32 << 20
+
+// citationRegex finds citations like [2] in an answer
+var citationRegex = regexp.MustCompile(`\[(\d+)\]`)
+
+// Embedder computes the embeddings of texts, in order
+type Embedder func(ctx context.Context, texts []string) ([][]float32, error)
+
+// Match is a chunk found for a question
+type Match struct {
+	Base    string
+	File    string
+	Section string
+	Text    string
+	Score   float64
+}
+
+// Source names the document a match comes from, such as "deploy.md › Rollback"
+func (m Match) Source() string {
+	if m.Section == "" {
+		return m.File
+	}
+	return m.File + " › " + m.Section
+}
+
+// Stats counts the files a sync went through
+type Stats struct {
+	Indexed   int
+	Unchanged int
+	Removed   int
+	Failed    int
+	// Chunks is the number of chunks embedded
+	Chunks int
+}
+
+// String summarizes the stats for logs and messages
+func (s Stats) String() string {
+	return fmt.Sprintf("%d files indexed (%d chunks), %d unchanged, %d removed, %d failed",
+		s.Indexed, s.Chunks, s.Unchanged, s.Removed, s.Failed)
+}
+
+// base is an opened knowledge base
+type base struct {
+	config.KnowledgeBaseConfig
+	index *vector.Index
+	// empty remembers the hash of files without text so that they are reported once
+	empty map[string]string
+}
+
+// Library holds the configured knowledge bases
+type Library struct {
+	cfg   config.KnowledgeConfig
+	embed Embedder
+	bases map[string]*base
+	// syncMutex lets one sync run at a time
+	syncMutex sync.Mutex
+}
+
+// NewLibrary opens the index of every configured knowledge base. The indexes
+// are brought up to date by Sync.
+func NewLibrary(cfg *config.KnowledgeConfig, embed Embedder) (*Library, error) {
+	library := &Library{cfg: *cfg, embed: embed, bases: make(map[string]*base)}
+	for _, baseConfig := range cfg.Bases {
+		index, err := vector.Open(filepath.Join(cfg.IndexDir, baseConfig.Name+".idx"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("error opening knowledge base %s: %w", baseConfig.Name, err)
+		}
+		library.bases[baseConfig.Name] = &base{KnowledgeBaseConfig: baseConfig, index: index, empty: make(map[string]string)}
+	}
+	return library, nil
+}
+
+// Bases returns the configured knowledge bases in configuration order
+func (l *Library) Bases() []config.KnowledgeBaseConfig {
+	return l.cfg.Bases
+}
+
+// Has reports whether a knowledge base is configured
+func (l *Library) Has(name string) bool {
+	_, ok := l.bases[name]
+	return ok
+}
+
+// Run syncs the knowledge bases now and then every reindex interval until ctx is done
+func (l *Library) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.cfg.ReindexInterval)
+	defer ticker.Stop()
+	for {
+		stats, err := l.Sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Error("Error indexing knowledge bases: %v", err)
+		}
+		if stats.Indexed > 0 || stats.Removed > 0 || stats.Failed > 0 {
+			logger.Info("Knowledge bases synced: %s", stats)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync indexes new and changed files of every knowledge base and drops the
+// chunks of deleted ones. Files that fail keep their previous chunks.
+func (l *Library) Sync(ctx context.Context) (Stats, error) {
+	l.syncMutex.Lock()
+	defer l.syncMutex.Unlock()
+
+	var total Stats
+	var errs []error
+	for _, baseConfig := range l.cfg.Bases {
+		stats, err := l.syncBase(ctx, l.bases[baseConfig.Name])
+		total.Indexed += stats.Indexed
+		total.Unchanged += stats.Unchanged
+		total.Removed += stats.Removed
+		total.Failed += stats.Failed
+		total.Chunks += stats.Chunks
+		if err != nil {
+			errs = append(errs, fmt.Errorf("knowledge base %s: %w", baseConfig.Name, err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return total, errors.Join(errs...)
+}
+
+// syncBase brings the index of a knowledge base up to date with its directory
+func (l *Library) syncBase(ctx context.Context, b *base) (Stats, error) {
+	var stats Stats
+
+	// The hash of every indexed file, from its chunks
+	indexed := make(map[string]string)
+	for _, item := range b.index.Items(nil) {
+		indexed[item.Meta["file"]] = item.Meta["hash"]
+	}
+
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(b.Dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && path != b.Dir {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || !supported(path) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(b.Dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		seen[rel] = true
+
+		indexedChunks, err := l.syncFile(ctx, b, path, rel, indexed[rel])
+		switch {
+		case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+			return err
+		case err != nil:
+			logger.Warn("Error indexing %s in knowledge base %s: %v", rel, b.Name, err)
+			stats.Failed++
+		case indexedChunks < 0:
+			stats.Unchanged++
+		default:
+			stats.Indexed++
+			stats.Chunks += indexedChunks
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	for file := range indexed {
+		if seen[file] {
+			continue
+		}
+		if _, err := b.index.Delete(func(item vector.Item) bool { return item.Meta["file"] == file }); err != nil {
+			return stats, err
+		}
+		stats.Removed++
+	}
+	return stats, nil
+}
+
+// syncFile re-indexes a file whose content no longer matches indexedHash and
+// returns the number of chunks embedded, or -1 if the file is unchanged
+func (l *Library) syncFile(ctx context.Context, b *base, path, rel, indexedHash string) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() > maxFileSize {
+		return 0, fmt.Errorf("file is larger than %d MB", maxFileSize>>20)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if hash == indexedHash || hash == b.empty[rel] {
+		return -1, nil
+	}
+
+	text, skipped := documentText(path, data)
+	if skipped {
+		logger.Warn("Skipped text in CID fonts without a readable encoding in %s in knowledge base %s", rel, b.Name)
+	}
+	chunks := split(text, isMarkdown(path), l.cfg.ChunkSize, l.cfg.ChunkOverlap)
+	if len(chunks) == 0 {
+		b.empty[rel] = hash
+		logger.Warn("No text found in %s in knowledge base %s", rel, b.Name)
+	} else {
+		delete(b.empty, rel)
+	}
+
+	items := make([]vector.Item, 0, len(chunks))
+	for start := 0; start < len(chunks); start += embedBatch {
+		end := start + embedBatch
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		batch := chunks[start:end]
+		texts := make([]string, len(batch))
+		for i, chunk := range batch {
+			// The file and section give the embedding context the passage lacks
+			texts[i] = rel + "\n" + chunk.Section + "\n\n" + chunk.Text
+		}
+		vectors, err := l.embed(ctx, texts)
+		if err != nil {
+			return 0, err
+		}
+		for i, chunk := range batch {
+			items = append(items, vector.Item{
+				ID:     rel + "#" + strconv.Itoa(start+i),
+				Vector: vectors[i],
+				Text:   chunk.Text,
+				Meta:   map[string]string{"file": rel, "hash": hash, "section": chunk.Section},
+				Time:   info.ModTime(),
+			})
+		}
+	}
+
+	// Replace the old chunks only once the new ones are embedded
+	if _, err := b.index.Delete(func(item vector.Item) bool { return item.Meta["file"] == rel }); err != nil {
+		return 0, err
+	}
+	if len(items) > 0 {
+		if err := b.index.Upsert(items...); err != nil {
+			return 0, err
+		}
+	}
+	return len(items), nil
+}
+
+// Search returns the chunks of the named knowledge bases most similar to the
+// query embedding, best first, up to the configured number and minimum score
+func (l *Library) Search(names []string, query []float32) []Match {
+	var matches []Match
+	for _, name := range names {
+		b, ok := l.bases[name]
+		if !ok {
+			continue
+		}
+		for _, result := range b.index.Search(query, l.cfg.TopK, nil) {
+			if result.Score < l.cfg.MinScore {
+				break
+			}
+			matches = append(matches, Match{
+				Base:    name,
+				File:    result.Meta["file"],
+				Section: result.Meta["section"],
+				Text:    result.Text,
+				Score:   result.Score,
+			})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) > l.cfg.TopK {
+		matches = matches[:l.cfg.TopK]
+	}
+	return matches
+}
+
+// Cited returns the numbers of the matches an answer cites, such as 1 for a
+// citation [1] of the first match, in ascending order
+func Cited(answer string, matches []Match) []int {
+	cited := make(map[int]bool)
+	for _, m := range citationRegex.FindAllStringSubmatch(answer, -1) {
+		if n, err := strconv.Atoi(m[1]); err == nil && n >= 1 && n <= len(matches) {
+			cited[n] = true
+		}
+	}
+	var numbers []int
+	for n := 1; n <= len(matches); n++ {
+		if cited[n] {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers
+}
+
+// supported reports whether a file is a document the library can index
+func supported(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown", ".txt", ".pdf":
+		return true
+	}
+	return false
+}
+
+// isMarkdown reports whether a file is Markdown
+func isMarkdown(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// documentText returns the text of a document and whether some of it had to
+// be skipped because it could not be decoded
+func documentText(path string, data []byte) (string, bool) {
+	if strings.ToLower(filepath.Ext(path)) == ".pdf" {
+		return pdfText(data)
+	}
+	if !utf8.Valid(data) {
+		return strings.ToValidUTF8(string(data), ""), false
+	}
+	return string(data), false
+}
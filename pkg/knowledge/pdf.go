@@ -0,0 +1,395 @@
+package knowledge
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxStreamSize bounds the decompressed size of a single PDF stream
+const maxStreamSize = 16 << 20
+
+// skippedStreams mark PDF streams that hold no page text
+var skippedStreams = []string{"/Image", "/XRef", "/ObjStm", "/Metadata", "/FontFile", "/Length1", "/DCTDecode", "/JPXDecode", "/CCITTFaxDecode", "/JBIG2Decode"}
+
+var (
+	// objectRegex finds the start of an indirect object such as "12 0 obj"
+	objectRegex = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	// firstRegex reads the offset of the first object in an object stream
+	firstRegex = regexp.MustCompile(`/First\s+(\d+)`)
+	// fontResourcesRegex finds font resource dictionaries, inline or referenced
+	fontResourcesRegex = regexp.MustCompile(`/Font\s*(?:<<([^>]*)>>|(\d+)\s+\d+\s+R)`)
+	// fontRefRegex finds the entries of a font resource dictionary such as /F1 5 0 R
+	fontRefRegex = regexp.MustCompile(`/([^\s/<>\[\]()]+)\s*(\d+)\s+\d+\s+R`)
+	// cidFontRegex matches composite fonts, whose codes need a ToUnicode map
+	cidFontRegex = regexp.MustCompile(`/Subtype\s*/Type0|/Encoding\s*/Identity-[HV]`)
+)
+
+// pdfText extracts the text shown by the page content streams of a PDF. It
+// reads uncompressed and Flate-compressed streams and fonts with standard
+// encodings, which covers documents exported by common writers. Scanned pages
+// and fonts with custom encodings yield little or no text. Text in composite
+// (CID) fonts such as those of CJK documents is skipped, as their codes only
+// mean something through ToUnicode maps, which are not read; skipped reports
+// whether there was any.
+func pdfText(data []byte) (text string, skipped bool) {
+	cidFonts := cidFontNames(pdfObjects(data))
+	var sb strings.Builder
+	for rest := data; ; {
+		start := bytes.Index(rest, []byte("stream"))
+		if start < 0 {
+			break
+		}
+		header := rest[:start]
+		rest = rest[start+len("stream"):]
+		if bytes.HasSuffix(header, []byte("end")) {
+			continue
+		}
+
+		end := bytes.Index(rest, []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		body := bytes.TrimLeft(rest[:end], "\r\n")
+		rest = rest[end+len("endstream"):]
+
+		// The stream dictionary is the part of its object before the keyword
+		if obj := bytes.LastIndex(header, []byte(" obj")); obj >= 0 {
+			header = header[obj:]
+		}
+		if skipStream(string(header)) {
+			continue
+		}
+		if bytes.Contains(header, []byte("/FlateDecode")) {
+			// Keep what inflates even if the stream is truncated
+			inflated, _ := inflate(body)
+			body = inflated
+		} else if bytes.Contains(header, []byte("/Filter")) {
+			continue
+		}
+		if showText(body, cidFonts, &sb) {
+			skipped = true
+		}
+	}
+	return cleanText(sb.String()), skipped
+}
+
+// pdfObjects returns the dictionaries of the objects of a PDF by object number,
+// including those packed into Flate-compressed object streams
+func pdfObjects(data []byte) map[string]string {
+	objects := make(map[string]string)
+	locs := objectRegex.FindAllSubmatchIndex(data, -1)
+	for i, loc := range locs {
+		body := data[loc[1]:]
+		if i+1 < len(locs) {
+			body = data[loc[1]:locs[i+1][0]]
+		}
+		if end := bytes.Index(body, []byte("endobj")); end >= 0 {
+			body = body[:end]
+		}
+		dict := body
+		start := bytes.Index(body, []byte("stream"))
+		if start >= 0 {
+			dict = body[:start]
+		}
+		objects[string(data[loc[2]:loc[3]])] = string(dict)
+
+		if start >= 0 && bytes.Contains(dict, []byte("/ObjStm")) && bytes.Contains(dict, []byte("/FlateDecode")) {
+			stream := bytes.TrimLeft(body[start+len("stream"):], "\r\n")
+			if end := bytes.Index(stream, []byte("endstream")); end >= 0 {
+				stream = stream[:end]
+			}
+			inflated, _ := inflate(stream)
+			unpackObjects(string(dict), inflated, objects)
+		}
+	}
+	return objects
+}
+
+// unpackObjects adds the objects of an object stream with dictionary dict to objects
+func unpackObjects(dict string, data []byte, objects map[string]string) {
+	m := firstRegex.FindStringSubmatch(dict)
+	if m == nil {
+		return
+	}
+	first, _ := strconv.Atoi(m[1])
+	if first > len(data) {
+		return
+	}
+	// The stream starts with pairs of object numbers and offsets from first
+	header := strings.Fields(string(data[:first]))
+	for i := 0; i+1 < len(header); i += 2 {
+		start, err := strconv.Atoi(header[i+1])
+		if err != nil || first+start > len(data) {
+			return
+		}
+		end := len(data)
+		if i+3 < len(header) {
+			if next, err := strconv.Atoi(header[i+3]); err == nil && next >= start && first+next <= len(data) {
+				end = first + next
+			}
+		}
+		objects[header[i]] = string(data[first+start : end])
+	}
+}
+
+// cidFontNames returns the resource names under which composite fonts are used
+func cidFontNames(objects map[string]string) map[string]bool {
+	names := make(map[string]bool)
+	for _, obj := range objects {
+		for _, m := range fontResourcesRegex.FindAllStringSubmatch(obj, -1) {
+			fonts := m[1]
+			if m[2] != "" {
+				fonts = objects[m[2]]
+			}
+			for _, ref := range fontRefRegex.FindAllStringSubmatch(fonts, -1) {
+				if cidFontRegex.MatchString(objects[ref[2]]) {
+					names[ref[1]] = true
+				}
+			}
+		}
+	}
+	return names
+}
+
+// skipStream reports whether a stream dictionary describes something other than page content
+func skipStream(header string) bool {
+	for _, marker := range skippedStreams {
+		if strings.Contains(header, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// inflate decompresses a Flate stream
+func inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, maxStreamSize))
+}
+
+// showText writes the strings that the text operators of a content stream
+// show, except those in the fonts of cidFonts, and reports whether it skipped any
+func showText(content []byte, cidFonts map[string]bool, sb *strings.Builder) (skipped bool) {
+	var (
+		operands []string
+		numbers  []float64
+		name     string
+		inArray  bool
+		// inCIDFont is set while a font of cidFonts is selected
+		inCIDFont bool
+	)
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, next := literalString(content, i)
+			operands = append(operands, s)
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			i += 2
+		case c == '>' && i+1 < len(content) && content[i+1] == '>':
+			i += 2
+		case c == '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return skipped
+			}
+			operands = append(operands, hexString(content[i+1:i+end]))
+			i += end + 1
+		case c == '/':
+			// Names such as font resources are not shown
+			start := i + 1
+			for i++; i < len(content) && !isSpace(content[i]) && !isDelimiter(content[i]); i++ {
+			}
+			name = string(content[start:i])
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isSpace(c):
+			i++
+		default:
+			start := i
+			for i < len(content) && !isSpace(content[i]) && !isDelimiter(content[i]) {
+				i++
+			}
+			if i == start {
+				i++
+				continue
+			}
+			token := string(content[start:i])
+			if n, err := strconv.ParseFloat(token, 64); err == nil {
+				// Large negative offsets in a TJ array separate words
+				if inArray && n < -200 {
+					operands = append(operands, " ")
+				}
+				numbers = append(numbers, n)
+				continue
+			}
+			switch token {
+			case "Tf":
+				inCIDFont = cidFonts[name]
+			case "Tj", "TJ":
+				if inCIDFont {
+					skipped = true
+				} else {
+					sb.WriteString(strings.Join(operands, ""))
+				}
+			case "'", "\"":
+				sb.WriteString("\n")
+				if inCIDFont {
+					skipped = true
+				} else {
+					sb.WriteString(strings.Join(operands, ""))
+				}
+			case "T*", "ET":
+				sb.WriteString("\n")
+			case "Td", "TD":
+				// A vertical move starts a new line
+				if len(numbers) >= 2 && numbers[len(numbers)-1] != 0 {
+					sb.WriteString("\n")
+				} else {
+					sb.WriteString(" ")
+				}
+			}
+			operands, numbers, name = nil, nil, ""
+		}
+	}
+	return skipped
+}
+
+// literalString decodes the string literal starting at content[start] and
+// returns it with the position after it
+func literalString(content []byte, start int) (string, int) {
+	var b []byte
+	depth := 0
+	i := start
+	for i < len(content) {
+		c := content[i]
+		i++
+		switch c {
+		case '(':
+			if depth > 0 {
+				b = append(b, c)
+			}
+			depth++
+			continue
+		case ')':
+			depth--
+			if depth == 0 {
+				return decodeString(b), i
+			}
+			b = append(b, c)
+			continue
+		case '\\':
+		default:
+			b = append(b, c)
+			continue
+		}
+
+		if i >= len(content) {
+			break
+		}
+		e := content[i]
+		i++
+		switch e {
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case 'b', 'f':
+		case '\r':
+			if i < len(content) && content[i] == '\n' {
+				i++
+			}
+		case '\n':
+		default:
+			if e >= '0' && e <= '7' {
+				n := int(e - '0')
+				for j := 0; j < 2 && i < len(content) && content[i] >= '0' && content[i] <= '7'; j++ {
+					n = n*8 + int(content[i]-'0')
+					i++
+				}
+				b = append(b, byte(n))
+			} else {
+				b = append(b, e)
+			}
+		}
+	}
+	return decodeString(b), i
+}
+
+// hexString decodes a hexadecimal string such as <48656C6C6F>
+func hexString(hex []byte) string {
+	var digits []byte
+	for _, c := range hex {
+		if !isSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b := make([]byte, 0, len(digits)/2)
+	for i := 0; i < len(digits); i += 2 {
+		n, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		if err != nil {
+			return ""
+		}
+		b = append(b, byte(n))
+	}
+	return decodeString(b)
+}
+
+// decodeString converts the bytes of a PDF string, which are UTF-16 with a
+// byte order mark or a Latin-1 superset otherwise
+func decodeString(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		units := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// cleanText collapses the spaces within lines and drops empty lines
+func cleanText(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
@@ -0,0 +1,119 @@
+// Package lock provides named mutual exclusion within a process or across replicas
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// retryInterval is how often a Redis lock is retried while it is held elsewhere
+const retryInterval = 100 * time.Millisecond
+
+// Locker hands out named locks
+type Locker interface {
+	// Lock blocks until the named lock is acquired or ctx is done and returns the
+	// function that releases it. A lock not released within ttl may be taken by
+	// another holder; implementations that cannot lose their holder ignore ttl.
+	Lock(ctx context.Context, name string, ttl time.Duration) (func(), error)
+}
+
+// LocalLocker is a Locker for a single process
+type LocalLocker struct {
+	locks map[string]chan struct{}
+	mutex sync.Mutex
+}
+
+// NewLocalLocker creates an in-process locker
+func NewLocalLocker() *LocalLocker {
+	return &LocalLocker{locks: make(map[string]chan struct{})}
+}
+
+// Lock implements Locker
+func (l *LocalLocker) Lock(ctx context.Context, name string, ttl time.Duration) (func(), error) {
+	l.mutex.Lock()
+	lock, ok := l.locks[name]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		l.locks[name] = lock
+	}
+	l.mutex.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() { once.Do(func() { <-lock }) }, nil
+}
+
+// unlockScript deletes the lock only if it still holds our token
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// RedisLocker is a Locker shared by every replica using the same Redis.
+// Locks are keys set with NX and a random token that expire after their TTL.
+type RedisLocker struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisLocker creates a locker on top of a Redis client, namespacing keys with prefix
+func NewRedisLocker(client redis.UniversalClient, prefix string) *RedisLocker {
+	return &RedisLocker{client: client, prefix: prefix + "lock:"}
+}
+
+// Lock implements Locker
+func (l *RedisLocker) Lock(ctx context.Context, name string, ttl time.Duration) (func(), error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	key := l.prefix + name
+
+	for {
+		ok, err := l.client.SetNX(ctx, key, token, ttl).Result()
+		if err != nil {
+			return nil, fmt.Errorf("error acquiring lock %s: %w", name, err)
+		}
+		if ok {
+			break
+		}
+
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			// Release even if the holder's context is already cancelled
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = unlockScript.Run(ctx, l.client, []string{key}, token).Err()
+		})
+	}, nil
+}
+
+// newToken returns a random lock token
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating lock token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
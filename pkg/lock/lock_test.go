@@ -0,0 +1,95 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLockers(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	lockers := map[string]Locker{
+		"local": NewLocalLocker(),
+		"redis": NewRedisLocker(client, "test:"),
+	}
+
+	for name, locker := range lockers {
+		t.Run(name, func(t *testing.T) {
+			unlock, err := locker.Lock(context.Background(), "chat:1", time.Minute)
+			if err != nil {
+				t.Fatalf("Lock() error = %v", err)
+			}
+
+			// Other names are independent
+			other, err := locker.Lock(context.Background(), "chat:2", time.Minute)
+			if err != nil {
+				t.Fatalf("Lock() of another name error = %v", err)
+			}
+			other()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+			defer cancel()
+			if _, err := locker.Lock(ctx, "chat:1", time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Lock() of a held lock error = %v, expected context.DeadlineExceeded", err)
+			}
+
+			acquired := make(chan struct{})
+			go func() {
+				second, err := locker.Lock(context.Background(), "chat:1", time.Minute)
+				if err == nil {
+					second()
+				}
+				close(acquired)
+			}()
+
+			unlock()
+			// Unlocking twice must not release somebody else's lock
+			unlock()
+
+			select {
+			case <-acquired:
+			case <-time.After(time.Second):
+				t.Fatal("Lock() was not acquired after unlock")
+			}
+		})
+	}
+}
+
+func TestRedisLockExpires(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	locker := NewRedisLocker(client, "test:")
+
+	stale, err := locker.Lock(context.Background(), "chat:1", time.Second)
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	// The holder died without unlocking; the lock frees itself after its TTL
+	server.FastForward(2 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	unlock, err := locker.Lock(ctx, "chat:1", time.Minute)
+	if err != nil {
+		t.Fatalf("Lock() after expiry error = %v", err)
+	}
+
+	// The stale holder must not release the new holder's lock
+	stale()
+	if !server.Exists("test:lock:chat:1") {
+		t.Error("Stale unlock released the new holder's lock")
+	}
+	unlock()
+	if server.Exists("test:lock:chat:1") {
+		t.Error("Unlock() did not release the lock")
+	}
+}
@@ -115,9 +115,10 @@ func Initialize(cfg *config.Config) error {
 	return nil
 }
 
-// Close closes any open file handles
+// Close flushes and closes any open file handles
 func Close() {
 	if logger != nil && logger.fileHandle != nil {
+		_ = logger.fileHandle.Sync()
 		logger.fileHandle.Close()
 		logger.fileHandle = nil
 	}
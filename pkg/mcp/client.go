@@ -0,0 +1,185 @@
+// Package mcp implements a Model Context Protocol client that exposes the
+// tools of external MCP servers to the model
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+)
+
+// protocolVersion is the MCP revision the client speaks
+const protocolVersion = "2025-03-26"
+
+// clientName and clientVersion identify the bot to MCP servers
+const (
+	clientName    = "telegpt"
+	clientVersion = "1.0.0"
+)
+
+// request is a JSON-RPC 2.0 request or notification (without ID)
+type request struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      *int64      `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// response is a JSON-RPC 2.0 message received from a server
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is a JSON-RPC error returned by a server
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("MCP error %d: %s", e.Code, e.Message)
+}
+
+// transport delivers JSON-RPC messages to a server
+type transport interface {
+	// roundTrip sends a request and waits for the response with the same ID
+	roundTrip(ctx context.Context, req request) (*response, error)
+	// notify sends a notification that has no response
+	notify(ctx context.Context, req request) error
+	close() error
+}
+
+// ToolInfo describes a tool offered by an MCP server
+type ToolInfo struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	InputSchema json.RawMessage  `json:"inputSchema"`
+	Annotations *ToolAnnotations `json:"annotations,omitempty"`
+}
+
+// ToolAnnotations are the behaviour hints a server gives about a tool
+type ToolAnnotations struct {
+	ReadOnlyHint    *bool `json:"readOnlyHint,omitempty"`
+	DestructiveHint *bool `json:"destructiveHint,omitempty"`
+}
+
+// ReadOnly reports whether the server declares that the tool does not modify anything
+func (t ToolInfo) ReadOnly() bool {
+	return t.Annotations != nil && t.Annotations.ReadOnlyHint != nil && *t.Annotations.ReadOnlyHint
+}
+
+// Content is an item of a tool result
+type Content struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+}
+
+// CallResult is the result of a tool call
+type CallResult struct {
+	Content []Content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// Client is a connection to a single MCP server
+type Client struct {
+	name      string
+	transport transport
+	nextID    int64
+}
+
+// newClient wraps a transport; Initialize must be called before use
+func newClient(name string, t transport) *Client {
+	return &Client{name: name, transport: t}
+}
+
+// Name returns the configured server name
+func (c *Client) Name() string {
+	return c.name
+}
+
+// call sends a request and decodes its result into out
+func (c *Client) call(ctx context.Context, method string, params, out interface{}) error {
+	id := atomic.AddInt64(&c.nextID, 1)
+	resp, err := c.transport.roundTrip(ctx, request{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("%s: %s: %w", c.name, method, err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s: %s: %w", c.name, method, resp.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, out); err != nil {
+		return fmt.Errorf("%s: %s: error decoding result: %w", c.name, method, err)
+	}
+	return nil
+}
+
+// Initialize performs the MCP handshake
+func (c *Client) Initialize(ctx context.Context) error {
+	params := map[string]interface{}{
+		"protocolVersion": protocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]string{"name": clientName, "version": clientVersion},
+	}
+
+	var result struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := c.call(ctx, "initialize", params, &result); err != nil {
+		return err
+	}
+
+	return c.transport.notify(ctx, request{JSONRPC: "2.0", Method: "notifications/initialized"})
+}
+
+// ListTools returns every tool the server offers
+func (c *Client) ListTools(ctx context.Context) ([]ToolInfo, error) {
+	var tools []ToolInfo
+	cursor := ""
+	for {
+		params := map[string]interface{}{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+
+		var result struct {
+			Tools      []ToolInfo `json:"tools"`
+			NextCursor string     `json:"nextCursor,omitempty"`
+		}
+		if err := c.call(ctx, "tools/list", params, &result); err != nil {
+			return nil, err
+		}
+
+		tools = append(tools, result.Tools...)
+		if result.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// CallTool invokes a tool with JSON arguments
+func (c *Client) CallTool(ctx context.Context, name string, arguments json.RawMessage) (*CallResult, error) {
+	params := map[string]interface{}{
+		"name":      name,
+		"arguments": arguments,
+	}
+
+	var result CallResult
+	if err := c.call(ctx, "tools/call", params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Close shuts down the connection and, for stdio servers, the server process
+func (c *Client) Close() error {
+	return c.transport.close()
+}
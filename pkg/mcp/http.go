@@ -0,0 +1,165 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// sessionHeader carries the session assigned by a streamable HTTP server
+const sessionHeader = "Mcp-Session-Id"
+
+// httpTransport talks to a server over the streamable HTTP transport
+type httpTransport struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	mu        sync.Mutex
+	sessionID string
+}
+
+func newHTTPTransport(url string, headers map[string]string) *httpTransport {
+	return &httpTransport{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{},
+	}
+}
+
+// post sends a JSON-RPC message and returns the HTTP response
+func (t *httpTransport) post(ctx context.Context, req request) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json, text/event-stream")
+	for key, value := range t.headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	t.mu.Lock()
+	if t.sessionID != "" {
+		httpReq.Header.Set(sessionHeader, t.sessionID)
+	}
+	t.mu.Unlock()
+
+	resp, err := t.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %w", err)
+	}
+
+	if sessionID := resp.Header.Get(sessionHeader); sessionID != "" {
+		t.mu.Lock()
+		t.sessionID = sessionID
+		t.mu.Unlock()
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server responded with status code: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (t *httpTransport) roundTrip(ctx context.Context, req request) (*response, error) {
+	resp, err := t.post(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		return readEventStream(resp.Body, *req.ID)
+	}
+
+	var msg response
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	return &msg, nil
+}
+
+// readEventStream reads server-sent events until the response to id arrives
+func readEventStream(body io.Reader, id int64) (*response, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "data:") {
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+
+		// An empty line terminates the event
+		var msg response
+		err := json.Unmarshal([]byte(data.String()), &msg)
+		data.Reset()
+		if err != nil {
+			continue
+		}
+		if msg.Method == "" && msg.ID != nil && *msg.ID == id {
+			return &msg, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading event stream: %w", err)
+	}
+	return nil, fmt.Errorf("event stream ended without a response")
+}
+
+func (t *httpTransport) notify(ctx context.Context, req request) error {
+	resp, err := t.post(ctx, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// close terminates the session if the server assigned one
+func (t *httpTransport) close() error {
+	t.mu.Lock()
+	sessionID := t.sessionID
+	t.mu.Unlock()
+	if sessionID == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest("DELETE", t.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(sessionHeader, sessionID)
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,186 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/openai"
+)
+
+// maxToolNameLength is the longest function name accepted by the chat completions API
+const maxToolNameLength = 64
+
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// Manager owns the connections to the configured MCP servers
+type Manager struct {
+	clients []*Client
+	tools   []openai.Tool
+}
+
+// Connect launches or connects to every configured server and discovers its tools.
+// Servers that fail to start are logged and skipped so that the bot stays available.
+func Connect(ctx context.Context, cfg *config.MCPConfig) *Manager {
+	m := &Manager{}
+
+	for _, server := range cfg.Servers {
+		client, err := connect(ctx, server)
+		if err != nil {
+			logger.Error("Failed to connect to MCP server %s: %v", server.Name, err)
+			continue
+		}
+
+		tools, err := client.ListTools(ctx)
+		if err != nil {
+			logger.Error("Failed to list tools of MCP server %s: %v", server.Name, err)
+			client.Close()
+			continue
+		}
+
+		m.clients = append(m.clients, client)
+		for _, info := range tools {
+			if !allowed(server.Allow, info.Name) {
+				continue
+			}
+			m.tools = append(m.tools, newTool(client, info, isMutating(server, info)))
+		}
+		logger.Info("Connected to MCP server %s", server.Name)
+	}
+
+	return m
+}
+
+// connect creates the transport for a server and performs the handshake
+func connect(ctx context.Context, server config.MCPServerConfig) (*Client, error) {
+	var t transport
+	if server.Command != "" {
+		stdio, err := newStdioTransport(server.Name, server.Command, server.Args, server.Env)
+		if err != nil {
+			return nil, err
+		}
+		t = stdio
+	} else {
+		t = newHTTPTransport(server.URL, server.Headers)
+	}
+
+	client := newClient(server.Name, t)
+	if err := client.Initialize(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Tools returns the discovered tools that passed the allow-lists
+func (m *Manager) Tools() []openai.Tool {
+	return m.tools
+}
+
+// Close disconnects from all servers
+func (m *Manager) Close() {
+	for _, client := range m.clients {
+		if err := client.Close(); err != nil {
+			logger.Warn("Error closing MCP server %s: %v", client.Name(), err)
+		}
+	}
+}
+
+// allowed reports whether a tool passes a server's allow-list; an empty list allows all
+func allowed(allowList []string, name string) bool {
+	if len(allowList) == 0 {
+		return true
+	}
+	for _, entry := range allowList {
+		if entry == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isMutating reports whether a tool needs confirmation: either it is listed as
+// mutating in the configuration or the server does not declare it read-only
+func isMutating(server config.MCPServerConfig, info ToolInfo) bool {
+	for _, name := range server.Mutating {
+		if name == info.Name {
+			return true
+		}
+	}
+	return !info.ReadOnly()
+}
+
+// Tool exposes an MCP server tool to the model
+type Tool struct {
+	client   *Client
+	info     ToolInfo
+	name     string
+	mutating bool
+}
+
+func newTool(client *Client, info ToolInfo, mutating bool) *Tool {
+	return &Tool{
+		client:   client,
+		info:     info,
+		name:     toolName(client.Name(), info.Name),
+		mutating: mutating,
+	}
+}
+
+// toolName prefixes the tool with its server and makes it a valid function name
+func toolName(server, tool string) string {
+	name := invalidToolNameChars.ReplaceAllString(server+"_"+tool, "_")
+	if len(name) > maxToolNameLength {
+		name = name[:maxToolNameLength]
+	}
+	return name
+}
+
+// Name implements openai.Tool
+func (t *Tool) Name() string { return t.name }
+
+// Description implements openai.Tool
+func (t *Tool) Description() string {
+	if t.info.Description == "" {
+		return fmt.Sprintf("%s (from %s)", t.info.Name, t.client.Name())
+	}
+	return t.info.Description
+}
+
+// Parameters implements openai.Tool
+func (t *Tool) Parameters() json.RawMessage {
+	if len(t.info.InputSchema) == 0 {
+		return json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	return t.info.InputSchema
+}
+
+// RequiresConfirmation implements openai.ConfirmableTool
+func (t *Tool) RequiresConfirmation() bool { return t.mutating }
+
+// Execute implements openai.Tool
+func (t *Tool) Execute(ctx context.Context, userID int64, arguments json.RawMessage) (string, error) {
+	result, err := t.client.CallTool(ctx, t.info.Name, arguments)
+	if err != nil {
+		return "", err
+	}
+
+	var texts []string
+	for _, content := range result.Content {
+		if content.Type == "text" {
+			texts = append(texts, content.Text)
+		} else {
+			texts = append(texts, fmt.Sprintf("[%s content]", content.Type))
+		}
+	}
+	output := strings.Join(texts, "\n")
+
+	if result.IsError {
+		return "", fmt.Errorf("%s", output)
+	}
+	return output, nil
+}
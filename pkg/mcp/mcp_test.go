@@ -0,0 +1,240 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/config"
+)
+
+// stubEnv makes the test binary act as a stdio MCP server
+const stubEnv = "TELEGPT_MCP_STUB"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(stubEnv) == "1" {
+		runStdioStub()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runStdioStub serves the stub over stdin and stdout
+func runStdioStub() {
+	scanner := bufio.NewScanner(os.Stdin)
+	encoder := json.NewEncoder(os.Stdout)
+	for scanner.Scan() {
+		if reply := stubHandle(scanner.Bytes()); reply != nil {
+			encoder.Encode(reply)
+		}
+	}
+}
+
+// stubHandle implements a tiny MCP server with a read-only "add" tool, a mutating
+// "delete" tool and a "lookup" tool without annotations
+func stubHandle(data []byte) map[string]interface{} {
+	var req struct {
+		ID     *int64          `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil || req.ID == nil {
+		return nil
+	}
+
+	reply := map[string]interface{}{"jsonrpc": "2.0", "id": *req.ID}
+	switch req.Method {
+	case "initialize":
+		reply["result"] = map[string]interface{}{
+			"protocolVersion": protocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": "stub", "version": "0.1"},
+		}
+	case "tools/list":
+		reply["result"] = map[string]interface{}{
+			"tools": []map[string]interface{}{
+				{
+					"name":        "add",
+					"description": "Add two numbers",
+					"inputSchema": map[string]interface{}{"type": "object"},
+					"annotations": map[string]bool{"readOnlyHint": true},
+				},
+				{
+					"name":        "delete",
+					"description": "Delete a record",
+					"inputSchema": map[string]interface{}{"type": "object"},
+					"annotations": map[string]bool{"readOnlyHint": false, "destructiveHint": true},
+				},
+				{
+					"name":        "lookup",
+					"description": "Look up a record",
+					"inputSchema": map[string]interface{}{"type": "object"},
+				},
+				{
+					"name":        "secret",
+					"inputSchema": map[string]interface{}{"type": "object"},
+				},
+			},
+		}
+	case "tools/call":
+		var params struct {
+			Name      string `json:"name"`
+			Arguments struct {
+				A, B float64
+			} `json:"arguments"`
+		}
+		json.Unmarshal(req.Params, &params)
+		if params.Name != "add" {
+			reply["result"] = map[string]interface{}{
+				"content": []map[string]string{{"type": "text", "text": "not allowed"}},
+				"isError": true,
+			}
+			break
+		}
+		reply["result"] = map[string]interface{}{
+			"content": []map[string]string{{"type": "text", "text": fmt.Sprint(params.Arguments.A + params.Arguments.B)}},
+		}
+	default:
+		reply["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+	}
+	return reply
+}
+
+// checkStubTools verifies discovery, allow-lists, confirmation flags and calls
+func checkStubTools(t *testing.T, m *Manager) {
+	t.Helper()
+
+	tools := m.Tools()
+	if len(tools) != 3 {
+		t.Fatalf("Expected 3 allowed tools, got %d", len(tools))
+	}
+
+	add, del, lookup := tools[0].(*Tool), tools[1].(*Tool), tools[2].(*Tool)
+	if add.Name() != "stub_add" || del.Name() != "stub_delete" || lookup.Name() != "stub_lookup" {
+		t.Errorf("Unexpected tool names %q, %q and %q", add.Name(), del.Name(), lookup.Name())
+	}
+	if add.RequiresConfirmation() {
+		t.Error("Read-only tool should not require confirmation")
+	}
+	if !del.RequiresConfirmation() {
+		t.Error("Destructive tool should require confirmation")
+	}
+	if !lookup.RequiresConfirmation() {
+		t.Error("Tool without annotations should require confirmation")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := add.Execute(ctx, 1, json.RawMessage(`{"a": 2, "b": 3}`))
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result != "5" {
+		t.Errorf("Execute() = %q, expected %q", result, "5")
+	}
+
+	if _, err := del.Execute(ctx, 1, json.RawMessage(`{}`)); err == nil {
+		t.Error("Execute() expected the tool error to be returned")
+	}
+}
+
+func TestStdioServer(t *testing.T) {
+	cfg := &config.MCPConfig{
+		Servers: []config.MCPServerConfig{{
+			Name:    "stub",
+			Command: os.Args[0],
+			Args:    []string{"-test.run=^$"},
+			Env:     map[string]string{stubEnv: "1"},
+			Allow:   []string{"add", "delete", "lookup"},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	m := Connect(ctx, cfg)
+	defer m.Close()
+
+	checkStubTools(t, m)
+}
+
+func TestHTTPServer(t *testing.T) {
+	var sessions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			return
+		}
+		sessions = append(sessions, r.Header.Get(sessionHeader))
+
+		var raw json.RawMessage
+		json.NewDecoder(r.Body).Decode(&raw)
+		reply := stubHandle(raw)
+		if reply == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		w.Header().Set(sessionHeader, "session-1")
+		// Answer tool calls as an event stream and everything else as plain JSON
+		var req struct{ Method string }
+		json.Unmarshal(raw, &req)
+		if req.Method == "tools/call" {
+			data, _ := json.Marshal(reply)
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+	}))
+	defer server.Close()
+
+	cfg := &config.MCPConfig{
+		Servers: []config.MCPServerConfig{{
+			Name:  "stub",
+			URL:   server.URL,
+			Allow: []string{"add", "delete", "lookup"},
+		}},
+	}
+
+	m := Connect(context.Background(), cfg)
+	defer m.Close()
+
+	checkStubTools(t, m)
+
+	if sessions[0] != "" || sessions[len(sessions)-1] != "session-1" {
+		t.Errorf("Session header was not propagated: %v", sessions)
+	}
+}
+
+func TestConnectSkipsFailingServers(t *testing.T) {
+	cfg := &config.MCPConfig{
+		Servers: []config.MCPServerConfig{{Name: "missing", Command: "/nonexistent/mcp-server"}},
+	}
+
+	m := Connect(context.Background(), cfg)
+	defer m.Close()
+
+	if len(m.Tools()) != 0 {
+		t.Errorf("Expected no tools, got %d", len(m.Tools()))
+	}
+}
+
+func TestToolName(t *testing.T) {
+	if name := toolName("jira", "search.issues"); name != "jira_search_issues" {
+		t.Errorf("toolName() = %q, expected %q", name, "jira_search_issues")
+	}
+
+	long := toolName("server", string(make([]byte, 100)))
+	if len(long) != maxToolNameLength {
+		t.Errorf("toolName() length = %d, expected %d", len(long), maxToolNameLength)
+	}
+}
@@ -0,0 +1,202 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/logger"
+)
+
+const (
+	// maxMessageSize bounds a single newline-delimited JSON-RPC message
+	maxMessageSize = 10 * 1024 * 1024
+	// closeTimeout is how long a server may take to exit after stdin is closed
+	closeTimeout = 5 * time.Second
+)
+
+// stdioTransport talks to a server process over its stdin and stdout
+type stdioTransport struct {
+	name    string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[int64]chan *response
+	done    chan struct{}
+	err     error
+}
+
+// newStdioTransport launches the server command
+func newStdioTransport(name, command string, args []string, env map[string]string) (*stdioTransport, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Env = os.Environ()
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stdout pipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stderr pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting %s: %w", command, err)
+	}
+
+	t := &stdioTransport{
+		name:    name,
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[int64]chan *response),
+		done:    make(chan struct{}),
+	}
+
+	go t.readLoop(stdout)
+	go t.logStderr(stderr)
+
+	return t, nil
+}
+
+// readLoop dispatches responses to the waiting callers until stdout closes
+func (t *stdioTransport) readLoop(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+
+	for scanner.Scan() {
+		var msg response
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			logger.Warn("MCP server %s sent invalid JSON: %v", t.name, err)
+			continue
+		}
+
+		switch {
+		case msg.Method != "" && msg.ID != nil:
+			// Requests from the server; only ping is supported
+			t.replyToServer(msg)
+		case msg.Method != "":
+			// Notifications are not used
+		case msg.ID != nil:
+			t.mu.Lock()
+			ch, ok := t.pending[*msg.ID]
+			delete(t.pending, *msg.ID)
+			t.mu.Unlock()
+			if ok {
+				ch <- &msg
+			}
+		}
+	}
+
+	t.mu.Lock()
+	t.err = fmt.Errorf("server %s closed the connection", t.name)
+	if err := scanner.Err(); err != nil {
+		t.err = fmt.Errorf("error reading from server %s: %w", t.name, err)
+	}
+	t.mu.Unlock()
+	close(t.done)
+}
+
+// replyToServer answers a request initiated by the server
+func (t *stdioTransport) replyToServer(msg response) {
+	reply := map[string]interface{}{"jsonrpc": "2.0", "id": *msg.ID}
+	if msg.Method == "ping" {
+		reply["result"] = map[string]interface{}{}
+	} else {
+		reply["error"] = RPCError{Code: -32601, Message: "method not supported"}
+	}
+	if err := t.write(reply); err != nil {
+		logger.Warn("Error replying to MCP server %s: %v", t.name, err)
+	}
+}
+
+// logStderr forwards the server's stderr to the debug log
+func (t *stdioTransport) logStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		logger.Debug("MCP server %s: %s", t.name, scanner.Text())
+	}
+}
+
+func (t *stdioTransport) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err = t.stdin.Write(append(data, '\n'))
+	return err
+}
+
+func (t *stdioTransport) roundTrip(ctx context.Context, req request) (*response, error) {
+	ch := make(chan *response, 1)
+
+	t.mu.Lock()
+	if t.err != nil {
+		err := t.err
+		t.mu.Unlock()
+		return nil, err
+	}
+	t.pending[*req.ID] = ch
+	t.mu.Unlock()
+
+	cleanup := func() {
+		t.mu.Lock()
+		delete(t.pending, *req.ID)
+		t.mu.Unlock()
+	}
+
+	if err := t.write(req); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("error writing request: %w", err)
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-t.done:
+		cleanup()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return nil, t.err
+	case <-ctx.Done():
+		cleanup()
+		return nil, ctx.Err()
+	}
+}
+
+func (t *stdioTransport) notify(ctx context.Context, req request) error {
+	return t.write(req)
+}
+
+// close closes stdin, which asks the server to exit, and kills it if it does not
+func (t *stdioTransport) close() error {
+	t.stdin.Close()
+
+	exited := make(chan error, 1)
+	go func() { exited <- t.cmd.Wait() }()
+
+	select {
+	case <-exited:
+	case <-time.After(closeTimeout):
+		_ = t.cmd.Process.Kill()
+		<-exited
+	}
+	return nil
+}
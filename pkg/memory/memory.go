@@ -0,0 +1,219 @@
+package memory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/privacy"
+	"github.com/redis/go-redis/v9"
+)
+
+// MaxLength is the longest fact that can be remembered, in characters
+const MaxLength = 300
+
+var (
+	// ErrFull is returned when a user has as many memories as allowed
+	ErrFull = errors.New("memory is full")
+	// ErrDuplicate is returned when the fact is already remembered
+	ErrDuplicate = errors.New("already remembered")
+	// ErrTooLong is returned for facts longer than MaxLength
+	ErrTooLong = fmt.Errorf("facts are limited to %d characters", MaxLength)
+)
+
+// NewStore opens the store selected by the configuration. A Redis client is
+// only required for the redis store.
+func NewStore(cfg *config.MemoryConfig, redisClient redis.UniversalClient, redisPrefix string) (Store, error) {
+	switch cfg.Store {
+	case "memory":
+		return NewMemoryStore(), nil
+	case "file", "":
+		return NewFileStore(cfg.Path)
+	case "redis":
+		if redisClient == nil {
+			return nil, fmt.Errorf("memory store redis requires a Redis connection")
+		}
+		return NewRedisStore(redisClient, redisPrefix), nil
+	}
+	return nil, fmt.Errorf("unknown memory store %q", cfg.Store)
+}
+
+// Manager remembers facts about users and picks the ones relevant to a message
+type Manager struct {
+	store      Store
+	maxEntries int
+	now        func() time.Time
+}
+
+// NewManager creates a manager on top of a store
+func NewManager(store Store, cfg *config.MemoryConfig) *Manager {
+	maxEntries := cfg.MaxEntries
+	if maxEntries == 0 {
+		maxEntries = config.DefaultMaxMemories
+	}
+	return &Manager{store: store, maxEntries: maxEntries, now: time.Now}
+}
+
+// Remember stores a fact about a user. Facts that are already remembered are
+// rejected with ErrDuplicate, and ErrFull is returned once the user has the
+// maximum number of memories.
+func (m *Manager) Remember(userID int64, text, source string) (Entry, error) {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return Entry{}, errors.New("nothing to remember")
+	}
+	if utf8.RuneCountInString(text) > MaxLength {
+		return Entry{}, ErrTooLong
+	}
+
+	entries, err := m.store.List(userID)
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, entry := range entries {
+		if normalize(entry.Text) == normalize(text) {
+			return entry, ErrDuplicate
+		}
+	}
+	if len(entries) >= m.maxEntries {
+		return Entry{}, ErrFull
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Entry{}, err
+	}
+	entry := Entry{ID: id, UserID: userID, Text: text, Source: source, CreatedAt: m.now()}
+	if err := m.store.Add(entry); err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+// List returns the memories of a user, oldest first
+func (m *Manager) List(userID int64) ([]Entry, error) {
+	return m.store.List(userID)
+}
+
+// Forget removes a memory of a user and reports whether it existed
+func (m *Manager) Forget(userID int64, id string) (bool, error) {
+	return m.store.Delete(userID, id)
+}
+
+// ForgetAll removes every memory of a user and returns how many there were
+func (m *Manager) ForgetAll(userID int64) (int, error) {
+	return m.store.DeleteUser(userID)
+}
+
+// Relevant returns at most limit memories of a user for a message, oldest
+// first. When there are more, the ones sharing the most words with the message
+// are chosen, and the newer ones among equals.
+func (m *Manager) Relevant(userID int64, text string, limit int) ([]Entry, error) {
+	entries, err := m.store.List(userID)
+	if err != nil || len(entries) <= limit {
+		return entries, err
+	}
+
+	query := words(text)
+	scores := make(map[string]int, len(entries))
+	for _, entry := range entries {
+		scores[entry.ID] = overlap(query, words(entry.Text))
+	}
+
+	ranked := append([]Entry(nil), entries...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if scores[a.ID] != scores[b.ID] {
+			return scores[a.ID] > scores[b.ID]
+		}
+		return a.CreatedAt.After(b.CreatedAt)
+	})
+	ranked = ranked[:limit]
+	sortEntries(ranked)
+	return ranked, nil
+}
+
+// Close releases the store
+func (m *Manager) Close() error {
+	return m.store.Close()
+}
+
+// DataSource exposes the memories of users for privacy exports and erasure
+func (m *Manager) DataSource() privacy.Source {
+	return managerSource{m}
+}
+
+// managerSource implements privacy.Source for a manager
+type managerSource struct {
+	manager *Manager
+}
+
+// Name implements privacy.Source
+func (managerSource) Name() string {
+	return "memories"
+}
+
+// Export implements privacy.Source
+func (s managerSource) Export(userID int64) (interface{}, error) {
+	entries, err := s.manager.List(userID)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Delete implements privacy.Source
+func (s managerSource) Delete(userID int64) error {
+	_, err := s.manager.ForgetAll(userID)
+	return err
+}
+
+// newID returns a short random entry ID
+func newID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating memory ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// normalize folds the differences that do not make two facts different
+func normalize(text string) string {
+	return strings.TrimRight(strings.ToLower(strings.Join(strings.Fields(text), " ")), ".!")
+}
+
+// words splits text into lower-case words of at least two characters
+func words(text string) []string {
+	var result []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if utf8.RuneCountInString(word) >= 2 {
+			result = append(result, word)
+		}
+	}
+	return result
+}
+
+// overlap counts the words of query that appear in words. A word matches
+// another that it starts or is started by, so that inflected forms and
+// particles such as in "프로젝트를" still match.
+func overlap(query, words []string) int {
+	count := 0
+	for _, q := range query {
+		for _, w := range words {
+			if strings.HasPrefix(w, q) || strings.HasPrefix(q, w) {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
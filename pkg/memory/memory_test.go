@@ -0,0 +1,218 @@
+package memory
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// testStores returns every Store implementation
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	file, err := NewFileStore(filepath.Join(t.TempDir(), "memories.json"))
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"file":   file,
+		"redis":  NewRedisStore(client, "test:"),
+	}
+}
+
+// texts returns the texts of entries
+func texts(entries []Entry) []string {
+	result := make([]string, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.Text
+	}
+	return result
+}
+
+func TestStores(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			for i, text := range []string{"b", "a", "c"} {
+				entry := Entry{ID: text, UserID: 1, Text: text, Source: SourceUser, CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+				if err := store.Add(entry); err != nil {
+					t.Fatalf("Add() error = %v", err)
+				}
+			}
+			store.Add(Entry{ID: "x", UserID: 2, Text: "x", CreatedAt: start})
+
+			entries, err := store.List(1)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if got := texts(entries); !reflect.DeepEqual(got, []string{"b", "a", "c"}) {
+				t.Errorf("List() = %v, expected the oldest first", got)
+			}
+			if !entries[0].CreatedAt.Equal(start) || entries[0].Source != SourceUser {
+				t.Errorf("List() entry = %+v", entries[0])
+			}
+
+			if found, err := store.Delete(1, "a"); err != nil || !found {
+				t.Errorf("Delete() = %v, %v, expected true", found, err)
+			}
+			if found, _ := store.Delete(1, "a"); found {
+				t.Error("Delete() of a deleted entry = true")
+			}
+			if found, _ := store.Delete(2, "b"); found {
+				t.Error("Delete() removed the entry of another user")
+			}
+
+			if removed, err := store.DeleteUser(1); err != nil || removed != 2 {
+				t.Errorf("DeleteUser() = %d, %v, expected 2", removed, err)
+			}
+			if entries, _ := store.List(1); len(entries) != 0 {
+				t.Errorf("List() after DeleteUser() = %v", texts(entries))
+			}
+			if entries, _ := store.List(2); len(entries) != 1 {
+				t.Errorf("DeleteUser() removed the entries of another user")
+			}
+		})
+	}
+}
+
+func TestFileStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memories.json")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	store.Add(Entry{ID: "1", UserID: 1, Text: "이름은 김민수", CreatedAt: time.Now()})
+	store.Add(Entry{ID: "2", UserID: 1, Text: "works on TeleGPT", CreatedAt: time.Now()})
+	store.Delete(1, "2")
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	if entries, _ := reopened.List(1); !reflect.DeepEqual(texts(entries), []string{"이름은 김민수"}) {
+		t.Errorf("다시 연 저장소의 기억 = %v", texts(entries))
+	}
+}
+
+func TestManagerRemember(t *testing.T) {
+	manager := NewManager(NewMemoryStore(), &config.MemoryConfig{MaxEntries: 2})
+
+	entry, err := manager.Remember(1, "  My name is   Kim ", SourceUser)
+	if err != nil {
+		t.Fatalf("Remember() error = %v", err)
+	}
+	if entry.ID == "" || entry.Text != "My name is Kim" || entry.UserID != 1 || entry.CreatedAt.IsZero() {
+		t.Errorf("Remember() = %+v", entry)
+	}
+
+	// 대소문자와 마침표만 다른 사실은 중복
+	if _, err := manager.Remember(1, "my name is kim.", SourceExtracted); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Remember() of a duplicate error = %v, expected ErrDuplicate", err)
+	}
+	if _, err := manager.Remember(1, strings.Repeat("가", MaxLength+1), SourceUser); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Remember() of a long fact error = %v, expected ErrTooLong", err)
+	}
+	if _, err := manager.Remember(1, " ", SourceUser); err == nil {
+		t.Error("Remember() of an empty fact expected an error")
+	}
+
+	manager.Remember(1, "Prefers Go", SourceUser)
+	if _, err := manager.Remember(1, "Lives in Seoul", SourceUser); !errors.Is(err, ErrFull) {
+		t.Errorf("Remember() beyond the limit error = %v, expected ErrFull", err)
+	}
+	// 다른 사용자는 따로 셈
+	if _, err := manager.Remember(2, "Lives in Seoul", SourceUser); err != nil {
+		t.Errorf("Remember() for another user error = %v", err)
+	}
+
+	if found, err := manager.Forget(1, entry.ID); err != nil || !found {
+		t.Errorf("Forget() = %v, %v", found, err)
+	}
+	if _, err := manager.Remember(1, "Lives in Seoul", SourceUser); err != nil {
+		t.Errorf("Remember() after Forget() error = %v", err)
+	}
+}
+
+func TestManagerRelevant(t *testing.T) {
+	manager := NewManager(NewMemoryStore(), &config.MemoryConfig{})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	manager.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	for _, fact := range []string{
+		"Works as a backend engineer",
+		"현재 텔레그램 봇 프로젝트를 진행 중",
+		"Has a cat named Nabi",
+		"Prefers answers in Korean",
+	} {
+		if _, err := manager.Remember(1, fact, SourceUser); err != nil {
+			t.Fatalf("Remember() error = %v", err)
+		}
+	}
+
+	// 한도 이내면 모두 반환
+	if entries, _ := manager.Relevant(1, "hello", 10); len(entries) != 4 {
+		t.Errorf("Relevant() = %v, expected all memories", texts(entries))
+	}
+
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{"What should my cat eat?", []string{"Has a cat named Nabi", "Prefers answers in Korean"}},
+		{"프로젝트 진행 상황을 정리해줘", []string{"현재 텔레그램 봇 프로젝트를 진행 중", "Prefers answers in Korean"}},
+		// 겹치는 단어가 없으면 최근 기억
+		{"hello", []string{"Has a cat named Nabi", "Prefers answers in Korean"}},
+	}
+	for _, tt := range tests {
+		entries, err := manager.Relevant(1, tt.text, 2)
+		if err != nil {
+			t.Fatalf("Relevant() error = %v", err)
+		}
+		if got := texts(entries); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Relevant(%q) = %v, expected %v", tt.text, got, tt.expected)
+		}
+	}
+}
+
+func TestManagerDataSource(t *testing.T) {
+	manager := NewManager(NewMemoryStore(), &config.MemoryConfig{})
+	source := manager.DataSource()
+
+	if data, err := source.Export(1); err != nil || data != nil {
+		t.Errorf("Export() without memories = %v, %v, expected nil", data, err)
+	}
+	manager.Remember(1, "Prefers Go", SourceUser)
+	manager.Remember(2, "Prefers Rust", SourceUser)
+
+	data, err := source.Export(1)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if entries, ok := data.([]Entry); !ok || !reflect.DeepEqual(texts(entries), []string{"Prefers Go"}) {
+		t.Errorf("Export() = %#v", data)
+	}
+
+	if err := source.Delete(1); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if entries, _ := manager.List(1); len(entries) != 0 {
+		t.Errorf("Delete() left %v", texts(entries))
+	}
+	if entries, _ := manager.List(2); len(entries) != 1 {
+		t.Error("Delete() removed the memories of another user")
+	}
+}
@@ -0,0 +1,221 @@
+// Package memory keeps durable facts about users, such as their name, role or
+// projects, across conversations
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sources of an entry
+const (
+	// SourceUser marks facts the user saved with /remember
+	SourceUser = "user"
+	// SourceExtracted marks facts the model picked up from a conversation
+	SourceExtracted = "extracted"
+)
+
+// Entry is one remembered fact about a user
+type Entry struct {
+	ID        string    `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Text      string    `json:"text"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store persists memory entries
+type Store interface {
+	// Add stores an entry
+	Add(entry Entry) error
+	// List returns the entries of a user, oldest first
+	List(userID int64) ([]Entry, error)
+	// Delete removes an entry of a user and reports whether it existed
+	Delete(userID int64, id string) (bool, error)
+	// DeleteUser removes the entries of a user and returns how many were removed
+	DeleteUser(userID int64) (int, error)
+	// Close flushes and releases the store
+	Close() error
+}
+
+// MemoryStore keeps entries in memory
+type MemoryStore struct {
+	entries map[int64][]Entry
+	mutex   sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[int64][]Entry)}
+}
+
+// Add implements Store
+func (s *MemoryStore) Add(entry Entry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries[entry.UserID] = append(s.entries[entry.UserID], entry)
+	return nil
+}
+
+// List implements Store
+func (s *MemoryStore) List(userID int64) ([]Entry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	entries := append([]Entry(nil), s.entries[userID]...)
+	sortEntries(entries)
+	return entries, nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(userID int64, id string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := s.entries[userID]
+	for i, entry := range entries {
+		if entry.ID == id {
+			s.entries[userID] = append(entries[:i:i], entries[i+1:]...)
+			if len(s.entries[userID]) == 0 {
+				delete(s.entries, userID)
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DeleteUser implements Store
+func (s *MemoryStore) DeleteUser(userID int64) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	removed := len(s.entries[userID])
+	delete(s.entries, userID)
+	return removed, nil
+}
+
+// Close implements Store
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// all returns every entry, ordered by user and age
+func (s *MemoryStore) all() []Entry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var entries []Entry
+	for _, userEntries := range s.entries {
+		entries = append(entries, userEntries...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].UserID != entries[j].UserID {
+			return entries[i].UserID < entries[j].UserID
+		}
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries
+}
+
+// FileStore keeps entries in memory and writes them to a JSON file after every change
+type FileStore struct {
+	*MemoryStore
+	path       string
+	flushMutex sync.Mutex
+}
+
+// NewFileStore loads the entries from path, creating the file on first write
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading memory file: %w", err)
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error decoding memory file: %w", err)
+	}
+	for _, entry := range entries {
+		_ = s.MemoryStore.Add(entry)
+	}
+	return s, nil
+}
+
+// Add implements Store
+func (s *FileStore) Add(entry Entry) error {
+	if err := s.MemoryStore.Add(entry); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// Delete implements Store
+func (s *FileStore) Delete(userID int64, id string) (bool, error) {
+	found, err := s.MemoryStore.Delete(userID, id)
+	if err != nil || !found {
+		return found, err
+	}
+	return true, s.flush()
+}
+
+// DeleteUser implements Store
+func (s *FileStore) DeleteUser(userID int64) (int, error) {
+	removed, err := s.MemoryStore.DeleteUser(userID)
+	if err != nil {
+		return 0, err
+	}
+	return removed, s.flush()
+}
+
+// Close implements Store
+func (s *FileStore) Close() error {
+	return s.flush()
+}
+
+// flush atomically replaces the file with the current entries
+func (s *FileStore) flush() error {
+	s.flushMutex.Lock()
+	defer s.flushMutex.Unlock()
+
+	entries := s.all()
+	if entries == nil {
+		entries = []Entry{}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding memories: %w", err)
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error replacing %s: %w", path, err)
+	}
+	return nil
+}
+
+// sortEntries orders entries from the oldest to the newest
+func sortEntries(entries []Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+}
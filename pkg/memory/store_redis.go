@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTimeout bounds every Redis round trip of the store
+const redisTimeout = 5 * time.Second
+
+// RedisStore keeps entries in Redis so that replicas share them. The entries of
+// a user are a hash of JSON values keyed by entry ID.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisStore creates a store on top of a Redis client, namespacing keys with prefix
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix + "memory:"}
+}
+
+func (s *RedisStore) userKey(userID int64) string {
+	return s.prefix + strconv.FormatInt(userID, 10)
+}
+
+// Add implements Store
+func (s *RedisStore) Add(entry Entry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding memory: %w", err)
+	}
+	if err := s.client.HSet(ctx, s.userKey(entry.UserID), entry.ID, data).Err(); err != nil {
+		return fmt.Errorf("error storing memory in Redis: %w", err)
+	}
+	return nil
+}
+
+// List implements Store
+func (s *RedisStore) List(userID int64) ([]Entry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	values, err := s.client.HGetAll(ctx, s.userKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error loading memories from Redis: %w", err)
+	}
+	entries := make([]Entry, 0, len(values))
+	for id, value := range values {
+		var entry Entry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, fmt.Errorf("error decoding memory %s: %w", id, err)
+		}
+		entries = append(entries, entry)
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+// Delete implements Store
+func (s *RedisStore) Delete(userID int64, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	removed, err := s.client.HDel(ctx, s.userKey(userID), id).Result()
+	if err != nil {
+		return false, fmt.Errorf("error deleting memory from Redis: %w", err)
+	}
+	return removed > 0, nil
+}
+
+// DeleteUser implements Store
+func (s *RedisStore) DeleteUser(userID int64) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var count *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.HLen(ctx, s.userKey(userID))
+		pipe.Del(ctx, s.userKey(userID))
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error deleting memories from Redis: %w", err)
+	}
+	return int(count.Val()), nil
+}
+
+// Close implements Store. The Redis client is owned by the caller.
+func (s *RedisStore) Close() error {
+	return nil
+}
@@ -0,0 +1,263 @@
+// Package moderation decides what happens to content flagged by the moderation
+// API or by local rules, and counts the violations of users
+package moderation
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/config"
+)
+
+// Stage is the point of a turn at which content is checked
+type Stage string
+
+const (
+	// Input is a message of a user before it reaches the model
+	Input Stage = "input"
+	// Output is an answer of the model before it reaches the chat
+	Output Stage = "output"
+)
+
+// Unavailable is the category of content that could not be checked
+const Unavailable = "moderation-unavailable"
+
+// Violation is a category a piece of content was flagged in
+type Violation struct {
+	Stage    Stage  `json:"stage"`
+	Category string `json:"category"`
+	// Source is "api" or "rule"
+	Source string `json:"source"`
+	Action string `json:"action"`
+}
+
+// Result holds the violations of a turn
+type Result struct {
+	Violations []Violation
+}
+
+// Blocked reports whether any violation blocks the turn
+func (r Result) Blocked() bool {
+	return r.has(config.ModerationBlock)
+}
+
+// Warned reports whether any violation asks to warn the user
+func (r Result) Warned() bool {
+	return r.has(config.ModerationWarn)
+}
+
+// Categories returns the distinct categories of the violations with action, sorted
+func (r Result) Categories(action string) []string {
+	seen := make(map[string]bool)
+	var categories []string
+	for _, v := range r.Violations {
+		if v.Action == action && !seen[v.Category] {
+			seen[v.Category] = true
+			categories = append(categories, v.Category)
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+func (r Result) has(action string) bool {
+	for _, v := range r.Violations {
+		if v.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// rule is a compiled moderation rule
+type rule struct {
+	category string
+	patterns []*regexp.Regexp
+	keywords []string
+}
+
+// Moderator applies the local rules and the configured actions
+type Moderator struct {
+	cfg   config.ModerationConfig
+	rules []rule
+}
+
+// New compiles the rules of a validated configuration
+func New(cfg *config.ModerationConfig) (*Moderator, error) {
+	m := &Moderator{cfg: *cfg}
+	for _, r := range cfg.Rules {
+		compiled := rule{category: r.Category}
+		for _, pattern := range r.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			compiled.patterns = append(compiled.patterns, re)
+		}
+		for _, keyword := range r.Keywords {
+			compiled.keywords = append(compiled.keywords, strings.ToLower(keyword))
+		}
+		m.rules = append(m.rules, compiled)
+	}
+	return m, nil
+}
+
+// Checks reports whether content is checked at a stage
+func (m *Moderator) Checks(stage Stage) bool {
+	if stage == Input {
+		return m.cfg.ChecksInput()
+	}
+	return m.cfg.ChecksOutput()
+}
+
+// UsesAPI reports whether content is classified by the moderation API
+func (m *Moderator) UsesAPI() bool {
+	return m.cfg.API
+}
+
+// Model returns the model of the moderation API
+func (m *Moderator) Model() string {
+	return m.cfg.Model
+}
+
+// FailClosed reports whether content that could not be checked is blocked
+func (m *Moderator) FailClosed() bool {
+	return m.cfg.FailClosed
+}
+
+// Match returns the categories of the local rules that text matches
+func (m *Moderator) Match(text string) []string {
+	lower := strings.ToLower(text)
+	var categories []string
+	for _, r := range m.rules {
+		if r.matches(text, lower) {
+			categories = append(categories, r.category)
+		}
+	}
+	return categories
+}
+
+func (r rule) matches(text, lower string) bool {
+	for _, re := range r.patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	for _, keyword := range r.keywords {
+		if strings.Contains(lower, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// Judge turns flagged categories into violations with their configured actions
+func (m *Moderator) Judge(stage Stage, source string, categories []string) []Violation {
+	violations := make([]Violation, len(categories))
+	for i, category := range categories {
+		action := m.cfg.ActionFor(category)
+		if category == Unavailable {
+			action = config.ModerationBlock
+		}
+		violations[i] = Violation{Stage: stage, Category: category, Source: source, Action: action}
+	}
+	return violations
+}
+
+// Explanation tells the user why a turn was blocked
+func Explanation(result Result) string {
+	var input, output []string
+	for _, v := range result.Violations {
+		if v.Action != config.ModerationBlock {
+			continue
+		}
+		if v.Stage == Input {
+			input = appendUnique(input, describe(v.Category))
+		} else {
+			output = appendUnique(output, describe(v.Category))
+		}
+	}
+	switch {
+	case len(input) > 0:
+		return "🚫 Your message was not sent to the assistant because it appears to contain " +
+			strings.Join(input, ", ") + ". Please rephrase it."
+	case len(output) > 0:
+		return "🚫 The answer was withheld because it appears to contain " +
+			strings.Join(output, ", ") + ". Please try asking differently."
+	}
+	return ""
+}
+
+// Warning tells the user about content that was let through with a warning
+func Warning(result Result) string {
+	var categories []string
+	for _, v := range result.Violations {
+		if v.Action == config.ModerationWarn {
+			categories = appendUnique(categories, describe(v.Category))
+		}
+	}
+	if len(categories) == 0 {
+		return ""
+	}
+	return "⚠️ This conversation appears to involve " + strings.Join(categories, ", ") +
+		". Please keep it within the usage policy; repeated violations are reported to the admins."
+}
+
+// describe turns a category such as "self-harm/intent" into readable words
+func describe(category string) string {
+	if category == Unavailable {
+		return "content that could not be checked"
+	}
+	return strings.NewReplacer("/", " ", "-", " ", "_", " ").Replace(category) + " content"
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// Tracker counts the violations of each user within a sliding window
+type Tracker struct {
+	threshold int
+	window    time.Duration
+	times     map[int64][]time.Time
+	mutex     sync.Mutex
+	now       func() time.Time
+}
+
+// NewTracker creates a tracker that alerts after threshold violations within window
+func NewTracker(threshold int, window time.Duration) *Tracker {
+	return &Tracker{threshold: threshold, window: window, times: make(map[int64][]time.Time), now: time.Now}
+}
+
+// Record adds a violation of a user and returns the number of violations in
+// the window. It reports true when they reach the threshold, after which the
+// count starts over so that admins are not alerted for every further violation.
+func (t *Tracker) Record(userID int64) (int, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	recent := t.times[userID][:0]
+	for _, at := range t.times[userID] {
+		if now.Sub(at) < t.window {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, now)
+	count := len(recent)
+
+	if count >= t.threshold {
+		delete(t.times, userID)
+		return count, true
+	}
+	t.times[userID] = recent
+	return count, false
+}
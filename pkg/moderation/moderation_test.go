@@ -0,0 +1,116 @@
+package moderation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/config"
+)
+
+func newTestModerator(t *testing.T) *Moderator {
+	t.Helper()
+	m, err := New(&config.ModerationConfig{
+		Enabled: true,
+		Check:   "both",
+		Rules: []config.ModerationRule{
+			{Category: "secrets", Patterns: []string{`(?i)password\s*[:=]`}},
+			{Category: "profanity", Keywords: []string{"Darn"}},
+		},
+		Actions:       map[string]string{"profanity": config.ModerationWarn, "self-harm": config.ModerationLog},
+		DefaultAction: config.ModerationBlock,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return m
+}
+
+func TestMatch(t *testing.T) {
+	m := newTestModerator(t)
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{"my PASSWORD = hunter2, darn it", []string{"secrets", "profanity"}},
+		{"DARN", []string{"profanity"}},
+		{"hello", nil},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.text); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Match(%q) = %v, expected %v", tt.text, got, tt.expected)
+		}
+	}
+}
+
+func TestJudge(t *testing.T) {
+	m := newTestModerator(t)
+	violations := m.Judge(Input, "api", []string{"profanity", "self-harm/intent", "violence", Unavailable})
+	actions := make([]string, len(violations))
+	for i, v := range violations {
+		actions[i] = v.Action
+	}
+	expected := []string{config.ModerationWarn, config.ModerationLog, config.ModerationBlock, config.ModerationBlock}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Judge() actions = %v, expected %v (하위 범주는 상위 범주의 동작을 따름)", actions, expected)
+	}
+
+	result := Result{Violations: violations}
+	if !result.Blocked() || !result.Warned() {
+		t.Error("Blocked() and Warned() should both be true")
+	}
+	if categories := result.Categories(config.ModerationBlock); !reflect.DeepEqual(categories, []string{Unavailable, "violence"}) {
+		t.Errorf("Categories(block) = %v", categories)
+	}
+}
+
+func TestExplanation(t *testing.T) {
+	input := Result{Violations: []Violation{
+		{Stage: Input, Category: "self-harm/intent", Action: config.ModerationBlock},
+		{Stage: Input, Category: "profanity", Action: config.ModerationWarn},
+	}}
+	if text := Explanation(input); !strings.Contains(text, "Your message was not sent") || !strings.Contains(text, "self harm intent content") || strings.Contains(text, "profanity") {
+		t.Errorf("Explanation() = %q", text)
+	}
+	if text := Warning(input); !strings.Contains(text, "profanity content") {
+		t.Errorf("Warning() = %q", text)
+	}
+
+	output := Result{Violations: []Violation{{Stage: Output, Category: "violence", Action: config.ModerationBlock}}}
+	if text := Explanation(output); !strings.Contains(text, "The answer was withheld") {
+		t.Errorf("Explanation() of an answer = %q", text)
+	}
+	if text := Explanation(Result{}); text != "" {
+		t.Errorf("Explanation() without violations = %q", text)
+	}
+}
+
+func TestChecks(t *testing.T) {
+	m, _ := New(&config.ModerationConfig{Enabled: true, Check: "input"})
+	if !m.Checks(Input) || m.Checks(Output) {
+		t.Error("check: input은 입력만 검사해야 함")
+	}
+}
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	tracker := NewTracker(3, time.Hour)
+	tracker.now = func() time.Time { return now }
+
+	tracker.Record(1)
+	now = now.Add(70 * time.Minute)
+	// 첫 위반은 기간이 지나 세지 않음
+	tracker.Record(1)
+	tracker.Record(2)
+	if count, alert := tracker.Record(1); count != 2 || alert {
+		t.Errorf("Record() = %d, %v, expected 2 without an alert", count, alert)
+	}
+	if count, alert := tracker.Record(1); count != 3 || !alert {
+		t.Errorf("Record() = %d, %v, expected an alert at 3", count, alert)
+	}
+	// 알림 후에는 다시 처음부터 셈
+	if count, alert := tracker.Record(1); count != 1 || alert {
+		t.Errorf("Record() after an alert = %d, %v", count, alert)
+	}
+}
@@ -0,0 +1,180 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/encryption"
+)
+
+// errNoKeyring is returned when an encrypted record is read without keys
+var errNoKeyring = errors.New("conversation is encrypted but no encryption keys are configured")
+
+// Reencrypter is implemented by the stores that can encrypt conversations.
+// Reencrypt rewrites every record that is not yet encrypted with the active
+// key, plaintext records included, and returns how many it rewrote.
+type Reencrypter interface {
+	Reencrypt() (int, error)
+}
+
+// encryptedConversation is a stored conversation whose title and messages are
+// sealed. The ID and timestamps stay readable so that expiry needs no keys.
+type encryptedConversation struct {
+	ID         string               `json:"id"`
+	LastUpdate time.Time            `json:"last_update"`
+	Started    time.Time            `json:"started,omitempty"`
+	Encrypted  *encryption.Envelope `json:"encrypted,omitempty"`
+}
+
+// encryptedContent is the sealed part of a conversation
+type encryptedContent struct {
+	Title    string          `json:"title,omitempty"`
+	Summary  string          `json:"summary,omitempty"`
+	Messages []storedMessage `json:"messages"`
+}
+
+// conversationCodec encodes conversations for the persistent stores, sealing
+// them when a keyring is set. Plaintext records stay readable either way.
+type conversationCodec struct {
+	keyring *encryption.Keyring
+}
+
+// encode serializes a conversation stored under key. The key is authenticated
+// with the content so that records cannot be swapped between chats.
+func (c conversationCodec) encode(key string, conv *Conversation) ([]byte, error) {
+	if c.keyring == nil {
+		data, err := json.Marshal(conv)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding conversation: %w", err)
+		}
+		return data, nil
+	}
+
+	stored := toStored(conv)
+	content, err := json.Marshal(encryptedContent{Title: stored.Title, Summary: stored.Summary, Messages: stored.Messages})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding conversation: %w", err)
+	}
+	env, err := c.keyring.Seal(content, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting conversation: %w", err)
+	}
+	data, err := json.Marshal(encryptedConversation{ID: conv.ID, LastUpdate: conv.LastUpdate, Started: conv.Started, Encrypted: env})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding conversation: %w", err)
+	}
+	return data, nil
+}
+
+// decode deserializes a conversation stored under key, decrypting it if needed
+func (c conversationCodec) decode(key string, data []byte) (*Conversation, error) {
+	var header encryptedConversation
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("error decoding conversation: %w", err)
+	}
+	if header.Encrypted == nil {
+		return decodeConversation(data)
+	}
+	if c.keyring == nil {
+		return nil, errNoKeyring
+	}
+
+	plaintext, err := c.keyring.Open(header.Encrypted, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting conversation %s: %w", key, err)
+	}
+	var content encryptedContent
+	if err := json.Unmarshal(plaintext, &content); err != nil {
+		return nil, fmt.Errorf("error decoding conversation: %w", err)
+	}
+	return fromStored(storedConversation{
+		ID:         header.ID,
+		Title:      content.Title,
+		Summary:    content.Summary,
+		Messages:   content.Messages,
+		LastUpdate: header.LastUpdate,
+		Started:    header.Started,
+	}), nil
+}
+
+// lastUpdate reads when a stored conversation was last updated without decrypting it
+func (c conversationCodec) lastUpdate(data []byte) (time.Time, error) {
+	var header encryptedConversation
+	if err := json.Unmarshal(data, &header); err != nil {
+		return time.Time{}, fmt.Errorf("error decoding conversation: %w", err)
+	}
+	return header.LastUpdate, nil
+}
+
+// current reports whether a stored conversation is encrypted with the active key
+func (c conversationCodec) current(data []byte) bool {
+	var header encryptedConversation
+	if c.keyring == nil || json.Unmarshal(data, &header) != nil || header.Encrypted == nil {
+		return false
+	}
+	return header.Encrypted.KeyID == c.keyring.ActiveKey()
+}
+
+// reencode decrypts a stored conversation and encrypts it with the active key,
+// keeping its ID and last update
+func (c conversationCodec) reencode(key string, data []byte) ([]byte, error) {
+	conv, err := c.decode(key, data)
+	if err != nil {
+		return nil, err
+	}
+	return c.encode(key, conv)
+}
+
+// encryptedRecord is a stored per-chat record whose content is sealed
+type encryptedRecord struct {
+	Encrypted *encryption.Envelope `json:"encrypted"`
+}
+
+// sealRecord encodes a per-chat record stored under key, sealing it when a
+// keyring is set. Records are JSON so that plaintext ones stay readable.
+func (c conversationCodec) sealRecord(key string, data []byte) ([]byte, error) {
+	if c.keyring == nil {
+		return data, nil
+	}
+	env, err := c.keyring.Seal(data, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting %s: %w", key, err)
+	}
+	return json.Marshal(encryptedRecord{Encrypted: env})
+}
+
+// openRecord decodes a per-chat record stored under key, decrypting it if needed
+func (c conversationCodec) openRecord(key string, data []byte) ([]byte, error) {
+	var record encryptedRecord
+	if json.Unmarshal(data, &record) != nil || record.Encrypted == nil {
+		return data, nil
+	}
+	if c.keyring == nil {
+		return nil, errNoKeyring
+	}
+	plaintext, err := c.keyring.Open(record.Encrypted, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting %s: %w", key, err)
+	}
+	return plaintext, nil
+}
+
+// recordCurrent reports whether a stored record is encrypted with the active key
+func (c conversationCodec) recordCurrent(data []byte) bool {
+	var record encryptedRecord
+	if c.keyring == nil || json.Unmarshal(data, &record) != nil || record.Encrypted == nil {
+		return false
+	}
+	return record.Encrypted.KeyID == c.keyring.ActiveKey()
+}
+
+// resealRecord decrypts a stored record and encrypts it with the active key
+func (c conversationCodec) resealRecord(key string, data []byte) ([]byte, error) {
+	plaintext, err := c.openRecord(key, data)
+	if err != nil {
+		return nil, err
+	}
+	return c.sealRecord(key, plaintext)
+}
@@ -1,106 +1,618 @@
 package openai
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/logger"
+)
+
+const (
+	// defaultSessionID is the session of a chat that never selected one. It is
+	// stored under the chat key alone, like conversations before sessions existed.
+	defaultSessionID = "main"
+	// maxTitleLength is the length in runes of generated session titles
+	maxTitleLength = 40
+)
+
+var (
+	// ErrConversationChanged is returned when a turn is committed to a conversation
+	// that was reset, replaced or expired after the turn began
+	ErrConversationChanged = errors.New("conversation changed during the turn")
+	// ErrSessionNotFound is returned when a session does not exist or was deleted
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionTooOld is returned when resuming a session older than the maximum session age
+	ErrSessionTooOld = errors.New("session is older than the maximum session age")
 )
 
 // Conversation represents a chat session with its history
 type Conversation struct {
-	Messages   []Message
-	LastUpdate time.Time
+	// ID changes whenever the conversation is started anew
+	ID         string    `json:"id"`
+	Title      string    `json:"title,omitempty"`
+	Messages   []Message `json:"messages"`
+	LastUpdate time.Time `json:"last_update"`
+	// Started is when the conversation was started, zero if it predates this field
+	Started time.Time `json:"started,omitempty"`
+	// Summary describes the expired conversation this one continues, if any
+	Summary string `json:"summary,omitempty"`
+}
+
+// Session describes one of the conversations of a chat
+type Session struct {
+	ID       string
+	Title    string
+	Messages int
+	LastUsed time.Time
+	Active   bool
+}
+
+// storedMessage is how a message is persisted: unlike requests to the API the
+// store keeps the model and metadata of every message
+type storedMessage struct {
+	Message
+	Model string       `json:"model,omitempty"`
+	Meta  *MessageMeta `json:"meta,omitempty"`
 }
 
-// ConversationManager manages user conversations
+// storedConversation is the persisted form of a Conversation
+type storedConversation struct {
+	ID         string          `json:"id"`
+	Title      string          `json:"title,omitempty"`
+	Messages   []storedMessage `json:"messages"`
+	LastUpdate time.Time       `json:"last_update"`
+	Started    time.Time       `json:"started,omitempty"`
+	Summary    string          `json:"summary,omitempty"`
+}
+
+// toStored converts a conversation to its persisted form
+func toStored(c *Conversation) storedConversation {
+	stored := storedConversation{ID: c.ID, Title: c.Title, Messages: make([]storedMessage, len(c.Messages)), LastUpdate: c.LastUpdate, Started: c.Started, Summary: c.Summary}
+	for i, msg := range c.Messages {
+		stored.Messages[i] = storedMessage{Message: msg, Model: msg.Model, Meta: msg.Meta}
+	}
+	return stored
+}
+
+// fromStored converts a persisted conversation back
+func fromStored(stored storedConversation) *Conversation {
+	c := &Conversation{ID: stored.ID, Title: stored.Title, LastUpdate: stored.LastUpdate, Started: stored.Started, Summary: stored.Summary, Messages: make([]Message, len(stored.Messages))}
+	for i, msg := range stored.Messages {
+		c.Messages[i] = msg.Message
+		c.Messages[i].Model = msg.Model
+		c.Messages[i].Meta = msg.Meta
+	}
+	return c
+}
+
+// MarshalJSON encodes the conversation including message metadata
+func (c Conversation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toStored(&c))
+}
+
+// UnmarshalJSON decodes a conversation including message metadata
+func (c *Conversation) UnmarshalJSON(data []byte) error {
+	var stored storedConversation
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*c = *fromStored(stored)
+	return nil
+}
+
+// clone returns a copy that shares neither the message slice nor the metadata
+func (c *Conversation) clone() *Conversation {
+	messages := append([]Message(nil), c.Messages...)
+	for i := range messages {
+		if messages[i].Meta != nil {
+			meta := *messages[i].Meta
+			messages[i].Meta = &meta
+		}
+	}
+	return &Conversation{
+		ID:         c.ID,
+		Title:      c.Title,
+		Messages:   messages,
+		LastUpdate: c.LastUpdate,
+		Started:    c.Started,
+		Summary:    c.Summary,
+	}
+}
+
+// newConversationID returns a random conversation ID
+func newConversationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// Fall back to the clock; IDs only need to differ between generations of one key
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// newSessionID returns a short random session ID
+func newSessionID() string {
+	return newConversationID()[:8]
+}
+
+// Expiry tells why a session no longer provides context
+type Expiry int
+
+const (
+	// NotExpired sessions still provide context
+	NotExpired Expiry = iota
+	// ExpiredIdle sessions have been unused for longer than the idle timeout
+	ExpiredIdle
+	// ExpiredAge sessions were started longer ago than the maximum session age
+	ExpiredAge
+)
+
+// Turn is a snapshot of a conversation taken before the model is called. Its
+// messages are committed together with CommitTurn or simply dropped on failure.
+type Turn struct {
+	userID    int64
+	key       string
+	sessionID string
+	// replaces is the active session a new session replaces once the turn is committed
+	replaces       string
+	conversationID string
+	title          string
+	summary        string
+	// Messages is the history the turn is based on
+	Messages []Message
+	// Expired tells why the turn started a new session, if it did
+	Expired Expiry
+	// Previous is the expired conversation the new session replaces, if any
+	Previous *Conversation
+	// Summary describes the conversation the session continues. It is only
+	// stored by CommitTurn, so a turn that fails leaves it to be made again.
+	Summary string
+}
+
+// PolicyFunc returns the retention policy of a chat
+type PolicyFunc func(userID int64) config.RetentionPolicy
+
+// ConversationManager manages the sessions of each chat on top of a
+// ConversationStore. A session that has been idle for longer than the idle
+// timeout of its chat, or is older than the maximum session age, no longer
+// provides context: the next message starts a new session, while the store
+// keeps the old one for its retention period.
 type ConversationManager struct {
-	conversations map[int64]*Conversation
-	mutex         sync.RWMutex
-	maxHistory    int
-	ttl           time.Duration
+	store    ConversationStore
+	policy   PolicyFunc
+	now      func() time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
 }
 
-// NewConversationManager creates a new conversation manager
-func NewConversationManager(maxHistory int, ttl time.Duration) *ConversationManager {
+// NewConversationManager creates a conversation manager that removes expired
+// conversations from the store every expireInterval, or never if it is zero
+// for stores that expire them on their own
+func NewConversationManager(store ConversationStore, policy PolicyFunc, expireInterval time.Duration) *ConversationManager {
 	manager := &ConversationManager{
-		conversations: make(map[int64]*Conversation),
-		maxHistory:    maxHistory,
-		ttl:           ttl,
+		store:  store,
+		policy: policy,
+		now:    time.Now,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
 	}
 
-	// Start a cleanup goroutine
-	go manager.cleanup()
+	if expireInterval > 0 {
+		go manager.expireLoop(expireInterval)
+	} else {
+		close(manager.done)
+	}
 
 	return manager
 }
 
-// GetConversation retrieves a user's conversation
-func (m *ConversationManager) GetConversation(userID int64) *Conversation {
-	m.mutex.RLock()
-	conv, exists := m.conversations[userID]
-	m.mutex.RUnlock()
+// conversationKey is the store key prefix of a user's sessions
+func conversationKey(userID int64) string {
+	return strconv.FormatInt(userID, 10)
+}
+
+// sessionKey is the store key of a session
+func sessionKey(userID int64, sessionID string) string {
+	if sessionID == defaultSessionID {
+		return conversationKey(userID)
+	}
+	return conversationKey(userID) + ":" + sessionID
+}
+
+// activeSession returns the ID of the session a user is talking in
+func (m *ConversationManager) activeSession(userID int64) (string, error) {
+	sessionID, err := m.store.ActiveSession(conversationKey(userID))
+	if err != nil || sessionID == "" {
+		return defaultSessionID, err
+	}
+	return sessionID, nil
+}
+
+// expiry reports whether a conversation of a chat no longer provides context
+func (m *ConversationManager) expiry(policy config.RetentionPolicy, conv *Conversation) Expiry {
+	if len(conv.Messages) == 0 {
+		return NotExpired
+	}
+	now := m.now()
+	if expired(conv.LastUpdate, policy.IdleTimeout, now) {
+		return ExpiredIdle
+	}
+	if !conv.Started.IsZero() && expired(conv.Started, policy.MaxSessionAge, now) {
+		return ExpiredAge
+	}
+	return NotExpired
+}
+
+// recent returns the newest messages of a conversation the chat's policy keeps
+func recent(policy config.RetentionPolicy, messages []Message) []Message {
+	return trimHistory(messages, policy.MaxHistory)
+}
+
+// GetConversation returns a copy of the conversation of the active session,
+// empty if there is none or it has expired
+func (m *ConversationManager) GetConversation(userID int64) (*Conversation, error) {
+	sessionID, err := m.activeSession(userID)
+	if err != nil {
+		return nil, err
+	}
+	conv, err := m.store.Get(sessionKey(userID, sessionID))
+	if err != nil {
+		return nil, err
+	}
+	policy := m.policy(userID)
+	if conv == nil || m.expiry(policy, conv) != NotExpired {
+		return &Conversation{Messages: []Message{}, LastUpdate: m.now()}, nil
+	}
+	conv.Messages = recent(policy, conv.Messages)
+	return conv, nil
+}
+
+// ActiveConversation returns a copy of the conversation of the active session
+// even if it has been idle, or nil if there is none
+func (m *ConversationManager) ActiveConversation(userID int64) (*Conversation, error) {
+	sessionID, err := m.activeSession(userID)
+	if err != nil {
+		return nil, err
+	}
+	return m.store.Get(sessionKey(userID, sessionID))
+}
 
-	if !exists || time.Since(conv.LastUpdate) > m.ttl {
-		// Create a new conversation if none exists or if it's expired
-		m.mutex.Lock()
-		m.conversations[userID] = &Conversation{
-			Messages:   []Message{},
-			LastUpdate: time.Now(),
+// BeginTurn snapshots the active session of a user for a new turn. If the
+// session has expired the turn starts a new one instead and tells why. The new
+// session only becomes the active one when the turn is committed, so a failed
+// turn leaves the expired session in place for the next message.
+func (m *ConversationManager) BeginTurn(userID int64) (*Turn, error) {
+	sessionID, err := m.activeSession(userID)
+	if err != nil {
+		return nil, err
+	}
+	conv, err := m.store.Get(sessionKey(userID, sessionID))
+	if err != nil {
+		return nil, err
+	}
+
+	policy := m.policy(userID)
+	expiry := NotExpired
+	if conv != nil {
+		expiry = m.expiry(policy, conv)
+	}
+	turn := &Turn{userID: userID, Messages: []Message{}, Expired: expiry}
+	if expiry != NotExpired {
+		turn.replaces, sessionID = sessionID, newSessionID()
+		turn.Previous, conv = conv, nil
+	}
+
+	turn.key, turn.sessionID = sessionKey(userID, sessionID), sessionID
+	if conv != nil {
+		turn.conversationID = conv.ID
+		turn.title = conv.Title
+		turn.summary = conv.Summary
+		turn.Summary = conv.Summary
+		turn.Messages = recent(policy, conv.Messages)
+	}
+	return turn, nil
+}
+
+// CommitTurn stores the messages of a turn at once and makes the session a
+// turn started the active one. It returns ErrConversationChanged without
+// keeping anything if the conversation, or for a new session the active one,
+// is no longer the one the turn began with. Untitled sessions are named after
+// their first user message.
+func (m *ConversationManager) CommitTurn(turn *Turn, messages ...Message) error {
+	if err := m.store.Commit(turn.key, turn.conversationID, messages...); err != nil {
+		return err
+	}
+	if turn.replaces != "" {
+		active, err := m.activeSession(turn.userID)
+		if err != nil {
+			return err
+		}
+		if active != turn.replaces {
+			// Another turn replaced the expired session first
+			if err := m.store.Reset(turn.key); err != nil {
+				return err
+			}
+			return ErrConversationChanged
+		}
+		if err := m.store.SetActiveSession(conversationKey(turn.userID), turn.sessionID); err != nil {
+			return err
+		}
+	}
+
+	title := ""
+	if turn.title == "" {
+		for _, msg := range messages {
+			if msg.Role == "user" {
+				title = sessionTitle(msg.Content)
+				break
+			}
+		}
+	}
+	if title == "" && turn.Summary == turn.summary {
+		return nil
+	}
+	return m.store.Update(turn.key, func(conv *Conversation) {
+		if conv.Title == "" {
+			conv.Title = title
+		}
+		if turn.Summary != turn.summary {
+			conv.Summary = turn.Summary
+		}
+	})
+}
+
+// sessionTitle derives a session title from a message
+func sessionTitle(content string) string {
+	title := []rune(strings.Join(strings.Fields(content), " "))
+	if len(title) > maxTitleLength {
+		return strings.TrimSpace(string(title[:maxTitleLength-1])) + "…"
+	}
+	return string(title)
+}
+
+// AddMessage adds a message to the active session
+func (m *ConversationManager) AddMessage(userID int64, message Message) error {
+	sessionID, err := m.activeSession(userID)
+	if err != nil {
+		return err
+	}
+	return m.store.Append(sessionKey(userID, sessionID), message)
+}
+
+// SetReplyMessageID records the Telegram message ID of the answer to the
+// Telegram message requestMessageID. Nothing is changed if the turn is no
+// longer in the history of the active session.
+func (m *ConversationManager) SetReplyMessageID(userID int64, requestMessageID, replyMessageID int) error {
+	sessionID, err := m.activeSession(userID)
+	if err != nil {
+		return err
+	}
+	return m.store.Update(sessionKey(userID, sessionID), func(conv *Conversation) {
+		messages := conv.Messages
+		for i := len(messages) - 1; i >= 0; i-- {
+			meta := messages[i].Meta
+			if messages[i].Role != "user" || meta == nil || meta.TelegramMessageID != requestMessageID {
+				continue
+			}
+			for j := i + 1; j < len(messages); j++ {
+				if messages[j].Role == "assistant" {
+					if messages[j].Meta == nil {
+						messages[j].Meta = &MessageMeta{}
+					}
+					messages[j].Meta.TelegramMessageID = replyMessageID
+					return
+				}
+			}
+			return
+		}
+	})
+}
+
+// ResetConversation clears the history of the active session
+func (m *ConversationManager) ResetConversation(userID int64) error {
+	sessionID, err := m.activeSession(userID)
+	if err != nil {
+		return err
+	}
+	return m.store.Reset(sessionKey(userID, sessionID))
+}
+
+// NewSession starts a new session and makes it the active one, keeping the
+// previous session unless it is still empty. Without a title the session is
+// named after its first message.
+func (m *ConversationManager) NewSession(userID int64, title string) (string, error) {
+	previous, err := m.activeSession(userID)
+	if err != nil {
+		return "", err
+	}
+	if conv, err := m.store.Get(sessionKey(userID, previous)); err != nil {
+		return "", err
+	} else if conv != nil && len(conv.Messages) == 0 {
+		if err := m.store.Reset(sessionKey(userID, previous)); err != nil {
+			return "", err
+		}
+	}
+
+	sessionID := newSessionID()
+	key := sessionKey(userID, sessionID)
+	if err := m.store.Replace(key, []Message{}); err != nil {
+		return "", err
+	}
+	if title = strings.TrimSpace(title); title != "" {
+		if err := m.store.Update(key, func(conv *Conversation) { conv.Title = title }); err != nil {
+			return "", err
 		}
-		conv = m.conversations[userID]
-		m.mutex.Unlock()
 	}
+	return sessionID, m.store.SetActiveSession(conversationKey(userID), sessionID)
+}
 
-	return conv
+// ImportSession stores an imported conversation as a new session and makes it
+// the active one. Untitled conversations are named after their first user message.
+func (m *ConversationManager) ImportSession(userID int64, conv *Conversation) (string, error) {
+	title := conv.Title
+	for _, msg := range conv.Messages {
+		if title == "" && msg.Role == "user" {
+			title = sessionTitle(msg.Content)
+		}
+	}
+
+	sessionID := newSessionID()
+	key := sessionKey(userID, sessionID)
+	if err := m.store.Replace(key, conv.Messages); err != nil {
+		return "", err
+	}
+	if title != "" {
+		if err := m.store.Update(key, func(stored *Conversation) { stored.Title = title }); err != nil {
+			return "", err
+		}
+	}
+	return sessionID, m.store.SetActiveSession(conversationKey(userID), sessionID)
 }
 
-// AddMessage adds a message to the conversation
-func (m *ConversationManager) AddMessage(userID int64, message Message) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// Sessions lists the sessions of a user, most recently used first
+func (m *ConversationManager) Sessions(userID int64) ([]Session, error) {
+	active, err := m.activeSession(userID)
+	if err != nil {
+		return nil, err
+	}
+	convs, err := m.Conversations(userID)
+	if err != nil {
+		return nil, err
+	}
 
-	conv, exists := m.conversations[userID]
-	if !exists {
-		conv = &Conversation{
-			Messages:   []Message{},
-			LastUpdate: time.Now(),
+	var sessions []Session
+	for sessionID, conv := range convs {
+		sessions = append(sessions, Session{
+			ID:       sessionID,
+			Title:    conv.Title,
+			Messages: len(conv.Messages),
+			LastUsed: conv.LastUpdate,
+			Active:   sessionID == active,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastUsed.Equal(sessions[j].LastUsed) {
+			return sessions[i].LastUsed.After(sessions[j].LastUsed)
 		}
-		m.conversations[userID] = conv
+		return sessionKey(userID, sessions[i].ID) < sessionKey(userID, sessions[j].ID)
+	})
+	return sessions, nil
+}
+
+// Conversations returns the stored sessions of a user by session ID
+func (m *ConversationManager) Conversations(userID int64) (map[string]*Conversation, error) {
+	chatKey := conversationKey(userID)
+	keys, err := m.store.List(chatKey)
+	if err != nil {
+		return nil, err
 	}
 
-	// Add the new message
-	conv.Messages = append(conv.Messages, message)
+	convs := make(map[string]*Conversation)
+	for _, key := range keys {
+		sessionID := defaultSessionID
+		if key != chatKey {
+			// Keys of other chats may share the prefix, e.g. 12 and 123:abc
+			if !strings.HasPrefix(key, chatKey+":") {
+				continue
+			}
+			sessionID = strings.TrimPrefix(key, chatKey+":")
+		}
+		conv, err := m.store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if conv != nil {
+			convs[sessionID] = conv
+		}
+	}
+	return convs, nil
+}
 
-	// Trim history if it exceeds the maximum
-	if len(conv.Messages) > m.maxHistory {
-		conv.Messages = conv.Messages[len(conv.Messages)-m.maxHistory:]
+// DeleteAll removes every session of a user, expired or not, together with
+// the session selection and returns how many sessions were removed
+func (m *ConversationManager) DeleteAll(userID int64) (int, error) {
+	removed, err := m.store.ResetChat(conversationKey(userID))
+	if err != nil {
+		return 0, err
 	}
+	return removed, m.store.SetActiveSession(conversationKey(userID), "")
+}
 
-	conv.LastUpdate = time.Now()
+// SwitchSession makes a stored session the active one. Resuming counts as using
+// it, so a session that had been idle provides context again. Sessions older
+// than the maximum session age cannot be resumed.
+func (m *ConversationManager) SwitchSession(userID int64, sessionID string) error {
+	key := sessionKey(userID, sessionID)
+	conv, err := m.store.Get(key)
+	if err != nil {
+		return err
+	}
+	if conv == nil {
+		return ErrSessionNotFound
+	}
+	if m.expiry(m.policy(userID), conv) == ExpiredAge {
+		return ErrSessionTooOld
+	}
+	if err := m.store.Update(key, func(*Conversation) {}); err != nil {
+		return err
+	}
+	return m.store.SetActiveSession(conversationKey(userID), sessionID)
 }
 
-// ResetConversation clears the conversation history for a user
-func (m *ConversationManager) ResetConversation(userID int64) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// DeleteSession removes a session. Deleting the active session starts a new one.
+func (m *ConversationManager) DeleteSession(userID int64, sessionID string) error {
+	key := sessionKey(userID, sessionID)
+	conv, err := m.store.Get(key)
+	if err != nil {
+		return err
+	}
+	if conv == nil {
+		return ErrSessionNotFound
+	}
+	if err := m.store.Reset(key); err != nil {
+		return err
+	}
 
-	m.conversations[userID] = &Conversation{
-		Messages:   []Message{},
-		LastUpdate: time.Now(),
+	active, err := m.activeSession(userID)
+	if err != nil || active != sessionID {
+		return err
 	}
+	return m.store.SetActiveSession(conversationKey(userID), newSessionID())
+}
+
+// Close stops removing expired conversations and closes the store
+func (m *ConversationManager) Close() error {
+	m.stopOnce.Do(func() { close(m.stop) })
+	<-m.done
+	return m.store.Close()
 }
 
-// cleanup periodically removes old conversations
-func (m *ConversationManager) cleanup() {
-	ticker := time.NewTicker(m.ttl / 2)
+// expireLoop periodically removes expired conversations until Close is called
+func (m *ConversationManager) expireLoop(interval time.Duration) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		m.mutex.Lock()
-		for userID, conv := range m.conversations {
-			if time.Since(conv.LastUpdate) > m.ttl {
-				delete(m.conversations, userID)
-			}
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if removed, err := m.store.Expire(); err != nil {
+			logger.Error("Error removing expired conversations: %v", err)
+		} else if removed > 0 {
+			logger.Debug("Removed %d expired conversations", removed)
 		}
-		m.mutex.Unlock()
 	}
 }
@@ -1,7 +1,18 @@
 package openai
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/itswryu/telegpt/pkg/config"
 )
@@ -22,7 +33,7 @@ func TestConversationHistory(t *testing.T) {
 	// 초기 대화 상태 확인
 	// 대화가 존재하지 않으면 GetConversation에서 새로운 대화를 생성하므로
 	// 대화 기록이 비어있는지 확인
-	conv := client.convManager.GetConversation(userID)
+	conv, _ := client.convManager.GetConversation(userID)
 	if len(conv.Messages) > 0 {
 		t.Errorf("대화가 비어있어야 함")
 	}
@@ -32,7 +43,7 @@ func TestConversationHistory(t *testing.T) {
 	client.addMessageToHistory(userID, "user", message1)
 
 	// 대화 기록에 메시지가 추가되었는지 확인
-	conv = client.convManager.GetConversation(userID)
+	conv, _ = client.convManager.GetConversation(userID)
 
 	if len(conv.Messages) != 1 {
 		t.Errorf("대화 기록에 메시지가 1개 있어야 함, 현재: %d", len(conv.Messages))
@@ -55,7 +66,7 @@ func TestConversationHistory(t *testing.T) {
 	client.addMessageToHistory(userID, "assistant", botResponse2)
 
 	// 대화 기록 확인
-	conv = client.convManager.GetConversation(userID)
+	conv, _ = client.convManager.GetConversation(userID)
 	messageCount := len(conv.Messages)
 
 	if messageCount != 4 {
@@ -67,7 +78,7 @@ func TestConversationHistory(t *testing.T) {
 	client.addMessageToHistory(userID, "user", message3)
 
 	// 전체 대화 기록 가져오기
-	conv = client.convManager.GetConversation(userID)
+	conv, _ = client.convManager.GetConversation(userID)
 	messages := make([]Message, len(conv.Messages))
 	copy(messages, conv.Messages)
 
@@ -100,7 +111,7 @@ func TestConversationHistory(t *testing.T) {
 	// 대화 초기화 테스트
 	client.ResetConversation(userID)
 
-	conv = client.convManager.GetConversation(userID)
+	conv, _ = client.convManager.GetConversation(userID)
 
 	if len(conv.Messages) != 0 {
 		t.Errorf("대화 초기화 후 메시지가 없어야 함, 현재: %d", len(conv.Messages))
@@ -108,16 +119,16 @@ func TestConversationHistory(t *testing.T) {
 
 	// 메시지 최대 개수 제한 테스트
 	// 최대 개수보다 많은 메시지를 추가하고 가장 오래된 메시지가 제거되는지 테스트
-	for i := 0; i < maxHistory+5; i++ {
+	for i := 0; i < config.DefaultMaxHistory+5; i++ {
 		client.addMessageToHistory(userID, "user", "테스트 메시지 "+string(rune('A'+i)))
 	}
 
-	conv = client.convManager.GetConversation(userID)
+	conv, _ = client.convManager.GetConversation(userID)
 	messages = make([]Message, len(conv.Messages))
 	copy(messages, conv.Messages)
 
-	if len(messages) > maxHistory {
-		t.Errorf("메시지 수가 최대값(%d)을 초과함: %d", maxHistory, len(messages))
+	if len(messages) > config.DefaultMaxHistory {
+		t.Errorf("메시지 수가 최대값(%d)을 초과함: %d", config.DefaultMaxHistory, len(messages))
 	}
 
 	// 첫 5개 메시지가 제거되었는지 확인
@@ -128,3 +139,617 @@ func TestConversationHistory(t *testing.T) {
 }
 
 // 이제 addMessageToHistory 메서드는 openai.go 파일에 구현되어 있음
+
+// testPolicy returns a policy function that applies policy to every chat
+func testPolicy(policy config.RetentionPolicy) PolicyFunc {
+	return func(int64) config.RetentionPolicy { return policy }
+}
+
+func TestConversationManagerClose(t *testing.T) {
+	manager := NewConversationManager(NewMemoryConversationStore(config.DefaultMaxHistory, time.Millisecond), testPolicy(config.RetentionPolicy{}), time.Minute)
+
+	closed := make(chan struct{})
+	go func() {
+		manager.Close()
+		// 두 번 호출해도 안전해야 함
+		manager.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close()가 정리 고루틴을 멈추지 못함")
+	}
+}
+
+func TestGenerateReplyDiscardsTurnOnFailure(t *testing.T) {
+	fail := true
+	var lastRequest ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&lastRequest)
+		if fail {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mockCompletion(w, "answer")
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.Config{OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"}})
+	client.SetBaseURL(server.URL)
+
+	if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "first"}); err == nil {
+		t.Fatal("GenerateReply() expected an error")
+	}
+	// 실패한 요청의 사용자 메시지는 기록에 남지 않아야 함
+	if conv, _ := client.convManager.GetConversation(1); len(conv.Messages) != 0 {
+		t.Errorf("실패 후 대화 기록 = %+v, 비어 있어야 함", conv.Messages)
+	}
+
+	fail = false
+	if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "second"}); err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	// 시스템 메시지 다음에 사용자 메시지가 하나만 전송되어야 함
+	if contents := messageContents(lastRequest.Messages[1:]); !reflect.DeepEqual(contents, []string{"second"}) {
+		t.Errorf("전송된 메시지 = %v, [second]만 있어야 함", contents)
+	}
+	conv, _ := client.convManager.GetConversation(1)
+	if contents := messageContents(conv.Messages); !reflect.DeepEqual(contents, []string{"second", "answer"}) {
+		t.Errorf("대화 기록 = %v, [second answer]여야 함", contents)
+	}
+}
+
+func TestGenerateReplyDoesNotStoreTurnAfterReset(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		started <- struct{}{}
+		<-release
+		mockCompletion(w, "late answer")
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.Config{OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"}})
+	client.SetBaseURL(server.URL)
+	client.addMessageToHistory(1, "user", "old question")
+	client.addMessageToHistory(1, "assistant", "old answer")
+
+	done := make(chan error)
+	go func() {
+		reply, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "question"})
+		if err == nil && reply.Content != "late answer" {
+			err = fmt.Errorf("reply = %q", reply.Content)
+		}
+		done <- err
+	}()
+
+	// 응답을 기다리는 동안 대화를 초기화
+	<-started
+	if err := client.ResetConversation(1); err != nil {
+		t.Fatalf("ResetConversation() error = %v", err)
+	}
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	// 응답은 전달되지만 초기화된 대화에는 저장되지 않아야 함
+	if conv, _ := client.convManager.GetConversation(1); len(conv.Messages) != 0 {
+		t.Errorf("초기화 후 대화 기록 = %v, 비어 있어야 함", messageContents(conv.Messages))
+	}
+}
+
+func TestGenerateReplyConcurrentTurnsAndResets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mockCompletion(w, "answer to "+req.Messages[len(req.Messages)-1].Content)
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.Config{OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"}})
+	client.SetBaseURL(server.URL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%5 == 0 {
+				client.ResetConversation(1)
+				return
+			}
+			if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: fmt.Sprintf("q%d", i)}); err != nil {
+				t.Errorf("GenerateReply() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 질문과 답변은 항상 짝을 이뤄 저장되어야 함
+	conv, _ := client.convManager.GetConversation(1)
+	if len(conv.Messages)%2 != 0 {
+		t.Fatalf("대화 기록의 메시지 수 = %d, 짝수여야 함", len(conv.Messages))
+	}
+	for i := 0; i < len(conv.Messages); i += 2 {
+		question, answer := conv.Messages[i], conv.Messages[i+1]
+		if question.Role != "user" || answer.Role != "assistant" || answer.Content != "answer to "+question.Content {
+			t.Errorf("짝이 맞지 않는 턴: %+v / %+v", question, answer)
+		}
+	}
+}
+
+func TestGenerateReplyStoresMetadata(t *testing.T) {
+	var lastBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastBody, _ = io.ReadAll(r.Body)
+		mockCompletion(w, "answer")
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.Config{OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"}})
+	client.SetBaseURL(server.URL)
+
+	if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "first", TelegramMessageID: 10}); err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	if err := client.SetReplyMessageID(1, 10, 11); err != nil {
+		t.Fatalf("SetReplyMessageID() error = %v", err)
+	}
+
+	conv, _ := client.convManager.GetConversation(1)
+	if len(conv.Messages) != 2 {
+		t.Fatalf("대화 기록 = %v, 두 개여야 함", messageContents(conv.Messages))
+	}
+	question, answer := conv.Messages[0], conv.Messages[1]
+	if question.Meta == nil || question.Meta.TelegramMessageID != 10 || question.Meta.Time.IsZero() {
+		t.Errorf("사용자 메시지 메타데이터 = %+v", question.Meta)
+	}
+	if answer.Meta == nil || answer.Meta.TelegramMessageID != 11 || answer.Meta.FinishReason != "stop" || answer.Model != "gpt-4.1-nano" {
+		t.Errorf("응답 메시지 메타데이터 = %+v, 모델 = %s", answer.Meta, answer.Model)
+	}
+	if answer.Meta != nil && answer.Meta.Time.Before(question.Meta.Time) {
+		t.Errorf("응답 시각 %v가 질문 시각 %v보다 이름", answer.Meta.Time, question.Meta.Time)
+	}
+
+	// 저장된 메타데이터는 API 요청에 포함되지 않아야 함
+	if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "second", TelegramMessageID: 12}); err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	var sent struct {
+		Messages []map[string]interface{} `json:"messages"`
+	}
+	if err := json.Unmarshal(lastBody, &sent); err != nil {
+		t.Fatalf("요청 디코딩 오류: %v", err)
+	}
+	if len(sent.Messages) != 4 {
+		t.Fatalf("전송된 메시지 수 = %d, 4여야 함", len(sent.Messages))
+	}
+	for _, msg := range sent.Messages {
+		for field := range msg {
+			switch field {
+			case "role", "content":
+			default:
+				t.Errorf("API 요청에 %q 필드가 포함됨: %v", field, msg)
+			}
+		}
+	}
+}
+
+func TestConversationSessions(t *testing.T) {
+	store := NewMemoryConversationStore(config.DefaultMaxHistory, 24*time.Hour)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	manager := NewConversationManager(store, testPolicy(config.RetentionPolicy{IdleTimeout: 30 * time.Minute}), time.Minute)
+	manager.now = store.now
+	defer manager.Close()
+
+	commit := func(content string) {
+		t.Helper()
+		turn, err := manager.BeginTurn(1)
+		if err != nil {
+			t.Fatalf("BeginTurn() error = %v", err)
+		}
+		if err := manager.CommitTurn(turn, Message{Role: "user", Content: content}, Message{Role: "assistant", Content: "ok"}); err != nil {
+			t.Fatalf("CommitTurn() error = %v", err)
+		}
+	}
+
+	// 세션을 선택하지 않은 대화는 기본 세션이 되고 첫 메시지로 제목이 정해짐
+	commit("How do I bake   sourdough bread at home without a proper oven?")
+	sessions, _ := manager.Sessions(1)
+	if len(sessions) != 1 || sessions[0].ID != defaultSessionID || !sessions[0].Active {
+		t.Fatalf("Sessions() = %+v, 기본 세션 하나여야 함", sessions)
+	}
+	if title := sessions[0].Title; title != "How do I bake sourdough bread at home w…" {
+		t.Errorf("자동 제목 = %q", title)
+	}
+
+	// 새 세션을 시작해도 이전 세션은 유지됨
+	now = now.Add(time.Minute)
+	travel, err := manager.NewSession(1, "Travel plans")
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	if conv, _ := manager.GetConversation(1); len(conv.Messages) != 0 {
+		t.Errorf("새 세션의 대화 = %v, 비어 있어야 함", messageContents(conv.Messages))
+	}
+	now = now.Add(time.Minute)
+	commit("Where should I go in May?")
+	sessions, _ = manager.Sessions(1)
+	if len(sessions) != 2 || sessions[0].ID != travel || sessions[0].Title != "Travel plans" || !sessions[0].Active || sessions[1].Active {
+		t.Fatalf("Sessions() = %+v", sessions)
+	}
+
+	// 이전 세션으로 전환하면 그 대화가 이어짐
+	if err := manager.SwitchSession(1, defaultSessionID); err != nil {
+		t.Fatalf("SwitchSession() error = %v", err)
+	}
+	if conv, _ := manager.GetConversation(1); len(conv.Messages) != 2 || conv.Messages[0].Content[:3] != "How" {
+		t.Errorf("전환 후 대화 = %v", messageContents(conv.Messages))
+	}
+	if err := manager.SwitchSession(1, "missing"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("SwitchSession(missing) error = %v, ErrSessionNotFound여야 함", err)
+	}
+
+	// 오래 쉬면 새 세션이 시작되지만 이전 세션은 보존 기간 동안 남아 있음
+	now = now.Add(time.Hour)
+	commit("A fresh topic")
+	sessions, _ = manager.Sessions(1)
+	if len(sessions) != 3 || sessions[0].Title != "A fresh topic" || !sessions[0].Active {
+		t.Fatalf("유휴 후 Sessions() = %+v", sessions)
+	}
+	if err := manager.SwitchSession(1, travel); err != nil {
+		t.Fatalf("SwitchSession() error = %v", err)
+	}
+	if conv, _ := manager.GetConversation(1); len(conv.Messages) != 2 {
+		t.Errorf("유휴 세션 재개 후 대화 = %v, 이어져야 함", messageContents(conv.Messages))
+	}
+
+	// 활성 세션을 삭제하면 새 세션이 시작됨
+	if err := manager.DeleteSession(1, travel); err != nil {
+		t.Fatalf("DeleteSession() error = %v", err)
+	}
+	if conv, _ := manager.GetConversation(1); len(conv.Messages) != 0 {
+		t.Errorf("삭제 후 대화 = %v, 비어 있어야 함", messageContents(conv.Messages))
+	}
+	if sessions, _ := manager.Sessions(1); len(sessions) != 2 {
+		t.Errorf("삭제 후 Sessions() = %+v, 두 개여야 함", sessions)
+	}
+
+	// 보존 기간이 지나면 세션이 사라짐
+	now = now.Add(25 * time.Hour)
+	if sessions, _ := manager.Sessions(1); len(sessions) != 0 {
+		t.Errorf("보존 기간 후 Sessions() = %+v, 비어 있어야 함", sessions)
+	}
+}
+
+func TestConversationRetentionPolicy(t *testing.T) {
+	store := NewMemoryConversationStore(20, 24*time.Hour)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	conversations := config.ConversationConfig{
+		RetentionPolicy: config.RetentionPolicy{MaxHistory: 4, IdleTimeout: 30 * time.Minute, MaxSessionAge: 2 * time.Hour},
+		Chats:           map[int64]config.RetentionPolicy{2: {MaxHistory: 20, IdleTimeout: 3 * time.Hour}},
+	}
+	manager := NewConversationManager(store, conversations.PolicyFor, time.Minute)
+	manager.now = store.now
+	defer manager.Close()
+
+	turn := func(chatID int64, content string) *Turn {
+		t.Helper()
+		turn, err := manager.BeginTurn(chatID)
+		if err != nil {
+			t.Fatalf("BeginTurn() error = %v", err)
+		}
+		if err := manager.CommitTurn(turn, Message{Role: "user", Content: content}, Message{Role: "assistant", Content: "ok"}); err != nil {
+			t.Fatalf("CommitTurn() error = %v", err)
+		}
+		return turn
+	}
+
+	// 채팅별 기록 길이만큼만 문맥으로 사용
+	for i := 0; i < 5; i++ {
+		turn(1, fmt.Sprintf("one %d", i))
+		turn(2, fmt.Sprintf("two %d", i))
+	}
+	if next := turn(1, "next"); len(next.Messages) != 4 || next.Expired != NotExpired {
+		t.Errorf("채팅 1의 문맥 = %d개 (%v), 4개여야 함", len(next.Messages), next.Expired)
+	}
+	if next := turn(2, "next"); len(next.Messages) != 10 {
+		t.Errorf("채팅 2의 문맥 = %d개, 10개여야 함", len(next.Messages))
+	}
+
+	// 유휴 시간이 지나면 새 문맥이 시작되고 이유가 전달됨
+	now = now.Add(time.Hour)
+	if next := turn(1, "after a break"); next.Expired != ExpiredIdle || len(next.Messages) != 0 {
+		t.Errorf("유휴 후 턴 = %v, %d개, ExpiredIdle이어야 함", next.Expired, len(next.Messages))
+	}
+	if next := turn(2, "after a break"); next.Expired != NotExpired || len(next.Messages) == 0 {
+		t.Errorf("채팅 2는 유휴 시간이 3시간이라 이어져야 함: %v", next.Expired)
+	}
+
+	// 계속 사용해도 최대 세션 나이가 지나면 새 문맥이 시작됨
+	now = now.Add(50 * time.Minute)
+	if next := turn(2, "still here"); next.Expired != NotExpired {
+		t.Errorf("최대 나이 전 턴 = %v, 이어져야 함", next.Expired)
+	}
+	now = now.Add(15 * time.Minute)
+	if next := turn(2, "much later"); next.Expired != ExpiredAge {
+		t.Errorf("최대 나이 후 턴 = %v, ExpiredAge여야 함", next.Expired)
+	}
+
+	// 너무 오래된 세션은 재개할 수 없음
+	sessions, _ := manager.Sessions(2)
+	if len(sessions) != 2 {
+		t.Fatalf("Sessions() = %+v, 두 개여야 함", sessions)
+	}
+	if err := manager.SwitchSession(2, sessions[1].ID); !errors.Is(err, ErrSessionTooOld) {
+		t.Errorf("SwitchSession() of an old session error = %v, ErrSessionTooOld여야 함", err)
+	}
+}
+
+func TestExpiredSessionIsReplacedOnCommit(t *testing.T) {
+	store := NewMemoryConversationStore(config.DefaultMaxHistory, 24*time.Hour)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	manager := NewConversationManager(store, testPolicy(config.RetentionPolicy{IdleTimeout: 30 * time.Minute}), time.Minute)
+	manager.now = store.now
+	defer manager.Close()
+
+	first, _ := manager.BeginTurn(1)
+	manager.CommitTurn(first, Message{Role: "user", Content: "hello"}, Message{Role: "assistant", Content: "hi"})
+	now = now.Add(time.Hour)
+
+	// 커밋되지 않은 턴은 만료된 세션을 그대로 둠
+	failed, _ := manager.BeginTurn(1)
+	if failed.Expired != ExpiredIdle || failed.Previous == nil {
+		t.Fatalf("BeginTurn() = %+v, 만료된 세션을 알려야 함", failed)
+	}
+	if sessionID, _ := manager.activeSession(1); sessionID != defaultSessionID {
+		t.Errorf("활성 세션 = %q, 커밋 전에는 바뀌지 않아야 함", sessionID)
+	}
+
+	// 동시에 시작한 두 턴 중 먼저 커밋한 턴만 새 세션이 됨
+	a, _ := manager.BeginTurn(1)
+	b, _ := manager.BeginTurn(1)
+	if a.Expired != ExpiredIdle || a.Previous == nil {
+		t.Fatalf("BeginTurn() after a failed turn = %+v, 만료 알림과 이전 대화가 남아 있어야 함", a)
+	}
+	if err := manager.CommitTurn(a, Message{Role: "user", Content: "a"}, Message{Role: "assistant", Content: "ok"}); err != nil {
+		t.Fatalf("CommitTurn() error = %v", err)
+	}
+	if err := manager.CommitTurn(b, Message{Role: "user", Content: "b"}, Message{Role: "assistant", Content: "ok"}); !errors.Is(err, ErrConversationChanged) {
+		t.Errorf("CommitTurn() of the second turn error = %v, ErrConversationChanged여야 함", err)
+	}
+
+	sessions, _ := manager.Sessions(1)
+	if len(sessions) != 2 || sessions[0].ID != a.sessionID || !sessions[0].Active {
+		t.Errorf("Sessions() = %+v, 기존 세션과 첫 턴의 새 세션만 있어야 함", sessions)
+	}
+}
+
+func TestGenerateReplyCarriesSummary(t *testing.T) {
+	var requests [][]Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req.Messages)
+		if req.Messages[0].Content == summaryPrompt {
+			mockCompletion(w, fmt.Sprintf("summary %d", len(requests)))
+			return
+		}
+		mockCompletion(w, "answer")
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"}}
+	cfg.Conversations.CarrySummary = true
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+	now := time.Now()
+	store := NewMemoryConversationStore(20, 24*time.Hour)
+	store.now = func() time.Time { return now }
+	client.SetConversationStore(store)
+	client.convManager.now = store.now
+
+	ask := func(chatID int64, text string) *Reply {
+		t.Helper()
+		reply, err := client.GenerateReply(context.Background(), Request{ChatID: chatID, Text: text})
+		if err != nil {
+			t.Fatalf("GenerateReply() error = %v", err)
+		}
+		return reply
+	}
+	systemMessage := func() string {
+		return requests[len(requests)-1][0].Content
+	}
+
+	ask(1, "my name is Kim")
+	ask(2, "hello")
+	client.SetCarrySummary(2, false)
+
+	// 유휴 시간이 지나면 이전 대화의 요약이 새 세션의 문맥이 됨
+	now = now.Add(time.Hour)
+	requests = nil
+	reply := ask(1, "what is my name?")
+	if len(requests) != 2 || !reply.Summarized || reply.Expired != ExpiredIdle {
+		t.Fatalf("요청 %d개, Summarized = %v, Expired = %v: 요약 요청과 응답 요청이 있어야 함", len(requests), reply.Summarized, reply.Expired)
+	}
+	if transcript := requests[0][1].Content; !strings.Contains(transcript, "User: my name is Kim") || !strings.Contains(transcript, "Assistant: answer") {
+		t.Errorf("요약할 대화 = %q", transcript)
+	}
+	if !strings.HasSuffix(systemMessage(), summarySection("summary 1")) {
+		t.Errorf("시스템 메시지에 요약이 없음: %q", systemMessage())
+	}
+	if len(requests[1]) != 2 {
+		t.Errorf("새 세션의 요청 메시지 = %d개, 시스템과 사용자 메시지만 있어야 함", len(requests[1]))
+	}
+
+	// 요약은 세션에 저장되어 이후 턴에도 사용됨
+	if conv, _ := client.convManager.GetConversation(1); conv.Summary != "summary 1" {
+		t.Errorf("저장된 요약 = %q", conv.Summary)
+	}
+	ask(1, "and again?")
+	if len(requests) != 3 || !strings.HasSuffix(systemMessage(), summarySection("summary 1")) {
+		t.Errorf("다음 턴의 시스템 메시지 = %q", systemMessage())
+	}
+
+	// 다음 요약은 이전 요약을 이어받음
+	now = now.Add(time.Hour)
+	requests = nil
+	ask(1, "still there?")
+	if transcript := requests[0][1].Content; !strings.HasPrefix(transcript, "Earlier conversation: summary 1") {
+		t.Errorf("요약할 대화에 이전 요약이 없음: %q", transcript)
+	}
+
+	// 요약을 끈 채팅은 빈 문맥으로 시작
+	requests = nil
+	if reply := ask(2, "hello again"); reply.Summarized || reply.Expired != ExpiredIdle || len(requests) != 1 {
+		t.Errorf("요약을 끈 채팅: Summarized = %v, Expired = %v, 요청 %d개", reply.Summarized, reply.Expired, len(requests))
+	}
+	if strings.Contains(systemMessage(), "Summary") {
+		t.Errorf("요약을 끈 채팅의 시스템 메시지 = %q", systemMessage())
+	}
+}
+
+func TestGenerateReplyKeepsExpiryAfterFailedTurn(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case req.Messages[0].Content == summaryPrompt:
+			mockCompletion(w, "summary")
+		case fail:
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			mockCompletion(w, "answer")
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"}}
+	cfg.Conversations.CarrySummary = true
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+	now := time.Now()
+	store := NewMemoryConversationStore(20, 24*time.Hour)
+	store.now = func() time.Time { return now }
+	client.SetConversationStore(store)
+	client.convManager.now = store.now
+
+	if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "my name is Kim"}); err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	now = now.Add(time.Hour)
+
+	// 만료 후 첫 메시지가 실패하면 다음 메시지가 만료 알림과 요약을 받음
+	fail = true
+	if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "what is my name?"}); err == nil {
+		t.Fatal("GenerateReply() expected an error")
+	}
+	fail = false
+	reply, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "what is my name?"})
+	if err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	if reply.Expired != ExpiredIdle || !reply.Summarized {
+		t.Errorf("Expired = %v, Summarized = %v, 실패한 턴 다음에도 만료 알림과 요약이 있어야 함", reply.Expired, reply.Summarized)
+	}
+	if sessions, _ := client.Sessions(1); len(sessions) != 2 {
+		t.Errorf("Sessions() = %+v, 실패한 턴은 세션을 남기지 않아야 함", sessions)
+	}
+}
+
+func TestSummaryIsStoredWithRetriedTurn(t *testing.T) {
+	fail, summaries := false, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case req.Messages[0].Content == summaryPrompt:
+			summaries++
+			mockCompletion(w, fmt.Sprintf("summary %d", summaries))
+		case fail:
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			mockCompletion(w, "answer")
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"}}
+	cfg.Conversations.CarrySummary = true
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+	now := time.Now()
+	store := NewMemoryConversationStore(20, 24*time.Hour)
+	store.now = func() time.Time { return now }
+	client.SetConversationStore(store)
+	client.convManager.now = store.now
+
+	client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "my name is Kim"})
+	now = now.Add(time.Hour)
+
+	fail = true
+	if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "what is my name?"}); err == nil {
+		t.Fatal("GenerateReply() expected an error")
+	}
+	// 실패한 턴의 요약은 어디에도 저장되지 않음
+	if keys, _ := store.List(""); len(keys) != 1 {
+		t.Errorf("List() = %v, 실패한 턴은 새 세션을 저장하지 않아야 함", keys)
+	}
+	if conv, _ := store.Get("1"); conv == nil || conv.Summary != "" {
+		t.Errorf("Get(1) = %+v, 실패한 턴의 요약이 저장되면 안 됨", conv)
+	}
+
+	fail = false
+	if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "what is my name?"}); err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	if summaries != 2 {
+		t.Errorf("요약 요청 %d개, 다시 시도한 턴에서 요약을 다시 만들어야 함", summaries)
+	}
+	if conv, _ := client.convManager.GetConversation(1); conv.Summary != "summary 2" {
+		t.Errorf("저장된 요약 = %q, 커밋된 턴의 요약이어야 함", conv.Summary)
+	}
+}
+
+func TestGenerateReplyContinuesWhenSummaryFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Messages[0].Content == summaryPrompt {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mockCompletion(w, "answer")
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"}}
+	cfg.Conversations.CarrySummary = true
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+	now := time.Now()
+	store := NewMemoryConversationStore(20, 24*time.Hour)
+	store.now = func() time.Time { return now }
+	client.SetConversationStore(store)
+	client.convManager.now = store.now
+
+	if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "hello"}); err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	now = now.Add(time.Hour)
+	reply, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "hello again"})
+	if err != nil {
+		t.Fatalf("요약 실패 시에도 응답해야 함: %v", err)
+	}
+	if reply.Content != "answer" || reply.Summarized {
+		t.Errorf("응답 = %q, Summarized = %v", reply.Content, reply.Summarized)
+	}
+}
@@ -0,0 +1,99 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// embeddingsPath is the endpoint of the embeddings API
+const embeddingsPath = "/embeddings"
+
+// EmbeddingRequest represents a request to compute embeddings
+type EmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// EmbeddingResponse represents the embeddings of the inputs of a request
+type EmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// EmbeddingModel returns the model that computes embeddings
+func (c *Client) EmbeddingModel() string {
+	return c.embeddingModel
+}
+
+// Embed returns the embedding of each input, in order, together with the
+// usage of the request. Embeddings are computed by the primary endpoint only,
+// because vectors of different models cannot be compared.
+func (c *Client) Embed(ctx context.Context, inputs []string) ([][]float32, Usage, error) {
+	reqBytes, err := json.Marshal(EmbeddingRequest{Model: c.embeddingModel, Input: inputs})
+	if err != nil {
+		return nil, Usage{}, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	endpointURL := strings.TrimSuffix(c.baseURL, "/") + embeddingsPath
+	req, err := http.NewRequestWithContext(ctx, "POST", endpointURL, bytes.NewBuffer(reqBytes))
+	if err != nil {
+		return nil, Usage{}, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, Usage{}, fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, Usage{}, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+
+	var result EmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, Usage{}, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	vectors := make([][]float32, len(inputs))
+	for _, data := range result.Data {
+		if data.Index < 0 || data.Index >= len(inputs) {
+			return nil, Usage{}, fmt.Errorf("embedding for unknown input %d", data.Index)
+		}
+		vectors[data.Index] = data.Embedding
+	}
+	for i, vector := range vectors {
+		if len(vector) == 0 {
+			return nil, Usage{}, fmt.Errorf("no embedding returned for input %d", i)
+		}
+	}
+
+	var usage Usage
+	if result.Usage != nil {
+		usage = *result.Usage
+	}
+	return vectors, usage, nil
+}
+
+// embed computes the embedding of one text and adds the usage of the request to usage
+func (c *Client) embed(ctx context.Context, text string, usage map[string]Usage) ([]float32, error) {
+	vectors, used, err := c.Embed(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	total := usage[c.embeddingModel]
+	total.add(used)
+	usage[c.embeddingModel] = total
+	return vectors[0], nil
+}
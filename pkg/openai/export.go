@@ -0,0 +1,182 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// exportVersion is the version of the JSON export format
+const exportVersion = 1
+
+// ExportFormats are the formats a conversation can be exported in
+var ExportFormats = []string{"md", "json", "html"}
+
+// ErrEmptyConversation is returned when there is nothing to export
+var ErrEmptyConversation = errors.New("conversation is empty")
+
+// exportFile is the JSON export of a conversation, which can be imported again
+type exportFile struct {
+	Version    int             `json:"version"`
+	Title      string          `json:"title,omitempty"`
+	ExportedAt time.Time       `json:"exported_at"`
+	Messages   []storedMessage `json:"messages"`
+}
+
+// ExportConversation renders a conversation as md, json or html, showing times in loc
+func ExportConversation(conv *Conversation, format string, loc *time.Location, now time.Time) ([]byte, error) {
+	switch format {
+	case "json":
+		file := exportFile{Version: exportVersion, Title: conv.Title, ExportedAt: now, Messages: make([]storedMessage, len(conv.Messages))}
+		for i, msg := range conv.Messages {
+			file.Messages[i] = storedMessage{Message: msg, Model: msg.Model, Meta: msg.Meta}
+		}
+		return json.MarshalIndent(file, "", "  ")
+	case "md":
+		return exportMarkdown(conv, loc, now), nil
+	case "html":
+		return exportHTML(conv, loc, now)
+	}
+	return nil, fmt.Errorf("unknown export format %q, use one of %s", format, strings.Join(ExportFormats, ", "))
+}
+
+// exportedMessage is a message prepared for the text formats
+type exportedMessage struct {
+	Speaker string
+	Time    string
+	Model   string
+	Content string
+}
+
+// exportMessages labels the messages of a conversation for the text formats
+func exportMessages(conv *Conversation, loc *time.Location) []exportedMessage {
+	messages := make([]exportedMessage, 0, len(conv.Messages))
+	for _, msg := range conv.Messages {
+		exported := exportedMessage{Speaker: "User", Content: msg.Content}
+		if msg.Role == "assistant" {
+			exported.Speaker = "Assistant"
+			exported.Model = msg.Model
+		}
+		if msg.Meta != nil && !msg.Meta.Time.IsZero() {
+			exported.Time = msg.Meta.Time.In(loc).Format("2006-01-02 15:04 MST")
+		}
+		messages = append(messages, exported)
+	}
+	return messages
+}
+
+// exportTitle returns the title of an exported conversation
+func exportTitle(conv *Conversation) string {
+	if conv.Title == "" {
+		return "TeleGPT conversation"
+	}
+	return conv.Title
+}
+
+// exportMarkdown renders a conversation as Markdown
+func exportMarkdown(conv *Conversation, loc *time.Location, now time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %s\n\nExported %s\n", exportTitle(conv), now.In(loc).Format("2006-01-02 15:04 MST"))
+	for _, msg := range exportMessages(conv, loc) {
+		fmt.Fprintf(&buf, "\n## %s", msg.Speaker)
+		if msg.Model != "" {
+			fmt.Fprintf(&buf, " (%s)", msg.Model)
+		}
+		if msg.Time != "" {
+			fmt.Fprintf(&buf, " · %s", msg.Time)
+		}
+		fmt.Fprintf(&buf, "\n\n%s\n", msg.Content)
+	}
+	return buf.Bytes()
+}
+
+// exportTemplate renders a conversation as a standalone HTML page
+var exportTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; }
+.message { border-radius: 0.5rem; padding: 0.75rem 1rem; margin: 1rem 0; }
+.User { background: #e8f0fe; }
+.Assistant { background: #f1f3f4; }
+.header { color: #5f6368; font-size: 0.85rem; margin-bottom: 0.5rem; }
+.content { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="header">Exported {{.ExportedAt}}</p>
+{{range .Messages}}<div class="message {{.Speaker}}">
+<div class="header"><strong>{{.Speaker}}</strong>{{if .Model}} ({{.Model}}){{end}}{{if .Time}} · {{.Time}}{{end}}</div>
+<div class="content">{{.Content}}</div>
+</div>
+{{end}}</body>
+</html>
+`))
+
+// exportHTML renders a conversation as HTML
+func exportHTML(conv *Conversation, loc *time.Location, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	err := exportTemplate.Execute(&buf, struct {
+		Title      string
+		ExportedAt string
+		Messages   []exportedMessage
+	}{exportTitle(conv), now.In(loc).Format("2006-01-02 15:04 MST"), exportMessages(conv, loc)})
+	if err != nil {
+		return nil, fmt.Errorf("error rendering export: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ImportConversation parses a JSON export. Only user and assistant messages
+// are accepted so that an import cannot smuggle in system or tool messages.
+func ImportConversation(data []byte) (*Conversation, error) {
+	var file exportFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("not a JSON export: %w", err)
+	}
+	if file.Version != exportVersion {
+		return nil, fmt.Errorf("unsupported export version %d", file.Version)
+	}
+	if len(file.Messages) == 0 {
+		return nil, ErrEmptyConversation
+	}
+
+	conv := &Conversation{Title: file.Title, Messages: make([]Message, len(file.Messages))}
+	for i, stored := range file.Messages {
+		if stored.Role != "user" && stored.Role != "assistant" {
+			return nil, fmt.Errorf("message %d has unsupported role %q", i+1, stored.Role)
+		}
+		conv.Messages[i] = Message{Role: stored.Role, Content: stored.Content, Model: stored.Model, Meta: stored.Meta}
+	}
+	return conv, nil
+}
+
+// ExportFilename returns the file name of an export of conv in format
+func ExportFilename(conv *Conversation, format string, now time.Time) string {
+	var slug strings.Builder
+slugLoop:
+	for _, r := range strings.ToLower(conv.Title) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			slug.WriteRune(r)
+		case slug.Len() > 0 && !strings.HasSuffix(slug.String(), "-"):
+			slug.WriteByte('-')
+		}
+		if slug.Len() >= 40 {
+			break slugLoop
+		}
+	}
+	name := strings.Trim(slug.String(), "-")
+	if name == "" {
+		name = "conversation"
+	}
+	return fmt.Sprintf("telegpt-%s-%s.%s", name, now.Format("20060102"), format)
+}
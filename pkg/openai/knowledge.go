@@ -0,0 +1,126 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/itswryu/telegpt/pkg/knowledge"
+	"github.com/itswryu/telegpt/pkg/logger"
+)
+
+// SetKnowledge lets chats answer from the knowledge bases of library
+func (c *Client) SetKnowledge(library *knowledge.Library) {
+	c.knowledge = library
+}
+
+// Knowledge returns the knowledge bases, or nil if none are configured
+func (c *Client) Knowledge() *knowledge.Library {
+	return c.knowledge
+}
+
+// EmbedDocuments computes the embeddings of document chunks for indexing. The
+// usage is logged because it belongs to no user.
+func (c *Client) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	if c.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+
+	vectors, usage, err := c.Embed(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Embedded %d document chunks with %s (%d tokens)", len(texts), c.embeddingModel, usage.TotalTokens)
+	return vectors, nil
+}
+
+// KnowledgeBases returns the knowledge bases a chat answers from: its own
+// choice if it made one with SetKnowledgeBase, the configured assignment otherwise
+func (c *Client) KnowledgeBases(chatID int64) []string {
+	if c.knowledge == nil {
+		return nil
+	}
+	settings := c.settings.Get(chatID)
+	var names []string
+	for _, base := range c.knowledge.Bases() {
+		if settings.KnowledgeChosen {
+			if contains(settings.KnowledgeBases, base.Name) {
+				names = append(names, base.Name)
+			}
+		} else if base.UsedBy(chatID) {
+			names = append(names, base.Name)
+		}
+	}
+	return names
+}
+
+// SetKnowledgeBase turns a knowledge base on or off for a chat
+func (c *Client) SetKnowledgeBase(chatID int64, name string, enabled bool) error {
+	if c.knowledge == nil || !c.knowledge.Has(name) {
+		return fmt.Errorf("unknown knowledge base %q", name)
+	}
+	current := c.KnowledgeBases(chatID)
+	return c.settings.Update(chatID, func(settings *ChatSettings) error {
+		names := make([]string, 0, len(current)+1)
+		for _, existing := range current {
+			if existing != name {
+				names = append(names, existing)
+			}
+		}
+		if enabled {
+			names = append(names, name)
+		}
+		settings.KnowledgeBases = names
+		settings.KnowledgeChosen = true
+		return nil
+	})
+}
+
+// ResetKnowledgeBases returns a chat to the knowledge bases assigned in the configuration
+func (c *Client) ResetKnowledgeBases(chatID int64) {
+	_ = c.settings.Update(chatID, func(settings *ChatSettings) error {
+		settings.KnowledgeBases = nil
+		settings.KnowledgeChosen = false
+		return nil
+	})
+}
+
+// knowledgeSection returns the part of the system message with the chunks of
+// the chat's knowledge bases relevant to text, together with those chunks, or
+// an empty string if the chat uses no knowledge base or nothing is relevant
+func (c *Client) knowledgeSection(ctx context.Context, chatID int64, text string, usage map[string]Usage) (string, []knowledge.Match) {
+	names := c.KnowledgeBases(chatID)
+	if len(names) == 0 {
+		return "", nil
+	}
+
+	embedding, err := c.embed(ctx, text, usage)
+	if err != nil {
+		logger.Warn("Error embedding the message of %d for the knowledge base: %v", chatID, err)
+		return "", nil
+	}
+	matches := c.knowledge.Search(names, embedding)
+	if len(matches) == 0 {
+		return "", nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Excerpts from the knowledge base. When they answer the question, base your answer on them " +
+		"and cite the excerpts you use by their number, like [1].")
+	for i, match := range matches {
+		fmt.Fprintf(&sb, "\n\n[%d] %s\n%s", i+1, match.Source(), match.Text)
+	}
+	return sb.String(), matches
+}
+
+// contains reports whether names includes name
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
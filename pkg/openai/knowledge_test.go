@@ -0,0 +1,119 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/knowledge"
+)
+
+// newKnowledgeClient returns a client with two synced knowledge bases: "pets"
+// used by every chat and "travel" assigned to chat 1
+func newKnowledgeClient(t *testing.T, complete http.HandlerFunc) *Client {
+	t.Helper()
+	pets, travel := t.TempDir(), t.TempDir()
+	for path, content := range map[string]string{
+		filepath.Join(pets, "cats.md"):     "# Feeding\n\nCats eat fish twice a day.",
+		filepath.Join(travel, "busan.txt"): "Take the KTX to Busan.",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{
+		Knowledge: config.KnowledgeConfig{
+			Bases: []config.KnowledgeBaseConfig{
+				{Name: "pets", Dir: pets},
+				{Name: "travel", Dir: travel, Chats: []int64{1}},
+			},
+			IndexDir:     t.TempDir(),
+			ChunkSize:    config.DefaultChunkSize,
+			ChunkOverlap: config.DefaultChunkOverlap,
+			TopK:         config.DefaultKnowledgeChunks,
+			MinScore:     0.5,
+		},
+	}
+	client := newTestClient(t, cfg, embeddingHandler(t, complete))
+	library, err := knowledge.NewLibrary(&cfg.Knowledge, client.EmbedDocuments)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	if _, err := library.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	client.SetKnowledge(library)
+	return client
+}
+
+func TestKnowledgeBasesPerChat(t *testing.T) {
+	client := newKnowledgeClient(t, nil)
+
+	if names := client.KnowledgeBases(1); !reflect.DeepEqual(names, []string{"pets", "travel"}) {
+		t.Errorf("KnowledgeBases(1) = %v", names)
+	}
+	if names := client.KnowledgeBases(2); !reflect.DeepEqual(names, []string{"pets"}) {
+		t.Errorf("KnowledgeBases(2) = %v, travel은 채팅 1에만 배정됨", names)
+	}
+
+	if err := client.SetKnowledgeBase(2, "travel", true); err != nil {
+		t.Fatalf("SetKnowledgeBase() error = %v", err)
+	}
+	client.SetKnowledgeBase(2, "pets", false)
+	if names := client.KnowledgeBases(2); !reflect.DeepEqual(names, []string{"travel"}) {
+		t.Errorf("KnowledgeBases(2) after choosing = %v", names)
+	}
+	client.SetKnowledgeBase(2, "travel", false)
+	if names := client.KnowledgeBases(2); len(names) != 0 {
+		t.Errorf("KnowledgeBases(2) with everything off = %v", names)
+	}
+	if err := client.SetKnowledgeBase(2, "unknown", true); err == nil {
+		t.Error("SetKnowledgeBase() expected an error for an unknown base")
+	}
+
+	client.ResetKnowledgeBases(2)
+	if names := client.KnowledgeBases(2); !reflect.DeepEqual(names, []string{"pets"}) {
+		t.Errorf("KnowledgeBases(2) after reset = %v", names)
+	}
+}
+
+func TestGenerateReplyInjectsKnowledge(t *testing.T) {
+	var system string
+	client := newKnowledgeClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		system = req.Messages[0].Content
+		mockCompletion(w, "Fish, twice a day [1].")
+	})
+
+	reply, err := client.GenerateReply(context.Background(), Request{ChatID: 2, Text: "What should my cat eat?"})
+	if err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	if !strings.HasSuffix(system, "[1] cats.md › Feeding\n# Feeding\n\nCats eat fish twice a day.") {
+		t.Errorf("시스템 메시지 = %q, 지식 베이스 발췌가 있어야 함", system)
+	}
+	if len(reply.Sources) != 1 || reply.Sources[0].File != "cats.md" {
+		t.Errorf("Sources = %+v", reply.Sources)
+	}
+	if reply.Usage[config.DefaultEmbeddingModel].TotalTokens != 5 {
+		t.Errorf("Usage = %+v, 질문 임베딩 사용량이 포함되어야 함", reply.Usage)
+	}
+
+	// 배정되지 않은 지식 베이스는 검색하지 않음
+	reply, _ = client.GenerateReply(context.Background(), Request{ChatID: 2, Text: "How do I get to Busan?"})
+	if strings.Contains(system, "Excerpts from the knowledge base") || len(reply.Sources) != 0 {
+		t.Errorf("시스템 메시지 = %q, 관련 발췌가 없어야 함", system)
+	}
+	client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "How do I get to Busan?"})
+	if !strings.Contains(system, "[1] busan.txt\nTake the KTX to Busan.") {
+		t.Errorf("시스템 메시지 = %q, 채팅 1은 travel을 사용함", system)
+	}
+}
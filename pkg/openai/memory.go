@@ -0,0 +1,145 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/memory"
+)
+
+const (
+	// extractionMaxTokens bounds the answer of a memory extraction
+	extractionMaxTokens = 200
+	// maxExtracted is the number of memories taken from one exchange
+	maxExtracted = 3
+)
+
+// extractionPrompt asks the model for new facts about the user in an exchange
+const extractionPrompt = "You maintain a long-term memory of facts about a user. " +
+	"Read the latest exchange and list new durable facts about the user that are worth remembering in future conversations, " +
+	"such as their name, role, preferred language, projects or lasting preferences. " +
+	"Ignore one-off requests, questions, guesses and anything already known. " +
+	"Answer only with a JSON array of short third-person statements, or [] if there is nothing new."
+
+// SetMemory enables long-term memories kept by manager
+func (c *Client) SetMemory(manager *memory.Manager) {
+	c.memory = manager
+}
+
+// Memory returns the long-term memory, or nil if it is disabled
+func (c *Client) Memory() *memory.Manager {
+	return c.memory
+}
+
+// memorySection returns the part of the system message that lists the
+// memories of a user relevant to text, or an empty string if there are none
+func (c *Client) memorySection(userID int64, text string) string {
+	if c.memory == nil {
+		return ""
+	}
+	entries, err := c.memory.Relevant(userID, text, c.memoryConfig.MaxInjected)
+	if err != nil {
+		logger.Warn("Error loading memories of %d: %v", userID, err)
+		return ""
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Facts you remember about the user from earlier conversations:")
+	for _, entry := range entries {
+		sb.WriteString("\n- " + c.redact(userID, entry.Text))
+	}
+	return sb.String()
+}
+
+// ExtractMemories asks the model for new facts about the user in an exchange
+// and remembers them, if extraction is enabled. It returns the new memories and
+// the usage of the request.
+func (c *Client) ExtractMemories(ctx context.Context, userID int64, question, answer string) ([]memory.Entry, map[string]Usage, error) {
+	if c.memory == nil || !c.memoryConfig.Extract {
+		return nil, nil, nil
+	}
+	if c.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+
+	known, err := c.memory.List(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sb strings.Builder
+	if len(known) > 0 {
+		sb.WriteString("Already known:\n")
+		for _, entry := range known {
+			sb.WriteString("- " + c.redact(userID, entry.Text) + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("User: " + c.redact(userID, question) + "\n\nAssistant: " + c.redact(userID, answer))
+
+	maxTokens := extractionMaxTokens
+	result, ep, err := c.createChatCompletion(ctx, c.model, []Message{
+		{Role: "system", Content: extractionPrompt},
+		{Role: "user", Content: sb.String()},
+	}, nil, config.SamplingConfig{MaxTokens: &maxTokens})
+	if err != nil {
+		return nil, nil, err
+	}
+	usage := make(map[string]Usage)
+	if result.Usage != nil {
+		usage[ep.model] = *result.Usage
+	}
+
+	facts, err := parseFacts(result.Choices[0].Message.Content)
+	if err != nil {
+		return nil, usage, err
+	}
+	if len(facts) > maxExtracted {
+		facts = facts[:maxExtracted]
+	}
+
+	var added []memory.Entry
+	for _, fact := range facts {
+		entry, err := c.memory.Remember(userID, fact, memory.SourceExtracted)
+		switch {
+		case errors.Is(err, memory.ErrDuplicate), errors.Is(err, memory.ErrTooLong):
+			continue
+		case errors.Is(err, memory.ErrFull):
+			return added, usage, nil
+		case err != nil:
+			return added, usage, err
+		}
+		added = append(added, entry)
+	}
+	return added, usage, nil
+}
+
+// parseFacts decodes the JSON array of an extraction answer, tolerating text
+// or a code fence around it
+func parseFacts(content string) ([]string, error) {
+	start, end := strings.Index(content, "["), strings.LastIndex(content, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("extraction answer is not a JSON array: %q", content)
+	}
+	var facts []string
+	if err := json.Unmarshal([]byte(content[start:end+1]), &facts); err != nil {
+		return nil, fmt.Errorf("error decoding extracted memories: %w", err)
+	}
+
+	result := facts[:0]
+	for _, fact := range facts {
+		if fact = strings.TrimSpace(fact); fact != "" {
+			result = append(result, fact)
+		}
+	}
+	return result, nil
+}
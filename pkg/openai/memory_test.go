@@ -0,0 +1,134 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/memory"
+)
+
+// newMemoryClient returns a client with long-term memory whose API is served by handler
+func newMemoryClient(t *testing.T, cfg config.MemoryConfig, handler http.HandlerFunc) *Client {
+	t.Helper()
+	cfg.Enabled = true
+	client := newTestClient(t, &config.Config{Memory: cfg}, handler)
+	client.SetMemory(memory.NewManager(memory.NewMemoryStore(), &cfg))
+	return client
+}
+
+func TestGenerateReplyInjectsMemories(t *testing.T) {
+	var system string
+	client := newMemoryClient(t, config.MemoryConfig{MaxInjected: 2}, func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		system = req.Messages[0].Content
+		mockCompletion(w, "answer")
+	})
+
+	if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "hello"}); err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	if strings.Contains(system, "Facts you remember") {
+		t.Errorf("기억이 없는데 시스템 메시지에 섹션이 있음: %q", system)
+	}
+
+	for _, fact := range []string{"Name is Kim", "Has a cat named Nabi", "Prefers answers in Korean"} {
+		client.Memory().Remember(1, fact, memory.SourceUser)
+	}
+	client.Memory().Remember(2, "Name is Lee", memory.SourceUser)
+
+	if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "what does my cat like?"}); err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	expected := "Facts you remember about the user from earlier conversations:\n- Has a cat named Nabi\n- Prefers answers in Korean"
+	if !strings.HasSuffix(system, expected) {
+		t.Errorf("시스템 메시지 = %q, 관련 기억 두 개가 있어야 함", system)
+	}
+	if strings.Contains(system, "Lee") {
+		t.Error("다른 사용자의 기억이 포함됨")
+	}
+}
+
+func TestExtractMemories(t *testing.T) {
+	var prompt string
+	answer := "```json\n[\"Works as a backend engineer\", \"Name is Kim\", \"\"]\n```"
+	client := newMemoryClient(t, config.MemoryConfig{Extract: true, MaxEntries: 3}, func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		prompt = req.Messages[1].Content
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"role": "assistant", "content": answer}}},
+			"usage":   map[string]int{"prompt_tokens": 50, "completion_tokens": 10, "total_tokens": 60},
+		})
+	})
+	client.Memory().Remember(1, "Name is Kim", memory.SourceUser)
+
+	added, usage, err := client.ExtractMemories(context.Background(), 1, "I'm a backend engineer", "Nice!")
+	if err != nil {
+		t.Fatalf("ExtractMemories() error = %v", err)
+	}
+	if len(added) != 1 || added[0].Text != "Works as a backend engineer" || added[0].Source != memory.SourceExtracted {
+		t.Errorf("ExtractMemories() = %+v, 새 사실 하나만 추가되어야 함", added)
+	}
+	if usage["gpt-4.1-nano"].TotalTokens != 60 {
+		t.Errorf("ExtractMemories() usage = %+v", usage)
+	}
+	if !strings.Contains(prompt, "Already known:\n- Name is Kim") || !strings.Contains(prompt, "User: I'm a backend engineer") {
+		t.Errorf("추출 요청 = %q", prompt)
+	}
+
+	// 기억이 가득 차면 더 추가하지 않음
+	answer = `["Lives in Seoul", "Has a cat"]`
+	added, _, err = client.ExtractMemories(context.Background(), 1, "I live in Seoul with my cat", "Nice!")
+	if err != nil || len(added) != 1 {
+		t.Errorf("ExtractMemories() on a nearly full memory = %+v, %v", added, err)
+	}
+	entries, _ := client.Memory().List(1)
+	if len(entries) != 3 {
+		t.Errorf("기억 = %d개, 최대 3개여야 함", len(entries))
+	}
+
+	answer = "nothing new"
+	if _, _, err := client.ExtractMemories(context.Background(), 1, "hi", "hello"); err == nil {
+		t.Error("ExtractMemories() expected an error for an answer without a JSON array")
+	}
+}
+
+func TestExtractMemoriesDisabled(t *testing.T) {
+	client := newMemoryClient(t, config.MemoryConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("추출이 꺼져 있으면 API를 호출하지 않아야 함")
+	})
+	if added, usage, err := client.ExtractMemories(context.Background(), 1, "I'm Kim", "Hi Kim"); added != nil || usage != nil || err != nil {
+		t.Errorf("ExtractMemories() = %v, %v, %v", added, usage, err)
+	}
+}
+
+func TestParseFacts(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected []string
+	}{
+		{`[]`, []string{}},
+		{`["a", " b "]`, []string{"a", "b"}},
+		{"Here you go:\n```json\n[\"a\"]\n```", []string{"a"}},
+	}
+	for _, tt := range tests {
+		facts, err := parseFacts(tt.content)
+		if err != nil {
+			t.Errorf("parseFacts(%q) error = %v", tt.content, err)
+			continue
+		}
+		if len(facts) != len(tt.expected) || (len(facts) > 0 && !reflect.DeepEqual(facts, tt.expected)) {
+			t.Errorf("parseFacts(%q) = %v, expected %v", tt.content, facts, tt.expected)
+		}
+	}
+	if _, err := parseFacts(`{"facts": "a"}`); err == nil {
+		t.Error("parseFacts() expected an error for an object")
+	}
+}
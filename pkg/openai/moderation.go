@@ -0,0 +1,110 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/moderation"
+)
+
+// moderationsPath is the endpoint of the moderation API
+const moderationsPath = "/moderations"
+
+// ModerationRequest represents a request to classify content
+type ModerationRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+// ModerationResponse represents the classification of content
+type ModerationResponse struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+}
+
+// SetModerator checks messages and answers with moderator
+func (c *Client) SetModerator(moderator *moderation.Moderator) {
+	c.moderator = moderator
+}
+
+// Moderate returns the categories the moderation API flags text in, sorted
+func (c *Client) Moderate(ctx context.Context, text string) ([]string, error) {
+	reqBytes, err := json.Marshal(ModerationRequest{Model: c.moderator.Model(), Input: text})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	endpointURL := strings.TrimSuffix(c.baseURL, "/") + moderationsPath
+	req, err := http.NewRequestWithContext(ctx, "POST", endpointURL, bytes.NewBuffer(reqBytes))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+
+	var result ModerationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	var categories []string
+	for _, r := range result.Results {
+		for category, flagged := range r.Categories {
+			if flagged {
+				categories = append(categories, category)
+			}
+		}
+	}
+	sort.Strings(categories)
+	return categories, nil
+}
+
+// moderate checks the content of a stage with the local rules and the
+// moderation API and returns the violations found. An unreachable API lets
+// the content through unless moderation fails closed. A cancelled or timed
+// out request is returned as an error rather than judged.
+func (c *Client) moderate(ctx context.Context, stage moderation.Stage, userID int64, text string) ([]moderation.Violation, error) {
+	if c.moderator == nil || !c.moderator.Checks(stage) {
+		return nil, nil
+	}
+
+	violations := c.moderator.Judge(stage, "rule", c.moderator.Match(text))
+	if c.moderator.UsesAPI() {
+		categories, err := c.Moderate(ctx, text)
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			logger.Warn("Error moderating the %s of %d: %v", stage, userID, err)
+			if c.moderator.FailClosed() {
+				categories = []string{moderation.Unavailable}
+			}
+		}
+		violations = append(violations, c.moderator.Judge(stage, "api", categories)...)
+	}
+
+	for _, v := range violations {
+		logger.Warn("Moderation flagged the %s of %d as %s by %s (%s)", stage, userID, v.Category, v.Source, v.Action)
+	}
+	return violations, nil
+}
@@ -0,0 +1,144 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/moderation"
+)
+
+// newModerationClient returns a client whose moderation API flags text
+// containing "attack" as violence and whose model answers with answer
+func newModerationClient(t *testing.T, cfg config.ModerationConfig, answer *string, completions *int) *Client {
+	t.Helper()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moderations" {
+			var req ModerationRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Model != config.DefaultModerationModel {
+				t.Errorf("모더레이션 모델 = %q", req.Model)
+			}
+			if strings.Contains(req.Input, "unavailable") {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			flagged := strings.Contains(req.Input, "attack")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"results": []map[string]interface{}{{
+					"flagged":    flagged,
+					"categories": map[string]bool{"violence": flagged, "harassment": false},
+				}},
+			})
+			return
+		}
+		*completions++
+		mockCompletion(w, *answer)
+	})
+
+	cfg.Enabled = true
+	cfg.Model = config.DefaultModerationModel
+	if cfg.Check == "" {
+		cfg.Check = "both"
+	}
+	if cfg.DefaultAction == "" {
+		cfg.DefaultAction = config.ModerationBlock
+	}
+	client := newTestClient(t, &config.Config{}, handler)
+	moderator, err := moderation.New(&cfg)
+	if err != nil {
+		t.Fatalf("moderation.New() error = %v", err)
+	}
+	client.SetModerator(moderator)
+	return client
+}
+
+func TestGenerateReplyBlocksInput(t *testing.T) {
+	answer, completions := "answer", 0
+	client := newModerationClient(t, config.ModerationConfig{
+		API:   true,
+		Rules: []config.ModerationRule{{Category: "secrets", Patterns: []string{`sk-[a-z0-9]{8}`}}},
+	}, &answer, &completions)
+
+	for _, text := range []string{"plan an attack", "my key is sk-abcdef12"} {
+		reply, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: text})
+		if err != nil {
+			t.Fatalf("GenerateReply() error = %v", err)
+		}
+		if !reply.Blocked || !strings.Contains(reply.Content, "Your message was not sent") {
+			t.Errorf("GenerateReply(%q) = %+v, 차단되어야 함", text, reply)
+		}
+	}
+	if completions != 0 {
+		t.Errorf("차단된 메시지가 모델에 전달됨 (%d회)", completions)
+	}
+	if conv, _ := client.convManager.GetConversation(1); len(conv.Messages) != 0 {
+		t.Errorf("차단된 메시지가 기록에 저장됨: %+v", conv.Messages)
+	}
+
+	reply, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "hello"})
+	if err != nil || reply.Blocked || reply.Content != "answer" || len(reply.Moderation.Violations) != 0 {
+		t.Errorf("GenerateReply() of a clean message = %+v, %v", reply, err)
+	}
+}
+
+func TestGenerateReplyBlocksOutput(t *testing.T) {
+	answer, completions := "here is how to attack", 0
+	client := newModerationClient(t, config.ModerationConfig{API: true, Check: "output"}, &answer, &completions)
+
+	// check: output이면 입력은 검사하지 않음
+	reply, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "tell me about an attack"})
+	if err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	if completions != 1 || !reply.Blocked || !strings.Contains(reply.Content, "The answer was withheld") {
+		t.Errorf("GenerateReply() = %+v, 답변이 차단되어야 함", reply)
+	}
+	if reply.Model != "gpt-4.1-nano" || reply.Usage == nil {
+		t.Errorf("차단된 답변의 모델과 사용량도 보고되어야 함: %+v", reply)
+	}
+	if conv, _ := client.convManager.GetConversation(1); len(conv.Messages) != 0 {
+		t.Errorf("차단된 답변이 기록에 저장됨: %+v", conv.Messages)
+	}
+}
+
+func TestGenerateReplyWarnsAndLogs(t *testing.T) {
+	answer, completions := "answer", 0
+	client := newModerationClient(t, config.ModerationConfig{
+		API:     true,
+		Rules:   []config.ModerationRule{{Category: "profanity", Keywords: []string{"darn"}}},
+		Actions: map[string]string{"violence": config.ModerationLog, "profanity": config.ModerationWarn},
+	}, &answer, &completions)
+
+	reply, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "darn, the attack failed"})
+	if err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	if reply.Blocked || reply.Content != "answer" || !reply.Moderation.Warned() || len(reply.Moderation.Violations) != 2 {
+		t.Errorf("GenerateReply() = %+v, 경고와 기록만 하고 답해야 함", reply)
+	}
+}
+
+func TestModerationAPIUnavailable(t *testing.T) {
+	answer, completions := "answer", 0
+	client := newModerationClient(t, config.ModerationConfig{API: true, Check: "input"}, &answer, &completions)
+	if reply, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "unavailable"}); err != nil || reply.Blocked {
+		t.Errorf("GenerateReply() failing open = %+v, %v", reply, err)
+	}
+
+	client = newModerationClient(t, config.ModerationConfig{API: true, Check: "input", FailClosed: true}, &answer, &completions)
+	if reply, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "unavailable"}); err != nil || !reply.Blocked {
+		t.Errorf("GenerateReply() failing closed = %+v, %v", reply, err)
+	}
+
+	// A request cut off by shutdown is an error, not content that could not be checked
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if reply, err := client.GenerateReply(ctx, Request{ChatID: 1, Text: "hello"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateReply() cancelled = %+v, %v", reply, err)
+	}
+}
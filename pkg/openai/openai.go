@@ -2,31 +2,83 @@ package openai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/knowledge"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/memory"
+	"github.com/itswryu/telegpt/pkg/moderation"
+	"github.com/itswryu/telegpt/pkg/prompt"
+	"github.com/itswryu/telegpt/pkg/recall"
+	"github.com/itswryu/telegpt/pkg/redact"
 )
 
 const (
-	defaultOpenAIBaseURL = "https://api.openai.com/v1/chat/completions"
-	timeout              = 60 * time.Second
-	maxHistory           = 10
-	historyTTL           = 30 * time.Minute
+	defaultOpenAIBaseURL = "https://api.openai.com/v1"
+	chatCompletionsPath  = "/chat/completions"
 )
 
 // Message represents a message in a chat conversation
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	// Model records which model produced an assistant message; it is never sent to the API
+	Model string `json:"-"`
+	// Meta describes a stored turn; it is never sent to the API
+	Meta *MessageMeta `json:"-"`
+}
+
+// MessageMeta is the bookkeeping kept with a message in the conversation history
+type MessageMeta struct {
+	Time time.Time `json:"time"`
+	// TelegramMessageID is the Telegram message the turn was received or sent as
+	TelegramMessageID int `json:"telegram_message_id,omitempty"`
+	// Token counts, finish reason and latency of the requests behind an assistant message
+	PromptTokens     int           `json:"prompt_tokens,omitempty"`
+	CompletionTokens int           `json:"completion_tokens,omitempty"`
+	FinishReason     string        `json:"finish_reason,omitempty"`
+	Latency          time.Duration `json:"latency,omitempty"`
+}
+
+// Request is a user message to be answered
+type Request struct {
+	// ChatID keys the conversation, settings and tool permissions
+	ChatID int64
+	Text   string
+	// TelegramMessageID is the ID of the Telegram message being answered, if any
+	TelegramMessageID int
+	// FirstName, LanguageCode, ChatTitle and ChatType describe the sender and
+	// the chat to the system prompt template
+	FirstName    string
+	LanguageCode string
+	ChatTitle    string
+	ChatType     string
 }
 
 // ChatCompletionRequest represents a request to create a chat completion
 type ChatCompletionRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model            string           `json:"model"`
+	Messages         []Message        `json:"messages"`
+	Tools            []ToolDefinition `json:"tools,omitempty"`
+	Temperature      *float64         `json:"temperature,omitempty"`
+	TopP             *float64         `json:"top_p,omitempty"`
+	MaxTokens        *int             `json:"max_tokens,omitempty"`
+	PresencePenalty  *float64         `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64         `json:"frequency_penalty,omitempty"`
+	Seed             *int64           `json:"seed,omitempty"`
+	Stop             []string         `json:"stop,omitempty"`
 }
 
 // ChatCompletionResponse represents a response from the OpenAI API
@@ -39,18 +91,125 @@ type ChatCompletionResponse struct {
 		Message      Message `json:"message"`
 		FinishReason string  `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// Usage is the token usage reported for a request
+type Usage struct {
+	PromptTokens        int                  `json:"prompt_tokens"`
+	CompletionTokens    int                  `json:"completion_tokens"`
+	TotalTokens         int                  `json:"total_tokens"`
+	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+// PromptTokensDetails breaks down the prompt tokens of a request
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// CachedTokens returns the number of prompt tokens served from the provider's cache
+func (u Usage) CachedTokens() int {
+	if u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokens
+}
+
+// add accumulates other into u
+func (u *Usage) add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	if cached := other.CachedTokens(); cached > 0 {
+		if u.PromptTokensDetails == nil {
+			u.PromptTokensDetails = &PromptTokensDetails{}
+		}
+		u.PromptTokensDetails.CachedTokens += cached
+	}
+}
+
+// Reply is a generated answer together with how it was produced
+type Reply struct {
+	Content string
+	// Model is the model that produced the final answer
+	Model string
+	// Usage is the token usage per model of every request made for the answer,
+	// including tool call iterations and failed-over attempts that reported usage
+	Usage map[string]Usage
+	// Expired tells why the message started a new session, if it did
+	Expired Expiry
+	// Summarized reports that the new session starts with a summary of the expired one
+	Summarized bool
+	// SessionID is the session the message was answered in
+	SessionID string
+	// Sources are the knowledge base excerpts given to the model; the answer
+	// cites them by their position counted from 1
+	Sources []knowledge.Match
+	// Moderation holds what moderation flagged in the message and the answer
+	Moderation moderation.Result
+	// Blocked reports that moderation stopped the turn; Content then explains why
+	Blocked bool
+}
+
+// APIError is returned when the API responds with a non-200 status code
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("API request failed with status code: %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("API request failed with status code: %d", e.StatusCode)
 }
 
 // Client represents an OpenAI API client
 type Client struct {
 	apiKey          string
 	model           string
+	embeddingModel  string
 	baseURL         string
 	client          *http.Client
 	convManager     *ConversationManager
-	systemPrompt    string
+	conversations   config.ConversationConfig
+	systemPrompt    *prompt.Template
+	personas        config.PersonasConfig
+	personaPrompts  map[string]*prompt.Template
+	botUsername     string
 	fewShotEnabled  bool
 	fewShotExamples []FewShotExample
+	fallbacks       []endpoint
+	latencyBudget   time.Duration
+	requestTimeout  time.Duration
+	auth            config.AuthConfig
+	toolsConfig     config.ToolsConfig
+	timezones       config.TimezoneConfig
+	tools           map[string]Tool
+	toolOrder       []string
+	confirmer       Confirmer
+	toolsMutex      sync.RWMutex
+	sampling        config.SamplingConfig
+	settings        *SettingsManager
+	// memory keeps facts about users across conversations, nil when disabled
+	memory       *memory.Manager
+	memoryConfig config.MemoryConfig
+	// recall archives past exchanges for semantic search, nil when disabled
+	recall       *recall.Archive
+	recallConfig config.RecallConfig
+	// knowledge holds the document collections chats answer from, nil when none are configured
+	knowledge *knowledge.Library
+	// moderator checks messages and answers, nil when moderation is disabled
+	moderator *moderation.Moderator
+	// redactor replaces personal data and secrets before they leave, nil when redaction is disabled
+	redactor *redact.Redactor
+}
+
+// endpoint is a model together with the API it is served from
+type endpoint struct {
+	model   string
+	baseURL string
+	apiKey  string
 }
 
 // FewShotExample defines a single example for few-shot prompting
@@ -64,11 +223,62 @@ func NewClient(cfg *config.Config) *Client {
 	client := &Client{
 		apiKey:         cfg.OpenAI.APIKey,
 		model:          cfg.OpenAI.Model,
+		embeddingModel: cfg.OpenAI.EmbeddingModel,
 		baseURL:        defaultOpenAIBaseURL,
-		client:         &http.Client{Timeout: timeout},
-		convManager:    NewConversationManager(maxHistory, historyTTL),
-		systemPrompt:   cfg.OpenAI.SystemPrompt,
+		client:         &http.Client{},
+		conversations:  cfg.Conversations,
 		fewShotEnabled: cfg.OpenAI.FewShotEnabled,
+		latencyBudget:  cfg.OpenAI.LatencyBudget,
+		requestTimeout: cfg.OpenAI.RequestTimeout,
+		auth:           cfg.Auth,
+		toolsConfig:    cfg.Tools,
+		timezones:      cfg.Timezones,
+		tools:          make(map[string]Tool),
+		sampling:       cfg.OpenAI.SamplingConfig,
+		settings:       NewSettingsManager(),
+		memoryConfig:   cfg.Memory,
+		recallConfig:   cfg.Recall,
+		personas:       cfg.Personas,
+		personaPrompts: make(map[string]*prompt.Template),
+	}
+
+	client.convManager = NewConversationManager(
+		NewMemoryConversationStore(client.conversations.MaxStoredHistory(), client.conversations.Retention),
+		client.RetentionPolicy, client.expireInterval())
+
+	if cfg.OpenAI.BaseURL != "" {
+		client.baseURL = cfg.OpenAI.BaseURL
+	}
+	if client.embeddingModel == "" {
+		client.embeddingModel = config.DefaultEmbeddingModel
+	}
+	if cfg.OpenAI.SystemPrompt != "" {
+		// The template was checked when the configuration was loaded
+		tmpl, err := prompt.Parse("system_prompt", cfg.OpenAI.SystemPrompt, cfg.OpenAI.PromptVars)
+		if err != nil {
+			logger.Error("Invalid system prompt, using the default: %v", err)
+		}
+		client.systemPrompt = tmpl
+	}
+	for _, persona := range cfg.Personas.List {
+		if persona.SystemPrompt == "" {
+			continue
+		}
+		tmpl, err := prompt.Parse(persona.Name, persona.SystemPrompt, cfg.OpenAI.PromptVars)
+		if err != nil {
+			logger.Error("Invalid system prompt of persona %s, using the default: %v", persona.Name, err)
+			continue
+		}
+		client.personaPrompts[persona.Name] = tmpl
+	}
+
+	// Empty base URL and API key are resolved against the primary model at request time
+	for _, fallback := range cfg.OpenAI.Fallbacks {
+		client.fallbacks = append(client.fallbacks, endpoint{
+			model:   fallback.Model,
+			baseURL: fallback.BaseURL,
+			apiKey:  fallback.APIKey,
+		})
 	}
 
 	// 퓨샷 예시 설정
@@ -89,75 +299,415 @@ func (c *Client) SetBaseURL(url string) {
 	c.baseURL = url
 }
 
-// cleanupOldConversations is no longer needed as ConversationManager handles cleanup
+// SetBotUsername tells the system prompt template the username of the bot
+func (c *Client) SetBotUsername(username string) {
+	c.botUsername = username
+}
+
+// SetConversationStore replaces the in-memory conversation history and chat
+// settings with store
+func (c *Client) SetConversationStore(store ConversationStore) {
+	_ = c.convManager.Close()
+	interval := c.expireInterval()
+	if _, ok := store.(selfExpiring); ok {
+		interval = 0
+	}
+	c.convManager = NewConversationManager(store, c.RetentionPolicy, interval)
+	c.settings.SetStore(store)
+}
+
+// expireInterval returns how often expired conversations are removed from the store
+func (c *Client) expireInterval() time.Duration {
+	if c.conversations.ExpireInterval > 0 {
+		return c.conversations.ExpireInterval
+	}
+	return config.DefaultExpireInterval
+}
+
+// RetentionPolicy returns how much and how long the history of a chat provides context
+func (c *Client) RetentionPolicy(chatID int64) config.RetentionPolicy {
+	return c.conversations.PolicyFor(chatID)
+}
 
 // GenerateResponse generates a response using the OpenAI API
-func (c *Client) GenerateResponse(userID int64, userMessage string) (string, error) {
-	// Get the user's conversation
-	conv := c.convManager.GetConversation(userID)
+func (c *Client) GenerateResponse(ctx context.Context, userID int64, userMessage string) (string, error) {
+	reply, err := c.GenerateReply(ctx, Request{ChatID: userID, Text: userMessage})
+	if err != nil {
+		return "", err
+	}
+	return reply.Content, nil
+}
+
+// GenerateReply generates a response and reports the model and token usage behind it.
+// Cancelling ctx aborts the in-flight request; the configured request timeout
+// bounds the whole reply including tool calls and fallbacks. If it fails after
+// requests were made, it returns a reply without content together with the
+// error, so that their usage can still be recorded.
+func (c *Client) GenerateReply(ctx context.Context, req Request) (*Reply, error) {
+	userID := req.ChatID
+	if c.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+
+	// Personal data and secrets are replaced before the message goes anywhere
+	req.Text = c.redact(userID, req.Text)
+
+	// Blocked messages never reach the model or the history
+	input, err := c.moderate(ctx, moderation.Input, userID, req.Text)
+	if err != nil {
+		return nil, err
+	}
+	checked := moderation.Result{Violations: input}
+	if checked.Blocked() {
+		return &Reply{Content: moderation.Explanation(checked), Usage: make(map[string]Usage), Moderation: checked, Blocked: true}, nil
+	}
+
+	// Snapshot the history; nothing is stored until the model has answered
+	turn, err := c.convManager.BeginTurn(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading conversation: %w", err)
+	}
+
+	start := time.Now()
+	reply := &Reply{Usage: make(map[string]Usage), Expired: turn.Expired, SessionID: turn.sessionID, Moderation: checked}
+
+	// Carry the gist of an expired conversation into the new session. The
+	// message is still answered if the summary fails, and the summary is only
+	// kept if the turn is committed.
+	if turn.Previous != nil && c.CarriesSummary(userID) {
+		summary, err := c.summarize(ctx, turn.Previous, reply.Usage)
+		if err != nil {
+			logger.Warn("Error summarizing the expired conversation of %d: %v", userID, err)
+		} else if summary != "" {
+			turn.Summary = summary
+			reply.Summarized = true
+		}
+	}
 
-	// Add the user's message to the conversation history
 	userMsg := Message{
 		Role:    "user",
-		Content: userMessage,
+		Content: req.Text,
+		Meta:    &MessageMeta{Time: start, TelegramMessageID: req.TelegramMessageID},
 	}
-	c.convManager.AddMessage(userID, userMsg)
-
-	// Create a copy of the conversation messages
-	c.convManager.mutex.RLock()
-	messages := make([]Message, len(conv.Messages))
-	copy(messages, conv.Messages)
-	c.convManager.mutex.RUnlock()
+	messages := append(turn.Messages, userMsg)
 
 	// 시스템 메시지와 퓨샷 예시를 추가
-	messages = c.prepareMessages(messages)
+	var sections []string
+	if memories := c.memorySection(userID, req.Text); memories != "" {
+		sections = append(sections, memories)
+	}
+	if recalled := c.recallSection(ctx, userID, turn.sessionID, req.Text, reply.Usage); recalled != "" {
+		sections = append(sections, recalled)
+	}
+	if turn.Summary != "" {
+		sections = append(sections, summarySection(turn.Summary))
+	}
+	if excerpts, matches := c.knowledgeSection(ctx, userID, req.Text, reply.Usage); excerpts != "" {
+		sections = append(sections, excerpts)
+		reply.Sources = matches
+	}
+	messages = c.prepareMessages(c.renderSystemPrompt(req, start), c.fewShotFor(userID), messages, sections...)
+
+	answer, err := c.complete(ctx, userID, messages, reply.Usage)
+	if err != nil {
+		return reply, err
+	}
 
+	// A blocked answer is withheld and the turn is not stored
+	output, err := c.moderate(ctx, moderation.Output, userID, answer.Content)
+	if err != nil {
+		return reply, err
+	}
+	reply.Moderation.Violations = append(reply.Moderation.Violations, output...)
+	if reply.Moderation.Blocked() {
+		reply.Content = moderation.Explanation(reply.Moderation)
+		reply.Model = answer.Model
+		reply.Blocked = true
+		reply.Sources = nil
+		return reply, nil
+	}
+
+	answer.Meta.Time = time.Now()
+	answer.Meta.Latency = answer.Meta.Time.Sub(start)
+	for _, u := range reply.Usage {
+		answer.Meta.PromptTokens += u.PromptTokens
+		answer.Meta.CompletionTokens += u.CompletionTokens
+	}
+
+	// Store the question and the answer together. The answer is still delivered
+	// if the conversation was reset meanwhile or the store failed.
+	switch err := c.convManager.CommitTurn(turn, userMsg, answer); {
+	case errors.Is(err, ErrConversationChanged):
+		logger.Info("Conversation of %d changed during the request, not storing the turn", userID)
+	case err != nil:
+		logger.Error("Error saving the turn of %d: %v", userID, err)
+	}
+
+	reply.Content = c.restore(userID, answer.Content)
+	reply.Model = answer.Model
+	return reply, nil
+}
+
+// complete runs the chat completion, executing requested tool calls and feeding
+// their results back until the model produces a final answer or the iteration cap is hit.
+// The usage of every request is accumulated per model into usage.
+func (c *Client) complete(ctx context.Context, userID int64, messages []Message, usage map[string]Usage) (Message, error) {
+	tools := c.availableTools(userID)
+	definitions := toolDefinitions(tools)
+	sampling := c.Sampling(userID)
+	model := c.modelFor(userID)
+
+	for iteration := 0; ; iteration++ {
+		// Once the cap is reached the tools are withheld so that the model has to answer
+		if iteration >= c.toolsConfig.MaxIterations {
+			definitions = nil
+		}
+
+		result, ep, err := c.createChatCompletion(ctx, model, messages, definitions, sampling)
+		if err != nil {
+			return Message{}, err
+		}
+
+		if result.Usage != nil {
+			total := usage[ep.model]
+			total.add(*result.Usage)
+			usage[ep.model] = total
+		}
+
+		reply := result.Choices[0].Message
+		reply.Model = ep.model
+		reply.Meta = &MessageMeta{FinishReason: result.Choices[0].FinishReason}
+		if len(reply.ToolCalls) == 0 || len(definitions) == 0 {
+			reply.ToolCalls = nil
+			return reply, nil
+		}
+
+		messages = append(messages, reply)
+		for _, call := range reply.ToolCalls {
+			messages = append(messages, c.executeToolCall(ctx, tools, userID, call))
+		}
+	}
+}
+
+// endpoints returns the primary model followed by the configured fallbacks
+func (c *Client) endpoints(model string) []endpoint {
+	eps := []endpoint{{model: model, baseURL: c.baseURL, apiKey: c.apiKey}}
+	for _, fb := range c.fallbacks {
+		if fb.baseURL == "" {
+			fb.baseURL = c.baseURL
+		}
+		if fb.apiKey == "" {
+			fb.apiKey = c.apiKey
+		}
+		eps = append(eps, fb)
+	}
+	return eps
+}
+
+// createChatCompletion sends the messages to model and walks the fallback
+// chain while the failures are retryable. It returns the endpoint that
+// actually answered. Once ctx is done no further model is tried.
+func (c *Client) createChatCompletion(ctx context.Context, model string, messages []Message, tools []ToolDefinition, sampling config.SamplingConfig) (*ChatCompletionResponse, endpoint, error) {
+	eps := c.endpoints(model)
+
+	var lastErr error
+	for i, ep := range eps {
+		// The latency budget only applies while there is still a model to fall back to
+		budget := time.Duration(0)
+		if i < len(eps)-1 {
+			budget = c.latencyBudget
+		}
+
+		result, err := c.doChatCompletion(ctx, ep, messages, tools, sampling, budget)
+		if err == nil {
+			if i > 0 {
+				logger.Info("Fallback model %s answered after %d failed attempt(s)", ep.model, i)
+			}
+			return result, ep, nil
+		}
+
+		lastErr = err
+		if ctx.Err() != nil || !isRetryable(err) {
+			break
+		}
+		if i < len(eps)-1 {
+			logger.Warn("Model %s failed: %v. Falling back to %s", ep.model, err, eps[i+1].model)
+		}
+	}
+
+	return nil, endpoint{}, lastErr
+}
+
+// doChatCompletion performs a single chat completion request against an endpoint
+func (c *Client) doChatCompletion(ctx context.Context, ep endpoint, messages []Message, tools []ToolDefinition, sampling config.SamplingConfig, budget time.Duration) (*ChatCompletionResponse, error) {
 	reqBody := ChatCompletionRequest{
-		Model:    c.model,
-		Messages: messages,
+		Model:            ep.model,
+		Messages:         messages,
+		Tools:            tools,
+		Temperature:      sampling.Temperature,
+		TopP:             sampling.TopP,
+		MaxTokens:        sampling.MaxTokens,
+		PresencePenalty:  sampling.PresencePenalty,
+		FrequencyPenalty: sampling.FrequencyPenalty,
+		Seed:             sampling.Seed,
+		Stop:             sampling.Stop,
 	}
 
 	reqBytes, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("error marshaling request: %w", err)
+		return nil, fmt.Errorf("error marshaling request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", c.baseURL, bytes.NewBuffer(reqBytes))
+	if budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+
+	endpointURL := strings.TrimSuffix(ep.baseURL, "/") + chatCompletionsPath
+	req, err := http.NewRequestWithContext(ctx, "POST", endpointURL, bytes.NewBuffer(reqBytes))
 	if err != nil {
-		return "", fmt.Errorf("error creating request: %w", err)
+		return nil, fmt.Errorf("error creating request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Authorization", "Bearer "+ep.apiKey)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("error making request: %w", err)
+		return nil, fmt.Errorf("error making request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status code: %d", resp.StatusCode)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
 	}
 
 	var result ChatCompletionResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("error decoding response: %w", err)
+		return nil, fmt.Errorf("error decoding response: %w", err)
 	}
 
 	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("no response generated")
+		return nil, fmt.Errorf("no response generated")
 	}
 
-	// Save the assistant's response to the conversation history
-	c.convManager.AddMessage(userID, result.Choices[0].Message)
+	return &result, nil
+}
+
+// isRetryable reports whether a failed request should be retried with the next model.
+// Rate limits, server errors, transport errors and exceeded latency budgets are retryable;
+// client errors such as a bad request or an invalid API key are not.
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusTooManyRequests,
+			apiErr.StatusCode == http.StatusRequestTimeout,
+			apiErr.StatusCode >= 500:
+			return true
+		default:
+			return false
+		}
+	}
+	// Transport errors (including the latency budget deadline) are worth retrying,
+	// malformed responses are not
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// SetReplyMessageID records the Telegram message a reply was sent as on the
+// assistant message that answered the given Telegram message
+func (c *Client) SetReplyMessageID(chatID int64, requestMessageID, replyMessageID int) error {
+	return c.convManager.SetReplyMessageID(chatID, requestMessageID, replyMessageID)
+}
 
-	return result.Choices[0].Message.Content, nil
+// Close stops background work and closes the conversation store
+func (c *Client) Close() error {
+	return c.convManager.Close()
 }
 
 // ResetConversation clears the conversation history for a user
-func (c *Client) ResetConversation(userID int64) {
-	c.convManager.ResetConversation(userID)
+func (c *Client) ResetConversation(userID int64) error {
+	return c.convManager.ResetConversation(userID)
+}
+
+// NewSession starts a new named session for a user and returns its ID
+func (c *Client) NewSession(userID int64, title string) (string, error) {
+	return c.convManager.NewSession(userID, title)
+}
+
+// Sessions lists the sessions of a user, most recently used first
+func (c *Client) Sessions(userID int64) ([]Session, error) {
+	return c.convManager.Sessions(userID)
+}
+
+// SwitchSession resumes a session of a user
+func (c *Client) SwitchSession(userID int64, sessionID string) error {
+	return c.convManager.SwitchSession(userID, sessionID)
+}
+
+// DeleteSession removes a session of a user
+func (c *Client) DeleteSession(userID int64, sessionID string) error {
+	if err := c.convManager.DeleteSession(userID, sessionID); err != nil {
+		return err
+	}
+	// Deleted chats can no longer be recalled either
+	if c.recall != nil {
+		if _, err := c.recall.DeleteSession(userID, sessionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportSession renders the active session of a user in format and returns it
+// together with a file name. It returns ErrEmptyConversation if there is nothing to export.
+func (c *Client) ExportSession(userID int64, format string) ([]byte, string, error) {
+	conv, err := c.convManager.ActiveConversation(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if conv == nil || len(conv.Messages) == 0 {
+		return nil, "", ErrEmptyConversation
+	}
+
+	now := time.Now()
+	data, err := ExportConversation(conv, format, c.location(userID), now)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, ExportFilename(conv, format, now), nil
+}
+
+// ImportSession restores a JSON export as a new session of a user and returns it
+func (c *Client) ImportSession(userID int64, data []byte) (Session, error) {
+	conv, err := ImportConversation(data)
+	if err != nil {
+		return Session{}, err
+	}
+	sessionID, err := c.convManager.ImportSession(userID, conv)
+	if err != nil {
+		return Session{}, err
+	}
+
+	// Report what was kept, as long histories are trimmed by the store
+	stored, err := c.convManager.ActiveConversation(userID)
+	if err != nil {
+		return Session{}, fmt.Errorf("error reading imported session: %w", err)
+	}
+	if stored == nil {
+		return Session{}, ErrSessionNotFound
+	}
+	return Session{ID: sessionID, Title: stored.Title, Messages: len(stored.Messages), LastUsed: stored.LastUpdate, Active: true}, nil
+}
+
+// location returns the timezone of a chat
+func (c *Client) location(userID int64) *time.Location {
+	return c.timezones.LocationFor(userID)
 }
 
 // addMessageToHistory adds a message to the conversation history
@@ -170,17 +720,46 @@ func (c *Client) addMessageToHistory(userID int64, role, content string) {
 	}
 
 	// Add the message using the conversation manager
-	c.convManager.AddMessage(userID, msg)
+	_ = c.convManager.AddMessage(userID, msg)
 }
 
-// prepareMessages prepares the messages with system prompt and few-shot examples if configured
-func (c *Client) prepareMessages(messages []Message) []Message {
+// renderSystemPrompt renders the system prompt of the chat's persona or the
+// configured one for a request, or returns an empty string if there is none
+// or it fails to render
+func (c *Client) renderSystemPrompt(req Request, now time.Time) string {
+	tmpl := c.systemPrompt
+	if persona := c.persona(req.ChatID); persona != nil && c.personaPrompts[persona.Name] != nil {
+		tmpl = c.personaPrompts[persona.Name]
+	}
+	if tmpl == nil {
+		return ""
+	}
+	data := prompt.NewData(now, c.location(req.ChatID))
+	data.FirstName = req.FirstName
+	data.Language = req.LanguageCode
+	data.ChatTitle = req.ChatTitle
+	data.ChatType = req.ChatType
+	data.BotUsername = c.botUsername
+	rendered, err := tmpl.Render(data)
+	if err != nil {
+		logger.Error("Error rendering the system prompt for %d: %v", req.ChatID, err)
+		return ""
+	}
+	return rendered
+}
+
+// prepareMessages prepares the messages with system prompt and few-shot examples if configured.
+// Sections such as a summary of an earlier conversation are appended to the system message.
+func (c *Client) prepareMessages(systemPrompt string, examples []FewShotExample, messages []Message, sections ...string) []Message {
 	var preparedMessages []Message
 
 	// 시스템 프롬프트 설정
 	systemContent := "You are a helpful assistant."
-	if c.systemPrompt != "" {
-		systemContent = c.systemPrompt
+	if systemPrompt != "" {
+		systemContent = systemPrompt
+	}
+	for _, section := range sections {
+		systemContent += "\n\n" + section
 	}
 
 	// 시스템 메시지 추가
@@ -191,8 +770,8 @@ func (c *Client) prepareMessages(messages []Message) []Message {
 	preparedMessages = append(preparedMessages, systemMsg)
 
 	// 퓨샷 예시 추가 (설정되어 있고 활성화된 경우에만)
-	if c.fewShotEnabled && len(c.fewShotExamples) > 0 {
-		for _, example := range c.fewShotExamples {
+	if len(examples) > 0 {
+		for _, example := range examples {
 			if example.UserQuestion != "" && example.BotResponse != "" {
 				preparedMessages = append(preparedMessages, Message{
 					Role:    "user",
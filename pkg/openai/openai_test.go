@@ -1,9 +1,16 @@
 package openai
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/itswryu/telegpt/pkg/config"
 )
@@ -47,7 +54,7 @@ func TestResetConversation(t *testing.T) {
 	client.addMessageToHistory(userID, "assistant", "Hi there!")
 
 	// Check that conversation exists with messages
-	conv := client.convManager.GetConversation(userID)
+	conv, _ := client.convManager.GetConversation(userID)
 	if len(conv.Messages) != 2 {
 		t.Errorf("Conversation messages count = %v, expected %v", len(conv.Messages), 2)
 	}
@@ -56,7 +63,7 @@ func TestResetConversation(t *testing.T) {
 	client.ResetConversation(userID)
 
 	// Check that conversation was reset
-	conv = client.convManager.GetConversation(userID)
+	conv, _ = client.convManager.GetConversation(userID)
 	if len(conv.Messages) != 0 {
 		t.Errorf("Conversation messages count after reset = %v, expected %v", len(conv.Messages), 0)
 	}
@@ -119,7 +126,7 @@ func TestGenerateResponseWithMockAPI(t *testing.T) {
 	client.SetBaseURL(server.URL)
 
 	// Test the GenerateResponse method
-	response, err := client.GenerateResponse(testUserID, testUserPrompt)
+	response, err := client.GenerateResponse(context.Background(), testUserID, testUserPrompt)
 
 	if err != nil {
 		t.Fatalf("GenerateResponse() error = %v", err)
@@ -129,3 +136,571 @@ func TestGenerateResponseWithMockAPI(t *testing.T) {
 		t.Errorf("GenerateResponse() = %v, expected %v", response, testResponse)
 	}
 }
+
+// newTestClient returns a client for cfg with the test API key whose API is
+// served by handler. The model defaults to gpt-4.1-nano.
+func newTestClient(t *testing.T, cfg *config.Config, handler http.Handler) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg.OpenAI.APIKey = "test-key"
+	if cfg.OpenAI.Model == "" {
+		cfg.OpenAI.Model = "gpt-4.1-nano"
+	}
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+	return client
+}
+
+// mockCompletion writes a successful chat completion response with the given content
+func mockCompletion(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     "mock-id",
+		"object": "chat.completion",
+		"choices": []map[string]interface{}{
+			{
+				"index":         0,
+				"message":       map[string]string{"role": "assistant", "content": content},
+				"finish_reason": "stop",
+			},
+		},
+	})
+}
+
+func TestGenerateResponseFallsBackOnRetryableError(t *testing.T) {
+	var models []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		models = append(models, req.Model)
+
+		if req.Model == "primary" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mockCompletion(w, "answer from "+req.Model)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		OpenAI: config.OpenAIConfig{
+			APIKey:    "test-key",
+			Model:     "primary",
+			Fallbacks: []config.FallbackModel{{Model: "secondary"}},
+		},
+	}
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+
+	response, err := client.GenerateResponse(context.Background(), 1, "hello")
+	if err != nil {
+		t.Fatalf("GenerateResponse() error = %v", err)
+	}
+	if response != "answer from secondary" {
+		t.Errorf("GenerateResponse() = %q, expected answer from the fallback model", response)
+	}
+	if len(models) != 2 || models[0] != "primary" || models[1] != "secondary" {
+		t.Errorf("Requested models = %v, expected [primary secondary]", models)
+	}
+
+	conv, _ := client.convManager.GetConversation(1)
+	if last := conv.Messages[len(conv.Messages)-1]; last.Model != "secondary" {
+		t.Errorf("History records model %q, expected %q", last.Model, "secondary")
+	}
+}
+
+func TestGenerateResponseDoesNotFallBackOnClientError(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		OpenAI: config.OpenAIConfig{
+			APIKey:    "test-key",
+			Model:     "primary",
+			Fallbacks: []config.FallbackModel{{Model: "secondary"}},
+		},
+	}
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+
+	_, err := client.GenerateResponse(context.Background(), 1, "hello")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("GenerateResponse() error = %v, expected 401 APIError", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 request, got %d", calls)
+	}
+}
+
+func TestGenerateResponseFallsBackWhenLatencyBudgetExceeded(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Drain the body so the server notices when the client gives up
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+		mockCompletion(w, "too late")
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mockCompletion(w, "fast answer")
+	}))
+	defer fast.Close()
+
+	cfg := &config.Config{
+		OpenAI: config.OpenAIConfig{
+			APIKey:        "test-key",
+			Model:         "slow-model",
+			BaseURL:       slow.URL,
+			Fallbacks:     []config.FallbackModel{{Model: "fast-model", BaseURL: fast.URL, APIKey: "other-key"}},
+			LatencyBudget: 50 * time.Millisecond,
+		},
+	}
+	client := NewClient(cfg)
+
+	response, err := client.GenerateResponse(context.Background(), 1, "hello")
+	if err != nil {
+		t.Fatalf("GenerateResponse() error = %v", err)
+	}
+	if response != "fast answer" {
+		t.Errorf("GenerateResponse() = %q, expected %q", response, "fast answer")
+	}
+}
+
+func TestGenerateResponseHonoursContext(t *testing.T) {
+	fallbackCalls := 0
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+		mockCompletion(w, "too late")
+	}))
+	defer slow.Close()
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackCalls++
+		mockCompletion(w, "fallback")
+	}))
+	defer fallback.Close()
+
+	t.Run("cancel", func(t *testing.T) {
+		client := NewClient(&config.Config{OpenAI: config.OpenAIConfig{
+			APIKey:    "test-key",
+			Model:     "slow-model",
+			BaseURL:   slow.URL,
+			Fallbacks: []config.FallbackModel{{Model: "fallback-model", BaseURL: fallback.URL}},
+		}})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, err := client.GenerateResponse(ctx, 1, "hello")
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("GenerateResponse() error = %v, expected context.Canceled", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("GenerateResponse() took %v after cancellation", elapsed)
+		}
+		// A cancelled request must not be retried with the fallback model
+		if fallbackCalls != 0 {
+			t.Errorf("Fallback was called %d times after cancellation", fallbackCalls)
+		}
+	})
+
+	t.Run("request timeout", func(t *testing.T) {
+		client := NewClient(&config.Config{OpenAI: config.OpenAIConfig{
+			APIKey:         "test-key",
+			Model:          "slow-model",
+			BaseURL:        slow.URL,
+			RequestTimeout: 50 * time.Millisecond,
+		}})
+
+		_, err := client.GenerateResponse(context.Background(), 1, "hello")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("GenerateResponse() error = %v, expected context.DeadlineExceeded", err)
+		}
+	})
+}
+
+// echoTool is a test tool that returns its arguments
+type echoTool struct {
+	calls int
+}
+
+func (t *echoTool) Name() string                { return "echo" }
+func (t *echoTool) Description() string         { return "Echo the arguments" }
+func (t *echoTool) Parameters() json.RawMessage { return json.RawMessage(`{"type":"object"}`) }
+func (t *echoTool) Execute(ctx context.Context, userID int64, arguments json.RawMessage) (string, error) {
+	t.calls++
+	return "echo: " + string(arguments), nil
+}
+
+// mockToolCall writes a chat completion response requesting the echo tool
+func mockToolCall(w http.ResponseWriter, id string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"choices": []map[string]interface{}{
+			{
+				"message": map[string]interface{}{
+					"role":    "assistant",
+					"content": nil,
+					"tool_calls": []map[string]interface{}{
+						{"id": id, "type": "function", "function": map[string]string{"name": "echo", "arguments": `{"text":"hi"}`}},
+					},
+				},
+				"finish_reason": "tool_calls",
+			},
+		},
+	})
+}
+
+func TestGenerateResponseExecutesToolCalls(t *testing.T) {
+	var requests []ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		if len(requests) == 1 {
+			mockToolCall(w, "call_1")
+			return
+		}
+		mockCompletion(w, "done")
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"},
+		Tools:  config.ToolsConfig{Enabled: true, MaxIterations: 3},
+	}
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+	tool := &echoTool{}
+	client.RegisterTool(tool)
+
+	response, err := client.GenerateResponse(context.Background(), 1, "use the tool")
+	if err != nil {
+		t.Fatalf("GenerateResponse() error = %v", err)
+	}
+	if response != "done" {
+		t.Errorf("GenerateResponse() = %q, expected %q", response, "done")
+	}
+	if tool.calls != 1 {
+		t.Errorf("Tool executed %d times, expected 1", tool.calls)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(requests))
+	}
+	if len(requests[0].Tools) != 1 || requests[0].Tools[0].Function.Name != "echo" {
+		t.Errorf("First request tools = %+v, expected the echo tool", requests[0].Tools)
+	}
+
+	second := requests[1].Messages
+	toolMsg := second[len(second)-1]
+	if toolMsg.Role != "tool" || toolMsg.ToolCallID != "call_1" || toolMsg.Content != `echo: {"text":"hi"}` {
+		t.Errorf("Tool result message = %+v", toolMsg)
+	}
+
+	// Only the user message and the final answer are kept in history
+	conv, _ := client.convManager.GetConversation(1)
+	if len(conv.Messages) != 2 {
+		t.Errorf("History has %d messages, expected 2", len(conv.Messages))
+	}
+}
+
+func TestGenerateResponseStopsAtToolIterationCap(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if len(req.Tools) == 0 {
+			mockCompletion(w, "final")
+			return
+		}
+		mockToolCall(w, fmt.Sprintf("call_%d", requests))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"},
+		Tools:  config.ToolsConfig{Enabled: true, MaxIterations: 2},
+	}
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+	tool := &echoTool{}
+	client.RegisterTool(tool)
+
+	response, err := client.GenerateResponse(context.Background(), 1, "loop forever")
+	if err != nil {
+		t.Fatalf("GenerateResponse() error = %v", err)
+	}
+	if response != "final" {
+		t.Errorf("GenerateResponse() = %q, expected %q", response, "final")
+	}
+	if tool.calls != 2 || requests != 3 {
+		t.Errorf("Tool calls = %d, requests = %d, expected 2 and 3", tool.calls, requests)
+	}
+}
+
+func TestAvailableToolsRespectsPolicies(t *testing.T) {
+	cfg := &config.Config{
+		OpenAI: config.OpenAIConfig{APIKey: "test-key"},
+		Auth:   config.AuthConfig{AdminChatIDs: []int64{1}},
+		Tools: config.ToolsConfig{
+			Enabled: true,
+			Roles:   map[string]config.ToolPolicy{config.RoleUser: {Deny: []string{"echo"}}},
+			Chats:   map[int64]config.ToolPolicy{3: {Disabled: true}},
+		},
+	}
+	client := NewClient(cfg)
+	client.RegisterTool(&echoTool{})
+
+	if tools := client.availableTools(1); len(tools) != 1 {
+		t.Errorf("Admin should have the echo tool, got %d tools", len(tools))
+	}
+	if tools := client.availableTools(2); len(tools) != 0 {
+		t.Errorf("User role should not have the echo tool, got %d tools", len(tools))
+	}
+
+	cfg.Auth.AdminChatIDs = append(cfg.Auth.AdminChatIDs, 3)
+	client = NewClient(cfg)
+	client.RegisterTool(&echoTool{})
+	if tools := client.availableTools(3); len(tools) != 0 {
+		t.Errorf("Chat with tools disabled should have no tools, got %d tools", len(tools))
+	}
+}
+
+// guardedTool is an echo tool that requires confirmation
+type guardedTool struct {
+	echoTool
+}
+
+func (t *guardedTool) RequiresConfirmation() bool { return true }
+
+func TestExecuteToolCallAsksForConfirmation(t *testing.T) {
+	cfg := &config.Config{
+		OpenAI: config.OpenAIConfig{APIKey: "test-key"},
+		Tools:  config.ToolsConfig{Enabled: true, MaxIterations: 1},
+	}
+	client := NewClient(cfg)
+	tool := &guardedTool{}
+	tools := []Tool{tool}
+	call := ToolCall{ID: "call_1", Type: "function", Function: FunctionCall{Name: "echo", Arguments: `{}`}}
+
+	// Without a confirmer the tool never runs
+	if msg := client.executeToolCall(context.Background(), tools, 1, call); tool.calls != 0 || !strings.HasPrefix(msg.Content, "error:") {
+		t.Errorf("Tool ran without confirmer: %+v", msg)
+	}
+
+	approve := false
+	var asked string
+	client.SetConfirmer(func(ctx context.Context, userID int64, name string, arguments string) (bool, error) {
+		asked = name
+		return approve, nil
+	})
+
+	if msg := client.executeToolCall(context.Background(), tools, 1, call); tool.calls != 0 || !strings.Contains(msg.Content, "declined") {
+		t.Errorf("Declined tool ran: %+v", msg)
+	}
+	if asked != "echo" {
+		t.Errorf("Confirmer asked about %q, expected %q", asked, "echo")
+	}
+
+	approve = true
+	if msg := client.executeToolCall(context.Background(), tools, 1, call); tool.calls != 1 || msg.Content != "echo: {}" {
+		t.Errorf("Approved tool did not run: %+v", msg)
+	}
+}
+
+func TestSamplingParametersAreSentAndOverridable(t *testing.T) {
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		mockCompletion(w, "ok")
+	}))
+	defer server.Close()
+
+	temperature := 0.7
+	cfg := &config.Config{
+		OpenAI: config.OpenAIConfig{
+			APIKey:         "test-key",
+			Model:          "gpt-4.1-nano",
+			SamplingConfig: config.SamplingConfig{Temperature: &temperature},
+		},
+	}
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+
+	if _, err := client.GenerateResponse(context.Background(), 1, "hello"); err != nil {
+		t.Fatalf("GenerateResponse() error = %v", err)
+	}
+	if requests[0]["temperature"] != 0.7 {
+		t.Errorf("temperature = %v, expected 0.7", requests[0]["temperature"])
+	}
+	if _, ok := requests[0]["top_p"]; ok {
+		t.Error("top_p should be omitted when not configured")
+	}
+
+	if err := client.SetSamplingParameter(1, "temperature", "3"); err == nil {
+		t.Error("SetSamplingParameter() expected an error for temperature 3")
+	}
+	if err := client.SetSamplingParameter(1, "temperature", "0.2"); err != nil {
+		t.Fatalf("SetSamplingParameter() error = %v", err)
+	}
+	if err := client.SetSamplingParameter(1, "max_tokens", "256"); err != nil {
+		t.Fatalf("SetSamplingParameter() error = %v", err)
+	}
+
+	if _, err := client.GenerateResponse(context.Background(), 1, "hello"); err != nil {
+		t.Fatalf("GenerateResponse() error = %v", err)
+	}
+	if requests[1]["temperature"] != 0.2 || requests[1]["max_tokens"] != float64(256) {
+		t.Errorf("Overrides not applied: temperature = %v, max_tokens = %v", requests[1]["temperature"], requests[1]["max_tokens"])
+	}
+
+	// Other chats keep the global values
+	if got := client.Sampling(2).Temperature; got == nil || *got != 0.7 {
+		t.Errorf("Sampling(2).Temperature = %v, expected 0.7", got)
+	}
+
+	client.ResetSampling(1)
+	if got := client.Sampling(1); *got.Temperature != 0.7 || got.MaxTokens != nil {
+		t.Errorf("Sampling(1) after reset = %+v, expected the global values", got)
+	}
+}
+
+func TestGenerateReplyReportsUsage(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		if calls == 1 {
+			w.Write([]byte(`{
+				"choices": [{"message": {"role": "assistant", "tool_calls": [
+					{"id": "call_1", "type": "function", "function": {"name": "echo", "arguments": "{}"}}
+				]}}],
+				"usage": {"prompt_tokens": 100, "completion_tokens": 10, "total_tokens": 110,
+					"prompt_tokens_details": {"cached_tokens": 64}}
+			}`))
+			return
+		}
+		w.Write([]byte(`{
+			"choices": [{"message": {"role": "assistant", "content": "done"}}],
+			"usage": {"prompt_tokens": 120, "completion_tokens": 20, "total_tokens": 140}
+		}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"},
+		Tools:  config.ToolsConfig{Enabled: true, MaxIterations: 3},
+	}
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+	client.RegisterTool(&echoTool{})
+
+	reply, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "hello"})
+	if err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+
+	if reply.Content != "done" || reply.Model != "gpt-4.1-nano" {
+		t.Errorf("GenerateReply() = %+v", reply)
+	}
+
+	u := reply.Usage["gpt-4.1-nano"]
+	if u.PromptTokens != 220 || u.CompletionTokens != 30 || u.TotalTokens != 250 || u.CachedTokens() != 64 {
+		t.Errorf("Accumulated usage = %+v (cached %d)", u, u.CachedTokens())
+	}
+}
+
+func TestGenerateReplyReportsUsageOfFailedReply(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		if calls == 1 {
+			w.Write([]byte(`{
+				"choices": [{"message": {"role": "assistant", "tool_calls": [
+					{"id": "call_1", "type": "function", "function": {"name": "echo", "arguments": "{}"}}
+				]}}],
+				"usage": {"prompt_tokens": 100, "completion_tokens": 10, "total_tokens": 110}
+			}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"message": "bad request"}}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"},
+		Tools:  config.ToolsConfig{Enabled: true, MaxIterations: 3},
+	}
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+	client.RegisterTool(&echoTool{})
+
+	reply, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "hello"})
+	if err == nil {
+		t.Fatal("실패한 응답에서 에러가 반환되어야 합니다")
+	}
+	if reply == nil {
+		t.Fatal("실패한 응답에서도 사용량이 반환되어야 합니다")
+	}
+	if u := reply.Usage["gpt-4.1-nano"]; u.PromptTokens != 100 || u.TotalTokens != 110 {
+		t.Errorf("Accumulated usage = %+v", u)
+	}
+}
+
+func TestGenerateReplyRendersSystemPrompt(t *testing.T) {
+	var system string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		system = req.Messages[0].Content
+		mockCompletion(w, "answer")
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		OpenAI: config.OpenAIConfig{
+			APIKey:       "test-key",
+			Model:        "gpt-4.1-nano",
+			SystemPrompt: "You are @{{.BotUsername}} for {{.Vars.team}}. User: {{.FirstName}} ({{.Language}}), {{.ChatType}} {{.ChatTitle}}, {{.Timezone}}.",
+			PromptVars:   map[string]string{"team": "Platform"},
+		},
+	}
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+	client.SetBotUsername("telegpt_bot")
+
+	_, err := client.GenerateReply(context.Background(), Request{
+		ChatID: -100, Text: "hello", FirstName: "Jimin", LanguageCode: "ko", ChatTitle: "Ops", ChatType: "supergroup",
+	})
+	if err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	expected := "You are @telegpt_bot for Platform. User: Jimin (ko), supergroup Ops, UTC."
+	if system != expected {
+		t.Errorf("시스템 메시지 = %q, expected %q", system, expected)
+	}
+}
@@ -0,0 +1,70 @@
+package openai
+
+import (
+	"fmt"
+
+	"github.com/itswryu/telegpt/pkg/config"
+)
+
+// Personas returns the configured personas in their configured order
+func (c *Client) Personas() []config.PersonaConfig {
+	return c.personas.List
+}
+
+// Persona returns the name of the persona a chat uses: its own choice if it
+// made one with SetPersona, the configured default otherwise. It is empty if
+// the chat uses the openai settings.
+func (c *Client) Persona(chatID int64) string {
+	if chosen := c.settings.Get(chatID).Persona; chosen != "" && c.personas.Find(chosen) != nil {
+		return chosen
+	}
+	return c.personas.DefaultFor(chatID)
+}
+
+// SetPersona switches a chat to a persona
+func (c *Client) SetPersona(chatID int64, name string) error {
+	if c.personas.Find(name) == nil {
+		return fmt.Errorf("unknown persona %q", name)
+	}
+	return c.settings.Update(chatID, func(settings *ChatSettings) error {
+		settings.Persona = name
+		return nil
+	})
+}
+
+// ResetPersona returns a chat to the persona assigned in the configuration
+func (c *Client) ResetPersona(chatID int64) {
+	_ = c.settings.Update(chatID, func(settings *ChatSettings) error {
+		settings.Persona = ""
+		return nil
+	})
+}
+
+// persona returns the persona of a chat, or nil if it uses the openai settings
+func (c *Client) persona(chatID int64) *config.PersonaConfig {
+	return c.personas.Find(c.Persona(chatID))
+}
+
+// modelFor returns the primary model of a chat
+func (c *Client) modelFor(chatID int64) string {
+	if p := c.persona(chatID); p != nil && p.Model != "" {
+		return p.Model
+	}
+	return c.model
+}
+
+// fewShotFor returns the few-shot examples of a chat: those of its persona if
+// it has any, the configured ones if they are enabled
+func (c *Client) fewShotFor(chatID int64) []FewShotExample {
+	if p := c.persona(chatID); p != nil && len(p.FewShotExamples) > 0 {
+		examples := make([]FewShotExample, len(p.FewShotExamples))
+		for i, example := range p.FewShotExamples {
+			examples[i] = FewShotExample{UserQuestion: example.UserQuestion, BotResponse: example.BotResponse}
+		}
+		return examples
+	}
+	if c.fewShotEnabled {
+		return c.fewShotExamples
+	}
+	return nil
+}
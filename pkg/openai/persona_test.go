@@ -0,0 +1,103 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/itswryu/telegpt/pkg/config"
+)
+
+func TestPersonas(t *testing.T) {
+	var requests []ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		mockCompletion(w, "answer")
+	}))
+	defer server.Close()
+
+	temperature, reviewerTemperature := 0.7, 0.1
+	cfg := &config.Config{
+		OpenAI: config.OpenAIConfig{
+			APIKey:          "test-key",
+			Model:           "gpt-4.1-nano",
+			SystemPrompt:    "You are a helpful assistant for {{.FirstName}}.",
+			FewShotEnabled:  true,
+			FewShotExamples: []config.FewShotExample{{UserQuestion: "hi", BotResponse: "hello"}},
+			SamplingConfig:  config.SamplingConfig{Temperature: &temperature},
+		},
+		Tools: config.ToolsConfig{Enabled: true, MaxIterations: 3},
+		Personas: config.PersonasConfig{
+			List: []config.PersonaConfig{
+				{
+					Name:            "reviewer",
+					SystemPrompt:    "You review code for {{.FirstName}}.",
+					FewShotExamples: []config.FewShotExample{{UserQuestion: "x := 1", BotResponse: "LGTM"}},
+					Model:           "gpt-4.1",
+					Tools:           []string{},
+					SamplingConfig:  config.SamplingConfig{Temperature: &reviewerTemperature},
+				},
+				{Name: "translator", SystemPrompt: "Translate between Korean and English."},
+			},
+			Chats: map[int64]string{2: "translator"},
+		},
+	}
+	client := NewClient(cfg)
+	client.SetBaseURL(server.URL)
+	client.RegisterTool(&echoTool{})
+
+	if client.Persona(1) != "" || client.Persona(2) != "translator" {
+		t.Errorf("Persona() = %q, %q, 채팅별 기본 페르소나가 적용되어야 함", client.Persona(1), client.Persona(2))
+	}
+	if err := client.SetPersona(1, "poet"); err == nil {
+		t.Error("SetPersona() expected an error for an unknown persona")
+	}
+
+	ask := func(chatID int64) ChatCompletionRequest {
+		t.Helper()
+		requests = nil
+		if _, err := client.GenerateReply(context.Background(), Request{ChatID: chatID, Text: "question", FirstName: "Jimin"}); err != nil {
+			t.Fatalf("GenerateReply() error = %v", err)
+		}
+		return requests[0]
+	}
+
+	req := ask(1)
+	if req.Model != "gpt-4.1-nano" || req.Messages[0].Content != "You are a helpful assistant for Jimin." ||
+		req.Messages[1].Content != "hi" || *req.Temperature != 0.7 || len(req.Tools) != 1 {
+		t.Errorf("기본 설정 요청 = %+v", req)
+	}
+
+	if err := client.SetPersona(1, "reviewer"); err != nil {
+		t.Fatalf("SetPersona() error = %v", err)
+	}
+	req = ask(1)
+	if req.Model != "gpt-4.1" || req.Messages[0].Content != "You review code for Jimin." ||
+		req.Messages[1].Content != "x := 1" || *req.Temperature != 0.1 || len(req.Tools) != 0 {
+		t.Errorf("reviewer 페르소나 요청 = %+v", req)
+	}
+
+	// A chat's own sampling overrides take precedence over the persona
+	if err := client.SetSamplingParameter(1, "temperature", "0.3"); err != nil {
+		t.Fatalf("SetSamplingParameter() error = %v", err)
+	}
+	if got := *client.Sampling(1).Temperature; got != 0.3 {
+		t.Errorf("Sampling() temperature = %v, expected 0.3", got)
+	}
+
+	// Personas without few-shot examples, model or tools use the configured ones
+	req = ask(2)
+	if req.Model != "gpt-4.1-nano" || req.Messages[0].Content != "Translate between Korean and English." ||
+		req.Messages[1].Content != "hi" || *req.Temperature != 0.7 || len(req.Tools) != 1 {
+		t.Errorf("translator 페르소나 요청 = %+v", req)
+	}
+
+	client.ResetPersona(1)
+	if client.Persona(1) != "" {
+		t.Errorf("ResetPersona() left persona %q", client.Persona(1))
+	}
+}
@@ -0,0 +1,118 @@
+package openai
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/privacy"
+)
+
+// sessionData is a session in a privacy export
+type sessionData struct {
+	ID         string          `json:"id"`
+	Title      string          `json:"title,omitempty"`
+	Active     bool            `json:"active"`
+	LastUpdate time.Time       `json:"last_update"`
+	Summary    string          `json:"summary,omitempty"`
+	Messages   []storedMessage `json:"messages"`
+}
+
+// DataSources returns the stores of the client that hold data about users.
+// Conversations belong to the user's private chat, whose ID is the user ID.
+func (c *Client) DataSources() []privacy.Source {
+	sources := []privacy.Source{conversationSource{c.convManager}, settingsSource{c.settings}}
+	if c.memory != nil {
+		sources = append(sources, c.memory.DataSource())
+	}
+	if c.recall != nil {
+		sources = append(sources, c.recall.DataSource())
+	}
+	if c.redactor != nil {
+		sources = append(sources, c.redactor.DataSource())
+	}
+	return sources
+}
+
+// conversationSource exposes every session of a user
+type conversationSource struct {
+	manager *ConversationManager
+}
+
+// Name implements privacy.Source
+func (conversationSource) Name() string {
+	return "conversations"
+}
+
+// Export implements privacy.Source
+func (s conversationSource) Export(userID int64) (interface{}, error) {
+	convs, err := s.manager.Conversations(userID)
+	if err != nil || len(convs) == 0 {
+		return nil, err
+	}
+	active, err := s.manager.activeSession(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]sessionData, 0, len(convs))
+	for sessionID, conv := range convs {
+		stored := toStored(conv)
+		sessions = append(sessions, sessionData{
+			ID:         sessionID,
+			Title:      stored.Title,
+			Active:     sessionID == active,
+			LastUpdate: stored.LastUpdate,
+			Summary:    stored.Summary,
+			Messages:   stored.Messages,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUpdate.After(sessions[j].LastUpdate)
+	})
+	return sessions, nil
+}
+
+// Delete implements privacy.Source
+func (s conversationSource) Delete(userID int64) error {
+	_, err := s.manager.DeleteAll(userID)
+	return err
+}
+
+// settingsSource exposes the per-chat setting overrides of a user
+type settingsSource struct {
+	settings *SettingsManager
+}
+
+// Name implements privacy.Source
+func (settingsSource) Name() string {
+	return "settings"
+}
+
+// Export implements privacy.Source
+func (s settingsSource) Export(userID int64) (interface{}, error) {
+	settings := s.settings.Get(userID)
+	if reflect.DeepEqual(settings, ChatSettings{}) {
+		return nil, nil
+	}
+	data := map[string]interface{}{}
+	if !reflect.DeepEqual(settings.Sampling, config.SamplingConfig{}) {
+		data["sampling"] = settings.Sampling
+	}
+	if settings.SummaryOptOut {
+		data["carry_summary"] = false
+	}
+	if settings.KnowledgeChosen {
+		data["knowledge_bases"] = settings.KnowledgeBases
+	}
+	if settings.Persona != "" {
+		data["persona"] = settings.Persona
+	}
+	return data, nil
+}
+
+// Delete implements privacy.Source
+func (s settingsSource) Delete(userID int64) error {
+	return s.settings.Reset(userID)
+}
@@ -0,0 +1,76 @@
+package openai
+
+import (
+	"testing"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/memory"
+	"github.com/itswryu/telegpt/pkg/recall"
+)
+
+func TestClientDataSources(t *testing.T) {
+	client := NewClient(&config.Config{OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"}})
+	client.SetMemory(memory.NewManager(memory.NewMemoryStore(), &config.MemoryConfig{}))
+	archive, _ := recall.NewArchive(&config.RecallConfig{Store: "memory"}, nil)
+	client.SetRecall(archive)
+	sources := client.DataSources()
+	if len(sources) != 4 {
+		t.Fatalf("DataSources() = %d sources, expected conversations, settings, memories and recall", len(sources))
+	}
+
+	client.addMessageToHistory(1, "user", "hello")
+	client.addMessageToHistory(12, "user", "other chat")
+	if _, err := client.NewSession(1, "Second"); err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	client.addMessageToHistory(1, "user", "second session")
+	if err := client.SetSamplingParameter(1, "temperature", "0.2"); err != nil {
+		t.Fatalf("SetSamplingParameter() error = %v", err)
+	}
+
+	if _, err := client.Memory().Remember(1, "Prefers Go", memory.SourceUser); err != nil {
+		t.Fatalf("Remember() error = %v", err)
+	}
+	if err := archive.Add(1, recall.Exchange{Question: "hello", Answer: "hi"}, []float32{1}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	exported := make(map[string]interface{})
+	for _, source := range sources {
+		data, err := source.Export(1)
+		if err != nil {
+			t.Fatalf("%s Export() error = %v", source.Name(), err)
+		}
+		exported[source.Name()] = data
+	}
+	sessions, ok := exported["conversations"].([]sessionData)
+	if !ok || len(sessions) != 2 {
+		t.Fatalf("conversations Export() = %#v, expected two sessions", exported["conversations"])
+	}
+	if exported["settings"] == nil {
+		t.Error("settings Export() = nil, expected the temperature override")
+	}
+	if exported["memories"] == nil {
+		t.Error("memories Export() = nil, expected the remembered fact")
+	}
+	if exported["recall"] == nil {
+		t.Error("recall Export() = nil, expected the archived exchange")
+	}
+
+	for _, source := range sources {
+		if err := source.Delete(1); err != nil {
+			t.Fatalf("%s Delete() error = %v", source.Name(), err)
+		}
+		if data, _ := source.Export(1); data != nil {
+			t.Errorf("%s Export() after Delete() = %#v, expected nil", source.Name(), data)
+		}
+	}
+
+	// 다른 채팅의 대화는 지워지지 않음
+	if conv, _ := client.convManager.GetConversation(12); len(conv.Messages) != 1 {
+		t.Errorf("다른 채팅의 대화 = %v, 남아 있어야 함", messageContents(conv.Messages))
+	}
+	if sessionID, _ := client.convManager.store.ActiveSession(conversationKey(1)); sessionID != "" {
+		t.Errorf("삭제 후 활성 세션 = %q, 비어 있어야 함", sessionID)
+	}
+}
@@ -0,0 +1,111 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/recall"
+)
+
+// maxRecalledText is the number of characters of a question or answer that a
+// recalled exchange adds to a prompt
+const maxRecalledText = 600
+
+// SetRecall enables semantic recall over the exchanges archived in archive
+func (c *Client) SetRecall(archive *recall.Archive) {
+	c.recall = archive
+}
+
+// RecallEnabled reports whether past exchanges are archived and can be recalled
+func (c *Client) RecallEnabled() bool {
+	return c.recall != nil
+}
+
+// ArchiveExchange embeds an answered question and adds it to the recall
+// archive of the user, if recall is enabled. It returns the usage of the request.
+func (c *Client) ArchiveExchange(ctx context.Context, userID int64, sessionID, question, answer string, at time.Time) (map[string]Usage, error) {
+	if c.recall == nil {
+		return nil, nil
+	}
+	if c.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+
+	exchange := recall.Exchange{SessionID: sessionID, Question: c.redact(userID, question), Answer: c.redact(userID, answer), Time: at}
+	usage := make(map[string]Usage)
+	embedding, err := c.embed(ctx, exchange.Text(), usage)
+	if err != nil {
+		return nil, err
+	}
+	return usage, c.recall.Add(userID, exchange, embedding)
+}
+
+// Recall returns the archived exchanges of a user most similar to query, best
+// first, together with the usage of embedding the query
+func (c *Client) Recall(ctx context.Context, userID int64, query string) ([]recall.Match, map[string]Usage, error) {
+	if c.recall == nil {
+		return nil, nil, nil
+	}
+	if c.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+
+	usage := make(map[string]Usage)
+	embedding, err := c.embed(ctx, c.redact(userID, query), usage)
+	if err != nil {
+		return nil, nil, err
+	}
+	matches, err := c.recall.Search(userID, embedding, c.recallConfig.MaxResults, 0, "")
+	for i := range matches {
+		matches[i].Question = c.restore(userID, matches[i].Question)
+		matches[i].Answer = c.restore(userID, matches[i].Answer)
+	}
+	return matches, usage, err
+}
+
+// recallSection returns the part of the system message with past exchanges
+// relevant to text, or an empty string if automatic recall is off or nothing
+// is relevant. Exchanges of the current session are already in the context.
+func (c *Client) recallSection(ctx context.Context, userID int64, sessionID, text string, usage map[string]Usage) string {
+	if c.recall == nil || !c.recallConfig.Auto {
+		return ""
+	}
+
+	embedding, err := c.embed(ctx, text, usage)
+	if err != nil {
+		logger.Warn("Error embedding the message of %d for recall: %v", userID, err)
+		return ""
+	}
+	matches, err := c.recall.Search(userID, embedding, c.recallConfig.AutoResults, c.recallConfig.MinScore, sessionID)
+	if err != nil {
+		logger.Warn("Error searching the recall archive of %d: %v", userID, err)
+		return ""
+	}
+	if len(matches) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Excerpts of earlier conversations with the user that may be relevant:")
+	for _, match := range matches {
+		fmt.Fprintf(&sb, "\n\n[%s]\nUser: %s\nAssistant: %s", match.Time.Format("2006-01-02"),
+			truncate(match.Question, maxRecalledText), truncate(match.Answer, maxRecalledText))
+	}
+	return sb.String()
+}
+
+// truncate shortens text to at most n characters, marking the cut with an ellipsis
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n]) + "…"
+}
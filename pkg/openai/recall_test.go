@@ -0,0 +1,166 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/recall"
+)
+
+// keywordEmbedding embeds text as a vector of the keywords it contains, so that
+// tests can tell which texts are similar
+func keywordEmbedding(text string) []float32 {
+	vector := []float32{0.01, 0, 0}
+	for i, keyword := range []string{"busan", "cat"} {
+		if strings.Contains(strings.ToLower(text), keyword) {
+			vector[i+1] = 1
+		}
+	}
+	return vector
+}
+
+// embeddingHandler serves embeddings made by keywordEmbedding and passes
+// every other request to complete
+func embeddingHandler(t *testing.T, complete http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			complete(w, r)
+			return
+		}
+		var req EmbeddingRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != config.DefaultEmbeddingModel {
+			t.Errorf("임베딩 모델 = %q", req.Model)
+		}
+		data := make([]map[string]interface{}, len(req.Input))
+		for i, input := range req.Input {
+			data[len(req.Input)-1-i] = map[string]interface{}{"index": i, "embedding": keywordEmbedding(input)}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data":  data,
+			"usage": map[string]int{"prompt_tokens": 5, "total_tokens": 5},
+		})
+	}
+}
+
+// newRecallClient returns a client with semantic recall whose chat completions
+// are answered by complete and whose embeddings use keywordEmbedding
+func newRecallClient(t *testing.T, cfg config.RecallConfig, complete http.HandlerFunc) *Client {
+	t.Helper()
+	cfg.Enabled = true
+	cfg.Store = "memory"
+	client := newTestClient(t, &config.Config{Recall: cfg}, embeddingHandler(t, complete))
+	archive, err := recall.NewArchive(&cfg, nil)
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+	client.SetRecall(archive)
+	return client
+}
+
+func TestEmbed(t *testing.T) {
+	client := newRecallClient(t, config.RecallConfig{}, nil)
+	vectors, usage, err := client.Embed(context.Background(), []string{"my cat", "Busan"})
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if len(vectors) != 2 || vectors[0][2] != 1 || vectors[1][1] != 1 {
+		t.Errorf("Embed() = %v, 입력 순서대로 반환되어야 함", vectors)
+	}
+	if usage.TotalTokens != 5 {
+		t.Errorf("Embed() usage = %+v", usage)
+	}
+}
+
+func TestRecall(t *testing.T) {
+	client := newRecallClient(t, config.RecallConfig{MaxResults: 1}, nil)
+	ctx := context.Background()
+	at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	if _, err := client.ArchiveExchange(ctx, 1, "s1", "Plan a trip to Busan", "Take the KTX", at); err != nil {
+		t.Fatalf("ArchiveExchange() error = %v", err)
+	}
+	usage, err := client.ArchiveExchange(ctx, 1, "s1", "What do cats eat?", "Fish", at)
+	if err != nil || usage[config.DefaultEmbeddingModel].TotalTokens != 5 {
+		t.Fatalf("ArchiveExchange() = %v, %v", usage, err)
+	}
+
+	matches, _, err := client.Recall(ctx, 1, "that busan trip")
+	if err != nil {
+		t.Fatalf("Recall() error = %v", err)
+	}
+	if len(matches) != 1 || matches[0].Answer != "Take the KTX" {
+		t.Errorf("Recall() = %+v, 부산 여행 교환 하나여야 함", matches)
+	}
+	if matches, _, _ := client.Recall(ctx, 2, "busan"); len(matches) != 0 {
+		t.Errorf("Recall() of another user = %+v", matches)
+	}
+}
+
+func TestGenerateReplyRecallsEarlierSessions(t *testing.T) {
+	var system string
+	client := newRecallClient(t, config.RecallConfig{Auto: true, AutoResults: 3, MinScore: 0.5}, func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		system = req.Messages[0].Content
+		mockCompletion(w, "answer")
+	})
+	ctx := context.Background()
+
+	reply, err := client.GenerateReply(ctx, Request{ChatID: 1, Text: "Plan a trip to Busan"})
+	if err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	if strings.Contains(system, "Excerpts of earlier conversations") {
+		t.Errorf("보관된 교환이 없는데 시스템 메시지에 섹션이 있음: %q", system)
+	}
+	if reply.Usage[config.DefaultEmbeddingModel].TotalTokens != 5 {
+		t.Errorf("GenerateReply() usage = %+v, 임베딩 사용량이 포함되어야 함", reply.Usage)
+	}
+	client.ArchiveExchange(ctx, 1, reply.SessionID, "Plan a trip to Busan", "Take the KTX", time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC))
+
+	// 현재 세션의 교환은 이미 문맥에 있으므로 다시 넣지 않음
+	client.GenerateReply(ctx, Request{ChatID: 1, Text: "Busan again"})
+	if strings.Contains(system, "Excerpts of earlier conversations") {
+		t.Errorf("현재 세션의 교환이 다시 들어감: %q", system)
+	}
+
+	if _, err := client.NewSession(1, ""); err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	client.GenerateReply(ctx, Request{ChatID: 1, Text: "Remind me about Busan"})
+	expected := "Excerpts of earlier conversations with the user that may be relevant:\n\n[2024-05-01]\nUser: Plan a trip to Busan\nAssistant: Take the KTX"
+	if !strings.HasSuffix(system, expected) {
+		t.Errorf("시스템 메시지 = %q, 이전 세션의 교환이 있어야 함", system)
+	}
+
+	client.GenerateReply(ctx, Request{ChatID: 1, Text: "Does my cat like fish?"})
+	if strings.Contains(system, "Excerpts of earlier conversations") {
+		t.Errorf("관련 없는 교환이 들어감: %q", system)
+	}
+}
+
+func TestDeleteSessionRemovesArchivedExchanges(t *testing.T) {
+	client := newRecallClient(t, config.RecallConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		mockCompletion(w, "answer")
+	})
+	ctx := context.Background()
+	reply, err := client.GenerateReply(ctx, Request{ChatID: 1, Text: "Busan"})
+	if err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	client.ArchiveExchange(ctx, 1, reply.SessionID, "Busan", "answer", time.Now())
+	client.NewSession(1, "")
+
+	if err := client.DeleteSession(1, reply.SessionID); err != nil {
+		t.Fatalf("DeleteSession() error = %v", err)
+	}
+	if matches, _, _ := client.Recall(ctx, 1, "Busan"); len(matches) != 0 {
+		t.Errorf("Recall() after DeleteSession() = %+v", matches)
+	}
+}
@@ -0,0 +1,51 @@
+package openai
+
+import "github.com/itswryu/telegpt/pkg/redact"
+
+// SetRedactor replaces personal data and secrets in messages with placeholders
+// before they are sent to the API. The values it remembers for restoring
+// answers are kept in the conversation store.
+func (c *Client) SetRedactor(redactor *redact.Redactor) {
+	redactor.SetStore(redactionStore{c})
+	c.redactor = redactor
+}
+
+// redactionStore keeps the values of the redactor next to the conversations,
+// encrypted like them
+type redactionStore struct {
+	client *Client
+}
+
+// LoadValues implements redact.Store
+func (s redactionStore) LoadValues(chatID int64) ([]byte, error) {
+	return s.client.convManager.store.ChatData("redactions", conversationKey(chatID))
+}
+
+// SaveValues implements redact.Store
+func (s redactionStore) SaveValues(chatID int64, data []byte) error {
+	return s.client.convManager.store.SetChatData("redactions", conversationKey(chatID), data)
+}
+
+// Mask replaces personal data and secrets in text for logging, if redaction is enabled
+func (c *Client) Mask(text string) string {
+	if c.redactor == nil {
+		return text
+	}
+	return c.redactor.Mask(text)
+}
+
+// redact replaces personal data and secrets in a message of a chat
+func (c *Client) redact(chatID int64, text string) string {
+	if c.redactor == nil {
+		return text
+	}
+	return c.redactor.Redact(chatID, text)
+}
+
+// restore puts the values of a chat back in place of the placeholders of an answer
+func (c *Client) restore(chatID int64, text string) string {
+	if c.redactor == nil {
+		return text
+	}
+	return c.redactor.Restore(chatID, text)
+}
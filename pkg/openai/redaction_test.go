@@ -0,0 +1,101 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/memory"
+	"github.com/itswryu/telegpt/pkg/redact"
+)
+
+func TestGenerateReplyRedactsAndRestores(t *testing.T) {
+	placeholder := regexp.MustCompile(`\[EMAIL_[0-9a-f]{8}\]`)
+	var sent []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		last := req.Messages[len(req.Messages)-1].Content
+		sent = append(sent, last)
+		mockCompletion(w, "I will write to "+placeholder.FindString(last)+".")
+	}))
+	defer server.Close()
+
+	for _, restore := range []bool{true, false} {
+		sent = nil
+		client := NewClient(&config.Config{OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"}})
+		client.SetBaseURL(server.URL)
+		redactor, err := redact.New(&config.RedactionConfig{Enabled: true, Restore: restore})
+		if err != nil {
+			t.Fatalf("redact.New() error = %v", err)
+		}
+		client.SetRedactor(redactor)
+
+		reply, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "Email jane@example.com about it"})
+		if err != nil {
+			t.Fatalf("GenerateReply() error = %v", err)
+		}
+		if len(sent) != 1 || strings.Contains(sent[0], "jane@example.com") || !placeholder.MatchString(sent[0]) {
+			t.Errorf("API에 전달된 메시지 = %q, 이메일이 가려져야 함", sent)
+		}
+		restored := reply.Content == "I will write to jane@example.com."
+		if restored != restore {
+			t.Errorf("restore=%v: GenerateReply() = %q", restore, reply.Content)
+		}
+
+		history, err := client.convManager.GetConversation(1)
+		if err != nil {
+			t.Fatalf("GetConversation() error = %v", err)
+		}
+		for _, message := range history.Messages {
+			if strings.Contains(message.Content, "jane@example.com") {
+				t.Errorf("기록에 원래 값이 저장됨: %q", message.Content)
+			}
+		}
+	}
+
+	if got := (&Client{}).Mask("jane@example.com"); got != "jane@example.com" {
+		t.Errorf("Mask() without a redactor = %q", got)
+	}
+}
+
+func TestMemoriesAreRedacted(t *testing.T) {
+	var bodies []string
+	cfg := config.MemoryConfig{Extract: true, MaxInjected: 5}
+	client := newMemoryClient(t, cfg, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		mockCompletion(w, `[]`)
+	})
+	redactor, err := redact.New(&config.RedactionConfig{Enabled: true, Restore: true})
+	if err != nil {
+		t.Fatalf("redact.New() error = %v", err)
+	}
+	client.SetRedactor(redactor)
+	client.Memory().Remember(1, "Email is jane@example.com", memory.SourceUser)
+
+	if _, err := client.GenerateReply(context.Background(), Request{ChatID: 1, Text: "what is my email?"}); err != nil {
+		t.Fatalf("GenerateReply() error = %v", err)
+	}
+	if _, _, err := client.ExtractMemories(context.Background(), 1, "hello", "hi"); err != nil {
+		t.Fatalf("ExtractMemories() error = %v", err)
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("Requests = %d, expected 2", len(bodies))
+	}
+	for _, body := range bodies {
+		if strings.Contains(body, "jane@example.com") {
+			t.Errorf("기억에 저장된 이메일이 요청에 포함됨: %s", body)
+		}
+		if !strings.Contains(body, "[EMAIL_") {
+			t.Errorf("요청에 기억의 자리표시자가 없음: %s", body)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/logger"
+)
+
+// ChatSettings holds the per-chat overrides of the global configuration
+type ChatSettings struct {
+	Sampling config.SamplingConfig `json:"sampling,omitempty"`
+	// SummaryOptOut turns off summaries of expired conversations for the chat
+	SummaryOptOut bool `json:"summary_opt_out,omitempty"`
+	// KnowledgeBases replace the configured knowledge bases of the chat once KnowledgeChosen is set
+	KnowledgeBases  []string `json:"knowledge_bases,omitempty"`
+	KnowledgeChosen bool     `json:"knowledge_chosen,omitempty"`
+	// Persona replaces the configured persona of the chat if set
+	Persona string `json:"persona,omitempty"`
+}
+
+// SettingsManager manages per-chat settings. They are kept in the conversation
+// store so that they survive restarts and are shared between replicas.
+type SettingsManager struct {
+	store ConversationStore
+	mutex sync.RWMutex
+}
+
+// NewSettingsManager creates a settings manager that keeps the settings in
+// memory until SetStore is called
+func NewSettingsManager() *SettingsManager {
+	return &SettingsManager{store: NewMemoryConversationStore(0, 0)}
+}
+
+// SetStore keeps the settings in store
+func (m *SettingsManager) SetStore(store ConversationStore) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.store = store
+}
+
+// Get returns the settings of a chat. Settings that cannot be read are
+// logged and treated as no overrides.
+func (m *SettingsManager) Get(chatID int64) ChatSettings {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	settings, err := m.load(chatID)
+	if err != nil {
+		logger.Warn("Error loading settings of %d: %v", chatID, err)
+	}
+	return settings
+}
+
+// Update applies fn to the settings of a chat and stores the result unless fn fails
+func (m *SettingsManager) Update(chatID int64, fn func(*ChatSettings) error) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	settings, err := m.load(chatID)
+	if err != nil {
+		return err
+	}
+	if err := fn(&settings); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(settings, ChatSettings{}) {
+		return m.store.SetChatData("settings", conversationKey(chatID), nil)
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("error encoding settings: %w", err)
+	}
+	return m.store.SetChatData("settings", conversationKey(chatID), data)
+}
+
+// Reset removes all overrides of a chat
+func (m *SettingsManager) Reset(chatID int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.store.SetChatData("settings", conversationKey(chatID), nil)
+}
+
+// load reads the settings of a chat
+func (m *SettingsManager) load(chatID int64) (ChatSettings, error) {
+	var settings ChatSettings
+	data, err := m.store.ChatData("settings", conversationKey(chatID))
+	if err != nil || data == nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return ChatSettings{}, fmt.Errorf("error decoding settings: %w", err)
+	}
+	return settings, nil
+}
+
+// Sampling returns the effective sampling parameters of a chat
+func (c *Client) Sampling(chatID int64) config.SamplingConfig {
+	return c.baseSampling(chatID).Merge(c.settings.Get(chatID).Sampling)
+}
+
+// baseSampling returns the sampling parameters of a chat before its own
+// overrides: the configured ones with those of its persona
+func (c *Client) baseSampling(chatID int64) config.SamplingConfig {
+	if p := c.persona(chatID); p != nil {
+		return c.sampling.Merge(p.SamplingConfig)
+	}
+	return c.sampling
+}
+
+// SetSamplingParameter overrides a sampling parameter for a chat.
+// The value "default" removes the override.
+func (c *Client) SetSamplingParameter(chatID int64, name, value string) error {
+	base := c.baseSampling(chatID)
+	return c.settings.Update(chatID, func(settings *ChatSettings) error {
+		override := settings.Sampling
+		if err := override.Set(name, value); err != nil {
+			return err
+		}
+		if err := base.Merge(override).Validate(); err != nil {
+			return err
+		}
+		settings.Sampling = override
+		return nil
+	})
+}
+
+// ResetSampling removes the sampling overrides of a chat
+func (c *Client) ResetSampling(chatID int64) {
+	_ = c.settings.Update(chatID, func(settings *ChatSettings) error {
+		settings.Sampling = config.SamplingConfig{}
+		return nil
+	})
+}
+
+// CarriesSummary reports whether a new session of a chat that replaces an
+// expired one starts with a summary of it
+func (c *Client) CarriesSummary(chatID int64) bool {
+	return c.conversations.CarrySummary && !c.settings.Get(chatID).SummaryOptOut
+}
+
+// SetCarrySummary opts a chat in or out of summaries of expired conversations
+func (c *Client) SetCarrySummary(chatID int64, enabled bool) {
+	_ = c.settings.Update(chatID, func(settings *ChatSettings) error {
+		settings.SummaryOptOut = !enabled
+		return nil
+	})
+}
+
+// SummariesAvailable reports whether summaries of expired conversations are enabled in the configuration
+func (c *Client) SummariesAvailable() bool {
+	return c.conversations.CarrySummary
+}
@@ -0,0 +1,324 @@
+package openai
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/encryption"
+	"github.com/redis/go-redis/v9"
+)
+
+// ConversationStore persists conversation histories by key. Stores keep at most
+// maxHistory messages per conversation and treat conversations that have not
+// been updated within their TTL as gone. They also remember which session of a
+// chat is active and keep small per-chat records such as its settings.
+type ConversationStore interface {
+	// Get returns the conversation stored under key, or nil if there is none or it expired
+	Get(key string) (*Conversation, error)
+	// Append adds messages to the conversation, starting a new one if it expired
+	Append(key string, messages ...Message) error
+	// Commit appends messages only if the conversation still has the given ID,
+	// or with an empty ID if there still is none, and returns
+	// ErrConversationChanged otherwise
+	Commit(key string, conversationID string, messages ...Message) error
+	// Update lets update modify the stored conversation in place, keeping its
+	// ID. Missing or expired conversations are left alone.
+	Update(key string, update func(conv *Conversation)) error
+	// Replace overwrites the messages of the conversation, starting a new generation
+	Replace(key string, messages []Message) error
+	// Reset removes the conversation
+	Reset(key string) error
+	// ResetChat removes every conversation of a chat, the one under chatKey and
+	// those under chatKey+":", expired or not, and returns how many it removed
+	ResetChat(chatKey string) (int, error)
+	// List returns the sorted keys starting with prefix of the conversations that have not expired
+	List(prefix string) ([]string, error)
+	// Expire removes expired conversations and returns how many were removed
+	Expire() (int, error)
+	// ActiveSession returns the session selected for a chat, empty if none was selected
+	ActiveSession(chatKey string) (string, error)
+	// SetActiveSession selects the session of a chat. An empty ID clears the selection.
+	SetActiveSession(chatKey string, sessionID string) error
+	// ChatData returns the record of the given kind stored for a chat, nil if there is none
+	ChatData(kind, chatKey string) ([]byte, error)
+	// SetChatData stores a record of a chat, which does not expire. Nil data removes it.
+	SetChatData(kind, chatKey string, data []byte) error
+	// Close flushes and releases the store
+	Close() error
+}
+
+// selfExpiring is implemented by the stores whose backend removes expired
+// conversations itself, so that they need no expiry loop
+type selfExpiring interface {
+	expiresItself()
+}
+
+// NewConversationStore creates the conversation store selected in the configuration.
+// The Redis client and key prefix are only used by the redis store. The
+// persistent stores encrypt conversations if encryption keys are configured.
+func NewConversationStore(cfg *config.ConversationConfig, redisClient redis.UniversalClient, redisPrefix string) (ConversationStore, error) {
+	var keyring *encryption.Keyring
+	if cfg.Encryption.Enabled() && cfg.Store != "memory" {
+		var err error
+		keyring, err = encryption.NewKeyring(cfg.Encryption.Keys, cfg.Encryption.ActiveKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch cfg.Store {
+	case "memory":
+		return NewMemoryConversationStore(cfg.MaxStoredHistory(), cfg.Retention), nil
+	case "file", "":
+		return NewBoltConversationStore(cfg.Path, cfg.MaxStoredHistory(), cfg.Retention, keyring)
+	case "redis":
+		if redisClient == nil {
+			return nil, fmt.Errorf("conversation store redis requires a Redis connection")
+		}
+		return NewRedisConversationStore(redisClient, redisPrefix, cfg.MaxStoredHistory(), cfg.Retention, keyring), nil
+	}
+	return nil, fmt.Errorf("unknown conversation store %q", cfg.Store)
+}
+
+// trimHistory keeps the newest maxHistory messages
+func trimHistory(messages []Message, maxHistory int) []Message {
+	if maxHistory > 0 && len(messages) > maxHistory {
+		return messages[len(messages)-maxHistory:]
+	}
+	return messages
+}
+
+// currentID returns the ID of a conversation or an empty ID if there is none
+func currentID(conv *Conversation) string {
+	if conv == nil {
+		return ""
+	}
+	return conv.ID
+}
+
+// expired reports whether a conversation last updated at lastUpdate has outlived ttl
+func expired(lastUpdate time.Time, ttl time.Duration, now time.Time) bool {
+	return ttl > 0 && now.Sub(lastUpdate) > ttl
+}
+
+// MemoryConversationStore keeps conversations in process memory
+type MemoryConversationStore struct {
+	conversations map[string]*Conversation
+	active        map[string]string
+	chatData      map[string][]byte
+	maxHistory    int
+	ttl           time.Duration
+	mutex         sync.RWMutex
+	now           func() time.Time
+}
+
+// NewMemoryConversationStore creates an empty in-memory store
+func NewMemoryConversationStore(maxHistory int, ttl time.Duration) *MemoryConversationStore {
+	return &MemoryConversationStore{
+		conversations: make(map[string]*Conversation),
+		active:        make(map[string]string),
+		chatData:      make(map[string][]byte),
+		maxHistory:    maxHistory,
+		ttl:           ttl,
+		now:           time.Now,
+	}
+}
+
+// Get implements ConversationStore
+func (s *MemoryConversationStore) Get(key string) (*Conversation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	conv, ok := s.conversations[key]
+	if !ok || expired(conv.LastUpdate, s.ttl, s.now()) {
+		return nil, nil
+	}
+	return conv.clone(), nil
+}
+
+// Append implements ConversationStore
+func (s *MemoryConversationStore) Append(key string, messages ...Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.append(key, s.current(key), messages)
+	return nil
+}
+
+// Commit implements ConversationStore
+func (s *MemoryConversationStore) Commit(key string, conversationID string, messages ...Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	conv := s.current(key)
+	if currentID(conv) != conversationID {
+		return ErrConversationChanged
+	}
+	s.append(key, conv, messages)
+	return nil
+}
+
+// current returns the live conversation stored under key or nil
+func (s *MemoryConversationStore) current(key string) *Conversation {
+	conv, ok := s.conversations[key]
+	if !ok || expired(conv.LastUpdate, s.ttl, s.now()) {
+		return nil
+	}
+	return conv
+}
+
+// append adds messages to conv, starting a new conversation if it is nil
+func (s *MemoryConversationStore) append(key string, conv *Conversation, messages []Message) {
+	if conv == nil {
+		conv = &Conversation{ID: newConversationID(), Started: s.now()}
+		s.conversations[key] = conv
+	}
+	conv.Messages = trimHistory(append(conv.Messages, messages...), s.maxHistory)
+	conv.LastUpdate = s.now()
+}
+
+// Update implements ConversationStore
+func (s *MemoryConversationStore) Update(key string, update func(conv *Conversation)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if conv := s.current(key); conv != nil {
+		// Work on a copy so that metadata shared with earlier snapshots is not changed
+		updated := conv.clone()
+		update(updated)
+		updated.ID = conv.ID
+		updated.LastUpdate = s.now()
+		s.conversations[key] = updated
+	}
+	return nil
+}
+
+// Replace implements ConversationStore
+func (s *MemoryConversationStore) Replace(key string, messages []Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.conversations[key] = &Conversation{
+		ID:         newConversationID(),
+		Messages:   trimHistory(append([]Message(nil), messages...), s.maxHistory),
+		LastUpdate: s.now(),
+		Started:    s.now(),
+	}
+	return nil
+}
+
+// Reset implements ConversationStore
+func (s *MemoryConversationStore) Reset(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.conversations, key)
+	return nil
+}
+
+// ResetChat implements ConversationStore
+func (s *MemoryConversationStore) ResetChat(chatKey string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := 0
+	for key := range s.conversations {
+		if key == chatKey || strings.HasPrefix(key, chatKey+":") {
+			delete(s.conversations, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// List implements ConversationStore
+func (s *MemoryConversationStore) List(prefix string) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := s.now()
+	keys := make([]string, 0, len(s.conversations))
+	for key, conv := range s.conversations {
+		if strings.HasPrefix(key, prefix) && !expired(conv.LastUpdate, s.ttl, now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Expire implements ConversationStore
+func (s *MemoryConversationStore) Expire() (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	removed := 0
+	for key, conv := range s.conversations {
+		if expired(conv.LastUpdate, s.ttl, now) {
+			delete(s.conversations, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Close implements ConversationStore
+func (s *MemoryConversationStore) Close() error {
+	return nil
+}
+
+// ActiveSession implements ConversationStore
+func (s *MemoryConversationStore) ActiveSession(chatKey string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.active[chatKey], nil
+}
+
+// SetActiveSession implements ConversationStore
+func (s *MemoryConversationStore) SetActiveSession(chatKey string, sessionID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if sessionID == "" {
+		delete(s.active, chatKey)
+		return nil
+	}
+	s.active[chatKey] = sessionID
+	return nil
+}
+
+// ChatData implements ConversationStore
+func (s *MemoryConversationStore) ChatData(kind, chatKey string) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	data, ok := s.chatData[chatDataKey(kind, chatKey)]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// SetChatData implements ConversationStore
+func (s *MemoryConversationStore) SetChatData(kind, chatKey string, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if data == nil {
+		delete(s.chatData, chatDataKey(kind, chatKey))
+		return nil
+	}
+	s.chatData[chatDataKey(kind, chatKey)] = append([]byte(nil), data...)
+	return nil
+}
+
+// chatDataKey is the key of a per-chat record, such as settings:123
+func chatDataKey(kind, chatKey string) string {
+	return kind + ":" + chatKey
+}
@@ -0,0 +1,343 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/encryption"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// conversationsBucket holds one JSON encoded Conversation per key
+	conversationsBucket = []byte("conversations")
+	// activeSessionsBucket maps chat keys to their active session
+	activeSessionsBucket = []byte("active_sessions")
+	// chatDataBucket holds the per-chat records by kind and chat key
+	chatDataBucket = []byte("chat_data")
+)
+
+// BoltConversationStore keeps conversations in an embedded bbolt database file
+type BoltConversationStore struct {
+	db         *bolt.DB
+	codec      conversationCodec
+	maxHistory int
+	ttl        time.Duration
+	now        func() time.Time
+}
+
+// NewBoltConversationStore opens or creates the database at path. Conversations
+// are encrypted if a keyring is given.
+func NewBoltConversationStore(path string, maxHistory int, ttl time.Duration, keyring *encryption.Keyring) (*BoltConversationStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating conversation store directory: %w", err)
+	}
+
+	// The timeout keeps a second process from blocking forever on the file lock
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening conversation store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{conversationsBucket, activeSessionsBucket, chatDataBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing conversation store: %w", err)
+	}
+
+	return &BoltConversationStore{db: db, codec: conversationCodec{keyring: keyring}, maxHistory: maxHistory, ttl: ttl, now: time.Now}, nil
+}
+
+// Get implements ConversationStore
+func (s *BoltConversationStore) Get(key string) (*Conversation, error) {
+	var conv *Conversation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		conv, err = s.load(tx, key)
+		return err
+	})
+	return conv, err
+}
+
+// Append implements ConversationStore
+func (s *BoltConversationStore) Append(key string, messages ...Message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		conv, err := s.load(tx, key)
+		if err != nil {
+			return err
+		}
+		return s.append(tx, key, conv, messages)
+	})
+}
+
+// Commit implements ConversationStore
+func (s *BoltConversationStore) Commit(key string, conversationID string, messages ...Message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		conv, err := s.load(tx, key)
+		if err != nil {
+			return err
+		}
+		if currentID(conv) != conversationID {
+			return ErrConversationChanged
+		}
+		return s.append(tx, key, conv, messages)
+	})
+}
+
+// Update implements ConversationStore
+func (s *BoltConversationStore) Update(key string, update func(conv *Conversation)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		conv, err := s.load(tx, key)
+		if err != nil || conv == nil {
+			return err
+		}
+		id := conv.ID
+		update(conv)
+		conv.ID = id
+		return s.save(tx, key, conv)
+	})
+}
+
+// Replace implements ConversationStore
+func (s *BoltConversationStore) Replace(key string, messages []Message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.save(tx, key, &Conversation{ID: newConversationID(), Messages: trimHistory(messages, s.maxHistory)})
+	})
+}
+
+// append adds messages to conv, starting a new conversation if it is nil
+func (s *BoltConversationStore) append(tx *bolt.Tx, key string, conv *Conversation, messages []Message) error {
+	if conv == nil {
+		conv = &Conversation{ID: newConversationID()}
+	}
+	conv.Messages = trimHistory(append(conv.Messages, messages...), s.maxHistory)
+	return s.save(tx, key, conv)
+}
+
+// Reset implements ConversationStore
+func (s *BoltConversationStore) Reset(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(conversationsBucket).Delete([]byte(key))
+	})
+}
+
+// ResetChat implements ConversationStore
+func (s *BoltConversationStore) ResetChat(chatKey string) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(conversationsBucket)
+		keys := [][]byte{[]byte(chatKey)}
+		prefix := []byte(chatKey + ":")
+		cursor := bucket.Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+
+		for _, key := range keys {
+			if bucket.Get(key) == nil {
+				continue
+			}
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// List implements ConversationStore
+func (s *BoltConversationStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(conversationsBucket).Cursor()
+		for k, v := cursor.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = cursor.Next() {
+			lastUpdate, err := s.codec.lastUpdate(v)
+			if err != nil {
+				return err
+			}
+			if !expired(lastUpdate, s.ttl, s.now()) {
+				keys = append(keys, string(k))
+			}
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Expire implements ConversationStore
+func (s *BoltConversationStore) Expire() (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(conversationsBucket)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			lastUpdate, err := s.codec.lastUpdate(v)
+			if err != nil {
+				return err
+			}
+			if expired(lastUpdate, s.ttl, s.now()) {
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+				removed++
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// ActiveSession implements ConversationStore
+func (s *BoltConversationStore) ActiveSession(chatKey string) (string, error) {
+	var sessionID string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		sessionID = string(tx.Bucket(activeSessionsBucket).Get([]byte(chatKey)))
+		return nil
+	})
+	return sessionID, err
+}
+
+// SetActiveSession implements ConversationStore
+func (s *BoltConversationStore) SetActiveSession(chatKey string, sessionID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if sessionID == "" {
+			return tx.Bucket(activeSessionsBucket).Delete([]byte(chatKey))
+		}
+		return tx.Bucket(activeSessionsBucket).Put([]byte(chatKey), []byte(sessionID))
+	})
+}
+
+// ChatData implements ConversationStore
+func (s *BoltConversationStore) ChatData(kind, chatKey string) ([]byte, error) {
+	key := chatDataKey(kind, chatKey)
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		stored := tx.Bucket(chatDataBucket).Get([]byte(key))
+		if stored == nil {
+			return nil
+		}
+		var err error
+		data, err = s.codec.openRecord(key, append([]byte(nil), stored...))
+		return err
+	})
+	return data, err
+}
+
+// SetChatData implements ConversationStore
+func (s *BoltConversationStore) SetChatData(kind, chatKey string, data []byte) error {
+	key := chatDataKey(kind, chatKey)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if data == nil {
+			return tx.Bucket(chatDataBucket).Delete([]byte(key))
+		}
+		sealed, err := s.codec.sealRecord(key, data)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(chatDataBucket).Put([]byte(key), sealed)
+	})
+}
+
+// Reencrypt implements Reencrypter. All records are rewritten in one transaction.
+func (s *BoltConversationStore) Reencrypt() (int, error) {
+	if s.codec.keyring == nil {
+		return 0, fmt.Errorf("no encryption keys are configured")
+	}
+
+	rewritten := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		buckets := []struct {
+			name     []byte
+			current  func([]byte) bool
+			reencode func(string, []byte) ([]byte, error)
+		}{
+			{conversationsBucket, s.codec.current, s.codec.reencode},
+			{chatDataBucket, s.codec.recordCurrent, s.codec.resealRecord},
+		}
+		for _, b := range buckets {
+			bucket := tx.Bucket(b.name)
+
+			// Collect first as the bucket must not change while it is iterated
+			updates := make(map[string][]byte)
+			err := bucket.ForEach(func(k, v []byte) error {
+				if b.current(v) {
+					return nil
+				}
+				data, err := b.reencode(string(k), v)
+				if err != nil {
+					return err
+				}
+				updates[string(k)] = data
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for key, data := range updates {
+				if err := bucket.Put([]byte(key), data); err != nil {
+					return err
+				}
+			}
+			rewritten += len(updates)
+		}
+		return nil
+	})
+	return rewritten, err
+}
+
+// Close implements ConversationStore
+func (s *BoltConversationStore) Close() error {
+	return s.db.Close()
+}
+
+// load reads a conversation, returning nil if it does not exist or expired
+func (s *BoltConversationStore) load(tx *bolt.Tx, key string) (*Conversation, error) {
+	data := tx.Bucket(conversationsBucket).Get([]byte(key))
+	if data == nil {
+		return nil, nil
+	}
+	conv, err := s.codec.decode(key, data)
+	if err != nil {
+		return nil, err
+	}
+	if expired(conv.LastUpdate, s.ttl, s.now()) {
+		return nil, nil
+	}
+	return conv, nil
+}
+
+// save writes a conversation and refreshes its last update time
+func (s *BoltConversationStore) save(tx *bolt.Tx, key string, conv *Conversation) error {
+	conv.LastUpdate = s.now()
+	if conv.Started.IsZero() {
+		conv.Started = conv.LastUpdate
+	}
+	data, err := s.codec.encode(key, conv)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(conversationsBucket).Put([]byte(key), data)
+}
+
+// decodeConversation decodes a stored conversation
+func decodeConversation(data []byte) (*Conversation, error) {
+	var conv Conversation
+	if err := json.Unmarshal(data, &conv); err != nil {
+		return nil, fmt.Errorf("error decoding conversation: %w", err)
+	}
+	return &conv, nil
+}
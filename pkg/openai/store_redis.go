@@ -0,0 +1,394 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/encryption"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisTimeout bounds every Redis round trip of the store
+	redisTimeout = 5 * time.Second
+	// redisMaxRetries is how often an append is retried when another replica
+	// changed the conversation at the same time
+	redisMaxRetries = 5
+)
+
+// RedisConversationStore keeps conversations in Redis so that replicas share them.
+// Each conversation is a JSON value whose Redis expiry implements the TTL.
+type RedisConversationStore struct {
+	client       redis.UniversalClient
+	codec        conversationCodec
+	prefix       string
+	activePrefix string
+	dataPrefix   string
+	maxHistory   int
+	ttl          time.Duration
+}
+
+// NewRedisConversationStore creates a store on top of a Redis client, namespacing
+// keys with prefix. Conversations are encrypted if a keyring is given.
+func NewRedisConversationStore(client redis.UniversalClient, prefix string, maxHistory int, ttl time.Duration, keyring *encryption.Keyring) *RedisConversationStore {
+	return &RedisConversationStore{
+		client:       client,
+		codec:        conversationCodec{keyring: keyring},
+		prefix:       prefix + "conversation:",
+		activePrefix: prefix + "active_session:",
+		dataPrefix:   prefix + "chat_data:",
+		maxHistory:   maxHistory,
+		ttl:          ttl,
+	}
+}
+
+// Get implements ConversationStore
+func (s *RedisConversationStore) Get(key string) (*Conversation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	return s.load(ctx, s.client, key)
+}
+
+// Append implements ConversationStore
+func (s *RedisConversationStore) Append(key string, messages ...Message) error {
+	return s.update(key, nil, messages)
+}
+
+// Commit implements ConversationStore
+func (s *RedisConversationStore) Commit(key string, conversationID string, messages ...Message) error {
+	return s.update(key, &conversationID, messages)
+}
+
+// update appends messages, checking the conversation ID first if one is given
+func (s *RedisConversationStore) update(key string, conversationID *string, messages []Message) error {
+	return s.modify(key, func(conv *Conversation) (*Conversation, error) {
+		if conversationID != nil && currentID(conv) != *conversationID {
+			return nil, ErrConversationChanged
+		}
+		if conv == nil {
+			conv = &Conversation{ID: newConversationID()}
+		}
+		conv.Messages = trimHistory(append(conv.Messages, messages...), s.maxHistory)
+		return conv, nil
+	})
+}
+
+// Update implements ConversationStore
+func (s *RedisConversationStore) Update(key string, update func(conv *Conversation)) error {
+	return s.modify(key, func(conv *Conversation) (*Conversation, error) {
+		if conv != nil {
+			id := conv.ID
+			update(conv)
+			conv.ID = id
+		}
+		return conv, nil
+	})
+}
+
+// modify runs a read-modify-write of a conversation in a WATCH transaction,
+// retrying if the conversation changed meanwhile. Nothing is written if change
+// returns a nil conversation.
+func (s *RedisConversationStore) modify(key string, change func(conv *Conversation) (*Conversation, error)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	for attempt := 0; attempt < redisMaxRetries; attempt++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			conv, err := s.load(ctx, tx, key)
+			if err != nil {
+				return err
+			}
+			conv, err = change(conv)
+			if err != nil || conv == nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				return s.save(ctx, pipe, key, conv)
+			})
+			return err
+		}, s.prefix+key)
+
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("conversation %s changed concurrently too often", key)
+}
+
+// Replace implements ConversationStore
+func (s *RedisConversationStore) Replace(key string, messages []Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	return s.save(ctx, s.client, key, &Conversation{ID: newConversationID(), Messages: trimHistory(messages, s.maxHistory)})
+}
+
+// Reset implements ConversationStore
+func (s *RedisConversationStore) Reset(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := s.client.Del(ctx, s.prefix+key).Err(); err != nil {
+		return fmt.Errorf("error deleting conversation: %w", err)
+	}
+	return nil
+}
+
+// ResetChat implements ConversationStore
+func (s *RedisConversationStore) ResetChat(chatKey string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	keys := []string{s.prefix + chatKey}
+	iter := s.client.Scan(ctx, 0, s.prefix+escapeGlob(chatKey+":")+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("error listing conversations: %w", err)
+	}
+
+	removed, err := s.client.Del(ctx, keys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("error deleting conversations: %w", err)
+	}
+	return int(removed), nil
+}
+
+// List implements ConversationStore
+func (s *RedisConversationStore) List(prefix string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var keys []string
+	iter := s.client.Scan(ctx, 0, s.prefix+escapeGlob(prefix)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), s.prefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("error listing conversations: %w", err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Expire implements ConversationStore. Redis removes expired conversations itself.
+func (s *RedisConversationStore) Expire() (int, error) {
+	return 0, nil
+}
+
+// expiresItself implements selfExpiring; Redis removes conversations at their TTL
+func (s *RedisConversationStore) expiresItself() {}
+
+// escapeGlob escapes the characters that are special in a SCAN pattern
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]^\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ActiveSession implements ConversationStore
+func (s *RedisConversationStore) ActiveSession(chatKey string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	sessionID, err := s.client.Get(ctx, s.activePrefix+chatKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading active session: %w", err)
+	}
+	return sessionID, nil
+}
+
+// SetActiveSession implements ConversationStore. The selection does not expire
+// so that it outlives idle periods of the chat.
+func (s *RedisConversationStore) SetActiveSession(chatKey string, sessionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var err error
+	if sessionID == "" {
+		err = s.client.Del(ctx, s.activePrefix+chatKey).Err()
+	} else {
+		err = s.client.Set(ctx, s.activePrefix+chatKey, sessionID, 0).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("error writing active session: %w", err)
+	}
+	return nil
+}
+
+// ChatData implements ConversationStore
+func (s *RedisConversationStore) ChatData(kind, chatKey string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	key := chatDataKey(kind, chatKey)
+	data, err := s.client.Get(ctx, s.dataPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", kind, err)
+	}
+	return s.codec.openRecord(key, data)
+}
+
+// SetChatData implements ConversationStore
+func (s *RedisConversationStore) SetChatData(kind, chatKey string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	key := chatDataKey(kind, chatKey)
+	var err error
+	if data == nil {
+		err = s.client.Del(ctx, s.dataPrefix+key).Err()
+	} else {
+		var sealed []byte
+		if sealed, err = s.codec.sealRecord(key, data); err != nil {
+			return err
+		}
+		err = s.client.Set(ctx, s.dataPrefix+key, sealed, 0).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", kind, err)
+	}
+	return nil
+}
+
+// Reencrypt implements Reencrypter. Each record is rewritten in its own
+// transaction and keeps its expiry, so the bot can keep running meanwhile.
+func (s *RedisConversationStore) Reencrypt() (int, error) {
+	if s.codec.keyring == nil {
+		return 0, fmt.Errorf("no encryption keys are configured")
+	}
+	keys, err := s.List("")
+	if err != nil {
+		return 0, err
+	}
+	dataKeys, err := s.chatDataKeys()
+	if err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	for _, key := range keys {
+		changed, err := s.reencrypt(s.prefix, key, s.codec.current, s.codec.reencode)
+		if err != nil {
+			return rewritten, err
+		}
+		if changed {
+			rewritten++
+		}
+	}
+	for _, key := range dataKeys {
+		changed, err := s.reencrypt(s.dataPrefix, key, s.codec.recordCurrent, s.codec.resealRecord)
+		if err != nil {
+			return rewritten, err
+		}
+		if changed {
+			rewritten++
+		}
+	}
+	return rewritten, nil
+}
+
+// chatDataKeys returns the keys of all per-chat records
+func (s *RedisConversationStore) chatDataKeys() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var keys []string
+	iter := s.client.Scan(ctx, 0, s.dataPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), s.dataPrefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("error listing chat data: %w", err)
+	}
+	return keys, nil
+}
+
+// reencrypt rewrites the record prefix+key with the active key and reports
+// whether it had to
+func (s *RedisConversationStore) reencrypt(prefix, key string, current func([]byte) bool, reencode func(string, []byte) ([]byte, error)) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	for attempt := 0; attempt < redisMaxRetries; attempt++ {
+		changed := false
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			data, err := tx.Get(ctx, prefix+key).Bytes()
+			if errors.Is(err, redis.Nil) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", key, err)
+			}
+			if current(data) {
+				return nil
+			}
+			data, err = reencode(key, data)
+			if err != nil {
+				return err
+			}
+
+			changed = true
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				return pipe.Set(ctx, prefix+key, data, redis.KeepTTL).Err()
+			})
+			return err
+		}, prefix+key)
+
+		if !errors.Is(err, redis.TxFailedErr) {
+			return changed, err
+		}
+	}
+	return false, fmt.Errorf("record %s changed concurrently too often", key)
+}
+
+// Close implements ConversationStore. The Redis client is owned by the caller.
+func (s *RedisConversationStore) Close() error {
+	return nil
+}
+
+// load reads a conversation, returning nil if it does not exist
+func (s *RedisConversationStore) load(ctx context.Context, client redis.Cmdable, key string) (*Conversation, error) {
+	data, err := client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading conversation: %w", err)
+	}
+	return s.codec.decode(key, data)
+}
+
+// save writes a conversation with a fresh expiry
+func (s *RedisConversationStore) save(ctx context.Context, client redis.Cmdable, key string, conv *Conversation) error {
+	conv.LastUpdate = time.Now()
+	if conv.Started.IsZero() {
+		conv.Started = conv.LastUpdate
+	}
+	data, err := s.codec.encode(key, conv)
+	if err != nil {
+		return err
+	}
+	if err := client.Set(ctx, s.prefix+key, data, s.ttl).Err(); err != nil {
+		return fmt.Errorf("error writing conversation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,541 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/encryption"
+	"github.com/redis/go-redis/v9"
+	"go.etcd.io/bbolt"
+)
+
+// testKeyring returns a keyring with a single test key
+func testKeyring(t *testing.T, id string, fill byte) *encryption.Keyring {
+	t.Helper()
+	keyring, err := encryption.NewKeyring(map[string][]byte{id: bytes.Repeat([]byte{fill}, encryption.KeySize)}, id)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	return keyring
+}
+
+// testStores returns every ConversationStore implementation, the persistent
+// ones with and without encryption, together with a function that moves its clock
+func testStores(t *testing.T, maxHistory int, ttl time.Duration) map[string]struct {
+	store   ConversationStore
+	advance func(time.Duration)
+} {
+	t.Helper()
+
+	now := time.Now()
+	memory := NewMemoryConversationStore(maxHistory, ttl)
+	memory.now = func() time.Time { return now }
+
+	stores := map[string]struct {
+		store   ConversationStore
+		advance func(time.Duration)
+	}{
+		"memory": {memory, func(d time.Duration) { now = now.Add(d) }},
+	}
+
+	for name, keyring := range map[string]*encryption.Keyring{"": nil, "-encrypted": testKeyring(t, "test", 1)} {
+		bolt, err := NewBoltConversationStore(filepath.Join(t.TempDir(), "conversations.db"), maxHistory, ttl, keyring)
+		if err != nil {
+			t.Fatalf("NewBoltConversationStore() error = %v", err)
+		}
+		boltNow := now
+		bolt.now = func() time.Time { return boltNow }
+		t.Cleanup(func() { bolt.Close() })
+		stores["bolt"+name] = struct {
+			store   ConversationStore
+			advance func(time.Duration)
+		}{bolt, func(d time.Duration) { boltNow = boltNow.Add(d) }}
+
+		// miniredis only moves its clock for key expiry
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		stores["redis"+name] = struct {
+			store   ConversationStore
+			advance func(time.Duration)
+		}{NewRedisConversationStore(client, "test:", maxHistory, ttl, keyring), server.FastForward}
+	}
+	return stores
+}
+
+func TestConversationStores(t *testing.T) {
+	for name, tt := range testStores(t, 3, time.Hour) {
+		t.Run(name, func(t *testing.T) {
+			store := tt.store
+
+			if conv, err := store.Get("1"); err != nil || conv != nil {
+				t.Fatalf("Get() on empty store = %v, %v, expected nil", conv, err)
+			}
+
+			for _, content := range []string{"a", "b", "c", "d"} {
+				if err := store.Append("1", Message{Role: "user", Content: content}); err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+			}
+			if err := store.Append("2", Message{Role: "user", Content: "x"}); err != nil {
+				t.Fatalf("Append() error = %v", err)
+			}
+
+			conv, err := store.Get("1")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if contents := messageContents(conv.Messages); !reflect.DeepEqual(contents, []string{"b", "c", "d"}) {
+				t.Errorf("Get() messages = %v, expected the newest 3", contents)
+			}
+
+			// Modifying the returned copy must not change the store
+			conv.Messages[0].Content = "changed"
+			if again, _ := store.Get("1"); again.Messages[0].Content != "b" {
+				t.Errorf("Get() returned a shared message slice")
+			}
+
+			if err := store.Replace("1", []Message{{Role: "user", Content: "z"}}); err != nil {
+				t.Fatalf("Replace() error = %v", err)
+			}
+			if conv, _ := store.Get("1"); len(conv.Messages) != 1 || conv.Messages[0].Content != "z" {
+				t.Errorf("Get() after Replace() = %+v", conv)
+			}
+
+			if keys, _ := store.List(""); !reflect.DeepEqual(keys, []string{"1", "2"}) {
+				t.Errorf("List() = %v, expected [1 2]", keys)
+			}
+
+			if err := store.Reset("2"); err != nil {
+				t.Fatalf("Reset() error = %v", err)
+			}
+			if conv, _ := store.Get("2"); conv != nil {
+				t.Errorf("Get() after Reset() = %+v, expected nil", conv)
+			}
+		})
+	}
+}
+
+func TestConversationStoresExpire(t *testing.T) {
+	for name, tt := range testStores(t, 10, time.Hour) {
+		t.Run(name, func(t *testing.T) {
+			store := tt.store
+
+			store.Append("old", Message{Role: "user", Content: "old"})
+			tt.advance(50 * time.Minute)
+			store.Append("new", Message{Role: "user", Content: "new"})
+			tt.advance(20 * time.Minute)
+
+			// Expired conversations are invisible before they are removed
+			if conv, _ := store.Get("old"); conv != nil {
+				t.Errorf("Get() of expired conversation = %+v, expected nil", conv)
+			}
+			if keys, _ := store.List(""); !reflect.DeepEqual(keys, []string{"new"}) {
+				t.Errorf("List() = %v, expected [new]", keys)
+			}
+
+			// Redis expires keys itself, the other stores remove them here
+			if _, err := store.Expire(); err != nil {
+				t.Errorf("Expire() error = %v", err)
+			}
+			if keys, _ := store.List(""); !reflect.DeepEqual(keys, []string{"new"}) {
+				t.Errorf("List() after Expire() = %v, expected [new]", keys)
+			}
+
+			// Appending to an expired conversation starts a new one
+			tt.advance(2 * time.Hour)
+			store.Append("new", Message{Role: "user", Content: "again"})
+			if conv, _ := store.Get("new"); len(conv.Messages) != 1 || conv.Messages[0].Content != "again" {
+				t.Errorf("Get() after expiry = %+v, expected only the new message", conv)
+			}
+		})
+	}
+}
+
+func TestConversationStoresResetChat(t *testing.T) {
+	for name, tt := range testStores(t, 10, time.Hour) {
+		t.Run(name, func(t *testing.T) {
+			store := tt.store
+
+			store.Append("1:old", Message{Role: "user", Content: "old"})
+			tt.advance(2 * time.Hour)
+			for _, key := range []string{"1", "1:new", "12", "12:other"} {
+				store.Append(key, Message{Role: "user", Content: key})
+			}
+
+			if _, err := store.ResetChat("1"); err != nil {
+				t.Fatalf("ResetChat() error = %v", err)
+			}
+
+			// Turning the clock back shows whether the expired session is gone too
+			tt.advance(-2 * time.Hour)
+			if keys, _ := store.List(""); !reflect.DeepEqual(keys, []string{"12", "12:other"}) {
+				t.Errorf("List() after ResetChat() = %v, expected only the other chat", keys)
+			}
+		})
+	}
+}
+
+func TestBoltConversationStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conversations.db")
+
+	store, err := NewBoltConversationStore(path, 10, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("NewBoltConversationStore() error = %v", err)
+	}
+	store.Append("1", Message{Role: "user", Content: "hello"}, Message{Role: "assistant", Content: "hi"})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	reopened, err := NewBoltConversationStore(path, 10, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("NewBoltConversationStore() error = %v", err)
+	}
+	defer reopened.Close()
+
+	conv, err := reopened.Get("1")
+	if err != nil || conv == nil {
+		t.Fatalf("Get() after reopening = %v, %v", conv, err)
+	}
+	if contents := messageContents(conv.Messages); !reflect.DeepEqual(contents, []string{"hello", "hi"}) {
+		t.Errorf("Get() after reopening = %v, expected [hello hi]", contents)
+	}
+}
+
+// messageContents returns the contents of messages
+func messageContents(messages []Message) []string {
+	contents := make([]string, len(messages))
+	for i, msg := range messages {
+		contents[i] = msg.Content
+	}
+	return contents
+}
+
+func TestConversationStoresCommit(t *testing.T) {
+	for name, tt := range testStores(t, 10, time.Hour) {
+		t.Run(name, func(t *testing.T) {
+			store := tt.store
+
+			// A turn that began without a conversation starts one
+			if err := store.Commit("1", "", Message{Role: "user", Content: "a"}, Message{Role: "assistant", Content: "b"}); err != nil {
+				t.Fatalf("Commit() error = %v", err)
+			}
+			conv, _ := store.Get("1")
+			if conv.ID == "" || len(conv.Messages) != 2 {
+				t.Fatalf("Get() after Commit() = %+v", conv)
+			}
+			if err := store.Commit("1", "", Message{Role: "user", Content: "c"}); !errors.Is(err, ErrConversationChanged) {
+				t.Errorf("Commit() with a stale empty ID error = %v, expected ErrConversationChanged", err)
+			}
+
+			// Appending keeps the conversation ID
+			store.Append("1", Message{Role: "user", Content: "c"})
+			if again, _ := store.Get("1"); again.ID != conv.ID {
+				t.Errorf("Append() changed the ID from %s to %s", conv.ID, again.ID)
+			}
+			if err := store.Commit("1", conv.ID, Message{Role: "assistant", Content: "d"}); err != nil {
+				t.Errorf("Commit() error = %v", err)
+			}
+
+			// Reset and Replace start new generations
+			store.Reset("1")
+			if err := store.Commit("1", conv.ID, Message{Role: "user", Content: "e"}); !errors.Is(err, ErrConversationChanged) {
+				t.Errorf("Commit() after Reset() error = %v, expected ErrConversationChanged", err)
+			}
+			store.Replace("1", []Message{{Role: "user", Content: "f"}})
+			if err := store.Commit("1", "", Message{Role: "user", Content: "g"}); !errors.Is(err, ErrConversationChanged) {
+				t.Errorf("Commit() after Replace() error = %v, expected ErrConversationChanged", err)
+			}
+			if conv, _ := store.Get("1"); !reflect.DeepEqual(messageContents(conv.Messages), []string{"f"}) {
+				t.Errorf("Rejected commits changed the conversation: %v", messageContents(conv.Messages))
+			}
+		})
+	}
+}
+
+func TestConversationStoresMetadata(t *testing.T) {
+	for name, tt := range testStores(t, 10, time.Hour) {
+		t.Run(name, func(t *testing.T) {
+			store := tt.store
+
+			sentAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			store.Append("1",
+				Message{Role: "user", Content: "hello", Meta: &MessageMeta{Time: sentAt, TelegramMessageID: 7}},
+				Message{Role: "assistant", Content: "hi", Model: "gpt-4.1-nano", Meta: &MessageMeta{
+					Time: sentAt.Add(time.Second), PromptTokens: 12, CompletionTokens: 3, FinishReason: "stop", Latency: time.Second,
+				}},
+			)
+			before, _ := store.Get("1")
+
+			if err := store.Update("1", func(conv *Conversation) {
+				conv.Messages[1].Meta.TelegramMessageID = 8
+				conv.Summary = "earlier"
+			}); err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			conv, _ := store.Get("1")
+			if conv.Summary != "earlier" {
+				t.Errorf("Summary = %q after Update(), expected \"earlier\"", conv.Summary)
+			}
+			if conv.ID != before.ID {
+				t.Errorf("Update() changed the ID from %s to %s", before.ID, conv.ID)
+			}
+			if before.Started.IsZero() || !conv.Started.Equal(before.Started) {
+				t.Errorf("Started = %v after Update(), expected %v", conv.Started, before.Started)
+			}
+			if before.Messages[1].Meta.TelegramMessageID != 0 {
+				t.Errorf("Update() changed an earlier copy")
+			}
+
+			expected := MessageMeta{Time: sentAt.Add(time.Second), TelegramMessageID: 8, PromptTokens: 12, CompletionTokens: 3, FinishReason: "stop", Latency: time.Second}
+			answer := conv.Messages[1]
+			if answer.Model != "gpt-4.1-nano" || answer.Meta == nil || !answer.Meta.Time.Equal(expected.Time) {
+				t.Fatalf("Get() answer = %+v %+v", answer, answer.Meta)
+			}
+			answer.Meta.Time = expected.Time
+			if *answer.Meta != expected {
+				t.Errorf("Get() metadata = %+v, expected %+v", *answer.Meta, expected)
+			}
+			if meta := conv.Messages[0].Meta; meta == nil || meta.TelegramMessageID != 7 {
+				t.Errorf("Get() question metadata = %+v", meta)
+			}
+
+			// Updating a missing conversation does not create one
+			if err := store.Update("2", func(*Conversation) { t.Error("update called for a missing conversation") }); err != nil {
+				t.Errorf("Update() error = %v", err)
+			}
+			if conv, _ := store.Get("2"); conv != nil {
+				t.Errorf("Update() created conversation %+v", conv)
+			}
+		})
+	}
+}
+
+func TestConversationStoresSessions(t *testing.T) {
+	for name, tt := range testStores(t, 10, time.Hour) {
+		t.Run(name, func(t *testing.T) {
+			store := tt.store
+
+			for _, key := range []string{"12", "12:a", "12:b", "123:c"} {
+				store.Append(key, Message{Role: "user", Content: key})
+			}
+			if keys, _ := store.List("12:"); !reflect.DeepEqual(keys, []string{"12:a", "12:b"}) {
+				t.Errorf("List(12:) = %v, expected [12:a 12:b]", keys)
+			}
+
+			if sessionID, err := store.ActiveSession("12"); err != nil || sessionID != "" {
+				t.Errorf("ActiveSession() without a selection = %q, %v", sessionID, err)
+			}
+			if err := store.SetActiveSession("12", "b"); err != nil {
+				t.Fatalf("SetActiveSession() error = %v", err)
+			}
+			// The selection outlives the retention of the conversations
+			tt.advance(2 * time.Hour)
+			if sessionID, _ := store.ActiveSession("12"); sessionID != "b" {
+				t.Errorf("ActiveSession() = %q, expected b", sessionID)
+			}
+
+			if err := store.SetActiveSession("12", ""); err != nil {
+				t.Fatalf("SetActiveSession() clearing error = %v", err)
+			}
+			if sessionID, _ := store.ActiveSession("12"); sessionID != "" {
+				t.Errorf("ActiveSession() after clearing = %q, expected none", sessionID)
+			}
+		})
+	}
+}
+
+func TestConversationStoresChatData(t *testing.T) {
+	for name, tt := range testStores(t, 10, time.Hour) {
+		t.Run(name, func(t *testing.T) {
+			store := tt.store
+
+			if data, err := store.ChatData("settings", "12"); err != nil || data != nil {
+				t.Fatalf("ChatData() without a record = %q, %v", data, err)
+			}
+			if err := store.SetChatData("settings", "12", []byte(`{"persona":"secret"}`)); err != nil {
+				t.Fatalf("SetChatData() error = %v", err)
+			}
+			store.SetChatData("redactions", "12", []byte(`[]`))
+
+			// Records outlive the retention of the conversations
+			tt.advance(2 * time.Hour)
+			if data, err := store.ChatData("settings", "12"); err != nil || string(data) != `{"persona":"secret"}` {
+				t.Errorf("ChatData() = %q, %v", data, err)
+			}
+			if data, _ := store.ChatData("settings", "123"); data != nil {
+				t.Errorf("ChatData() of another chat = %q, expected none", data)
+			}
+
+			if bolt, ok := store.(*BoltConversationStore); ok && bolt.codec.keyring != nil {
+				bolt.db.View(func(tx *bbolt.Tx) error {
+					if raw := tx.Bucket(chatDataBucket).Get([]byte("settings:12")); strings.Contains(string(raw), "secret") {
+						t.Errorf("Stored record contains the plaintext: %s", raw)
+					}
+					return nil
+				})
+			}
+
+			if err := store.SetChatData("settings", "12", nil); err != nil {
+				t.Fatalf("SetChatData(nil) error = %v", err)
+			}
+			if data, _ := store.ChatData("settings", "12"); data != nil {
+				t.Errorf("ChatData() after removal = %q, expected none", data)
+			}
+			if data, _ := store.ChatData("redactions", "12"); string(data) != `[]` {
+				t.Errorf("ChatData() of another kind = %q, expected it to be kept", data)
+			}
+		})
+	}
+}
+
+func TestChatSettingsAreStored(t *testing.T) {
+	cfg := &config.Config{OpenAI: config.OpenAIConfig{APIKey: "test-key", Model: "gpt-4.1-nano"}}
+	cfg.Conversations.CarrySummary = true
+	cfg.Personas.List = []config.PersonaConfig{{Name: "pirate"}}
+
+	for name, tt := range testStores(t, 10, time.Hour) {
+		t.Run(name, func(t *testing.T) {
+			client := NewClient(cfg)
+			client.SetConversationStore(tt.store)
+			if err := client.SetSamplingParameter(1, "temperature", "0.3"); err != nil {
+				t.Fatalf("SetSamplingParameter() error = %v", err)
+			}
+			client.SetCarrySummary(1, false)
+			if err := client.SetPersona(1, "pirate"); err != nil {
+				t.Fatalf("SetPersona() error = %v", err)
+			}
+			client.settings.Update(1, func(settings *ChatSettings) error {
+				settings.KnowledgeBases, settings.KnowledgeChosen = []string{"docs"}, true
+				return nil
+			})
+
+			// A restarted bot or another replica on the same store
+			replica := NewClient(cfg)
+			replica.SetConversationStore(tt.store)
+			tt.advance(2 * time.Hour)
+			if temperature := replica.Sampling(1).Temperature; temperature == nil || *temperature != 0.3 {
+				t.Errorf("Sampling() temperature = %v, expected 0.3", temperature)
+			}
+			if replica.CarriesSummary(1) {
+				t.Error("CarriesSummary() = true, expected the opt-out to be kept")
+			}
+			if persona := replica.Persona(1); persona != "pirate" {
+				t.Errorf("Persona() = %q, expected pirate", persona)
+			}
+			if settings := replica.settings.Get(1); !settings.KnowledgeChosen || !reflect.DeepEqual(settings.KnowledgeBases, []string{"docs"}) {
+				t.Errorf("Get() knowledge bases = %v, %v", settings.KnowledgeBases, settings.KnowledgeChosen)
+			}
+			if settings := replica.settings.Get(2); !reflect.DeepEqual(settings, ChatSettings{}) {
+				t.Errorf("Get() of another chat = %+v, expected no overrides", settings)
+			}
+
+			if err := replica.settings.Reset(1); err != nil {
+				t.Fatalf("Reset() error = %v", err)
+			}
+			if settings := client.settings.Get(1); !reflect.DeepEqual(settings, ChatSettings{}) {
+				t.Errorf("Get() after Reset() = %+v, expected no overrides", settings)
+			}
+		})
+	}
+}
+
+func TestConversationStoresReencrypt(t *testing.T) {
+	oldKey, rotated := testKeyring(t, "old", 1), testKeyring(t, "new", 2)
+	both, err := encryption.NewKeyring(map[string][]byte{
+		"old": bytes.Repeat([]byte{1}, encryption.KeySize),
+		"new": bytes.Repeat([]byte{2}, encryption.KeySize),
+	}, "new")
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	// open returns a store with the given keys and a function reading its raw records
+	stores := map[string]func(keyring *encryption.Keyring) (ConversationStore, func(key string) string){
+		"bolt": func(keyring *encryption.Keyring) (ConversationStore, func(string) string) {
+			store, err := NewBoltConversationStore(filepath.Join(dir, "conversations.db"), 10, time.Hour, keyring)
+			if err != nil {
+				t.Fatalf("NewBoltConversationStore() error = %v", err)
+			}
+			return store, func(key string) string {
+				var raw string
+				store.db.View(func(tx *bbolt.Tx) error {
+					raw = string(tx.Bucket(conversationsBucket).Get([]byte(key)))
+					return nil
+				})
+				return raw
+			}
+		},
+		"redis": func(keyring *encryption.Keyring) (ConversationStore, func(string) string) {
+			return NewRedisConversationStore(client, "test:", 10, time.Hour, keyring), func(key string) string {
+				raw, _ := client.Get(context.Background(), "test:conversation:"+key).Result()
+				return raw
+			}
+		},
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			// A plaintext record from before encryption and one sealed with the old key
+			plain, _ := open(nil)
+			plain.Append("1", Message{Role: "user", Content: "plaintext secret"})
+			plain.Close()
+			sealed, raw := open(oldKey)
+			sealed.Append("2", Message{Role: "user", Content: "old secret"})
+			if strings.Contains(raw("2"), "old secret") {
+				t.Errorf("Stored record contains the plaintext: %s", raw("2"))
+			}
+			before, _ := sealed.Get("1")
+			sealed.Close()
+
+			// Without the old key the sealed record cannot be read
+			withoutOld, _ := open(rotated)
+			if _, err := withoutOld.Get("2"); err == nil {
+				t.Error("Get() without the key of the record expected an error")
+			}
+			withoutOld.Close()
+
+			store, raw := open(both)
+			defer store.Close()
+			count, err := store.(Reencrypter).Reencrypt()
+			if err != nil || count != 2 {
+				t.Fatalf("Reencrypt() = %d, %v, expected 2 records", count, err)
+			}
+			if count, _ := store.(Reencrypter).Reencrypt(); count != 0 {
+				t.Errorf("Reencrypt() again rewrote %d records", count)
+			}
+
+			for key, content := range map[string]string{"1": "plaintext secret", "2": "old secret"} {
+				if record := raw(key); strings.Contains(record, content) || !strings.Contains(record, `"key_id":"new"`) {
+					t.Errorf("Record %s after Reencrypt() = %s", key, record)
+				}
+			}
+
+			// Only the new key is needed afterwards and records keep their last update
+			store.Close()
+			reopened, _ := open(rotated)
+			defer reopened.Close()
+			conv, err := reopened.Get("1")
+			if err != nil || conv == nil || conv.Messages[0].Content != "plaintext secret" {
+				t.Fatalf("Get() after Reencrypt() = %+v, %v", conv, err)
+			}
+			if !conv.LastUpdate.Equal(before.LastUpdate) || conv.ID != before.ID {
+				t.Errorf("Reencrypt() changed the record from %+v to %+v", before, conv)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/itswryu/telegpt/pkg/config"
+)
+
+const (
+	// summaryMaxTokens bounds the length of a conversation summary
+	summaryMaxTokens = 300
+	// maxSummaryInput is the number of characters of a conversation that are summarized
+	maxSummaryInput = 12000
+)
+
+// summaryPrompt instructs the model to summarize an expired conversation
+const summaryPrompt = "Summarize the conversation below between a user and an assistant in at most five sentences. " +
+	"Keep facts about the user, decisions, open questions and anything the user may come back to. " +
+	"Write the summary in the language of the conversation and do not address the user."
+
+// summarize asks the model for a short summary of a conversation, including
+// the summary it continued. The usage of the request is added to usage.
+func (c *Client) summarize(ctx context.Context, conv *Conversation, usage map[string]Usage) (string, error) {
+	transcript := summaryTranscript(conv)
+	if transcript == "" {
+		return "", nil
+	}
+
+	maxTokens := summaryMaxTokens
+	result, ep, err := c.createChatCompletion(ctx, c.model, []Message{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: transcript},
+	}, nil, config.SamplingConfig{MaxTokens: &maxTokens})
+	if err != nil {
+		return "", err
+	}
+	if result.Usage != nil {
+		total := usage[ep.model]
+		total.add(*result.Usage)
+		usage[ep.model] = total
+	}
+
+	summary := strings.TrimSpace(result.Choices[0].Message.Content)
+	if summary == "" {
+		return "", fmt.Errorf("model %s returned an empty summary", ep.model)
+	}
+	return summary, nil
+}
+
+// summaryTranscript renders the user and assistant messages of a conversation
+// as text, keeping the newest messages if it is too long
+func summaryTranscript(conv *Conversation) string {
+	var lines []string
+	for _, msg := range conv.Messages {
+		switch {
+		case msg.Role == "user" && msg.Content != "":
+			lines = append(lines, "User: "+msg.Content)
+		case msg.Role == "assistant" && msg.Content != "":
+			lines = append(lines, "Assistant: "+msg.Content)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	transcript := strings.Join(lines, "\n\n")
+	if len(transcript) > maxSummaryInput {
+		transcript = "…" + strings.ToValidUTF8(transcript[len(transcript)-maxSummaryInput:], "")
+	}
+	if conv.Summary != "" {
+		transcript = "Earlier conversation: " + conv.Summary + "\n\n" + transcript
+	}
+	return transcript
+}
+
+// summarySection is the part of the system message that carries a summary forward
+func summarySection(summary string) string {
+	return "Summary of the user's previous conversation, for context:\n" + summary
+}
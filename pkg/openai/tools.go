@@ -0,0 +1,169 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/itswryu/telegpt/pkg/logger"
+)
+
+// Tool is a function the model can call while generating a response
+type Tool interface {
+	// Name is the function name exposed to the model
+	Name() string
+	// Description tells the model when to use the tool
+	Description() string
+	// Parameters is the JSON schema of the tool arguments
+	Parameters() json.RawMessage
+	// Execute runs the tool with the JSON arguments produced by the model
+	Execute(ctx context.Context, userID int64, arguments json.RawMessage) (string, error)
+}
+
+// ConfirmableTool is implemented by tools that may need the user's approval before they run
+type ConfirmableTool interface {
+	Tool
+	// RequiresConfirmation reports whether the tool modifies external state
+	RequiresConfirmation() bool
+}
+
+// Confirmer asks the user whether a tool call may be executed
+type Confirmer func(ctx context.Context, userID int64, tool string, arguments string) (bool, error)
+
+// ToolCall is a function call requested by the model
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall holds the name and raw JSON arguments of a requested call
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolDefinition describes a tool in a chat completion request
+type ToolDefinition struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+// FunctionDefinition describes a callable function
+type FunctionDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// RegisterTool makes a tool available to the model. Registering a tool with
+// an existing name replaces it.
+func (c *Client) RegisterTool(tool Tool) {
+	c.toolsMutex.Lock()
+	defer c.toolsMutex.Unlock()
+
+	if _, exists := c.tools[tool.Name()]; !exists {
+		c.toolOrder = append(c.toolOrder, tool.Name())
+	}
+	c.tools[tool.Name()] = tool
+}
+
+// SetConfirmer sets the function used to approve tools that require confirmation.
+// Without a confirmer such tools are never executed.
+func (c *Client) SetConfirmer(confirmer Confirmer) {
+	c.toolsMutex.Lock()
+	defer c.toolsMutex.Unlock()
+	c.confirmer = confirmer
+}
+
+// availableTools returns the tools the user may use according to the tools
+// configuration, limited to the tool set of the chat's persona if it has one
+func (c *Client) availableTools(userID int64) []Tool {
+	var allowed []string
+	if p := c.persona(userID); p != nil {
+		allowed = p.Tools
+	}
+
+	c.toolsMutex.RLock()
+	defer c.toolsMutex.RUnlock()
+
+	role := c.auth.RoleOf(userID)
+	var available []Tool
+	for _, name := range c.toolOrder {
+		if allowed != nil && !contains(allowed, name) {
+			continue
+		}
+		if c.toolsConfig.Permits(userID, role, name) {
+			available = append(available, c.tools[name])
+		}
+	}
+	return available
+}
+
+// toolDefinitions converts tools into their request representation
+func toolDefinitions(tools []Tool) []ToolDefinition {
+	definitions := make([]ToolDefinition, 0, len(tools))
+	for _, tool := range tools {
+		definitions = append(definitions, ToolDefinition{
+			Type: "function",
+			Function: FunctionDefinition{
+				Name:        tool.Name(),
+				Description: tool.Description(),
+				Parameters:  tool.Parameters(),
+			},
+		})
+	}
+	return definitions
+}
+
+// executeToolCall runs a single tool call and returns the tool message to send back.
+// Failures are reported to the model rather than aborting the response.
+func (c *Client) executeToolCall(ctx context.Context, tools []Tool, userID int64, call ToolCall) Message {
+	result := func(content string) Message {
+		return Message{Role: "tool", Content: content, ToolCallID: call.ID}
+	}
+
+	var tool Tool
+	for _, t := range tools {
+		if t.Name() == call.Function.Name {
+			tool = t
+			break
+		}
+	}
+	if tool == nil {
+		logger.Warn("Model requested unavailable tool %q", call.Function.Name)
+		return result(fmt.Sprintf("error: tool %q is not available", call.Function.Name))
+	}
+
+	arguments := json.RawMessage(call.Function.Arguments)
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+
+	if confirmable, ok := tool.(ConfirmableTool); ok && confirmable.RequiresConfirmation() {
+		c.toolsMutex.RLock()
+		confirmer := c.confirmer
+		c.toolsMutex.RUnlock()
+
+		if confirmer == nil {
+			return result("error: this tool requires user confirmation, which is not available")
+		}
+		approved, err := confirmer(ctx, userID, tool.Name(), string(arguments))
+		if err != nil {
+			logger.Warn("Confirmation of tool %s failed: %v", tool.Name(), err)
+			return result("error: the user did not confirm the tool call")
+		}
+		if !approved {
+			logger.Info("User %d declined tool %s", userID, tool.Name())
+			return result("error: the user declined to run this tool")
+		}
+	}
+
+	logger.Debug("Executing tool %s for %d with arguments %s", tool.Name(), userID, arguments)
+	output, err := tool.Execute(ctx, userID, arguments)
+	if err != nil {
+		logger.Warn("Tool %s failed: %v", tool.Name(), err)
+		return result("error: " + err.Error())
+	}
+	return result(output)
+}
@@ -0,0 +1,114 @@
+// Package privacy finds the personal data the bot holds about a user across
+// every store that registers as a data source, to export or erase it.
+package privacy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Source is a store that holds data about users
+type Source interface {
+	// Name identifies the source in exports, e.g. "conversations"
+	Name() string
+	// Export returns the data held for a user as a JSON-serializable value,
+	// or nil if there is none
+	Export(userID int64) (interface{}, error)
+	// Delete erases the data held for a user
+	Delete(userID int64) error
+}
+
+// Export is everything held for a user
+type Export struct {
+	UserID     int64                  `json:"user_id"`
+	ExportedAt time.Time              `json:"exported_at"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+// Registry is the set of data sources
+type Registry struct {
+	sources []Source
+	mutex   sync.RWMutex
+}
+
+// NewRegistry creates a registry of the given sources
+func NewRegistry(sources ...Source) *Registry {
+	r := &Registry{}
+	for _, source := range sources {
+		r.Register(source)
+	}
+	return r
+}
+
+// Register adds a data source. Nil sources are ignored so that optional
+// features can register unconditionally.
+func (r *Registry) Register(source Source) {
+	if source == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sources = append(r.sources, source)
+}
+
+// Names returns the names of the registered sources in registration order
+func (r *Registry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := make([]string, len(r.sources))
+	for i, source := range r.sources {
+		names[i] = source.Name()
+	}
+	return names
+}
+
+// Export collects the data of a user from every source. It fails if any
+// source fails, as an incomplete export would be misleading.
+func (r *Registry) Export(userID int64, now time.Time) (*Export, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	export := &Export{UserID: userID, ExportedAt: now, Data: make(map[string]interface{})}
+	for _, source := range r.sources {
+		data, err := source.Export(userID)
+		if err != nil {
+			return nil, fmt.Errorf("error exporting %s: %w", source.Name(), err)
+		}
+		if data != nil {
+			export.Data[source.Name()] = data
+		}
+	}
+	return export, nil
+}
+
+// ExportJSON returns the export of a user as indented JSON
+func (r *Registry) ExportJSON(userID int64, now time.Time) ([]byte, error) {
+	export, err := r.Export(userID, now)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding export: %w", err)
+	}
+	return data, nil
+}
+
+// Delete erases the data of a user from every source. A failing source does
+// not stop the others; the failures are joined in the returned error.
+func (r *Registry) Delete(userID int64) error {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var errs []error
+	for _, source := range r.sources {
+		if err := source.Delete(userID); err != nil {
+			errs = append(errs, fmt.Errorf("error deleting %s: %w", source.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,88 @@
+package privacy
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mapSource is a data source backed by a map
+type mapSource struct {
+	name string
+	data map[int64]string
+	err  error
+}
+
+func (s *mapSource) Name() string { return s.name }
+
+func (s *mapSource) Export(userID int64) (interface{}, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if value, ok := s.data[userID]; ok {
+		return value, nil
+	}
+	return nil, nil
+}
+
+func (s *mapSource) Delete(userID int64) error {
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.data, userID)
+	return nil
+}
+
+func TestRegistryExport(t *testing.T) {
+	notes := &mapSource{name: "notes", data: map[int64]string{1: "likes tea", 2: "likes coffee"}}
+	empty := &mapSource{name: "empty", data: map[int64]string{}}
+	registry := NewRegistry(notes, empty)
+	registry.Register(nil)
+
+	if names := registry.Names(); strings.Join(names, ",") != "notes,empty" {
+		t.Errorf("Names() = %v", names)
+	}
+
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	data, err := registry.ExportJSON(1, now)
+	if err != nil {
+		t.Fatalf("ExportJSON() error = %v", err)
+	}
+	var export Export
+	if err := json.Unmarshal(data, &export); err != nil {
+		t.Fatalf("ExportJSON() is not valid JSON: %v", err)
+	}
+	if export.UserID != 1 || !export.ExportedAt.Equal(now) || len(export.Data) != 1 || export.Data["notes"] != "likes tea" {
+		t.Errorf("ExportJSON() = %s", data)
+	}
+
+	// 불완전한 내보내기는 실패해야 함
+	registry.Register(&mapSource{name: "broken", err: errors.New("unavailable")})
+	if _, err := registry.Export(1, now); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Export() with a failing source error = %v", err)
+	}
+}
+
+func TestRegistryDelete(t *testing.T) {
+	first := &mapSource{name: "first", data: map[int64]string{1: "a", 2: "b"}}
+	broken := &mapSource{name: "broken", err: errors.New("unavailable")}
+	last := &mapSource{name: "last", data: map[int64]string{1: "c"}}
+	registry := NewRegistry(first, broken, last)
+
+	// 실패한 저장소가 있어도 나머지는 모두 지워야 함
+	err := registry.Delete(1)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Delete() error = %v, expected the failing source", err)
+	}
+	if _, ok := first.data[1]; ok {
+		t.Error("Delete() kept the data of the first source")
+	}
+	if _, ok := last.data[1]; ok {
+		t.Error("Delete() stopped at the failing source")
+	}
+	if first.data[2] != "b" {
+		t.Error("Delete() removed the data of another user")
+	}
+}
@@ -0,0 +1,75 @@
+// Package prompt renders system prompts written as text/template with the
+// details of the request they are sent with
+package prompt
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Data holds the variables a system prompt can use, such as {{.FirstName}}
+type Data struct {
+	// Now is the time of the request in the user's timezone
+	Now time.Time
+	// Date is Now as 2006-01-02, Time as 15:04 and Weekday as Monday
+	Date     string
+	Time     string
+	Weekday  string
+	Timezone string
+	// FirstName and Language are those of the sender; Language is an IETF tag such as "ko"
+	FirstName string
+	Language  string
+	// ChatTitle is empty in private chats; ChatType is private, group, supergroup or channel
+	ChatTitle   string
+	ChatType    string
+	BotUsername string
+	// Vars are the custom variables of the configuration, used as {{.Vars.team}}
+	Vars map[string]string
+}
+
+// NewData fills in the date and time variables for now in loc
+func NewData(now time.Time, loc *time.Location) Data {
+	now = now.In(loc)
+	return Data{
+		Now:      now,
+		Date:     now.Format("2006-01-02"),
+		Time:     now.Format("15:04"),
+		Weekday:  now.Weekday().String(),
+		Timezone: loc.String(),
+	}
+}
+
+// Template is a parsed system prompt
+type Template struct {
+	tmpl *template.Template
+	vars map[string]string
+}
+
+// Parse parses a system prompt and renders it once with empty details, so that
+// unknown variables and functions are reported before any request is made
+func Parse(name, text string, vars map[string]string) (*Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	t := &Template{tmpl: tmpl, vars: vars}
+	if _, err := t.Render(NewData(time.Now(), time.UTC)); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Render renders the prompt with data and the custom variables
+func (t *Template) Render(data Data) (string, error) {
+	data.Vars = t.vars
+	if data.Vars == nil {
+		data.Vars = map[string]string{}
+	}
+	var sb strings.Builder
+	if err := t.tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("error rendering system prompt: %w", err)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,50 @@
+package prompt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	tmpl, err := Parse("system_prompt",
+		`{{.Date}} {{.Time}} ({{.Weekday}}, {{.Timezone}}) {{if .FirstName}}Hi {{.FirstName}}{{end}} [{{.Language}}] `+
+			`{{.ChatType}}{{with .ChatTitle}} "{{.}}"{{end}} @{{.BotUsername}} {{.Vars.team}}`,
+		map[string]string{"team": "Platform"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	data := NewData(time.Date(2024, 5, 17, 23, 30, 0, 0, time.UTC), seoul)
+	data.FirstName, data.Language = "Jimin", "ko"
+	data.ChatType, data.ChatTitle = "group", "Ops"
+	data.BotUsername = "telegpt_bot"
+
+	got, err := tmpl.Render(data)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	expected := `2024-05-18 08:30 (Saturday, Asia/Seoul) Hi Jimin [ko] group "Ops" @telegpt_bot Platform`
+	if got != expected {
+		t.Errorf("Render() = %q, expected %q (사용자 시간대 기준으로 날짜를 계산해야 함)", got, expected)
+	}
+}
+
+func TestParseRejectsInvalidTemplates(t *testing.T) {
+	for _, text := range []string{
+		"Hello {{.FirstName",
+		"Hello {{.Nickname}}",
+		"Team {{.Vars.team}}",
+		"{{upper .FirstName}}",
+	} {
+		if _, err := Parse("system_prompt", text, nil); err == nil {
+			t.Errorf("Parse(%q) expected an error", text)
+		}
+	}
+	if _, err := Parse("system_prompt", "Plain prompt", nil); err != nil {
+		t.Errorf("Parse() error = %v, 템플릿 문법이 없는 프롬프트도 허용해야 함", err)
+	}
+}
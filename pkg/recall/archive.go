@@ -0,0 +1,247 @@
+// Package recall archives the exchanges of each user in a vector index so that
+// past conversations can be searched by meaning
+package recall
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/encryption"
+	"github.com/itswryu/telegpt/pkg/privacy"
+	"github.com/itswryu/telegpt/pkg/vector"
+)
+
+// maxEmbeddedText is the number of bytes of an exchange that are embedded
+const maxEmbeddedText = 8000
+
+// Exchange is a question of a user together with the answer it got
+type Exchange struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"session_id"`
+	Question  string    `json:"question"`
+	Answer    string    `json:"answer"`
+	Time      time.Time `json:"time"`
+}
+
+// Text is the text an exchange is embedded from
+func (e Exchange) Text() string {
+	text := "User: " + e.Question + "\nAssistant: " + e.Answer
+	if len(text) > maxEmbeddedText {
+		text = strings.ToValidUTF8(text[:maxEmbeddedText], "")
+	}
+	return text
+}
+
+// Match is an exchange found by a search
+type Match struct {
+	Exchange
+	// Score is the cosine similarity to the query
+	Score float64
+}
+
+// Archive keeps a vector index of exchanges per user. Indexes are opened on
+// first use and stay in memory.
+type Archive struct {
+	// dir holds one index file per user; empty keeps the indexes in memory only
+	dir          string
+	keyring      *encryption.Keyring
+	maxExchanges int
+	indexes      map[int64]*vector.Index
+	mutex        sync.Mutex
+}
+
+// NewArchive creates an archive in the configured directory. With encryption
+// keys the index files are encrypted like stored conversations.
+func NewArchive(cfg *config.RecallConfig, keys *config.EncryptionConfig) (*Archive, error) {
+	archive := &Archive{maxExchanges: cfg.MaxExchanges, indexes: make(map[int64]*vector.Index)}
+	if archive.maxExchanges == 0 {
+		archive.maxExchanges = config.DefaultRecallExchanges
+	}
+	if cfg.Store != "memory" {
+		archive.dir = cfg.Path
+	}
+	if keys != nil && keys.Enabled() {
+		keyring, err := encryption.NewKeyring(keys.Keys, keys.ActiveKey)
+		if err != nil {
+			return nil, err
+		}
+		archive.keyring = keyring
+	}
+	return archive, nil
+}
+
+// index returns the index of a user, opening it if needed
+func (a *Archive) index(userID int64) (*vector.Index, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if index, ok := a.indexes[userID]; ok {
+		return index, nil
+	}
+	path := ""
+	if a.dir != "" {
+		path = filepath.Join(a.dir, strconv.FormatInt(userID, 10)+".idx")
+	}
+	index, err := vector.Open(path, a.keyring)
+	if err != nil {
+		return nil, fmt.Errorf("error opening recall index of %d: %w", userID, err)
+	}
+	a.indexes[userID] = index
+	return index, nil
+}
+
+// Add archives an exchange of a user with its embedding. Once a user has the
+// maximum number of exchanges the oldest ones are dropped.
+func (a *Archive) Add(userID int64, exchange Exchange, embedding []float32) error {
+	if exchange.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+		exchange.ID = id
+	}
+	index, err := a.index(userID)
+	if err != nil {
+		return err
+	}
+
+	err = index.Upsert(vector.Item{
+		ID:     exchange.ID,
+		Vector: embedding,
+		Meta:   map[string]string{"session": exchange.SessionID, "question": exchange.Question, "answer": exchange.Answer},
+		Time:   exchange.Time,
+	})
+	if err != nil {
+		return err
+	}
+
+	if excess := index.Len() - a.maxExchanges; excess > 0 {
+		oldest := make(map[string]bool, excess)
+		for _, item := range index.Items(nil)[:excess] {
+			oldest[item.ID] = true
+		}
+		_, err = index.Delete(func(item vector.Item) bool { return oldest[item.ID] })
+	}
+	return err
+}
+
+// Search returns at most k exchanges of a user similar to the query embedding
+// that score at least minScore, best first. Exchanges of the session
+// excludeSession are skipped because they are already in the context.
+func (a *Archive) Search(userID int64, query []float32, k int, minScore float64, excludeSession string) ([]Match, error) {
+	index, err := a.index(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter func(vector.Item) bool
+	if excludeSession != "" {
+		filter = func(item vector.Item) bool { return item.Meta["session"] != excludeSession }
+	}
+	var matches []Match
+	for _, result := range index.Search(query, k, filter) {
+		if result.Score < minScore {
+			break
+		}
+		matches = append(matches, Match{Exchange: exchangeOf(result.Item), Score: result.Score})
+	}
+	return matches, nil
+}
+
+// Exchanges returns the archived exchanges of a user, oldest first
+func (a *Archive) Exchanges(userID int64) ([]Exchange, error) {
+	index, err := a.index(userID)
+	if err != nil {
+		return nil, err
+	}
+	items := index.Items(nil)
+	exchanges := make([]Exchange, len(items))
+	for i, item := range items {
+		exchanges[i] = exchangeOf(item)
+	}
+	return exchanges, nil
+}
+
+// DeleteSession removes the exchanges of a session and returns how many were removed
+func (a *Archive) DeleteSession(userID int64, sessionID string) (int, error) {
+	index, err := a.index(userID)
+	if err != nil {
+		return 0, err
+	}
+	return index.Delete(func(item vector.Item) bool { return item.Meta["session"] == sessionID })
+}
+
+// DeleteUser removes every exchange of a user and returns how many were removed
+func (a *Archive) DeleteUser(userID int64) (int, error) {
+	index, err := a.index(userID)
+	if err != nil {
+		return 0, err
+	}
+	removed := index.Len()
+	if err := index.Remove(); err != nil {
+		return 0, err
+	}
+
+	a.mutex.Lock()
+	delete(a.indexes, userID)
+	a.mutex.Unlock()
+	return removed, nil
+}
+
+// DataSource exposes the archived exchanges of users for privacy exports and erasure
+func (a *Archive) DataSource() privacy.Source {
+	return archiveSource{a}
+}
+
+// archiveSource implements privacy.Source for an archive
+type archiveSource struct {
+	archive *Archive
+}
+
+// Name implements privacy.Source
+func (archiveSource) Name() string {
+	return "recall"
+}
+
+// Export implements privacy.Source. Embeddings are left out because they only
+// restate the text.
+func (s archiveSource) Export(userID int64) (interface{}, error) {
+	exchanges, err := s.archive.Exchanges(userID)
+	if err != nil || len(exchanges) == 0 {
+		return nil, err
+	}
+	return exchanges, nil
+}
+
+// Delete implements privacy.Source
+func (s archiveSource) Delete(userID int64) error {
+	_, err := s.archive.DeleteUser(userID)
+	return err
+}
+
+// exchangeOf restores the exchange an index item was made from
+func exchangeOf(item vector.Item) Exchange {
+	return Exchange{
+		ID:        item.ID,
+		SessionID: item.Meta["session"],
+		Question:  item.Meta["question"],
+		Answer:    item.Meta["answer"],
+		Time:      item.Time,
+	}
+}
+
+// newID returns a random exchange ID
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating exchange ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,100 @@
+package recall
+
+import (
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/itswryu/telegpt/pkg/config"
+)
+
+func newTestArchive(t *testing.T, cfg config.RecallConfig) *Archive {
+	t.Helper()
+	archive, err := NewArchive(&cfg, nil)
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+	return archive
+}
+
+func TestArchiveSearch(t *testing.T) {
+	archive := newTestArchive(t, config.RecallConfig{Store: "memory"})
+	at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	archive.Add(1, Exchange{SessionID: "s1", Question: "trip to Busan", Answer: "KTX", Time: at}, []float32{1, 0})
+	archive.Add(1, Exchange{SessionID: "s1", Question: "cat food", Answer: "fish", Time: at}, []float32{0, 1})
+	archive.Add(1, Exchange{SessionID: "s2", Question: "train times", Answer: "hourly", Time: at}, []float32{1, 0.2})
+	archive.Add(2, Exchange{SessionID: "s3", Question: "other user", Answer: "-", Time: at}, []float32{1, 0})
+
+	matches, err := archive.Search(1, []float32{1, 0}, 5, 0.5, "")
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(matches) != 2 || matches[0].Question != "trip to Busan" || matches[1].Question != "train times" {
+		t.Fatalf("Search() = %+v, 점수가 낮은 항목과 다른 사용자는 제외되어야 함", matches)
+	}
+	if matches[0].SessionID != "s1" || !matches[0].Time.Equal(at) || matches[0].ID == "" {
+		t.Errorf("Search() 교환 복원 = %+v", matches[0].Exchange)
+	}
+
+	matches, _ = archive.Search(1, []float32{1, 0}, 5, 0.5, "s1")
+	if len(matches) != 1 || matches[0].SessionID != "s2" {
+		t.Errorf("Search() excluding s1 = %+v", matches)
+	}
+}
+
+func TestArchiveTrimsOldest(t *testing.T) {
+	archive := newTestArchive(t, config.RecallConfig{Store: "file", Path: t.TempDir(), MaxExchanges: 2})
+	for _, question := range []string{"one", "two", "three"} {
+		if err := archive.Add(1, Exchange{Question: question}, []float32{1}); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	exchanges, _ := archive.Exchanges(1)
+	if len(exchanges) != 2 || exchanges[0].Question != "two" || exchanges[1].Question != "three" {
+		t.Errorf("Exchanges() = %+v, 가장 오래된 교환이 삭제되어야 함", exchanges)
+	}
+}
+
+func TestArchiveDelete(t *testing.T) {
+	dir := t.TempDir()
+	archive := newTestArchive(t, config.RecallConfig{Store: "file", Path: dir})
+	archive.Add(1, Exchange{SessionID: "s1", Question: "a"}, []float32{1})
+	archive.Add(1, Exchange{SessionID: "s1", Question: "b"}, []float32{1})
+	archive.Add(1, Exchange{SessionID: "s2", Question: "c"}, []float32{1})
+
+	if removed, err := archive.DeleteSession(1, "s1"); err != nil || removed != 2 {
+		t.Errorf("DeleteSession() = %d, %v", removed, err)
+	}
+
+	// 파일에 저장된 내용이 새 아카이브에서도 보임
+	reopened := newTestArchive(t, config.RecallConfig{Store: "file", Path: dir})
+	exchanges, _ := reopened.Exchanges(1)
+	if len(exchanges) != 1 || exchanges[0].Question != "c" {
+		t.Fatalf("다시 연 아카이브 = %+v", exchanges)
+	}
+
+	source := reopened.DataSource()
+	if exported, err := source.Export(1); err != nil || len(exported.([]Exchange)) != 1 {
+		t.Errorf("Export() = %v, %v", exported, err)
+	}
+	if err := source.Delete(1); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if exported, err := source.Export(1); err != nil || exported != nil {
+		t.Errorf("Export() after Delete() = %v, %v", exported, err)
+	}
+}
+
+func TestExchangeText(t *testing.T) {
+	long := make([]rune, maxEmbeddedText)
+	for i := range long {
+		long[i] = '가'
+	}
+	text := Exchange{Question: string(long), Answer: "x"}.Text()
+	if len(text) > maxEmbeddedText {
+		t.Errorf("Text() = %d바이트, 최대 %d바이트여야 함", len(text), maxEmbeddedText)
+	}
+	if !utf8.ValidString(text) {
+		t.Error("Text()가 UTF-8 문자 중간에서 잘림")
+	}
+}
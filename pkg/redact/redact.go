@@ -0,0 +1,384 @@
+// Package redact replaces personal data and secrets in text with placeholders
+// and puts the original values back where the placeholders are repeated
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/privacy"
+)
+
+// maxValues bounds the original values kept per chat for restoring answers
+const maxValues = 1000
+
+// placeholderRegex finds placeholders in text
+var placeholderRegex = regexp.MustCompile(`\[[A-Z0-9_]+_[0-9a-f]{8}\]`)
+
+// detector finds one kind of sensitive value
+type detector struct {
+	// label names the placeholders of the detector, such as EMAIL
+	label string
+	re    *regexp.Regexp
+	// valid rejects matches that only look like the value, if set
+	valid func(string) bool
+	// numeric detectors skip matches that are part of a longer number or code
+	numeric bool
+}
+
+// builtins are the detectors of config.RedactionDetectors
+var builtins = map[string]detector{
+	"private_key": {label: "PRIVATE_KEY", re: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]+?-----END [A-Z ]*PRIVATE KEY-----`)},
+	"aws":         {label: "AWS_KEY", re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b|(?i:aws_?secret_?access_?key)\W{1,4}([A-Za-z0-9/+=]{40})\b`)},
+	"github":      {label: "GITHUB_TOKEN", re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`)},
+	"openai":      {label: "API_KEY", re: regexp.MustCompile(`\bsk-(?:proj-)?[A-Za-z0-9_-]{20,}`)},
+	"slack":       {label: "SLACK_TOKEN", re: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	"email":       {label: "EMAIL", re: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)},
+	"iban": {label: "IBAN", re: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`),
+		valid: validIBAN},
+	"card": {label: "CARD", re: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: validCard, numeric: true},
+	"phone": {label: "PHONE", re: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{1,4}\)[ .-]?)?\d{2,4}(?:[ .-]\d{2,4}){1,4}\b|\+\d{8,15}\b`),
+		valid: validPhone, numeric: true},
+}
+
+// builtinOrder is the order in which the built-in detectors run. Secrets come
+// first so that the digits inside them are not taken for phone or card numbers.
+var builtinOrder = []string{"private_key", "aws", "github", "openai", "slack", "email", "iban", "card", "phone"}
+
+// Store keeps the values remembered for each chat, so that answers can still
+// be restored after a restart or by another replica. Nil data removes them.
+type Store interface {
+	LoadValues(chatID int64) ([]byte, error)
+	SaveValues(chatID int64, data []byte) error
+}
+
+// memoryStore keeps the values in process memory
+type memoryStore map[int64][]byte
+
+// LoadValues implements Store
+func (s memoryStore) LoadValues(chatID int64) ([]byte, error) {
+	return s[chatID], nil
+}
+
+// SaveValues implements Store
+func (s memoryStore) SaveValues(chatID int64, data []byte) error {
+	if data == nil {
+		delete(s, chatID)
+	} else {
+		s[chatID] = data
+	}
+	return nil
+}
+
+// remembered is a value remembered for a chat and its placeholder
+type remembered struct {
+	Placeholder string `json:"placeholder"`
+	Value       string `json:"value"`
+}
+
+// Redactor replaces sensitive values with placeholders that stay the same for
+// a value as long as the salt does, and remembers per chat what they stand for
+type Redactor struct {
+	detectors []detector
+	restore   bool
+	// salt keys the placeholder hashes so that they reveal nothing about the values
+	salt  []byte
+	store Store
+	mutex sync.Mutex
+}
+
+// New creates a redactor with the configured detectors. The configuration is
+// expected to be validated. Without a configured salt a random one is used,
+// so placeholders change on every start.
+func New(cfg *config.RedactionConfig) (*Redactor, error) {
+	salt := []byte(cfg.Salt)
+	if len(salt) == 0 {
+		salt = make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("error generating redaction salt: %w", err)
+		}
+	}
+	r := &Redactor{restore: cfg.Restore, salt: salt, store: memoryStore{}}
+
+	enabled := cfg.Detectors
+	if len(enabled) == 0 {
+		enabled = config.RedactionDetectors
+	}
+	for _, name := range builtinOrder {
+		for _, e := range enabled {
+			if e == name {
+				r.detectors = append(r.detectors, builtins[name])
+			}
+		}
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern.Name, err)
+		}
+		r.detectors = append(r.detectors, detector{label: strings.ToUpper(pattern.Name), re: re})
+	}
+	return r, nil
+}
+
+// SetStore keeps the remembered values in store instead of process memory
+func (r *Redactor) SetStore(store Store) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.store = store
+}
+
+// Redact replaces the sensitive values in text and remembers them for the chat
+// if answers are restored. Once a chat has maxValues values the ones it used
+// least recently are forgotten.
+func (r *Redactor) Redact(chatID int64, text string) string {
+	if !r.restore {
+		return r.replace(text, nil)
+	}
+	var found []remembered
+	redacted := r.replace(text, &found)
+	if len(found) == 0 {
+		return redacted
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	values, err := r.load(chatID)
+	if err != nil {
+		logger.Warn("Error loading redacted values of chat %d: %v", chatID, err)
+		return redacted
+	}
+	for _, v := range found {
+		for i := range values {
+			if values[i].Placeholder == v.Placeholder {
+				values = append(values[:i], values[i+1:]...)
+				break
+			}
+		}
+		values = append(values, v)
+	}
+	if len(values) > maxValues {
+		values = values[len(values)-maxValues:]
+	}
+	if err := r.save(chatID, values); err != nil {
+		logger.Warn("Error saving redacted values of chat %d: %v", chatID, err)
+	}
+	return redacted
+}
+
+// Mask replaces the sensitive values in text without remembering them, for logs
+func (r *Redactor) Mask(text string) string {
+	return r.replace(text, nil)
+}
+
+// Restore puts the values a chat sent back in place of their placeholders. It
+// leaves text unchanged if restoring is disabled.
+func (r *Redactor) Restore(chatID int64, text string) string {
+	if !r.restore || !placeholderRegex.MatchString(text) {
+		return text
+	}
+	r.mutex.Lock()
+	values, err := r.load(chatID)
+	r.mutex.Unlock()
+	if err != nil {
+		logger.Warn("Error loading redacted values of chat %d: %v", chatID, err)
+		return text
+	}
+	if len(values) == 0 {
+		return text
+	}
+	byPlaceholder := make(map[string]string, len(values))
+	for _, v := range values {
+		byPlaceholder[v.Placeholder] = v.Value
+	}
+	return placeholderRegex.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if value, ok := byPlaceholder[placeholder]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// Forget drops the values remembered for a chat
+func (r *Redactor) Forget(chatID int64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.store.SaveValues(chatID, nil)
+}
+
+// load returns the values of a chat, the least recently used first
+func (r *Redactor) load(chatID int64) ([]remembered, error) {
+	data, err := r.store.LoadValues(chatID)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var values []remembered
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("error decoding redacted values: %w", err)
+	}
+	return values, nil
+}
+
+// save stores the values of a chat
+func (r *Redactor) save(chatID int64, values []remembered) error {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("error encoding redacted values: %w", err)
+	}
+	return r.store.SaveValues(chatID, data)
+}
+
+// replace substitutes every detected value and records it in found if not nil
+func (r *Redactor) replace(text string, found *[]remembered) string {
+	for _, d := range r.detectors {
+		var sb strings.Builder
+		last := 0
+		for _, loc := range d.re.FindAllStringSubmatchIndex(text, -1) {
+			// Only the capture group is sensitive if the pattern has one
+			start, end := loc[0], loc[1]
+			if len(loc) >= 4 && loc[2] >= 0 {
+				start, end = loc[2], loc[3]
+			}
+			value := text[start:end]
+			if d.valid != nil && !d.valid(value) || d.numeric && continuesNumber(text, start, end) {
+				continue
+			}
+			placeholder := r.placeholder(d.label, value)
+			if found != nil {
+				*found = append(*found, remembered{Placeholder: placeholder, Value: value})
+			}
+			sb.WriteString(text[last:start])
+			sb.WriteString(placeholder)
+			last = end
+		}
+		sb.WriteString(text[last:])
+		text = sb.String()
+	}
+	return text
+}
+
+// continuesNumber reports whether the digits at text[start:end] are part of
+// something longer, such as a date followed by a time or the tail of an
+// account number, judging by the characters around them
+func continuesNumber(text string, start, end int) bool {
+	isDigit := func(i int) bool { return i >= 0 && i < len(text) && text[i] >= '0' && text[i] <= '9' }
+	isAlnum := func(i int) bool {
+		return isDigit(i) || i >= 0 && i < len(text) && (text[i] >= 'a' && text[i] <= 'z' || text[i] >= 'A' && text[i] <= 'Z')
+	}
+	isSeparator := func(i int) bool { return i >= 0 && i < len(text) && strings.IndexByte(" .-/:", text[i]) >= 0 }
+	return isAlnum(start-1) || isSeparator(start-1) && isDigit(start-2) ||
+		isAlnum(end) || isSeparator(end) && isDigit(end+1)
+}
+
+// placeholder returns the placeholder of a value, such as [EMAIL_1a2b3c4d]
+func (r *Redactor) placeholder(label, value string) string {
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte(label + "\x00" + value))
+	return "[" + label + "_" + hex.EncodeToString(mac.Sum(nil))[:8] + "]"
+}
+
+// DataSource exposes the values remembered for restoring answers for privacy
+// exports and erasure
+func (r *Redactor) DataSource() privacy.Source {
+	return redactorSource{r}
+}
+
+// redactorSource implements privacy.Source for a redactor
+type redactorSource struct {
+	redactor *Redactor
+}
+
+// Name implements privacy.Source
+func (redactorSource) Name() string {
+	return "redactions"
+}
+
+// Export implements privacy.Source
+func (s redactorSource) Export(userID int64) (interface{}, error) {
+	s.redactor.mutex.Lock()
+	defer s.redactor.mutex.Unlock()
+	values, err := s.redactor.load(userID)
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Placeholder < values[j].Placeholder })
+	exported := make([]map[string]string, len(values))
+	for i, v := range values {
+		exported[i] = map[string]string{"placeholder": v.Placeholder, "value": v.Value}
+	}
+	return exported, nil
+}
+
+// Delete implements privacy.Source
+func (s redactorSource) Delete(userID int64) error {
+	return s.redactor.Forget(userID)
+}
+
+// digits returns the decimal digits of s
+func digits(s string) string {
+	var sb strings.Builder
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+// validCard reports whether a number has the length and Luhn checksum of a payment card
+func validCard(s string) bool {
+	number := digits(s)
+	if len(number) < 13 || len(number) > 19 {
+		return false
+	}
+	sum := 0
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if (len(number)-1-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// validIBAN reports whether an account number passes the IBAN mod-97 check
+func validIBAN(s string) bool {
+	iban := strings.ReplaceAll(s, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	var numeric strings.Builder
+	for _, c := range iban[4:] + iban[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			numeric.WriteRune(c)
+		case c >= 'A' && c <= 'Z':
+			fmt.Fprintf(&numeric, "%d", c-'A'+10)
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(numeric.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// validPhone reports whether a match has as many digits as a phone number
+func validPhone(s string) bool {
+	n := len(digits(s))
+	return n >= 9 && n <= 15
+}
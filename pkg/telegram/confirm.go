@@ -0,0 +1,146 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/logger"
+)
+
+const (
+	// confirmCallbackPrefix marks inline button data of tool confirmations
+	confirmCallbackPrefix = "confirm:"
+	// maxConfirmArgumentsLength bounds the arguments shown in a confirmation prompt
+	maxConfirmArgumentsLength = 1000
+)
+
+// confirmation is a pending approval request for a tool call
+type confirmation struct {
+	chatID int64
+	// requesterID is the user whose message led to the tool call and who alone may answer
+	requesterID int64
+	answer      chan bool
+}
+
+// requesterKey is the context key of the user a request is answered for
+type requesterKey struct{}
+
+// withRequester records in ctx the user whose message is being answered
+func withRequester(ctx context.Context, userID int64) context.Context {
+	return context.WithValue(ctx, requesterKey{}, userID)
+}
+
+// confirmToolCall asks the user to approve a mutating tool call with inline buttons
+// and waits for the answer, the confirmation timeout or cancellation of ctx
+func (b *Bot) confirmToolCall(ctx context.Context, chatID int64, tool string, arguments string) (bool, error) {
+	id, err := newConfirmationID()
+	if err != nil {
+		return false, err
+	}
+
+	// In private chats the chat ID is the user ID
+	requesterID, ok := ctx.Value(requesterKey{}).(int64)
+	if !ok {
+		requesterID = chatID
+	}
+	pending := &confirmation{chatID: chatID, requesterID: requesterID, answer: make(chan bool, 1)}
+	b.confirmMutex.Lock()
+	b.confirmations[id] = pending
+	b.confirmMutex.Unlock()
+
+	defer func() {
+		b.confirmMutex.Lock()
+		delete(b.confirmations, id)
+		b.confirmMutex.Unlock()
+	}()
+
+	if len(arguments) > maxConfirmArgumentsLength {
+		arguments = arguments[:maxConfirmArgumentsLength] + "…"
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(
+		"🔧 The assistant wants to run %s with these arguments:\n\n%s\n\nAllow it?", tool, arguments))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Allow", confirmCallbackPrefix+id+":yes"),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Deny", confirmCallbackPrefix+id+":no"),
+		),
+	)
+	sent, err := b.api.Send(msg)
+	if err != nil {
+		return false, fmt.Errorf("error sending confirmation request: %w", err)
+	}
+
+	timer := time.NewTimer(b.confirmTimeout)
+	defer timer.Stop()
+
+	select {
+	case approved := <-pending.answer:
+		return approved, nil
+	case <-timer.C:
+		b.finishConfirmation(chatID, sent.MessageID, fmt.Sprintf("⌛ %s was not confirmed in time.", tool))
+		return false, fmt.Errorf("confirmation timed out")
+	case <-ctx.Done():
+		b.finishConfirmation(chatID, sent.MessageID, fmt.Sprintf("⌛ %s was cancelled.", tool))
+		return false, ctx.Err()
+	}
+}
+
+// handleConfirmCallback delivers the answer of a confirmation button press
+func (b *Bot) handleConfirmCallback(query *tgbotapi.CallbackQuery) {
+	parts := strings.Split(strings.TrimPrefix(query.Data, confirmCallbackPrefix), ":")
+	if len(parts) != 2 {
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Invalid confirmation"))
+		return
+	}
+
+	b.confirmMutex.Lock()
+	pending, ok := b.confirmations[parts[0]]
+	b.confirmMutex.Unlock()
+
+	if !ok || pending.chatID != query.Message.Chat.ID {
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "This request has expired"))
+		return
+	}
+	if query.From == nil || query.From.ID != pending.requesterID {
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Only the requester can confirm this."))
+		return
+	}
+
+	approved := parts[1] == "yes"
+	select {
+	case pending.answer <- approved:
+	default:
+		// Already answered
+	}
+
+	text := "❌ Denied."
+	if approved {
+		text = "✅ Allowed."
+	}
+	_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, text))
+	b.finishConfirmation(query.Message.Chat.ID, query.Message.MessageID, query.Message.Text+"\n\n"+text)
+	logger.Info("Tool confirmation in chat %d: approved=%v", query.Message.Chat.ID, approved)
+}
+
+// finishConfirmation replaces the confirmation prompt and removes its buttons
+func (b *Bot) finishConfirmation(chatID int64, messageID int, text string) {
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	if _, err := b.api.Send(edit); err != nil {
+		logger.Warn("Error updating confirmation message: %v", err)
+	}
+}
+
+// newConfirmationID returns a random identifier short enough for callback data
+func newConfirmationID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error generating confirmation ID: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
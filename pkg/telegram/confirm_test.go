@@ -0,0 +1,107 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// newTestBot returns a bot talking to a fake Bot API that records the texts
+// of answered callback queries
+func newTestBot(t *testing.T) (*Bot, func() []string) {
+	t.Helper()
+	var mutex sync.Mutex
+	var callbacks []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		switch path.Base(r.URL.Path) {
+		case "getMe":
+			fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"username":"test_bot"}}`)
+		case "answerCallbackQuery":
+			mutex.Lock()
+			callbacks = append(callbacks, r.FormValue("text"))
+			mutex.Unlock()
+			fmt.Fprint(w, `{"ok":true,"result":true}`)
+		default:
+			fmt.Fprint(w, `{"ok":true,"result":{"message_id":10,"date":0,"chat":{"id":-100,"type":"group"}}}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	api, err := tgbotapi.NewBotAPIWithAPIEndpoint("test-token", server.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatalf("NewBotAPIWithAPIEndpoint() error = %v", err)
+	}
+	b := &Bot{api: api, confirmTimeout: 5 * time.Second, confirmations: make(map[string]*confirmation)}
+	return b, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), callbacks...)
+	}
+}
+
+// pendingConfirmation waits until the bot has asked for a confirmation and returns its ID
+func pendingConfirmation(t *testing.T, b *Bot) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b.confirmMutex.Lock()
+		for id := range b.confirmations {
+			b.confirmMutex.Unlock()
+			return id
+		}
+		b.confirmMutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("No confirmation was requested")
+	return ""
+}
+
+func TestConfirmationOnlyByRequester(t *testing.T) {
+	b, callbacks := newTestBot(t)
+	const chatID, requester, other = -100, 42, 43
+
+	result := make(chan bool, 1)
+	go func() {
+		approved, _ := b.confirmToolCall(withRequester(context.Background(), requester), chatID, "delete", "{}")
+		result <- approved
+	}()
+	id := pendingConfirmation(t, b)
+
+	press := func(userID int64, answer string) {
+		b.handleConfirmCallback(&tgbotapi.CallbackQuery{
+			ID:      "query",
+			From:    &tgbotapi.User{ID: userID},
+			Message: &tgbotapi.Message{MessageID: 10, Chat: &tgbotapi.Chat{ID: chatID}},
+			Data:    confirmCallbackPrefix + id + ":" + answer,
+		})
+	}
+
+	press(other, "yes")
+	select {
+	case approved := <-result:
+		t.Fatalf("다른 사용자의 승인으로 확인이 끝남 (approved=%v)", approved)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := callbacks(); len(got) != 1 || got[0] != "Only the requester can confirm this." {
+		t.Errorf("Callback answers = %q", got)
+	}
+
+	press(requester, "yes")
+	select {
+	case approved := <-result:
+		if !approved {
+			t.Error("요청한 사용자의 승인이 반영되어야 함")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Confirmation was not answered")
+	}
+}
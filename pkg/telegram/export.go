@@ -0,0 +1,167 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/openai"
+)
+
+const (
+	// maxImportSize is the largest file /import accepts
+	maxImportSize = 1 << 20
+	// importWindow is how long after /import the bot waits for the file
+	importWindow = 5 * time.Minute
+	// importTimeout bounds downloading an imported file
+	importTimeout = 30 * time.Second
+)
+
+// handleExportCommand sends the current session as a file: /export [md|json|html]
+func (b *Bot) handleExportCommand(chatID int64, arguments string) {
+	format := strings.ToLower(strings.TrimSpace(arguments))
+	if format == "" {
+		format = "md"
+	}
+	if !contains(openai.ExportFormats, format) {
+		b.sendText(chatID, "Usage: /export [md|json|html]")
+		return
+	}
+
+	data, filename, err := b.openaiClient.ExportSession(chatID, format)
+	if errors.Is(err, openai.ErrEmptyConversation) {
+		b.sendText(chatID, "There is nothing to export yet.")
+		return
+	}
+	if err != nil {
+		logger.Error("Error exporting the conversation of %d: %v", chatID, err)
+		b.sendText(chatID, "Sorry, I couldn't export the conversation. Please try again later.")
+		return
+	}
+
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: filename, Bytes: data})
+	if format == "json" {
+		doc.Caption = "Send this file with /import to restore the conversation."
+	}
+	if _, err := b.api.Send(doc); err != nil {
+		logger.Error("Error sending export to %d: %v", chatID, err)
+		b.sendText(chatID, "Sorry, I couldn't send the export. Please try again later.")
+	}
+}
+
+// handleImportCommand imports the document /import replies to, or waits for
+// the next document the chat sends
+func (b *Bot) handleImportCommand(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	if reply := message.ReplyToMessage; reply != nil && reply.Document != nil {
+		b.startImport(chatID, reply.Document)
+		return
+	}
+
+	b.importMutex.Lock()
+	b.pendingImports[chatID] = time.Now().Add(importWindow)
+	b.importMutex.Unlock()
+	b.sendText(chatID, "📥 Send me a JSON file created with /export json and I'll restore it as a new chat.")
+}
+
+// handleDocument imports a document captioned /import or sent after /import
+func (b *Bot) handleDocument(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+
+	b.importMutex.Lock()
+	deadline, pending := b.pendingImports[chatID]
+	delete(b.pendingImports, chatID)
+	b.importMutex.Unlock()
+
+	if strings.HasPrefix(strings.TrimSpace(message.Caption), "/import") || (pending && time.Now().Before(deadline)) {
+		b.startImport(chatID, message.Document)
+		return
+	}
+	b.sendText(chatID, "To restore a conversation, send the file with the caption /import.")
+}
+
+// startImport imports a document in the background like a message
+func (b *Bot) startImport(chatID int64, doc *tgbotapi.Document) {
+	if !b.beginHandler() {
+		b.sendText(chatID, retryNotice)
+		return
+	}
+	go func() {
+		defer b.endHandler()
+		b.importDocument(b.ctx, chatID, doc)
+	}()
+}
+
+// importDocument downloads a JSON export and restores it as a new session
+func (b *Bot) importDocument(ctx context.Context, chatID int64, doc *tgbotapi.Document) {
+	if doc.FileSize > maxImportSize {
+		b.sendText(chatID, fmt.Sprintf("⚠️ The file is too large to import, the limit is %d KB.", maxImportSize>>10))
+		return
+	}
+
+	data, err := b.downloadFile(ctx, doc.FileID)
+	if err != nil {
+		logger.Error("Error downloading import of %d: %v", chatID, err)
+		b.sendText(chatID, "Sorry, I couldn't download the file. Please try again later.")
+		return
+	}
+
+	session, err := b.openaiClient.ImportSession(chatID, data)
+	if err != nil {
+		logger.Warn("Error importing conversation of %d: %v", chatID, err)
+		b.sendText(chatID, fmt.Sprintf("⚠️ I couldn't import this file: %v", err))
+		return
+	}
+
+	logger.Info("Chat %d imported session %s with %d messages", chatID, session.ID, session.Messages)
+	b.sendText(chatID, fmt.Sprintf("📥 Imported \"%s\" with %d messages. It is now your current chat, see /sessions for the others.",
+		sessionLabel(session), session.Messages))
+}
+
+// downloadFile fetches a file sent to the bot, reading at most maxImportSize bytes
+func (b *Bot) downloadFile(ctx context.Context, fileID string) ([]byte, error) {
+	url, err := b.api.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, importTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportSize {
+		return nil, fmt.Errorf("file exceeds %d bytes", maxImportSize)
+	}
+	return data, nil
+}
+
+// contains reports whether values contains value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
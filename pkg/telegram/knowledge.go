@@ -0,0 +1,166 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/knowledge"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/openai"
+)
+
+// knowledgeCallbackPrefix marks inline button data of the /kb menu
+const knowledgeCallbackPrefix = "kb:"
+
+// handleKnowledgeCommand shows the knowledge bases of the chat or changes them:
+// /kb [<name> on|off | default | reindex]
+func (b *Bot) handleKnowledgeCommand(chatID int64, arguments string) {
+	library := b.openaiClient.Knowledge()
+	if library == nil {
+		b.sendText(chatID, "No knowledge bases are configured on this bot.")
+		return
+	}
+
+	fields := strings.Fields(arguments)
+	switch {
+	case len(fields) == 0:
+		// Show the menu below
+	case len(fields) == 1 && fields[0] == "default":
+		b.openaiClient.ResetKnowledgeBases(chatID)
+		logger.Info("Chat %d reset its knowledge bases", chatID)
+	case len(fields) == 1 && fields[0] == "reindex":
+		b.reindexKnowledge(chatID)
+		return
+	case len(fields) == 2 && (fields[1] == "on" || fields[1] == "off"):
+		if err := b.openaiClient.SetKnowledgeBase(chatID, fields[0], fields[1] == "on"); err != nil {
+			b.sendText(chatID, fmt.Sprintf("⚠️ %v. See /kb for the list.", err))
+			return
+		}
+		logger.Info("Chat %d turned knowledge base %s %s", chatID, fields[0], fields[1])
+	default:
+		b.sendText(chatID, "Usage: /kb [<name> on|off], /kb default or /kb reindex")
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, b.knowledgeText(chatID))
+	msg.ReplyMarkup = b.knowledgeKeyboard(chatID)
+	_, _ = b.api.Send(msg)
+}
+
+// handleKnowledgeCallback toggles a knowledge base chosen in the /kb menu
+func (b *Bot) handleKnowledgeCallback(query *tgbotapi.CallbackQuery) {
+	chatID := query.Message.Chat.ID
+	data := strings.TrimPrefix(query.Data, knowledgeCallbackPrefix)
+	if b.openaiClient.Knowledge() == nil {
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Invalid knowledge base"))
+		return
+	}
+
+	if data == "default" {
+		b.openaiClient.ResetKnowledgeBases(chatID)
+	} else {
+		name := strings.TrimPrefix(data, "toggle:")
+		enabled := !containsName(b.openaiClient.KnowledgeBases(chatID), name)
+		if err := b.openaiClient.SetKnowledgeBase(chatID, name, enabled); err != nil {
+			_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Invalid knowledge base"))
+			return
+		}
+	}
+	_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Saved"))
+
+	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, query.Message.MessageID, b.knowledgeText(chatID), b.knowledgeKeyboard(chatID))
+	if _, err := b.api.Send(edit); err != nil {
+		logger.Debug("Error updating knowledge base menu: %v", err)
+	}
+}
+
+// reindexKnowledge lets admins check every knowledge base for changed files now
+// instead of at the next interval
+func (b *Bot) reindexKnowledge(chatID int64) {
+	if b.auth.RoleOf(chatID) != config.RoleAdmin {
+		b.sendText(chatID, "Only admins can reindex the knowledge bases.")
+		return
+	}
+	if !b.beginHandler() {
+		b.sendText(chatID, retryNotice)
+		return
+	}
+	b.sendText(chatID, "📚 Reindexing the knowledge bases…")
+	go func() {
+		defer b.endHandler()
+		stats, err := b.openaiClient.Knowledge().Sync(b.ctx)
+		if err != nil {
+			logger.Error("Error reindexing knowledge bases for %d: %v", chatID, err)
+			b.sendText(chatID, fmt.Sprintf("⚠️ Reindexing finished with errors: %s. See the logs for details.", stats))
+			return
+		}
+		logger.Info("Admin %d reindexed the knowledge bases: %s", chatID, stats)
+		b.sendText(chatID, fmt.Sprintf("📚 Reindexed: %s.", stats))
+	}()
+}
+
+// knowledgeText describes the knowledge bases and which of them the chat uses
+func (b *Bot) knowledgeText(chatID int64) string {
+	active := b.openaiClient.KnowledgeBases(chatID)
+
+	var sb strings.Builder
+	sb.WriteString("📚 Knowledge bases\n\n")
+	for _, base := range b.openaiClient.Knowledge().Bases() {
+		marker := "▫️"
+		if containsName(active, base.Name) {
+			marker = "✅"
+		}
+		sb.WriteString(marker + " " + base.Name)
+		if base.Description != "" {
+			sb.WriteString(" — " + base.Description)
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\nI answer from the checked bases and cite the documents I used. " +
+		"Tap a base to turn it on or off for this chat, or use /kb <name> on|off.")
+	return sb.String()
+}
+
+// knowledgeKeyboard builds a toggle button per knowledge base and one to return to the default
+func (b *Bot) knowledgeKeyboard(chatID int64) tgbotapi.InlineKeyboardMarkup {
+	active := b.openaiClient.KnowledgeBases(chatID)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, base := range b.openaiClient.Knowledge().Bases() {
+		label := "▫️ " + base.Name
+		if containsName(active, base.Name) {
+			label = "✅ " + base.Name
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, knowledgeCallbackPrefix+"toggle:"+base.Name)))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("↩️ Default", knowledgeCallbackPrefix+"default")))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// withCitations appends the knowledge base documents an answer cites to it
+func withCitations(reply *openai.Reply) string {
+	cited := knowledge.Cited(reply.Content, reply.Sources)
+	if len(cited) == 0 {
+		return reply.Content
+	}
+
+	var sb strings.Builder
+	sb.WriteString(reply.Content + "\n\n📚 Sources:")
+	for _, n := range cited {
+		fmt.Fprintf(&sb, "\n[%d] %s", n, reply.Sources[n-1].Source())
+	}
+	return sb.String()
+}
+
+// containsName reports whether names includes name
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/memory"
+	"github.com/itswryu/telegpt/pkg/openai"
+)
+
+// memoryCallbackPrefix marks inline button data of the /memories list
+const memoryCallbackPrefix = "memory:"
+
+// memoryDisabledNotice answers memory commands when memory is not configured
+const memoryDisabledNotice = "Long-term memory is not enabled on this bot."
+
+// handleRememberCommand saves a fact about the user: /remember <fact>
+func (b *Bot) handleRememberCommand(chatID int64, arguments string) {
+	memories := b.openaiClient.Memory()
+	if memories == nil {
+		b.sendText(chatID, memoryDisabledNotice)
+		return
+	}
+	if strings.TrimSpace(arguments) == "" {
+		b.sendText(chatID, "Usage: /remember <fact>, e.g. /remember I prefer answers in Korean")
+		return
+	}
+
+	_, err := memories.Remember(chatID, arguments, memory.SourceUser)
+	switch {
+	case errors.Is(err, memory.ErrDuplicate):
+		b.sendText(chatID, "I already remember that.")
+	case errors.Is(err, memory.ErrFull):
+		b.sendText(chatID, "My memory about you is full. Delete some entries with /memories first.")
+	case errors.Is(err, memory.ErrTooLong):
+		b.sendText(chatID, fmt.Sprintf("⚠️ %v. Please shorten it.", err))
+	case err != nil:
+		logger.Error("Error remembering a fact for %d: %v", chatID, err)
+		b.sendText(chatID, "Sorry, I couldn't remember that. Please try again later.")
+	default:
+		b.sendText(chatID, "🧠 Got it, I'll remember that. See everything I remember with /memories.")
+	}
+}
+
+// handleMemoriesCommand lists the memories of the user with delete buttons, or
+// deletes one by number: /memories [delete <number>]
+func (b *Bot) handleMemoriesCommand(chatID int64, arguments string) {
+	memories := b.openaiClient.Memory()
+	if memories == nil {
+		b.sendText(chatID, memoryDisabledNotice)
+		return
+	}
+
+	entries, err := memories.List(chatID)
+	if err != nil {
+		logger.Error("Error listing memories of %d: %v", chatID, err)
+		b.sendText(chatID, "Sorry, I couldn't load your memories. Please try again later.")
+		return
+	}
+
+	if fields := strings.Fields(arguments); len(fields) > 0 {
+		if len(fields) != 2 || fields[0] != "delete" {
+			b.sendText(chatID, "Usage: /memories [delete <number from the list>]")
+			return
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 || n > len(entries) {
+			b.sendText(chatID, fmt.Sprintf("There is no memory %q. See /memories for the list.", fields[1]))
+			return
+		}
+		b.sendText(chatID, b.forgetMemory(chatID, entries[n-1].ID))
+		return
+	}
+
+	if len(entries) == 0 {
+		b.sendText(chatID, "I don't remember anything about you yet. Tell me with /remember <fact>.")
+		return
+	}
+	msg := tgbotapi.NewMessage(chatID, memoriesText(entries))
+	msg.ReplyMarkup = memoriesKeyboard(entries)
+	_, _ = b.api.Send(msg)
+}
+
+// forgetMemory deletes a memory and describes the outcome
+func (b *Bot) forgetMemory(chatID int64, id string) string {
+	found, err := b.openaiClient.Memory().Forget(chatID, id)
+	switch {
+	case err != nil:
+		logger.Error("Error deleting memory of %d: %v", chatID, err)
+		return "Sorry, I couldn't delete that. Please try again later."
+	case !found:
+		return "I no longer remember that."
+	}
+	return "🗑 Forgotten."
+}
+
+// handleMemoryCallback deletes a memory chosen in the /memories list
+func (b *Bot) handleMemoryCallback(query *tgbotapi.CallbackQuery) {
+	chatID := query.Message.Chat.ID
+	id := strings.TrimPrefix(query.Data, memoryCallbackPrefix+"delete:")
+	if b.openaiClient.Memory() == nil || id == query.Data {
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Invalid memory"))
+		return
+	}
+	_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, b.forgetMemory(chatID, id)))
+
+	// Refresh the list so that it shows the new state
+	entries, err := b.openaiClient.Memory().List(chatID)
+	if err != nil {
+		logger.Error("Error listing memories of %d: %v", chatID, err)
+		return
+	}
+	var edit tgbotapi.Chattable
+	if len(entries) == 0 {
+		edit = tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, "I don't remember anything about you.")
+	} else {
+		edit = tgbotapi.NewEditMessageTextAndMarkup(chatID, query.Message.MessageID, memoriesText(entries), memoriesKeyboard(entries))
+	}
+	if _, err := b.api.Send(edit); err != nil {
+		logger.Debug("Error updating memories message: %v", err)
+	}
+}
+
+// extractMemories lets the model pick up new facts from an exchange and tells
+// the user what it remembered
+func (b *Bot) extractMemories(ctx context.Context, message *tgbotapi.Message, answer string) {
+	chatID := message.Chat.ID
+	added, used, err := b.openaiClient.ExtractMemories(ctx, chatID, message.Text, answer)
+	if used != nil {
+		b.recordUsage(message, &openai.Reply{Usage: used})
+	}
+	if err != nil {
+		logger.Warn("Error extracting memories of %d: %v", chatID, err)
+		return
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	facts := make([]string, len(added))
+	for i, entry := range added {
+		facts[i] = "• " + entry.Text
+	}
+	logger.Info("Remembered %d new facts about %d", len(added), chatID)
+	b.sendText(chatID, "🧠 I'll remember:\n"+strings.Join(facts, "\n")+"\n\nManage my memories with /memories.")
+}
+
+// memoriesText describes the memories of a user
+func memoriesText(entries []memory.Entry) string {
+	var sb strings.Builder
+	sb.WriteString("🧠 What I remember about you\n\n")
+	for i, entry := range entries {
+		marker := ""
+		if entry.Source == memory.SourceExtracted {
+			marker = " (learned)"
+		}
+		fmt.Fprintf(&sb, "%d. %s%s\n", i+1, entry.Text, marker)
+	}
+	sb.WriteString("\nTap 🗑 to delete an entry, or use /memories delete <number>.")
+	return sb.String()
+}
+
+// memoriesKeyboard builds a delete button for each memory, five to a row
+func memoriesKeyboard(entries []memory.Entry) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, entry := range entries {
+		if i%5 == 0 {
+			rows = append(rows, nil)
+		}
+		button := tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🗑 %d", i+1), memoryCallbackPrefix+"delete:"+entry.ID)
+		rows[len(rows)-1] = append(rows[len(rows)-1], button)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
@@ -0,0 +1,50 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/moderation"
+)
+
+// noteViolations counts a turn that moderation blocked or warned about
+// against the sender and alerts the admins once the sender has repeated it.
+// Content that could not be checked is not held against the sender.
+func (b *Bot) noteViolations(message *tgbotapi.Message, result moderation.Result) {
+	if b.violations == nil {
+		return
+	}
+
+	var flagged []string
+	for _, v := range result.Violations {
+		if v.Action != config.ModerationLog && v.Category != moderation.Unavailable {
+			flagged = append(flagged, fmt.Sprintf("%s %s (%s)", v.Stage, v.Category, v.Action))
+		}
+	}
+	if len(flagged) == 0 {
+		return
+	}
+
+	userID := senderID(message)
+	count, alert := b.violations.Record(userID)
+	if !alert {
+		return
+	}
+	who := fmt.Sprintf("User %d", userID)
+	if message.From != nil && message.From.UserName != "" {
+		who += " (@" + message.From.UserName + ")"
+	}
+	if message.Chat.ID != userID {
+		who += fmt.Sprintf(" in chat %d", message.Chat.ID)
+	}
+
+	logger.Warn("User %d reached %d moderation violations, alerting admins", userID, count)
+	text := fmt.Sprintf("🚨 %s had %d moderation violations within %v.\nLatest: %s",
+		who, count, b.moderationWindow, strings.Join(flagged, ", "))
+	for _, adminID := range b.auth.AdminChatIDs {
+		b.sendText(adminID, text)
+	}
+}
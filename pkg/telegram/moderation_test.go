@@ -0,0 +1,31 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/moderation"
+)
+
+func TestNoteViolationsSkipsUnavailable(t *testing.T) {
+	b, _ := newTestBot(t)
+	b.violations = moderation.NewTracker(10, time.Hour)
+	message := &tgbotapi.Message{From: &tgbotapi.User{ID: 7}, Chat: &tgbotapi.Chat{ID: 7}}
+
+	unavailable := moderation.Result{Violations: []moderation.Violation{
+		{Stage: moderation.Input, Category: moderation.Unavailable, Source: "api", Action: config.ModerationBlock},
+	}}
+	b.noteViolations(message, unavailable)
+	b.noteViolations(message, unavailable)
+
+	violence := moderation.Result{Violations: []moderation.Violation{
+		{Stage: moderation.Input, Category: "violence", Source: "api", Action: config.ModerationBlock},
+	}}
+	b.noteViolations(message, violence)
+
+	if count, _ := b.violations.Record(7); count != 2 {
+		t.Errorf("위반 횟수 = %d, expected 2 (검사하지 못한 내용은 제외)", count)
+	}
+}
@@ -0,0 +1,105 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/logger"
+)
+
+// personaCallbackPrefix marks inline button data of the /persona menu
+const personaCallbackPrefix = "persona:"
+
+// handlePersonaCommand shows the personas or switches the chat to one:
+// /persona [<name> | default]
+func (b *Bot) handlePersonaCommand(chatID int64, arguments string) {
+	if len(b.openaiClient.Personas()) == 0 {
+		b.sendText(chatID, "No personas are configured on this bot.")
+		return
+	}
+
+	fields := strings.Fields(arguments)
+	switch {
+	case len(fields) == 0:
+		// Show the menu below
+	case len(fields) == 1 && fields[0] == "default":
+		b.openaiClient.ResetPersona(chatID)
+		logger.Info("Chat %d reset its persona", chatID)
+	case len(fields) == 1:
+		if err := b.openaiClient.SetPersona(chatID, fields[0]); err != nil {
+			b.sendText(chatID, fmt.Sprintf("⚠️ %v. See /persona for the list.", err))
+			return
+		}
+		logger.Info("Chat %d switched to persona %s", chatID, fields[0])
+	default:
+		b.sendText(chatID, "Usage: /persona [<name>] or /persona default")
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, b.personaText(chatID))
+	msg.ReplyMarkup = b.personaKeyboard(chatID)
+	_, _ = b.api.Send(msg)
+}
+
+// handlePersonaCallback switches to the persona chosen in the /persona menu
+func (b *Bot) handlePersonaCallback(query *tgbotapi.CallbackQuery) {
+	chatID := query.Message.Chat.ID
+	data := strings.TrimPrefix(query.Data, personaCallbackPrefix)
+
+	if data == "default" {
+		b.openaiClient.ResetPersona(chatID)
+	} else if err := b.openaiClient.SetPersona(chatID, strings.TrimPrefix(data, "set:")); err != nil {
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Invalid persona"))
+		return
+	}
+	logger.Info("Chat %d switched to persona %q", chatID, b.openaiClient.Persona(chatID))
+	_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Saved"))
+
+	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, query.Message.MessageID, b.personaText(chatID), b.personaKeyboard(chatID))
+	if _, err := b.api.Send(edit); err != nil {
+		logger.Debug("Error updating persona menu: %v", err)
+	}
+}
+
+// personaText describes the personas and which of them the chat uses
+func (b *Bot) personaText(chatID int64) string {
+	active := b.openaiClient.Persona(chatID)
+
+	var sb strings.Builder
+	sb.WriteString("🎭 Personas\n\n")
+	for _, persona := range b.openaiClient.Personas() {
+		marker := "▫️"
+		if persona.Name == active {
+			marker = "✅"
+		}
+		sb.WriteString(marker + " " + persona.Name)
+		if persona.Description != "" {
+			sb.WriteString(" — " + persona.Description)
+		}
+		sb.WriteString("\n")
+	}
+	if active == "" {
+		sb.WriteString("\nThis chat uses the standard assistant.")
+	}
+	sb.WriteString("\nTap a persona to use it in this chat, or use /persona <name>. " +
+		"The conversation so far is kept.")
+	return sb.String()
+}
+
+// personaKeyboard builds a button per persona and one to return to the default
+func (b *Bot) personaKeyboard(chatID int64) tgbotapi.InlineKeyboardMarkup {
+	active := b.openaiClient.Persona(chatID)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, persona := range b.openaiClient.Personas() {
+		label := persona.Name
+		if persona.Name == active {
+			label = "✅ " + persona.Name
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, personaCallbackPrefix+"set:"+persona.Name)))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("↩️ Default", personaCallbackPrefix+"default")))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
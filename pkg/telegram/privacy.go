@@ -0,0 +1,179 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/logger"
+)
+
+const (
+	// forgetCallbackPrefix marks inline button data of /forgetme confirmations
+	forgetCallbackPrefix = "forget:"
+	// forgetWindow is how long a /forgetme confirmation can be answered
+	forgetWindow = 5 * time.Minute
+)
+
+// erasure is a /forgetme request waiting for confirmation
+type erasure struct {
+	chatID      int64
+	requesterID int64
+	userID      int64
+	expires     time.Time
+}
+
+// handleMyDataCommand sends everything the bot holds about the caller as a JSON file
+func (b *Bot) handleMyDataCommand(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	if !message.Chat.IsPrivate() {
+		b.sendText(chatID, "For your privacy, please use /mydata in a private chat with me.")
+		return
+	}
+
+	userID := senderID(message)
+	now := time.Now()
+	data, err := b.dataSources.ExportJSON(userID, now)
+	if err != nil {
+		logger.Error("Error exporting the data of %d: %v", userID, err)
+		b.sendText(chatID, "Sorry, I couldn't collect your data. Please try again later.")
+		return
+	}
+
+	filename := fmt.Sprintf("telegpt-mydata-%d-%s.json", userID, now.Format("20060102"))
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: filename, Bytes: data})
+	doc.Caption = "📦 This is everything I store about you. Use /forgetme to delete it."
+	if _, err := b.api.Send(doc); err != nil {
+		logger.Error("Error sending data export to %d: %v", chatID, err)
+		b.sendText(chatID, "Sorry, I couldn't send the export. Please try again later.")
+		return
+	}
+	logger.Info("User %d exported their data", userID)
+}
+
+// handleForgetMeCommand asks the caller to confirm erasing their data, or with
+// a user ID lets admins erase the data of that user: /forgetme [user_id]
+func (b *Bot) handleForgetMeCommand(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	requesterID := senderID(message)
+
+	userID := requesterID
+	text := "⚠️ This permanently deletes your conversations, usage history, settings and everything else I store about you. " +
+		"Use /mydata first if you want a copy.\n\nDelete everything?"
+
+	if argument := strings.TrimSpace(message.CommandArguments()); argument != "" {
+		if b.auth.RoleOf(chatID) != config.RoleAdmin {
+			b.sendText(chatID, "Only admins can delete the data of other users.")
+			return
+		}
+		id, err := strconv.ParseInt(argument, 10, 64)
+		if err != nil {
+			b.sendText(chatID, "Usage: /forgetme [user_id]")
+			return
+		}
+		userID = id
+		text = fmt.Sprintf("⚠️ This permanently deletes everything stored about user %d. Continue?", userID)
+	} else if !message.Chat.IsPrivate() {
+		b.sendText(chatID, "For your privacy, please use /forgetme in a private chat with me.")
+		return
+	}
+
+	id, err := newConfirmationID()
+	if err != nil {
+		logger.Error("Error creating erasure confirmation: %v", err)
+		b.sendText(chatID, "Sorry, something went wrong. Please try again later.")
+		return
+	}
+
+	b.erasureMutex.Lock()
+	for key, pending := range b.erasures {
+		if time.Now().After(pending.expires) {
+			delete(b.erasures, key)
+		}
+	}
+	b.erasures[id] = &erasure{chatID: chatID, requesterID: requesterID, userID: userID, expires: time.Now().Add(forgetWindow)}
+	b.erasureMutex.Unlock()
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🗑 Delete everything", forgetCallbackPrefix+id+":yes"),
+			tgbotapi.NewInlineKeyboardButtonData("Cancel", forgetCallbackPrefix+id+":no"),
+		),
+	)
+	if _, err := b.api.Send(msg); err != nil {
+		logger.Warn("Error sending erasure confirmation to %d: %v", chatID, err)
+	}
+}
+
+// handleForgetCallback erases the data once the requester confirms
+func (b *Bot) handleForgetCallback(query *tgbotapi.CallbackQuery) {
+	parts := strings.Split(strings.TrimPrefix(query.Data, forgetCallbackPrefix), ":")
+	if len(parts) != 2 {
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Invalid confirmation"))
+		return
+	}
+
+	b.erasureMutex.Lock()
+	pending, ok := b.erasures[parts[0]]
+	// Only the user who asked may answer, not other members of a group
+	valid := ok && pending.chatID == query.Message.Chat.ID && query.From != nil && query.From.ID == pending.requesterID
+	if valid {
+		delete(b.erasures, parts[0])
+	}
+	b.erasureMutex.Unlock()
+
+	if !valid || time.Now().After(pending.expires) {
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "This request has expired"))
+		return
+	}
+
+	chatID, messageID := query.Message.Chat.ID, query.Message.MessageID
+	if parts[1] != "yes" {
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Cancelled"))
+		b.finishConfirmation(chatID, messageID, "Nothing was deleted.")
+		return
+	}
+	_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Deleting…"))
+
+	if !b.beginHandler() {
+		b.finishConfirmation(chatID, messageID, retryNotice)
+		return
+	}
+	go func() {
+		defer b.endHandler()
+		b.finishConfirmation(chatID, messageID, b.eraseUserData(b.ctx, pending))
+	}()
+}
+
+// eraseUserData deletes the data of a user from every data source and returns
+// the outcome to report. The user's chat is locked so that no turn that is in
+// progress writes the conversation back.
+func (b *Bot) eraseUserData(ctx context.Context, request *erasure) string {
+	unlock, err := b.locker.Lock(ctx, "chat:"+strconv.FormatInt(request.userID, 10), b.lockTTL)
+	if err != nil {
+		logger.Error("Error locking chat %d for erasure: %v", request.userID, err)
+		return "Sorry, I couldn't delete the data. Please try again later."
+	}
+	defer unlock()
+
+	sources := strings.Join(b.dataSources.Names(), ", ")
+	if request.requesterID != request.userID {
+		logger.Info("Admin %d is erasing the data of user %d from %s", request.requesterID, request.userID, sources)
+	} else {
+		logger.Info("User %d is erasing their data from %s", request.userID, sources)
+	}
+	if err := b.dataSources.Delete(request.userID); err != nil {
+		logger.Error("Error erasing the data of %d: %v", request.userID, err)
+		return "⚠️ Some data couldn't be deleted. Please try again later."
+	}
+
+	if request.requesterID != request.userID {
+		return fmt.Sprintf("🗑 Deleted everything stored about user %d.", request.userID)
+	}
+	return "🗑 Deleted everything I stored about you."
+}
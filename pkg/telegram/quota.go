@@ -0,0 +1,134 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/usage"
+)
+
+// defaultBoostDuration is how long a /boost lasts when no duration is given
+const defaultBoostDuration = 24 * time.Hour
+
+// quotaStatus returns the state of a user's configured limits
+func (b *Bot) quotaStatus(userID int64) (usage.QuotaStatus, error) {
+	limits := b.quotas.LimitsFor(userID, b.auth.RoleOf(userID))
+	return b.usageTracker.Quota(userID, limits)
+}
+
+// checkQuota reports whether the sender may send another message, telling
+// them when their allowance resets if not
+func (b *Bot) checkQuota(message *tgbotapi.Message) bool {
+	if b.usageTracker == nil {
+		return true
+	}
+
+	userID := senderID(message)
+	status, err := b.quotaStatus(userID)
+	if err != nil {
+		// Do not lock users out because the store is unavailable
+		logger.Error("Error checking quota of %d: %v", userID, err)
+		return true
+	}
+
+	exceeded, ok := status.Exceeded()
+	if !ok {
+		return true
+	}
+
+	logger.Info("User %d reached the %s %s limit", userID, periodName(exceeded.Period), exceeded.Kind)
+	b.sendText(message.Chat.ID, fmt.Sprintf(
+		"⏳ You've reached your %s limit of %s.\n\n%s\nYour allowance resets %s.",
+		periodName(exceeded.Period)+" "+exceeded.Kind.String(),
+		formatAmount(exceeded.Kind, exceeded.Limit),
+		quotaText(status),
+		exceeded.Resets.Format("Jan 2 15:04 MST")))
+	return false
+}
+
+// handleBoostCommand lets admins temporarily raise a user's quota:
+// /boost <user_id> <tokens|requests|cost> <amount> [duration]
+func (b *Bot) handleBoostCommand(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	if b.auth.RoleOf(chatID) != config.RoleAdmin {
+		b.sendText(chatID, "Only admins can boost quotas.")
+		return
+	}
+	if b.usageTracker == nil {
+		b.sendText(chatID, "Usage accounting is not enabled.")
+		return
+	}
+
+	usageText := "Usage: /boost <user_id> <tokens|requests|cost> <amount> [duration, e.g. 24h]"
+	fields := strings.Fields(message.CommandArguments())
+	if len(fields) < 3 || len(fields) > 4 {
+		b.sendText(chatID, usageText)
+		return
+	}
+
+	userID, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		b.sendText(chatID, "⚠️ Invalid user ID.\n"+usageText)
+		return
+	}
+	kind, err := usage.ParseLimitKind(fields[1])
+	if err != nil {
+		b.sendText(chatID, fmt.Sprintf("⚠️ %v", err))
+		return
+	}
+	amount, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		b.sendText(chatID, "⚠️ Invalid amount.\n"+usageText)
+		return
+	}
+	duration := defaultBoostDuration
+	if len(fields) == 4 {
+		if duration, err = time.ParseDuration(fields[3]); err != nil {
+			b.sendText(chatID, "⚠️ Invalid duration.\n"+usageText)
+			return
+		}
+	}
+
+	boost, err := b.usageTracker.Boost(userID, senderID(message), kind, amount, duration)
+	if err != nil {
+		b.sendText(chatID, fmt.Sprintf("⚠️ %v", err))
+		return
+	}
+
+	logger.Info("Admin %d boosted the %s quota of %d by %s until %s",
+		senderID(message), kind, userID, formatAmount(kind, amount), boost.ExpiresAt.Format(time.RFC3339))
+	b.sendText(chatID, fmt.Sprintf("✅ Raised the %s limits of %d by %s until %s.",
+		kind, userID, formatAmount(kind, amount), boost.ExpiresAt.Format("Jan 2 15:04 MST")))
+}
+
+// quotaText lists the remaining allowance of every configured limit
+func quotaText(status usage.QuotaStatus) string {
+	var sb strings.Builder
+	for _, limit := range status {
+		sb.WriteString(fmt.Sprintf("• %s %s: %s of %s left\n",
+			periodName(limit.Period), limit.Kind,
+			formatAmount(limit.Kind, limit.Remaining()), formatAmount(limit.Kind, limit.Limit)))
+	}
+	return sb.String()
+}
+
+// periodName names an accounting period in messages
+func periodName(period usage.Period) string {
+	if period == usage.Monthly {
+		return "monthly"
+	}
+	return "daily"
+}
+
+// formatAmount renders an amount of the given kind
+func formatAmount(kind usage.LimitKind, amount float64) string {
+	if kind == usage.LimitCost {
+		return fmt.Sprintf("$%.2f", amount)
+	}
+	return strconv.FormatInt(int64(amount), 10)
+}
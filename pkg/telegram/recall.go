@@ -0,0 +1,99 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/openai"
+	"github.com/itswryu/telegpt/pkg/recall"
+)
+
+// maxRecallPreview is the number of characters shown of each recalled question and answer
+const maxRecallPreview = 200
+
+// handleRecallCommand searches earlier conversations by meaning: /recall <query>
+func (b *Bot) handleRecallCommand(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	if !b.openaiClient.RecallEnabled() {
+		b.sendText(chatID, "Recall of earlier conversations is not enabled on this bot.")
+		return
+	}
+	query := strings.TrimSpace(message.CommandArguments())
+	if query == "" {
+		b.sendText(chatID, "Usage: /recall <what to look for>, e.g. /recall the trip to Busan we planned")
+		return
+	}
+	if !b.checkQuota(message) {
+		return
+	}
+
+	if !b.beginHandler() {
+		b.sendText(chatID, retryNotice)
+		return
+	}
+	go func() {
+		defer b.endHandler()
+		b.recall(b.ctx, message, query)
+	}()
+}
+
+// recall sends the archived exchanges of the sender that best match query
+func (b *Bot) recall(ctx context.Context, message *tgbotapi.Message, query string) {
+	chatID := message.Chat.ID
+	matches, used, err := b.openaiClient.Recall(ctx, chatID, query)
+	if used != nil {
+		b.recordUsage(message, &openai.Reply{Usage: used})
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			b.sendText(chatID, retryNotice)
+			return
+		}
+		logger.Error("Error recalling conversations of %d: %v", chatID, err)
+		b.sendText(chatID, "Sorry, I couldn't search your earlier conversations. Please try again later.")
+		return
+	}
+	if len(matches) == 0 {
+		b.sendText(chatID, "I couldn't find anything like that in our earlier conversations.")
+		return
+	}
+	b.sendText(chatID, recallText(matches))
+}
+
+// archiveExchange adds an answered message to the recall archive of the chat
+func (b *Bot) archiveExchange(ctx context.Context, message *tgbotapi.Message, reply *openai.Reply) {
+	chatID := message.Chat.ID
+	used, err := b.openaiClient.ArchiveExchange(ctx, chatID, reply.SessionID, message.Text, reply.Content, time.Now())
+	if used != nil {
+		b.recordUsage(message, &openai.Reply{Usage: used})
+	}
+	if err != nil {
+		logger.Warn("Error archiving an exchange of %d: %v", chatID, err)
+	}
+}
+
+// recallText describes recalled exchanges, best match first
+func recallText(matches []recall.Match) string {
+	var sb strings.Builder
+	sb.WriteString("🔎 From our earlier conversations\n")
+	for i, match := range matches {
+		fmt.Fprintf(&sb, "\n%d. %s (%.0f%% match)\nYou: %s\nMe: %s\n", i+1,
+			match.Time.Format("Jan 2, 2006"), match.Score*100,
+			preview(match.Question), preview(match.Answer))
+	}
+	return sb.String()
+}
+
+// preview shortens text to one line of at most maxRecallPreview characters
+func preview(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxRecallPreview {
+		return string(runes[:maxRecallPreview]) + "…"
+	}
+	return text
+}
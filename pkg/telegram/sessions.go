@@ -0,0 +1,274 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/openai"
+)
+
+// sessionCallbackPrefix marks inline button data of the /sessions menu
+const sessionCallbackPrefix = "session:"
+
+const (
+	// maxListedSessions is how many of the most recent sessions /sessions
+	// shows, keeping the message and its keyboard within Telegram's limits
+	maxListedSessions = 20
+	// maxSessionLabel is the length in runes of the session titles shown
+	maxSessionLabel = 40
+)
+
+// handleNewChat starts a new session, keeping the previous one
+func (b *Bot) handleNewChat(chatID int64, title string) {
+	if _, err := b.openaiClient.NewSession(chatID, title); err != nil {
+		logger.Error("Error starting a session for %d: %v", chatID, err)
+		b.sendText(chatID, "Sorry, I couldn't start a new chat. Please try again later.")
+		return
+	}
+	heading := "Starting a new chat! 🆕"
+	if title = strings.TrimSpace(title); title != "" {
+		heading = fmt.Sprintf("Starting a new chat \"%s\"! 🆕", title)
+	}
+	b.sendText(chatID, heading+"\nWhat would you like to discuss?\n\nYour previous chats are kept, see /sessions.")
+}
+
+// handleSessionsCommand lists the sessions of a chat with buttons to switch to or delete them
+func (b *Bot) handleSessionsCommand(chatID int64) {
+	sessions, err := b.openaiClient.Sessions(chatID)
+	if err != nil {
+		logger.Error("Error listing sessions of %d: %v", chatID, err)
+		b.sendText(chatID, "Sorry, I couldn't load your chats. Please try again later.")
+		return
+	}
+	if len(sessions) == 0 {
+		b.sendText(chatID, "You have no saved chats yet. Just send a message to start one.")
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, sessionsText(sessions, time.Now()))
+	msg.ReplyMarkup = sessionsKeyboard(sessions)
+	_, _ = b.api.Send(msg)
+}
+
+// handleSwitchCommand resumes the session given by number or ID: /switch <session>
+func (b *Bot) handleSwitchCommand(chatID int64, arguments string) {
+	if strings.TrimSpace(arguments) == "" {
+		b.handleSessionsCommand(chatID)
+		return
+	}
+	session, ok := b.findSession(chatID, arguments)
+	if !ok {
+		return
+	}
+	b.sendText(chatID, b.switchSession(chatID, session.ID))
+}
+
+// handleDeleteCommand removes the session given by number or ID: /delete <session>
+func (b *Bot) handleDeleteCommand(chatID int64, arguments string) {
+	if strings.TrimSpace(arguments) == "" {
+		b.sendText(chatID, "Usage: /delete <number or ID from /sessions>")
+		return
+	}
+	session, ok := b.findSession(chatID, arguments)
+	if !ok {
+		return
+	}
+	b.sendText(chatID, b.deleteSession(chatID, session.ID))
+}
+
+// findSession resolves a /sessions list number or session ID, telling the user if there is no such session
+func (b *Bot) findSession(chatID int64, argument string) (openai.Session, bool) {
+	sessions, err := b.openaiClient.Sessions(chatID)
+	if err != nil {
+		logger.Error("Error listing sessions of %d: %v", chatID, err)
+		b.sendText(chatID, "Sorry, I couldn't load your chats. Please try again later.")
+		return openai.Session{}, false
+	}
+
+	argument = strings.TrimSpace(argument)
+	if n, err := strconv.Atoi(argument); err == nil && n >= 1 && n <= len(sessions) {
+		return sessions[n-1], true
+	}
+	for _, session := range sessions {
+		if session.ID == argument {
+			return session, true
+		}
+	}
+	b.sendText(chatID, fmt.Sprintf("There is no chat %q. See /sessions for your chats.", argument))
+	return openai.Session{}, false
+}
+
+// switchSession resumes a session and describes the outcome
+func (b *Bot) switchSession(chatID int64, sessionID string) string {
+	switch err := b.openaiClient.SwitchSession(chatID, sessionID); {
+	case errors.Is(err, openai.ErrSessionNotFound):
+		return "That chat no longer exists."
+	case errors.Is(err, openai.ErrSessionTooOld):
+		return "That chat is too old to continue. Start a new one with /new."
+	case err != nil:
+		logger.Error("Error switching session of %d: %v", chatID, err)
+		return "Sorry, I couldn't switch chats. Please try again later."
+	}
+	return "▶️ Resumed the chat. Continue where you left off."
+}
+
+// deleteSession removes a session and describes the outcome
+func (b *Bot) deleteSession(chatID int64, sessionID string) string {
+	switch err := b.openaiClient.DeleteSession(chatID, sessionID); {
+	case errors.Is(err, openai.ErrSessionNotFound):
+		return "That chat no longer exists."
+	case err != nil:
+		logger.Error("Error deleting session of %d: %v", chatID, err)
+		return "Sorry, I couldn't delete the chat. Please try again later."
+	}
+	logger.Info("Chat %d deleted session %s", chatID, sessionID)
+	return "🗑 Deleted the chat."
+}
+
+// handleSessionCallback switches to or deletes a session chosen in the /sessions menu
+func (b *Bot) handleSessionCallback(query *tgbotapi.CallbackQuery) {
+	chatID := query.Message.Chat.ID
+	parts := strings.SplitN(strings.TrimPrefix(query.Data, sessionCallbackPrefix), ":", 2)
+	if len(parts) != 2 {
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Invalid chat"))
+		return
+	}
+
+	var result string
+	switch parts[0] {
+	case "switch":
+		result = b.switchSession(chatID, parts[1])
+	case "delete":
+		result = b.deleteSession(chatID, parts[1])
+	default:
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Invalid chat"))
+		return
+	}
+	_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, result))
+
+	// Refresh the list so that it shows the new state
+	sessions, err := b.openaiClient.Sessions(chatID)
+	if err != nil {
+		logger.Error("Error listing sessions of %d: %v", chatID, err)
+		return
+	}
+	var edit tgbotapi.Chattable
+	if len(sessions) == 0 {
+		edit = tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, "You have no saved chats.")
+	} else {
+		edit = tgbotapi.NewEditMessageTextAndMarkup(chatID, query.Message.MessageID, sessionsText(sessions, time.Now()), sessionsKeyboard(sessions))
+	}
+	if _, err := b.api.Send(edit); err != nil {
+		logger.Debug("Error updating sessions message: %v", err)
+	}
+}
+
+// sessionsText describes the most recent sessions of a chat
+func sessionsText(sessions []openai.Session, now time.Time) string {
+	var sb strings.Builder
+	sb.WriteString("💬 Your chats\n\n")
+	for i, session := range listedSessions(sessions) {
+		marker := ""
+		if session.Active {
+			marker = " ✅"
+		}
+		fmt.Fprintf(&sb, "%d. %s%s\n   %d messages, used %s\n", i+1, sessionLabel(session), marker,
+			session.Messages, formatAge(now.Sub(session.LastUsed)))
+	}
+	if more := len(sessions) - maxListedSessions; more > 0 {
+		fmt.Fprintf(&sb, "…and %d older chats, numbered up to %d.\n", more, len(sessions))
+	}
+	sb.WriteString("\nTap a chat to resume it, or use /switch <number> and /delete <number>.")
+	return sb.String()
+}
+
+// sessionsKeyboard builds a resume and a delete button for each listed session
+func sessionsKeyboard(sessions []openai.Session) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, session := range listedSessions(sessions) {
+		label := fmt.Sprintf("%d. %s", i+1, sessionLabel(session))
+		if session.Active {
+			label = "✅ " + label
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, sessionCallbackPrefix+"switch:"+session.ID),
+			tgbotapi.NewInlineKeyboardButtonData("🗑", sessionCallbackPrefix+"delete:"+session.ID),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// listedSessions returns the sessions /sessions shows, the most recent first
+func listedSessions(sessions []openai.Session) []openai.Session {
+	if len(sessions) > maxListedSessions {
+		return sessions[:maxListedSessions]
+	}
+	return sessions
+}
+
+// sessionLabel returns the title of a session shortened to one line, or a
+// placeholder for untitled ones
+func sessionLabel(session openai.Session) string {
+	title := strings.Join(strings.Fields(session.Title), " ")
+	if title == "" {
+		return "Untitled chat"
+	}
+	if runes := []rune(title); len(runes) > maxSessionLabel {
+		return string(runes[:maxSessionLabel-1]) + "…"
+	}
+	return title
+}
+
+// formatAge describes how long ago something happened
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%d min ago", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%d h ago", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%d days ago", int(d/(24*time.Hour)))
+	}
+}
+
+// formatDuration describes a configured duration such as a timeout
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%d min", int(d/time.Minute))
+	case d < 24*time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%d h", int(d/time.Hour))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%d h %d min", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	default:
+		return fmt.Sprintf("%d days", int(d/(24*time.Hour)))
+	}
+}
+
+// expiryNotice tells the user why their message started a fresh context, or
+// returns an empty string if it continued the session
+func (b *Bot) expiryNotice(chatID int64, reply *openai.Reply) string {
+	policy := b.openaiClient.RetentionPolicy(chatID)
+	var notice string
+	switch reply.Expired {
+	case openai.ExpiredIdle:
+		notice = fmt.Sprintf("🕒 Your previous chat expired after %s without messages, so I'm starting fresh. "+
+			"Resume it with /sessions if you want to continue it.", formatDuration(policy.IdleTimeout))
+	case openai.ExpiredAge:
+		notice = fmt.Sprintf("🕒 Your previous chat reached the maximum age of %s, so I'm starting fresh.",
+			formatDuration(policy.MaxSessionAge))
+	default:
+		return ""
+	}
+	if reply.Summarized {
+		notice += "\n📝 I kept a short summary of it as context. Turn this off with /settings summary off."
+	}
+	return notice
+}
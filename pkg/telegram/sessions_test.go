@@ -0,0 +1,43 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/itswryu/telegpt/pkg/openai"
+)
+
+func TestSessionsListIsCapped(t *testing.T) {
+	now := time.Now()
+	sessions := make([]openai.Session, 25)
+	for i := range sessions {
+		sessions[i] = openai.Session{
+			ID:       fmt.Sprintf("s%d", i),
+			Title:    fmt.Sprintf("%d %s", i, strings.Repeat("아주 긴 제목 ", 50)),
+			Messages: 10,
+			LastUsed: now.Add(-time.Duration(i) * time.Hour),
+		}
+	}
+
+	text := sessionsText(sessions, now)
+	if n := utf8.RuneCountInString(text); n > 4096 {
+		t.Errorf("sessionsText() = %d characters, 텔레그램 메시지 한도를 넘으면 안 됨", n)
+	}
+	if !strings.Contains(text, "20. 19 ") || strings.Contains(text, "21. ") {
+		t.Errorf("sessionsText() = %q, 최근 20개만 표시해야 함", text)
+	}
+	if !strings.Contains(text, "…and 5 older chats") {
+		t.Errorf("sessionsText() = %q, 나머지 개수를 알려야 함", text)
+	}
+
+	keyboard := sessionsKeyboard(sessions)
+	if len(keyboard.InlineKeyboard) != maxListedSessions {
+		t.Errorf("sessionsKeyboard() = %d rows, expected %d", len(keyboard.InlineKeyboard), maxListedSessions)
+	}
+	if label := keyboard.InlineKeyboard[0][0].Text; utf8.RuneCountInString(label) > maxSessionLabel+4 || !strings.HasSuffix(label, "…") {
+		t.Errorf("sessionsKeyboard() label = %q, 긴 제목은 잘라야 함", label)
+	}
+}
@@ -0,0 +1,154 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/logger"
+)
+
+// settingsCallbackPrefix marks inline button data of the /settings menu
+const settingsCallbackPrefix = "settings:"
+
+// settingsPresets are the values offered as buttons in the /settings menu
+var settingsPresets = []struct {
+	label  string
+	name   string
+	values []string
+}{
+	{"🌡", "temperature", []string{"0.2", "0.7", "1.0", "1.5"}},
+	{"🎯 top_p", "top_p", []string{"0.5", "0.9", "1.0"}},
+	{"📏 max", "max_tokens", []string{"256", "1024", "4096"}},
+}
+
+// handleSettingsCommand shows the effective sampling parameters or, with
+// arguments such as "temperature 0.5", changes one of them
+func (b *Bot) handleSettingsCommand(chatID int64, arguments string) {
+	fields := strings.Fields(arguments)
+
+	switch {
+	case len(fields) == 0:
+		// Show the menu below
+	case len(fields) == 1 && fields[0] == "reset":
+		b.openaiClient.ResetSampling(chatID)
+	case len(fields) == 2 && fields[0] == "summary":
+		if !b.openaiClient.SummariesAvailable() {
+			b.sendText(chatID, "Summaries of expired chats are not enabled on this bot.")
+			return
+		}
+		switch fields[1] {
+		case "on":
+			b.openaiClient.SetCarrySummary(chatID, true)
+		case "off":
+			b.openaiClient.SetCarrySummary(chatID, false)
+		default:
+			b.sendText(chatID, "Usage: /settings summary on|off")
+			return
+		}
+		logger.Info("Chat %d turned summaries %s", chatID, fields[1])
+	case len(fields) >= 2:
+		name, value := fields[0], strings.Join(fields[1:], " ")
+		if err := b.openaiClient.SetSamplingParameter(chatID, name, value); err != nil {
+			b.sendText(chatID, fmt.Sprintf("⚠️ %v", err))
+			return
+		}
+		logger.Info("Chat %d set %s to %s", chatID, name, value)
+	default:
+		b.sendText(chatID, "Usage: /settings [<parameter> <value|default>], /settings summary on|off or /settings reset\n"+
+			"Parameters: "+strings.Join(config.SamplingParameters, ", "))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, b.settingsText(chatID))
+	msg.ReplyMarkup = settingsKeyboard()
+	_, _ = b.api.Send(msg)
+}
+
+// handleSettingsCallback applies a preset chosen from the /settings menu
+func (b *Bot) handleSettingsCallback(query *tgbotapi.CallbackQuery) {
+	chatID := query.Message.Chat.ID
+	data := strings.TrimPrefix(query.Data, settingsCallbackPrefix)
+
+	if data == "reset" {
+		b.openaiClient.ResetSampling(chatID)
+	} else {
+		parts := strings.SplitN(data, ":", 2)
+		if len(parts) != 2 {
+			_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Invalid setting"))
+			return
+		}
+		if err := b.openaiClient.SetSamplingParameter(chatID, parts[0], parts[1]); err != nil {
+			_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, err.Error()))
+			return
+		}
+	}
+
+	_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Saved"))
+	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, query.Message.MessageID, b.settingsText(chatID), settingsKeyboard())
+	if _, err := b.api.Send(edit); err != nil {
+		logger.Debug("Error updating settings message: %v", err)
+	}
+}
+
+// settingsText describes the effective sampling parameters of a chat
+func (b *Bot) settingsText(chatID int64) string {
+	s := b.openaiClient.Sampling(chatID)
+
+	float := func(v *float64) string {
+		if v == nil {
+			return "default"
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	}
+
+	maxTokens, seed, stop := "default", "default", "none"
+	if s.MaxTokens != nil {
+		maxTokens = strconv.Itoa(*s.MaxTokens)
+	}
+	if s.Seed != nil {
+		seed = strconv.FormatInt(*s.Seed, 10)
+	}
+	if len(s.Stop) > 0 {
+		stop = strings.Join(s.Stop, ", ")
+	}
+
+	summary := ""
+	if b.openaiClient.SummariesAvailable() {
+		summary = "summary of expired chats: off\n"
+		if b.openaiClient.CarriesSummary(chatID) {
+			summary = "summary of expired chats: on\n"
+		}
+	}
+
+	return "⚙️ Current settings\n\n" +
+		"temperature: " + float(s.Temperature) + "\n" +
+		"top_p: " + float(s.TopP) + "\n" +
+		"max_tokens: " + maxTokens + "\n" +
+		"presence_penalty: " + float(s.PresencePenalty) + "\n" +
+		"frequency_penalty: " + float(s.FrequencyPenalty) + "\n" +
+		"seed: " + seed + "\n" +
+		"stop: " + stop + "\n" +
+		summary + "\n" +
+		"Change other values with /settings <parameter> <value|default>, e.g. /settings seed 42"
+}
+
+// settingsKeyboard builds the preset buttons of the /settings menu
+func settingsKeyboard() tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, preset := range settingsPresets {
+		row := []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(preset.label, settingsCallbackPrefix+preset.name+":default"),
+		}
+		for _, value := range preset.values {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(value, settingsCallbackPrefix+preset.name+":"+value))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("♻️ Reset to defaults", settingsCallbackPrefix+"reset"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
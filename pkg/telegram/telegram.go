@@ -1,12 +1,22 @@
 package telegram
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/lock"
 	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/moderation"
 	"github.com/itswryu/telegpt/pkg/openai"
+	"github.com/itswryu/telegpt/pkg/privacy"
+	"github.com/itswryu/telegpt/pkg/usage"
 )
 
 // Bot represents a Telegram bot
@@ -14,10 +24,47 @@ type Bot struct {
 	api            *tgbotapi.BotAPI
 	openaiClient   *openai.Client
 	allowedChatIDs map[int64]bool
+	auth           config.AuthConfig
+	usageTracker   *usage.Tracker
+	locker         lock.Locker
+	lockTTL        time.Duration
+	quotas         config.QuotaConfig
+	confirmTimeout time.Duration
+	confirmations  map[string]*confirmation
+	confirmMutex   sync.Mutex
+	// pendingImports maps chats that sent /import to when the offer expires
+	pendingImports map[int64]time.Time
+	importMutex    sync.Mutex
+	// dataSources hold what the bot stores about users, for /mydata and /forgetme
+	dataSources  *privacy.Registry
+	erasures     map[string]*erasure
+	erasureMutex sync.Mutex
+	// violations counts moderation violations per user, nil when moderation is disabled
+	violations       *moderation.Tracker
+	moderationWindow time.Duration
+	// ctx is the parent of every request context and is cancelled by Stop
+	ctx    context.Context
+	cancel context.CancelFunc
+	// In-flight message handlers, drained by Stop
+	shutdownGrace time.Duration
+	inFlight      sync.WaitGroup
+	inFlightCount int
+	stopping      bool
+	inFlightMutex sync.Mutex
 }
 
-// NewBot creates a new Telegram bot
-func NewBot(cfg *config.Config, openaiClient *openai.Client) (*Bot, error) {
+// lockTTLMargin is added to the request timeout so that a chat lock outlives the
+// request it protects but is freed if its replica dies
+const lockTTLMargin = 30 * time.Second
+
+// cancelNoticeTimeout is how long Stop waits for cancelled handlers to tell their users to retry
+const cancelNoticeTimeout = 5 * time.Second
+
+// retryNotice is sent to users whose message could not be answered because the bot is stopping
+const retryNotice = "⚠️ The bot is restarting and couldn't answer your message. Please send it again in a moment."
+
+// NewBot creates a new Telegram bot. The locker serializes the turns of each chat.
+func NewBot(cfg *config.Config, openaiClient *openai.Client, usageTracker *usage.Tracker, locker lock.Locker) (*Bot, error) {
 	bot, err := tgbotapi.NewBotAPI(cfg.Telegram.BotToken)
 	if err != nil {
 		return nil, fmt.Errorf("error creating Telegram bot: %w", err)
@@ -29,11 +76,36 @@ func NewBot(cfg *config.Config, openaiClient *openai.Client) (*Bot, error) {
 		allowedChatIDs[id] = true
 	}
 
-	return &Bot{
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bot{
 		api:            bot,
 		openaiClient:   openaiClient,
 		allowedChatIDs: allowedChatIDs,
-	}, nil
+		auth:           cfg.Auth,
+		usageTracker:   usageTracker,
+		locker:         locker,
+		lockTTL:        cfg.OpenAI.RequestTimeout + lockTTLMargin,
+		quotas:         cfg.Quotas,
+		confirmTimeout: cfg.MCP.ConfirmTimeout,
+		confirmations:  make(map[string]*confirmation),
+		pendingImports: make(map[int64]time.Time),
+		dataSources:    privacy.NewRegistry(openaiClient.DataSources()...),
+		erasures:       make(map[string]*erasure),
+		ctx:            ctx,
+		cancel:         cancel,
+		shutdownGrace:  cfg.Telegram.ShutdownGracePeriod,
+	}
+	openaiClient.SetConfirmer(b.confirmToolCall)
+	openaiClient.SetBotUsername(bot.Self.UserName)
+	if usageTracker != nil {
+		b.dataSources.Register(usageTracker.DataSource())
+	}
+	if cfg.Moderation.Enabled {
+		b.violations = moderation.NewTracker(cfg.Moderation.AlertAfter, cfg.Moderation.AlertWindow)
+		b.moderationWindow = cfg.Moderation.AlertWindow
+	}
+
+	return b, nil
 }
 
 // Start starts the bot and listens for messages
@@ -46,6 +118,11 @@ func (b *Bot) Start() error {
 	updates := b.api.GetUpdatesChan(u)
 
 	for update := range updates {
+		if update.CallbackQuery != nil {
+			b.handleCallbackQuery(update.CallbackQuery)
+			continue
+		}
+
 		if update.Message == nil {
 			continue
 		}
@@ -61,19 +138,37 @@ func (b *Bot) Start() error {
 		}
 
 		// Process the message
+		if update.Message.IsCommand() && b.handleCommand(update.Message) {
+			continue
+		}
+
+		if update.Message.Document != nil {
+			b.handleDocument(update.Message)
+			continue
+		}
+
 		if update.Message.Text != "" {
 			switch update.Message.Text {
-			case "/start":
-				b.handleStartCommand(chatID)
 			case "🆕 New Chat":
-				b.handleNewChat(chatID)
+				b.handleNewChat(chatID, "")
 			case "🔄 Reset Chat":
-				b.openaiClient.ResetConversation(chatID)
+				if err := b.openaiClient.ResetConversation(chatID); err != nil {
+					logger.Error("Error resetting conversation of %d: %v", chatID, err)
+					b.sendText(chatID, "Sorry, I couldn't reset the conversation. Please try again later.")
+					continue
+				}
 				msg := tgbotapi.NewMessage(chatID, "Conversation history has been reset.")
 				_, _ = b.api.Send(msg)
 			default:
-				// Handle normal message
-				go b.handleMessage(update.Message)
+				// Handle normal message unless the bot is shutting down
+				if !b.beginHandler() {
+					b.sendText(chatID, retryNotice)
+					continue
+				}
+				go func(message *tgbotapi.Message) {
+					defer b.endHandler()
+					b.handleMessage(b.ctx, message)
+				}(update.Message)
 			}
 		}
 	}
@@ -81,11 +176,67 @@ func (b *Bot) Start() error {
 	return nil
 }
 
-// Stop gracefully stops the bot
+// Stop stops receiving updates and waits up to the shutdown grace period for
+// in-flight replies to be sent. Replies still running after that are cancelled
+// and their users are asked to send their message again.
 func (b *Bot) Stop() {
 	// Stop getting updates
 	b.api.StopReceivingUpdates()
-	logger.Info("Bot stopped receiving updates")
+
+	b.inFlightMutex.Lock()
+	b.stopping = true
+	count := b.inFlightCount
+	b.inFlightMutex.Unlock()
+	logger.Info("Bot stopped receiving updates, waiting for %d in-flight replies", count)
+
+	if !b.waitInFlight(b.shutdownGrace) {
+		logger.Warn("Shutdown grace period of %v expired, cancelling in-flight replies", b.shutdownGrace)
+		b.cancel()
+		if !b.waitInFlight(cancelNoticeTimeout) {
+			logger.Warn("Some handlers did not finish after cancellation")
+		}
+	}
+
+	// Abort anything left such as pending confirmations
+	b.cancel()
+	logger.Info("Bot stopped")
+}
+
+// beginHandler registers an in-flight message handler and reports false once the bot is stopping
+func (b *Bot) beginHandler() bool {
+	b.inFlightMutex.Lock()
+	defer b.inFlightMutex.Unlock()
+
+	if b.stopping {
+		return false
+	}
+	b.inFlight.Add(1)
+	b.inFlightCount++
+	return true
+}
+
+// endHandler marks an in-flight message handler as finished
+func (b *Bot) endHandler() {
+	b.inFlightMutex.Lock()
+	b.inFlightCount--
+	b.inFlightMutex.Unlock()
+	b.inFlight.Done()
+}
+
+// waitInFlight waits for the in-flight handlers and reports whether they finished within timeout
+func (b *Bot) waitInFlight(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		b.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 // isAllowedUser checks if a user is allowed to use the bot
@@ -93,37 +244,184 @@ func (b *Bot) isAllowedUser(chatID int64) bool {
 	return b.allowedChatIDs[chatID]
 }
 
-// handleMessage processes a message and generates a response
-func (b *Bot) handleMessage(message *tgbotapi.Message) {
+// handleMessage processes a message and generates a response until ctx is cancelled
+func (b *Bot) handleMessage(ctx context.Context, message *tgbotapi.Message) {
 	chatID := message.Chat.ID
 	userMessage := message.Text
 
+	logger.Info("Received message from %d: %s", chatID, b.openaiClient.Mask(userMessage))
+
+	// One turn per chat at a time, across replicas when the locker is shared
+	unlock, err := b.locker.Lock(ctx, "chat:"+strconv.FormatInt(chatID, 10), b.lockTTL)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			b.sendText(chatID, retryNotice)
+		} else {
+			logger.Error("Error locking chat %d: %v", chatID, err)
+			b.sendText(chatID, "Sorry, I encountered an error generating a response. Please try again later.")
+		}
+		return
+	}
+	defer unlock()
+
+	if !b.checkQuota(message) {
+		return
+	}
+
 	// Send "typing" action
 	typingMsg := tgbotapi.NewChatAction(chatID, tgbotapi.ChatTyping)
 	_, _ = b.api.Send(typingMsg)
 
-	logger.Info("Received message from %d: %s", chatID, userMessage)
-
 	// Generate response using OpenAI
-	response, err := b.openaiClient.GenerateResponse(chatID, userMessage)
+	var firstName, language string
+	if message.From != nil {
+		firstName, language = message.From.FirstName, message.From.LanguageCode
+	}
+	// Only the sender may confirm the tool calls of the answer
+	reply, err := b.openaiClient.GenerateReply(withRequester(ctx, senderID(message)), openai.Request{
+		ChatID:            chatID,
+		Text:              userMessage,
+		TelegramMessageID: message.MessageID,
+		FirstName:         firstName,
+		LanguageCode:      language,
+		ChatTitle:         message.Chat.Title,
+		ChatType:          message.Chat.Type,
+	})
+	// Requests made for a failed reply count towards the quotas as well
+	if reply != nil {
+		b.recordUsage(message, reply)
+	}
 	if err != nil {
-		logger.Error("Error generating response: %v", err)
-		msg := tgbotapi.NewMessage(chatID, "Sorry, I encountered an error generating a response. Please try again later.")
+		text := "Sorry, I encountered an error generating a response. Please try again later."
+		switch {
+		case errors.Is(err, context.Canceled):
+			logger.Info("Request of %d was cancelled by shutdown", chatID)
+			text = retryNotice
+		case errors.Is(err, context.DeadlineExceeded):
+			logger.Warn("Request of %d timed out: %v", chatID, err)
+			text = "Sorry, generating a response took too long. Please try again."
+		default:
+			logger.Error("Error generating response: %v", err)
+		}
+		msg := tgbotapi.NewMessage(chatID, text)
 		_, _ = b.api.Send(msg)
 		return
 	}
 
+	b.noteViolations(message, reply.Moderation)
+	if reply.Blocked {
+		b.sendText(chatID, reply.Content)
+		return
+	}
+	if warning := moderation.Warning(reply.Moderation); warning != "" {
+		b.sendText(chatID, warning)
+	}
+
+	if notice := b.expiryNotice(chatID, reply); notice != "" {
+		b.sendText(chatID, notice)
+	}
+
 	// Send response back to user
-	msg := tgbotapi.NewMessage(chatID, response)
+	msg := tgbotapi.NewMessage(chatID, withCitations(reply))
 	msg.ParseMode = tgbotapi.ModeMarkdown
 	msg.ReplyMarkup = b.createMainMenu()
-	_, err = b.api.Send(msg)
+	sent, err := b.api.Send(msg)
 
 	if err != nil {
 		// If markdown parsing fails, try sending without markdown
 		logger.Warn("Error sending markdown message: %v. Trying without markdown.", err)
 		msg.ParseMode = ""
-		_, _ = b.api.Send(msg)
+		if sent, err = b.api.Send(msg); err != nil {
+			logger.Error("Error sending response: %v", err)
+			return
+		}
+	}
+
+	if err := b.openaiClient.SetReplyMessageID(chatID, message.MessageID, sent.MessageID); err != nil {
+		logger.Warn("Error recording the reply message of %d: %v", chatID, err)
+	}
+
+	b.archiveExchange(ctx, message, reply)
+	b.extractMemories(ctx, message, reply.Content)
+}
+
+// handleCommand handles bot commands and reports whether the command was recognized
+func (b *Bot) handleCommand(message *tgbotapi.Message) bool {
+	chatID := message.Chat.ID
+
+	switch message.Command() {
+	case "start":
+		b.handleStartCommand(chatID)
+	case "settings":
+		b.handleSettingsCommand(chatID, message.CommandArguments())
+	case "usage":
+		b.handleUsageCommand(message)
+	case "boost":
+		b.handleBoostCommand(message)
+	case "new":
+		b.handleNewChat(chatID, message.CommandArguments())
+	case "sessions":
+		b.handleSessionsCommand(chatID)
+	case "switch":
+		b.handleSwitchCommand(chatID, message.CommandArguments())
+	case "delete":
+		b.handleDeleteCommand(chatID, message.CommandArguments())
+	case "export":
+		b.handleExportCommand(chatID, message.CommandArguments())
+	case "import":
+		b.handleImportCommand(message)
+	case "mydata":
+		b.handleMyDataCommand(message)
+	case "forgetme":
+		b.handleForgetMeCommand(message)
+	case "remember":
+		b.handleRememberCommand(chatID, message.CommandArguments())
+	case "memories":
+		b.handleMemoriesCommand(chatID, message.CommandArguments())
+	case "recall":
+		b.handleRecallCommand(message)
+	case "kb":
+		b.handleKnowledgeCommand(chatID, message.CommandArguments())
+	case "persona":
+		b.handlePersonaCommand(chatID, message.CommandArguments())
+	default:
+		return false
+	}
+	return true
+}
+
+// handleCallbackQuery dispatches inline keyboard button presses
+func (b *Bot) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
+	if query.Message == nil || !b.isAllowedUser(query.Message.Chat.ID) {
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, "Unauthorized"))
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(query.Data, confirmCallbackPrefix):
+		b.handleConfirmCallback(query)
+	case strings.HasPrefix(query.Data, settingsCallbackPrefix):
+		b.handleSettingsCallback(query)
+	case strings.HasPrefix(query.Data, sessionCallbackPrefix):
+		b.handleSessionCallback(query)
+	case strings.HasPrefix(query.Data, forgetCallbackPrefix):
+		b.handleForgetCallback(query)
+	case strings.HasPrefix(query.Data, memoryCallbackPrefix):
+		b.handleMemoryCallback(query)
+	case strings.HasPrefix(query.Data, knowledgeCallbackPrefix):
+		b.handleKnowledgeCallback(query)
+	case strings.HasPrefix(query.Data, personaCallbackPrefix):
+		b.handlePersonaCallback(query)
+	default:
+		_, _ = b.api.Request(tgbotapi.NewCallback(query.ID, ""))
+	}
+}
+
+// sendText sends a plain text message
+func (b *Bot) sendText(chatID int64, text string) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	if _, err := b.api.Send(msg); err != nil {
+		logger.Warn("Error sending message to %d: %v", chatID, err)
 	}
 }
 
@@ -142,18 +440,20 @@ func (b *Bot) handleStartCommand(chatID int64) {
 	welcomeText := "Welcome to TeleGPT! 🤖\n\n" +
 		"I'm here to help you with your questions and tasks.\n\n" +
 		"You can:\n" +
-		"• Start a new chat with '🆕 New Chat'\n" +
+		"• Start a new chat with '🆕 New Chat' or /new [title]\n" +
+		"• Resume or delete earlier chats with /sessions\n" +
+		"• Share the current chat as a file with /export [md|json|html], restore one with /import\n" +
 		"• Reset the current chat with '🔄 Reset Chat'\n" +
+		"• Switch between personas such as a reviewer or a translator with /persona\n" +
+		"• Adjust temperature and other parameters with /settings\n" +
+		"• See your token usage and remaining quota with /usage\n" +
+		"• Tell me facts to keep across chats with /remember, review them with /memories\n" +
+		"• Search our earlier conversations by meaning with /recall <query>\n" +
+		"• Choose the knowledge bases I answer from with /kb\n" +
+		"• Download everything I store about you with /mydata, delete it with /forgetme\n" +
 		"• Just type your message to continue the current conversation"
 
 	msg := tgbotapi.NewMessage(chatID, welcomeText)
 	msg.ReplyMarkup = b.createMainMenu()
 	_, _ = b.api.Send(msg)
 }
-
-// handleNewChat handles starting a new chat
-func (b *Bot) handleNewChat(chatID int64) {
-	b.openaiClient.ResetConversation(chatID)
-	msg := tgbotapi.NewMessage(chatID, "Starting a new chat! 🆕\nWhat would you like to discuss?")
-	_, _ = b.api.Send(msg)
-}
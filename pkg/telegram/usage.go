@@ -0,0 +1,148 @@
+package telegram
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/logger"
+	"github.com/itswryu/telegpt/pkg/openai"
+	"github.com/itswryu/telegpt/pkg/usage"
+)
+
+// senderID returns the ID of the user who sent a message, or the chat ID
+// for messages without a sender such as channel posts
+func senderID(message *tgbotapi.Message) int64 {
+	if message.From != nil {
+		return message.From.ID
+	}
+	return message.Chat.ID
+}
+
+// recordUsage accounts the token usage of a reply to the sender and chat
+func (b *Bot) recordUsage(message *tgbotapi.Message, reply *openai.Reply) {
+	if b.usageTracker == nil {
+		return
+	}
+
+	userID := senderID(message)
+	for model, u := range reply.Usage {
+		// The request is counted once, for the model that answered
+		requests := int64(0)
+		if model == reply.Model {
+			requests = 1
+		}
+
+		tokens := usage.Tokens{
+			Prompt:     int64(u.PromptTokens),
+			Cached:     int64(u.CachedTokens()),
+			Completion: int64(u.CompletionTokens),
+		}
+		if _, err := b.usageTracker.Record(userID, message.Chat.ID, model, tokens, requests); err != nil {
+			logger.Error("Error recording usage of %d: %v", userID, err)
+		}
+	}
+}
+
+// handleUsageCommand reports the caller's usage, or with "all" every user's usage to admins
+func (b *Bot) handleUsageCommand(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	if b.usageTracker == nil {
+		b.sendText(chatID, "Usage accounting is not enabled.")
+		return
+	}
+
+	if strings.TrimSpace(message.CommandArguments()) == "all" {
+		if b.auth.RoleOf(chatID) != config.RoleAdmin {
+			b.sendText(chatID, "Only admins can see the usage of all users.")
+			return
+		}
+		b.sendText(chatID, b.allUsageText())
+		return
+	}
+
+	b.sendText(chatID, b.userUsageText(senderID(message)))
+}
+
+// userUsageText summarizes a user's daily and monthly usage
+func (b *Bot) userUsageText(userID int64) string {
+	today, err := b.usageTracker.Totals(userID, usage.Daily)
+	if err != nil {
+		logger.Error("Error querying usage: %v", err)
+		return "Sorry, I couldn't load your usage."
+	}
+	month, err := b.usageTracker.Totals(userID, usage.Monthly)
+	if err != nil {
+		logger.Error("Error querying usage: %v", err)
+		return "Sorry, I couldn't load your usage."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("📊 Your usage\n\n")
+	sb.WriteString("Today: " + formatTotals(today) + "\n")
+	sb.WriteString("This month: " + formatTotals(month) + "\n")
+
+	if status, err := b.quotaStatus(userID); err != nil {
+		logger.Error("Error checking quota of %d: %v", userID, err)
+	} else if len(status) > 0 {
+		sb.WriteString("\nRemaining quota:\n" + quotaText(status))
+	}
+
+	byModel, err := b.usageTracker.ByModel(userID, usage.Monthly)
+	if err == nil && len(byModel) > 0 {
+		models := make([]string, 0, len(byModel))
+		for model := range byModel {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+
+		sb.WriteString("\nThis month by model:\n")
+		for _, model := range models {
+			sb.WriteString(fmt.Sprintf("• %s: %s\n", model, formatTotals(byModel[model])))
+		}
+	}
+	return sb.String()
+}
+
+// allUsageText summarizes the daily and monthly usage of every user
+func (b *Bot) allUsageText() string {
+	var sb strings.Builder
+	sb.WriteString("📊 Usage of all users\n")
+
+	for _, period := range []struct {
+		title  string
+		period usage.Period
+	}{{"Today", usage.Daily}, {"This month", usage.Monthly}} {
+		users, err := b.usageTracker.ByUser(period.period)
+		if err != nil {
+			logger.Error("Error querying usage: %v", err)
+			return "Sorry, I couldn't load the usage."
+		}
+
+		var total usage.Totals
+		sb.WriteString("\n" + period.title + ":\n")
+		if len(users) == 0 {
+			sb.WriteString("• no usage\n")
+		}
+		for _, user := range users {
+			sb.WriteString(fmt.Sprintf("• %d: %s\n", user.UserID, formatTotals(user.Totals)))
+			total.Requests += user.Requests
+			total.PromptTokens += user.PromptTokens
+			total.CachedTokens += user.CachedTokens
+			total.CompletionTokens += user.CompletionTokens
+			total.Cost += user.Cost
+		}
+		if len(users) > 1 {
+			sb.WriteString("Total: " + formatTotals(total) + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// formatTotals renders totals on a single line
+func formatTotals(t usage.Totals) string {
+	return fmt.Sprintf("%d requests, %d tokens (%d prompt, %d cached, %d completion), ~$%.4f",
+		t.Requests, t.TotalTokens(), t.PromptTokens, t.CachedTokens, t.CompletionTokens, t.Cost)
+}
@@ -0,0 +1,341 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxExpressionLength bounds the input accepted by the calculator
+const maxExpressionLength = 1000
+
+// Calculator evaluates arithmetic expressions
+type Calculator struct{}
+
+// NewCalculator creates the calculator tool
+func NewCalculator() *Calculator {
+	return &Calculator{}
+}
+
+// Name implements openai.Tool
+func (t *Calculator) Name() string { return CalculatorName }
+
+// Description implements openai.Tool
+func (t *Calculator) Description() string {
+	return "Evaluate an arithmetic expression. Supports + - * / % ^, parentheses, " +
+		"the constants pi and e and the functions sqrt, abs, ln, log10, exp, sin, cos, tan, round, floor, ceil, min, max."
+}
+
+// Parameters implements openai.Tool
+func (t *Calculator) Parameters() json.RawMessage {
+	return json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"expression": {"type": "string", "description": "Expression to evaluate, e.g. (3 + 4) * sqrt(16)"}
+		},
+		"required": ["expression"]
+	}`)
+}
+
+// Execute implements openai.Tool
+func (t *Calculator) Execute(ctx context.Context, userID int64, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := decodeArguments(arguments, &args); err != nil {
+		return "", err
+	}
+
+	result, err := Evaluate(args.Expression)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(result, 'g', -1, 64), nil
+}
+
+// Evaluate evaluates an arithmetic expression
+func Evaluate(expression string) (float64, error) {
+	if len(expression) > maxExpressionLength {
+		return 0, fmt.Errorf("expression is too long")
+	}
+
+	p := &parser{input: expression}
+	result, err := p.parseExpression()
+	if err != nil {
+		return 0, err
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos+1)
+	}
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, fmt.Errorf("result is not a finite number")
+	}
+	return result, nil
+}
+
+// parser is a recursive descent parser for arithmetic expressions
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space byte without consuming it
+func (p *parser) peek() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// expression = term { ("+" | "-") term }
+func (p *parser) parseExpression() (float64, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		switch p.peek() {
+		case '+':
+			p.pos++
+			right, err := p.parseTerm()
+			if err != nil {
+				return 0, err
+			}
+			left += right
+		case '-':
+			p.pos++
+			right, err := p.parseTerm()
+			if err != nil {
+				return 0, err
+			}
+			left -= right
+		default:
+			return left, nil
+		}
+	}
+}
+
+// term = unary { ("*" | "/" | "%") unary }
+func (p *parser) parseTerm() (float64, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case '*':
+			left *= right
+		case '/':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			left /= right
+		case '%':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			left = math.Mod(left, right)
+		}
+	}
+}
+
+// unary = ("-" | "+") unary | power
+func (p *parser) parseUnary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		value, err := p.parseUnary()
+		return -value, err
+	case '+':
+		p.pos++
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+// power = primary [ "^" unary ]
+func (p *parser) parsePower() (float64, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return 0, err
+	}
+
+	if p.peek() == '^' {
+		p.pos++
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		return math.Pow(base, exponent), nil
+	}
+	return base, nil
+}
+
+// primary = number | "(" expression ")" | identifier [ "(" arguments ")" ]
+func (p *parser) parsePrimary() (float64, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return 0, fmt.Errorf("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		value, err := p.parseExpression()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return value, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case unicode.IsLetter(rune(c)):
+		return p.parseIdentifier()
+	}
+	return 0, fmt.Errorf("unexpected %q at position %d", c, p.pos+1)
+}
+
+func (p *parser) parseNumber() (float64, error) {
+	start := p.pos
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		isExponent := (c == 'e' || c == 'E') && p.pos+1 < len(p.input) &&
+			(p.input[p.pos+1] >= '0' && p.input[p.pos+1] <= '9' || p.input[p.pos+1] == '-' || p.input[p.pos+1] == '+')
+		switch {
+		case c >= '0' && c <= '9', c == '.', c == '_':
+			p.pos++
+		case isExponent:
+			p.pos += 2
+		default:
+			return p.number(start)
+		}
+	}
+	return p.number(start)
+}
+
+func (p *parser) number(start int) (float64, error) {
+	text := strings.ReplaceAll(p.input[start:p.pos], "_", "")
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", text)
+	}
+	return value, nil
+}
+
+func (p *parser) parseIdentifier() (float64, error) {
+	start := p.pos
+	for p.pos < len(p.input) && (unicode.IsLetter(rune(p.input[p.pos])) || unicode.IsDigit(rune(p.input[p.pos]))) {
+		p.pos++
+	}
+	name := strings.ToLower(p.input[start:p.pos])
+
+	if p.peek() != '(' {
+		switch name {
+		case "pi":
+			return math.Pi, nil
+		case "e":
+			return math.E, nil
+		}
+		return 0, fmt.Errorf("unknown constant %q", name)
+	}
+	p.pos++
+
+	var args []float64
+	if p.peek() == ')' {
+		p.pos++
+	} else {
+		for {
+			arg, err := p.parseExpression()
+			if err != nil {
+				return 0, err
+			}
+			args = append(args, arg)
+
+			next := p.peek()
+			p.pos++
+			if next == ')' {
+				break
+			}
+			if next != ',' {
+				return 0, fmt.Errorf("expected ',' or ')' in arguments of %s", name)
+			}
+		}
+	}
+
+	return callFunction(name, args)
+}
+
+// callFunction applies a named math function
+func callFunction(name string, args []float64) (float64, error) {
+	unary := map[string]func(float64) float64{
+		"sqrt":  math.Sqrt,
+		"abs":   math.Abs,
+		"ln":    math.Log,
+		"log":   math.Log,
+		"log10": math.Log10,
+		"exp":   math.Exp,
+		"sin":   math.Sin,
+		"cos":   math.Cos,
+		"tan":   math.Tan,
+		"round": math.Round,
+		"floor": math.Floor,
+		"ceil":  math.Ceil,
+	}
+
+	if fn, ok := unary[name]; ok {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("%s expects 1 argument, got %d", name, len(args))
+		}
+		return fn(args[0]), nil
+	}
+
+	switch name {
+	case "min", "max":
+		if len(args) == 0 {
+			return 0, fmt.Errorf("%s expects at least 1 argument", name)
+		}
+		result := args[0]
+		for _, arg := range args[1:] {
+			if name == "min" {
+				result = math.Min(result, arg)
+			} else {
+				result = math.Max(result, arg)
+			}
+		}
+		return result, nil
+	case "pow":
+		if len(args) != 2 {
+			return 0, fmt.Errorf("pow expects 2 arguments, got %d", len(args))
+		}
+		return math.Pow(args[0], args[1]), nil
+	}
+
+	return 0, fmt.Errorf("unknown function %q", name)
+}
@@ -0,0 +1,61 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DateTime reports the current date and time in the user's timezone
+type DateTime struct {
+	locationFor func(userID int64) *time.Location
+	now         func() time.Time
+}
+
+// NewDateTime creates the date/time tool. locationFor resolves the default
+// timezone of a user when the model does not ask for a specific one.
+func NewDateTime(locationFor func(userID int64) *time.Location) *DateTime {
+	return &DateTime{locationFor: locationFor, now: time.Now}
+}
+
+// Name implements openai.Tool
+func (t *DateTime) Name() string { return DateTimeName }
+
+// Description implements openai.Tool
+func (t *DateTime) Description() string {
+	return "Get the current date, time and weekday. Uses the user's timezone unless another IANA timezone is given."
+}
+
+// Parameters implements openai.Tool
+func (t *DateTime) Parameters() json.RawMessage {
+	return json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"timezone": {"type": "string", "description": "IANA timezone such as Asia/Seoul or Europe/Berlin"}
+		}
+	}`)
+}
+
+// Execute implements openai.Tool
+func (t *DateTime) Execute(ctx context.Context, userID int64, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := decodeArguments(arguments, &args); err != nil {
+		return "", err
+	}
+
+	loc := time.UTC
+	if args.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(args.Timezone); err != nil {
+			return "", fmt.Errorf("unknown timezone %q", args.Timezone)
+		}
+	} else if t.locationFor != nil {
+		loc = t.locationFor(userID)
+	}
+
+	now := t.now().In(loc)
+	return fmt.Sprintf("%s (%s, %s)", now.Format(time.RFC3339), now.Weekday(), loc.String()), nil
+}
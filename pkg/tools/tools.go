@@ -0,0 +1,51 @@
+// Package tools provides the built-in tools the model can call
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/openai"
+)
+
+// Built-in tool names
+const (
+	DateTimeName      = "get_current_datetime"
+	CalculatorName    = "calculate"
+	UnitConverterName = "convert_units"
+)
+
+// Builtins returns the built-in tools enabled in the configuration.
+// An empty builtin list enables all of them. The date/time tool reports the
+// time in the timezones of the chats.
+func Builtins(cfg *config.ToolsConfig, timezones *config.TimezoneConfig) ([]openai.Tool, error) {
+	all := map[string]openai.Tool{
+		DateTimeName:      NewDateTime(timezones.LocationFor),
+		CalculatorName:    NewCalculator(),
+		UnitConverterName: NewUnitConverter(),
+	}
+
+	names := cfg.Builtin
+	if len(names) == 0 {
+		names = []string{DateTimeName, CalculatorName, UnitConverterName}
+	}
+
+	tools := make([]openai.Tool, 0, len(names))
+	for _, name := range names {
+		tool, ok := all[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown built-in tool %q", name)
+		}
+		tools = append(tools, tool)
+	}
+	return tools, nil
+}
+
+// decodeArguments unmarshals tool arguments into v
+func decodeArguments(arguments json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(arguments, v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
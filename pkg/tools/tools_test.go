@@ -0,0 +1,142 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/config"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"2 ^ 3 ^ 2", 512},
+		{"-2 ^ 2", -4},
+		{"10 % 4", 2},
+		{"sqrt(16) + abs(-3)", 7},
+		{"max(1, 5, 3) - min(4, 2)", 3},
+		{"2 * pi", 2 * math.Pi},
+		{"1.5e3 / 3", 500},
+		{"1_000 * 2", 2000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			result, err := Evaluate(tt.expression)
+			if err != nil {
+				t.Fatalf("Evaluate(%q) error = %v", tt.expression, err)
+			}
+			if math.Abs(result-tt.expected) > 1e-9 {
+				t.Errorf("Evaluate(%q) = %v, expected %v", tt.expression, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	for _, expression := range []string{"", "1 / 0", "(1 + 2", "foo(1)", "1 +", "2 3", "sqrt(-1)"} {
+		if _, err := Evaluate(expression); err == nil {
+			t.Errorf("Evaluate(%q) expected an error", expression)
+		}
+	}
+}
+
+func TestConvertUnits(t *testing.T) {
+	tests := []struct {
+		value    float64
+		from, to string
+		expected float64
+	}{
+		{1, "km", "m", 1000},
+		{1, "mile", "km", 1.609344},
+		{10, "lbs", "kg", 4.5359237},
+		{100, "C", "F", 212},
+		{32, "fahrenheit", "celsius", 0},
+		{0, "K", "C", -273.15},
+		{1, "GiB", "MiB", 1024},
+		{121, "pyeong", "m2", 400},
+		{36, "km/h", "m/s", 10},
+	}
+
+	for _, tt := range tests {
+		result, err := ConvertUnits(tt.value, tt.from, tt.to)
+		if err != nil {
+			t.Errorf("ConvertUnits(%v, %q, %q) error = %v", tt.value, tt.from, tt.to, err)
+			continue
+		}
+		if math.Abs(result-tt.expected) > 1e-9 {
+			t.Errorf("ConvertUnits(%v, %q, %q) = %v, expected %v", tt.value, tt.from, tt.to, result, tt.expected)
+		}
+	}
+
+	if _, err := ConvertUnits(1, "kg", "m"); err == nil {
+		t.Error("ConvertUnits() expected an error for incompatible units")
+	}
+	if _, err := ConvertUnits(1, "C", "kg"); err == nil {
+		t.Error("ConvertUnits() expected an error for temperature to mass")
+	}
+}
+
+func TestDateTimeUsesUserTimezone(t *testing.T) {
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	tool := NewDateTime(func(userID int64) *time.Location {
+		if userID == 1 {
+			return seoul
+		}
+		return time.UTC
+	})
+	tool.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	result, err := tool.Execute(context.Background(), 1, json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if !strings.HasPrefix(result, "2024-01-01T09:00:00+09:00") {
+		t.Errorf("Execute() = %q, expected Seoul time", result)
+	}
+
+	result, err = tool.Execute(context.Background(), 2, json.RawMessage(`{"timezone": "America/New_York"}`))
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if !strings.HasPrefix(result, "2023-12-31T19:00:00-05:00") {
+		t.Errorf("Execute() = %q, expected New York time", result)
+	}
+
+	if _, err := tool.Execute(context.Background(), 2, json.RawMessage(`{"timezone": "Mars/Olympus"}`)); err == nil {
+		t.Error("Execute() expected an error for an unknown timezone")
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	all, err := Builtins(&config.ToolsConfig{}, &config.TimezoneConfig{})
+	if err != nil {
+		t.Fatalf("Builtins() error = %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("Builtins() returned %d tools, expected 3", len(all))
+	}
+
+	some, err := Builtins(&config.ToolsConfig{Builtin: []string{CalculatorName}}, &config.TimezoneConfig{})
+	if err != nil {
+		t.Fatalf("Builtins() error = %v", err)
+	}
+	if len(some) != 1 || some[0].Name() != CalculatorName {
+		t.Errorf("Builtins() = %v, expected only the calculator", some)
+	}
+
+	if _, err := Builtins(&config.ToolsConfig{Builtin: []string{"shell"}}, &config.TimezoneConfig{}); err == nil {
+		t.Error("Builtins() expected an error for an unknown tool")
+	}
+}
@@ -0,0 +1,197 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// unit is a unit of measurement expressed as a factor of its dimension's base unit
+type unit struct {
+	dimension string
+	factor    float64
+}
+
+// units maps unit names and symbols to their definition.
+// Base units: metre, kilogram, litre, second, square metre, metre per second, byte.
+var units = map[string]unit{
+	// length
+	"mm": {"length", 0.001}, "millimeter": {"length", 0.001},
+	"cm": {"length", 0.01}, "centimeter": {"length", 0.01},
+	"m": {"length", 1}, "meter": {"length", 1},
+	"km": {"length", 1000}, "kilometer": {"length", 1000},
+	"in": {"length", 0.0254}, "inch": {"length", 0.0254},
+	"ft": {"length", 0.3048}, "foot": {"length", 0.3048}, "feet": {"length", 0.3048},
+	"yd": {"length", 0.9144}, "yard": {"length", 0.9144},
+	"mi": {"length", 1609.344}, "mile": {"length", 1609.344},
+	"nmi": {"length", 1852}, "nautical_mile": {"length", 1852},
+
+	// mass
+	"mg": {"mass", 1e-6}, "milligram": {"mass", 1e-6},
+	"g": {"mass", 0.001}, "gram": {"mass", 0.001},
+	"kg": {"mass", 1}, "kilogram": {"mass", 1},
+	"t": {"mass", 1000}, "tonne": {"mass", 1000},
+	"oz": {"mass", 0.028349523125}, "ounce": {"mass", 0.028349523125},
+	"lb": {"mass", 0.45359237}, "pound": {"mass", 0.45359237},
+
+	// volume
+	"ml": {"volume", 0.001}, "milliliter": {"volume", 0.001},
+	"l": {"volume", 1}, "liter": {"volume", 1},
+	"m3": {"volume", 1000}, "cubic_meter": {"volume", 1000},
+	"tsp": {"volume", 0.00492892159375}, "teaspoon": {"volume", 0.00492892159375},
+	"tbsp": {"volume", 0.01478676478125}, "tablespoon": {"volume", 0.01478676478125},
+	"cup":  {"volume", 0.2365882365},
+	"floz": {"volume", 0.0295735295625}, "fluid_ounce": {"volume", 0.0295735295625},
+	"gal": {"volume", 3.785411784}, "gallon": {"volume", 3.785411784},
+
+	// time
+	"ms": {"time", 0.001}, "millisecond": {"time", 0.001},
+	"s": {"time", 1}, "sec": {"time", 1}, "second": {"time", 1},
+	"min": {"time", 60}, "minute": {"time", 60},
+	"h": {"time", 3600}, "hr": {"time", 3600}, "hour": {"time", 3600},
+	"day":  {"time", 86400},
+	"week": {"time", 604800},
+
+	// area
+	"m2": {"area", 1}, "square_meter": {"area", 1},
+	"km2": {"area", 1e6}, "square_kilometer": {"area", 1e6},
+	"ft2": {"area", 0.09290304}, "square_foot": {"area", 0.09290304},
+	"ha": {"area", 10000}, "hectare": {"area", 10000},
+	"acre":   {"area", 4046.8564224},
+	"pyeong": {"area", 400.0 / 121.0},
+
+	// speed
+	"m/s":  {"speed", 1},
+	"km/h": {"speed", 1000.0 / 3600.0}, "kph": {"speed", 1000.0 / 3600.0},
+	"mph":  {"speed", 0.44704},
+	"knot": {"speed", 1852.0 / 3600.0}, "kn": {"speed", 1852.0 / 3600.0},
+
+	// data
+	"b": {"data", 1}, "byte": {"data", 1},
+	"kb": {"data", 1e3}, "kilobyte": {"data", 1e3},
+	"mb": {"data", 1e6}, "megabyte": {"data", 1e6},
+	"gb": {"data", 1e9}, "gigabyte": {"data", 1e9},
+	"tb": {"data", 1e12}, "terabyte": {"data", 1e12},
+	"kib": {"data", 1 << 10}, "mib": {"data", 1 << 20}, "gib": {"data", 1 << 30}, "tib": {"data", 1 << 40},
+}
+
+// temperatures are handled separately because they are not proportional
+var temperatures = map[string]string{
+	"c": "c", "celsius": "c", "°c": "c",
+	"f": "f", "fahrenheit": "f", "°f": "f",
+	"k": "k", "kelvin": "k",
+}
+
+// UnitConverter converts values between units of measurement
+type UnitConverter struct{}
+
+// NewUnitConverter creates the unit converter tool
+func NewUnitConverter() *UnitConverter {
+	return &UnitConverter{}
+}
+
+// Name implements openai.Tool
+func (t *UnitConverter) Name() string { return UnitConverterName }
+
+// Description implements openai.Tool
+func (t *UnitConverter) Description() string {
+	return "Convert a value between units of length, mass, volume, time, area, speed, data size and temperature " +
+		"(e.g. km to mi, lb to kg, F to C, pyeong to m2, GiB to GB)."
+}
+
+// Parameters implements openai.Tool
+func (t *UnitConverter) Parameters() json.RawMessage {
+	return json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"value": {"type": "number"},
+			"from": {"type": "string", "description": "Source unit symbol or name, e.g. km, lb, F"},
+			"to": {"type": "string", "description": "Target unit symbol or name, e.g. mi, kg, C"}
+		},
+		"required": ["value", "from", "to"]
+	}`)
+}
+
+// Execute implements openai.Tool
+func (t *UnitConverter) Execute(ctx context.Context, userID int64, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Value float64 `json:"value"`
+		From  string  `json:"from"`
+		To    string  `json:"to"`
+	}
+	if err := decodeArguments(arguments, &args); err != nil {
+		return "", err
+	}
+
+	result, err := ConvertUnits(args.Value, args.From, args.To)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s = %s %s",
+		strconv.FormatFloat(args.Value, 'g', -1, 64), args.From,
+		strconv.FormatFloat(result, 'g', 10, 64), args.To), nil
+}
+
+// ConvertUnits converts value from one unit to another
+func ConvertUnits(value float64, from, to string) (float64, error) {
+	fromKey, toKey := normalizeUnit(from), normalizeUnit(to)
+
+	fromTemp, fromIsTemp := temperatures[fromKey]
+	toTemp, toIsTemp := temperatures[toKey]
+	if fromIsTemp || toIsTemp {
+		if !fromIsTemp || !toIsTemp {
+			return 0, fmt.Errorf("cannot convert %s to %s", from, to)
+		}
+		return convertTemperature(value, fromTemp, toTemp), nil
+	}
+
+	fromUnit, ok := units[fromKey]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+	toUnit, ok := units[toKey]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+	if fromUnit.dimension != toUnit.dimension {
+		return 0, fmt.Errorf("cannot convert %s (%s) to %s (%s)", from, fromUnit.dimension, to, toUnit.dimension)
+	}
+
+	return value * fromUnit.factor / toUnit.factor, nil
+}
+
+// normalizeUnit lower-cases a unit and strips a plural "s" from unit names
+func normalizeUnit(name string) string {
+	key := strings.ToLower(strings.TrimSpace(name))
+	key = strings.ReplaceAll(key, " ", "_")
+	key = strings.ReplaceAll(key, "metre", "meter")
+	key = strings.ReplaceAll(key, "litre", "liter")
+	if _, ok := units[key]; ok {
+		return key
+	}
+	if _, ok := temperatures[key]; ok {
+		return key
+	}
+	return strings.TrimSuffix(key, "s")
+}
+
+func convertTemperature(value float64, from, to string) float64 {
+	// Convert to Celsius first
+	celsius := value
+	switch from {
+	case "f":
+		celsius = (value - 32) * 5 / 9
+	case "k":
+		celsius = value - 273.15
+	}
+
+	switch to {
+	case "f":
+		return celsius*9/5 + 32
+	case "k":
+		return celsius + 273.15
+	}
+	return celsius
+}
@@ -0,0 +1,151 @@
+package usage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/config"
+)
+
+// LimitKind is the quantity a quota limits
+type LimitKind int
+
+const (
+	// LimitTokens limits prompt plus completion tokens
+	LimitTokens LimitKind = iota
+	// LimitRequests limits the number of answered messages
+	LimitRequests
+	// LimitCost limits the estimated cost in USD
+	LimitCost
+)
+
+// String returns the name used in commands and messages
+func (k LimitKind) String() string {
+	switch k {
+	case LimitRequests:
+		return "requests"
+	case LimitCost:
+		return "cost"
+	}
+	return "tokens"
+}
+
+// ParseLimitKind parses "tokens", "requests" or "cost"
+func ParseLimitKind(s string) (LimitKind, error) {
+	for _, kind := range []LimitKind{LimitTokens, LimitRequests, LimitCost} {
+		if kind.String() == s {
+			return kind, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown quota kind %q, expected tokens, requests or cost", s)
+}
+
+// LimitStatus is the state of a single configured limit
+type LimitStatus struct {
+	Kind   LimitKind
+	Period Period
+	Used   float64
+	Limit  float64
+	Resets time.Time
+}
+
+// Remaining returns the allowance left in the period
+func (s LimitStatus) Remaining() float64 {
+	if s.Used >= s.Limit {
+		return 0
+	}
+	return s.Limit - s.Used
+}
+
+// Exceeded reports whether the allowance is used up
+func (s LimitStatus) Exceeded() bool {
+	return s.Used >= s.Limit
+}
+
+// QuotaStatus holds the configured limits of a user, boosts included
+type QuotaStatus []LimitStatus
+
+// Exceeded returns the first limit that is used up
+func (q QuotaStatus) Exceeded() (LimitStatus, bool) {
+	for _, status := range q {
+		if status.Exceeded() {
+			return status, true
+		}
+	}
+	return LimitStatus{}, false
+}
+
+// Quota compares a user's usage in the current periods with limits raised by
+// the user's active boosts. Limits that are not set are left out.
+func (t *Tracker) Quota(userID int64, limits config.QuotaLimits) (QuotaStatus, error) {
+	boosts, err := t.store.Boosts(userID, t.now())
+	if err != nil {
+		return nil, err
+	}
+	var extra Totals
+	for _, boost := range boosts {
+		extra.Requests += boost.Requests
+		extra.PromptTokens += boost.Tokens
+		extra.Cost += boost.Cost
+	}
+
+	var status QuotaStatus
+	for _, period := range []struct {
+		period   Period
+		tokens   int64
+		requests int64
+		cost     float64
+	}{
+		{Daily, limits.DailyTokens, limits.DailyRequests, limits.DailyCost},
+		{Monthly, limits.MonthlyTokens, limits.MonthlyRequests, limits.MonthlyCost},
+	} {
+		if period.tokens <= 0 && period.requests <= 0 && period.cost <= 0 {
+			continue
+		}
+
+		used, err := t.Totals(userID, period.period)
+		if err != nil {
+			return nil, err
+		}
+		_, resets := t.Bounds(period.period)
+
+		if period.tokens > 0 {
+			status = append(status, LimitStatus{LimitTokens, period.period,
+				float64(used.TotalTokens()), float64(period.tokens + extra.PromptTokens), resets})
+		}
+		if period.requests > 0 {
+			status = append(status, LimitStatus{LimitRequests, period.period,
+				float64(used.Requests), float64(period.requests + extra.Requests), resets})
+		}
+		if period.cost > 0 {
+			status = append(status, LimitStatus{LimitCost, period.period,
+				used.Cost, period.cost + extra.Cost, resets})
+		}
+	}
+	return status, nil
+}
+
+// Boost raises a user's limits of one kind by amount until the duration has passed
+func (t *Tracker) Boost(userID, grantedBy int64, kind LimitKind, amount float64, duration time.Duration) (Boost, error) {
+	if amount <= 0 {
+		return Boost{}, fmt.Errorf("boost amount must be positive")
+	}
+	if duration <= 0 {
+		return Boost{}, fmt.Errorf("boost duration must be positive")
+	}
+
+	boost := Boost{
+		UserID:    userID,
+		ExpiresAt: t.now().Add(duration),
+		GrantedBy: grantedBy,
+	}
+	switch kind {
+	case LimitTokens:
+		boost.Tokens = int64(amount)
+	case LimitRequests:
+		boost.Requests = int64(amount)
+	case LimitCost:
+		boost.Cost = amount
+	}
+	return boost, t.store.AddBoost(boost)
+}
@@ -0,0 +1,308 @@
+// Package usage accounts token usage and estimated cost per user, chat and model
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Record holds the usage counters of one user in one chat for one model on one day
+type Record struct {
+	UserID           int64   `json:"user_id"`
+	ChatID           int64   `json:"chat_id"`
+	Model            string  `json:"model"`
+	Day              string  `json:"day"` // YYYY-MM-DD in the configured timezone
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CachedTokens     int64   `json:"cached_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// TotalTokens returns prompt plus completion tokens
+func (r Record) TotalTokens() int64 {
+	return r.PromptTokens + r.CompletionTokens
+}
+
+// add accumulates the counters of other into r
+func (r *Record) add(other Record) {
+	r.Requests += other.Requests
+	r.PromptTokens += other.PromptTokens
+	r.CachedTokens += other.CachedTokens
+	r.CompletionTokens += other.CompletionTokens
+	r.Cost += other.Cost
+}
+
+// key identifies the bucket a record belongs to
+func (r Record) key() string {
+	return fmt.Sprintf("%d|%d|%s|%s", r.UserID, r.ChatID, r.Model, r.Day)
+}
+
+// Filter selects records. Zero values match everything; days are inclusive.
+type Filter struct {
+	UserID  int64
+	FromDay string
+	ToDay   string
+}
+
+// matches reports whether the record passes the filter
+func (f Filter) matches(r Record) bool {
+	if f.UserID != 0 && r.UserID != f.UserID {
+		return false
+	}
+	if f.FromDay != "" && r.Day < f.FromDay {
+		return false
+	}
+	if f.ToDay != "" && r.Day > f.ToDay {
+		return false
+	}
+	return true
+}
+
+// Boost is a temporary increase of a user's quota
+type Boost struct {
+	UserID    int64     `json:"user_id"`
+	Tokens    int64     `json:"tokens,omitempty"`
+	Requests  int64     `json:"requests,omitempty"`
+	Cost      float64   `json:"cost,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	GrantedBy int64     `json:"granted_by"`
+}
+
+// Store persists usage records and quota boosts
+type Store interface {
+	// Add increments the counters of the bucket matching the record
+	Add(record Record) error
+	// Query returns the records matching the filter
+	Query(filter Filter) ([]Record, error)
+	// AddBoost stores a quota boost
+	AddBoost(boost Boost) error
+	// Boosts returns the boosts of a user that have not expired at now
+	Boosts(userID int64, now time.Time) ([]Boost, error)
+	// DeleteUser removes the records and boosts of a user and returns how many records were removed
+	DeleteUser(userID int64) (int, error)
+	// Close flushes and releases the store
+	Close() error
+}
+
+// MemoryStore keeps usage records in memory
+type MemoryStore struct {
+	records map[string]*Record
+	boosts  []Boost
+	mutex   sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]*Record)}
+}
+
+// AddBoost implements Store
+func (s *MemoryStore) AddBoost(boost Boost) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Drop expired boosts while we are here
+	active := s.boosts[:0]
+	for _, b := range s.boosts {
+		if b.ExpiresAt.After(time.Now()) {
+			active = append(active, b)
+		}
+	}
+	s.boosts = append(active, boost)
+	return nil
+}
+
+// Boosts implements Store
+func (s *MemoryStore) Boosts(userID int64, now time.Time) ([]Boost, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var boosts []Boost
+	for _, b := range s.boosts {
+		if b.UserID == userID && b.ExpiresAt.After(now) {
+			boosts = append(boosts, b)
+		}
+	}
+	return boosts, nil
+}
+
+// Add implements Store
+func (s *MemoryStore) Add(record Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if existing, ok := s.records[record.key()]; ok {
+		existing.add(record)
+		return nil
+	}
+	s.records[record.key()] = &record
+	return nil
+}
+
+// Query implements Store
+func (s *MemoryStore) Query(filter Filter) ([]Record, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var records []Record
+	for _, record := range s.records {
+		if filter.matches(*record) {
+			records = append(records, *record)
+		}
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+// DeleteUser implements Store
+func (s *MemoryStore) DeleteUser(userID int64) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := 0
+	for key, record := range s.records {
+		if record.UserID == userID {
+			delete(s.records, key)
+			removed++
+		}
+	}
+
+	kept := s.boosts[:0]
+	for _, b := range s.boosts {
+		if b.UserID != userID {
+			kept = append(kept, b)
+		}
+	}
+	s.boosts = kept
+	return removed, nil
+}
+
+// Close implements Store
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// fileContents is the layout of the usage file
+type fileContents struct {
+	Records []Record `json:"records"`
+	Boosts  []Boost  `json:"boosts,omitempty"`
+}
+
+// FileStore keeps usage records in memory and writes them to a JSON file after every change
+type FileStore struct {
+	*MemoryStore
+	path       string
+	flushMutex sync.Mutex
+}
+
+// NewFileStore loads the records from path, creating the file on first write
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading usage file: %w", err)
+	}
+
+	var contents fileContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		// Files written before boosts existed hold a plain list of records
+		if err := json.Unmarshal(data, &contents.Records); err != nil {
+			return nil, fmt.Errorf("error decoding usage file: %w", err)
+		}
+	}
+	for _, record := range contents.Records {
+		_ = s.MemoryStore.Add(record)
+	}
+	s.boosts = contents.Boosts
+	return s, nil
+}
+
+// Add implements Store
+func (s *FileStore) Add(record Record) error {
+	if err := s.MemoryStore.Add(record); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// AddBoost implements Store
+func (s *FileStore) AddBoost(boost Boost) error {
+	if err := s.MemoryStore.AddBoost(boost); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// DeleteUser implements Store
+func (s *FileStore) DeleteUser(userID int64) (int, error) {
+	removed, err := s.MemoryStore.DeleteUser(userID)
+	if err != nil {
+		return 0, err
+	}
+	return removed, s.flush()
+}
+
+// Close implements Store
+func (s *FileStore) Close() error {
+	return s.flush()
+}
+
+// flush atomically replaces the file with the current records
+func (s *FileStore) flush() error {
+	s.flushMutex.Lock()
+	defer s.flushMutex.Unlock()
+
+	records, _ := s.MemoryStore.Query(Filter{})
+	s.mutex.RLock()
+	boosts := append([]Boost(nil), s.boosts...)
+	s.mutex.RUnlock()
+
+	data, err := json.MarshalIndent(fileContents{Records: records, Boosts: boosts}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding usage records: %w", err)
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error replacing %s: %w", path, err)
+	}
+	return nil
+}
+
+// sortRecords orders records by day, user, chat and model
+func sortRecords(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		if a.ChatID != b.ChatID {
+			return a.ChatID < b.ChatID
+		}
+		return a.Model < b.Model
+	})
+}
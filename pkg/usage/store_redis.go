@@ -0,0 +1,232 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTimeout bounds every Redis round trip of the store
+const redisTimeout = 5 * time.Second
+
+// RedisStore keeps usage records and boosts in Redis so that replicas share them.
+// Each bucket is a hash of counters incremented atomically; a sorted set of days
+// and a set of buckets per day index them for queries.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisStore creates a store on top of a Redis client, namespacing keys with prefix
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix + "usage:"}
+}
+
+// bucketID identifies a bucket within a day
+func bucketID(r Record) string {
+	return fmt.Sprintf("%d|%d|%s", r.UserID, r.ChatID, r.Model)
+}
+
+func (s *RedisStore) daysKey() string           { return s.prefix + "days" }
+func (s *RedisStore) dayKey(day string) string  { return s.prefix + "day:" + day }
+func (s *RedisStore) bucketKey(r Record) string { return s.prefix + "bucket:" + r.key() }
+func (s *RedisStore) boostsKey(userID int64) string {
+	return s.prefix + "boosts:" + strconv.FormatInt(userID, 10)
+}
+
+// Add implements Store
+func (s *RedisStore) Add(record Record) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	key := s.bucketKey(record)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, s.daysKey(), redis.Z{Member: record.Day})
+		pipe.SAdd(ctx, s.dayKey(record.Day), bucketID(record))
+		pipe.HIncrBy(ctx, key, "requests", record.Requests)
+		pipe.HIncrBy(ctx, key, "prompt_tokens", record.PromptTokens)
+		pipe.HIncrBy(ctx, key, "cached_tokens", record.CachedTokens)
+		pipe.HIncrBy(ctx, key, "completion_tokens", record.CompletionTokens)
+		pipe.HIncrByFloat(ctx, key, "cost", record.Cost)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error recording usage in Redis: %w", err)
+	}
+	return nil
+}
+
+// Query implements Store
+func (s *RedisStore) Query(filter Filter) ([]Record, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	from, to := "-", "+"
+	if filter.FromDay != "" {
+		from = "[" + filter.FromDay
+	}
+	if filter.ToDay != "" {
+		to = "[" + filter.ToDay
+	}
+	days, err := s.client.ZRangeByLex(ctx, s.daysKey(), &redis.ZRangeBy{Min: from, Max: to}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error querying usage days: %w", err)
+	}
+
+	var records []Record
+	for _, day := range days {
+		ids, err := s.client.SMembers(ctx, s.dayKey(day)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("error querying usage buckets: %w", err)
+		}
+
+		for _, id := range ids {
+			record, ok := parseBucketID(id)
+			if !ok {
+				continue
+			}
+			record.Day = day
+			if !filter.matches(record) {
+				continue
+			}
+
+			counters, err := s.client.HGetAll(ctx, s.bucketKey(record)).Result()
+			if err != nil {
+				return nil, fmt.Errorf("error reading usage bucket: %w", err)
+			}
+			record.Requests, _ = strconv.ParseInt(counters["requests"], 10, 64)
+			record.PromptTokens, _ = strconv.ParseInt(counters["prompt_tokens"], 10, 64)
+			record.CachedTokens, _ = strconv.ParseInt(counters["cached_tokens"], 10, 64)
+			record.CompletionTokens, _ = strconv.ParseInt(counters["completion_tokens"], 10, 64)
+			record.Cost, _ = strconv.ParseFloat(counters["cost"], 64)
+			records = append(records, record)
+		}
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+// parseBucketID splits a bucket ID into the user, chat and model of a record
+func parseBucketID(id string) (Record, bool) {
+	parts := strings.SplitN(id, "|", 3)
+	if len(parts) != 3 {
+		return Record{}, false
+	}
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Record{}, false
+	}
+	chatID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Record{}, false
+	}
+	return Record{UserID: userID, ChatID: chatID, Model: parts[2]}, true
+}
+
+// AddBoost implements Store. Boosts are kept in a sorted set per user scored by
+// their expiry; the set itself expires with its last boost.
+func (s *RedisStore) AddBoost(boost Boost) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := json.Marshal(boost)
+	if err != nil {
+		return fmt.Errorf("error encoding boost: %w", err)
+	}
+
+	key := s.boostsKey(boost.UserID)
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(time.Now().Unix(), 10))
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(boost.ExpiresAt.Unix()), Member: data})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error storing boost in Redis: %w", err)
+	}
+
+	// Keep the set as long as its latest boost
+	latest, err := s.client.ZRangeWithScores(ctx, key, -1, -1).Result()
+	if err == nil && len(latest) == 1 {
+		err = s.client.ExpireAt(ctx, key, time.Unix(int64(latest[0].Score), 0)).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("error setting boost expiry: %w", err)
+	}
+	return nil
+}
+
+// Boosts implements Store
+func (s *RedisStore) Boosts(userID int64, now time.Time) ([]Boost, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	members, err := s.client.ZRangeByScore(ctx, s.boostsKey(userID), &redis.ZRangeBy{
+		Min: strconv.FormatInt(now.Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error querying boosts: %w", err)
+	}
+
+	var boosts []Boost
+	for _, member := range members {
+		var boost Boost
+		if err := json.Unmarshal([]byte(member), &boost); err != nil {
+			return nil, fmt.Errorf("error decoding boost: %w", err)
+		}
+		if boost.ExpiresAt.After(now) {
+			boosts = append(boosts, boost)
+		}
+	}
+	return boosts, nil
+}
+
+// DeleteUser implements Store
+func (s *RedisStore) DeleteUser(userID int64) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	days, err := s.client.ZRange(ctx, s.daysKey(), 0, -1).Result()
+	if err != nil {
+		return 0, fmt.Errorf("error querying usage days: %w", err)
+	}
+
+	removed := 0
+	for _, day := range days {
+		ids, err := s.client.SMembers(ctx, s.dayKey(day)).Result()
+		if err != nil {
+			return removed, fmt.Errorf("error querying usage buckets: %w", err)
+		}
+		for _, id := range ids {
+			record, ok := parseBucketID(id)
+			if !ok || record.UserID != userID {
+				continue
+			}
+			record.Day = day
+			_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.SRem(ctx, s.dayKey(day), id)
+				pipe.Del(ctx, s.bucketKey(record))
+				return nil
+			})
+			if err != nil {
+				return removed, fmt.Errorf("error deleting usage bucket: %w", err)
+			}
+			removed++
+		}
+	}
+
+	if err := s.client.Del(ctx, s.boostsKey(userID)).Err(); err != nil {
+		return removed, fmt.Errorf("error deleting boosts: %w", err)
+	}
+	return removed, nil
+}
+
+// Close implements Store. The Redis client is owned by the caller.
+func (s *RedisStore) Close() error {
+	return nil
+}
@@ -0,0 +1,278 @@
+package usage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/itswryu/telegpt/pkg/privacy"
+	"github.com/redis/go-redis/v9"
+)
+
+// dayLayout is the format of Record.Day
+const dayLayout = "2006-01-02"
+
+// Tokens are the token counts reported for one or more API calls
+type Tokens struct {
+	Prompt     int64
+	Cached     int64
+	Completion int64
+}
+
+// Period is an accounting period
+type Period int
+
+const (
+	// Daily is the current calendar day
+	Daily Period = iota
+	// Monthly is the current calendar month
+	Monthly
+)
+
+// Totals are accumulated counters
+type Totals struct {
+	Requests         int64
+	PromptTokens     int64
+	CachedTokens     int64
+	CompletionTokens int64
+	Cost             float64
+}
+
+// TotalTokens returns prompt plus completion tokens
+func (t Totals) TotalTokens() int64 {
+	return t.PromptTokens + t.CompletionTokens
+}
+
+func (t *Totals) add(r Record) {
+	t.Requests += r.Requests
+	t.PromptTokens += r.PromptTokens
+	t.CachedTokens += r.CachedTokens
+	t.CompletionTokens += r.CompletionTokens
+	t.Cost += r.Cost
+}
+
+// Tracker records usage and computes estimated costs
+type Tracker struct {
+	store  Store
+	prices map[string]config.ModelPrice
+	loc    *time.Location
+	now    func() time.Time
+}
+
+// NewStore creates the usage store selected in the configuration. The Redis
+// client and key prefix are only used by the redis store.
+func NewStore(cfg *config.UsageConfig, redisClient redis.UniversalClient, redisPrefix string) (Store, error) {
+	switch cfg.Store {
+	case "memory":
+		return NewMemoryStore(), nil
+	case "file", "":
+		return NewFileStore(cfg.Path)
+	case "redis":
+		if redisClient == nil {
+			return nil, fmt.Errorf("usage store redis requires a Redis connection")
+		}
+		return NewRedisStore(redisClient, redisPrefix), nil
+	}
+	return nil, fmt.Errorf("unknown usage store %q", cfg.Store)
+}
+
+// NewTracker creates a tracker on top of a store
+func NewTracker(store Store, cfg *config.UsageConfig) (*Tracker, error) {
+	loc := time.UTC
+	if cfg.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid usage timezone: %w", err)
+		}
+	}
+
+	return &Tracker{
+		store:  store,
+		prices: cfg.Prices,
+		loc:    loc,
+		now:    time.Now,
+	}, nil
+}
+
+// Store returns the underlying store
+func (t *Tracker) Store() Store {
+	return t.store
+}
+
+// DataSource exposes the usage records and quota boosts of users for privacy
+// exports and erasure
+func (t *Tracker) DataSource() privacy.Source {
+	return trackerSource{t}
+}
+
+// trackerSource implements privacy.Source for a tracker
+type trackerSource struct {
+	tracker *Tracker
+}
+
+// Name implements privacy.Source
+func (trackerSource) Name() string {
+	return "usage"
+}
+
+// Export implements privacy.Source
+func (s trackerSource) Export(userID int64) (interface{}, error) {
+	store := s.tracker.store
+	records, err := store.Query(Filter{UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+	boosts, err := store.Boosts(userID, s.tracker.now())
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 && len(boosts) == 0 {
+		return nil, nil
+	}
+	return fileContents{Records: records, Boosts: boosts}, nil
+}
+
+// Delete implements privacy.Source
+func (s trackerSource) Delete(userID int64) error {
+	_, err := s.tracker.store.DeleteUser(userID)
+	return err
+}
+
+// Record adds the usage of a model for a user in a chat and returns its estimated cost
+func (t *Tracker) Record(userID, chatID int64, model string, tokens Tokens, requests int64) (float64, error) {
+	cost := t.Cost(model, tokens)
+	err := t.store.Add(Record{
+		UserID:           userID,
+		ChatID:           chatID,
+		Model:            model,
+		Day:              t.now().In(t.loc).Format(dayLayout),
+		Requests:         requests,
+		PromptTokens:     tokens.Prompt,
+		CachedTokens:     tokens.Cached,
+		CompletionTokens: tokens.Completion,
+		Cost:             cost,
+	})
+	return cost, err
+}
+
+// Cost estimates the cost of tokens in USD. Models without a price cost nothing.
+func (t *Tracker) Cost(model string, tokens Tokens) float64 {
+	price, ok := t.price(model)
+	if !ok {
+		return 0
+	}
+
+	cachedPrice := price.CachedPrompt
+	if cachedPrice == 0 {
+		cachedPrice = price.Prompt
+	}
+
+	uncached := tokens.Prompt - tokens.Cached
+	if uncached < 0 {
+		uncached = 0
+	}
+	return (float64(uncached)*price.Prompt +
+		float64(tokens.Cached)*cachedPrice +
+		float64(tokens.Completion)*price.Completion) / 1e6
+}
+
+// price finds the price of a model, falling back to the longest configured
+// prefix so that dated snapshots such as gpt-4.1-nano-2025-04-14 are matched
+func (t *Tracker) price(model string) (config.ModelPrice, bool) {
+	if price, ok := t.prices[model]; ok {
+		return price, true
+	}
+
+	best := ""
+	for name := range t.prices {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return config.ModelPrice{}, false
+	}
+	return t.prices[best], true
+}
+
+// Bounds returns the first day of the period containing now and the start of the next period
+func (t *Tracker) Bounds(period Period) (string, time.Time) {
+	now := t.now().In(t.loc)
+	if period == Monthly {
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, t.loc)
+		return start.Format(dayLayout), start.AddDate(0, 1, 0)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, t.loc)
+	return start.Format(dayLayout), start.AddDate(0, 0, 1)
+}
+
+// Totals returns a user's totals for the current period
+func (t *Tracker) Totals(userID int64, period Period) (Totals, error) {
+	from, _ := t.Bounds(period)
+	records, err := t.store.Query(Filter{UserID: userID, FromDay: from})
+	if err != nil {
+		return Totals{}, err
+	}
+
+	var totals Totals
+	for _, record := range records {
+		totals.add(record)
+	}
+	return totals, nil
+}
+
+// ByModel returns a user's totals for the current period per model
+func (t *Tracker) ByModel(userID int64, period Period) (map[string]Totals, error) {
+	from, _ := t.Bounds(period)
+	records, err := t.store.Query(Filter{UserID: userID, FromDay: from})
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]Totals)
+	for _, record := range records {
+		model := totals[record.Model]
+		model.add(record)
+		totals[record.Model] = model
+	}
+	return totals, nil
+}
+
+// UserTotals is the totals of a single user
+type UserTotals struct {
+	UserID int64
+	Totals
+}
+
+// ByUser returns the totals of every user for the current period, most expensive first
+func (t *Tracker) ByUser(period Period) ([]UserTotals, error) {
+	from, _ := t.Bounds(period)
+	records, err := t.store.Query(Filter{FromDay: from})
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := make(map[int64]*UserTotals)
+	for _, record := range records {
+		user, ok := byUser[record.UserID]
+		if !ok {
+			user = &UserTotals{UserID: record.UserID}
+			byUser[record.UserID] = user
+		}
+		user.add(record)
+	}
+
+	users := make([]UserTotals, 0, len(byUser))
+	for _, user := range byUser {
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Cost != users[j].Cost {
+			return users[i].Cost > users[j].Cost
+		}
+		return users[i].TotalTokens() > users[j].TotalTokens()
+	})
+	return users, nil
+}
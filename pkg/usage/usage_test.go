@@ -0,0 +1,306 @@
+package usage
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/itswryu/telegpt/pkg/config"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestTracker(t *testing.T, store Store, now time.Time) *Tracker {
+	t.Helper()
+
+	tracker, err := NewTracker(store, &config.UsageConfig{
+		Timezone: "Asia/Seoul",
+		Prices: map[string]config.ModelPrice{
+			"gpt-4.1-nano": {Prompt: 0.10, CachedPrompt: 0.025, Completion: 0.40},
+			"gpt-4.1":      {Prompt: 2.00, Completion: 8.00},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewTracker() error = %v", err)
+	}
+	tracker.now = func() time.Time { return now }
+	return tracker
+}
+
+func TestCost(t *testing.T) {
+	tracker := newTestTracker(t, NewMemoryStore(), time.Now())
+
+	tests := []struct {
+		model    string
+		tokens   Tokens
+		expected float64
+	}{
+		{"gpt-4.1-nano", Tokens{Prompt: 1_000_000, Completion: 1_000_000}, 0.50},
+		{"gpt-4.1-nano", Tokens{Prompt: 1_000_000, Cached: 1_000_000}, 0.025},
+		{"gpt-4.1-nano-2025-04-14", Tokens{Prompt: 1_000_000}, 0.10},
+		// Without a cached price the prompt price applies
+		{"gpt-4.1", Tokens{Prompt: 1_000_000, Cached: 500_000}, 2.00},
+		{"unknown-model", Tokens{Prompt: 1_000_000}, 0},
+	}
+
+	for _, tt := range tests {
+		if cost := tracker.Cost(tt.model, tt.tokens); math.Abs(cost-tt.expected) > 1e-9 {
+			t.Errorf("Cost(%q, %+v) = %v, expected %v", tt.model, tt.tokens, cost, tt.expected)
+		}
+	}
+}
+
+func TestTotalsPerPeriod(t *testing.T) {
+	store := NewMemoryStore()
+	// 2024-03-15 10:00 in Seoul
+	now := time.Date(2024, 3, 15, 1, 0, 0, 0, time.UTC)
+	tracker := newTestTracker(t, store, now)
+
+	// Earlier this month and last month
+	store.Add(Record{UserID: 1, ChatID: 1, Model: "gpt-4.1-nano", Day: "2024-03-01", Requests: 2, PromptTokens: 100, CompletionTokens: 50})
+	store.Add(Record{UserID: 1, ChatID: 1, Model: "gpt-4.1-nano", Day: "2024-02-28", Requests: 5, PromptTokens: 1000})
+
+	if _, err := tracker.Record(1, 1, "gpt-4.1-nano", Tokens{Prompt: 10, Completion: 20}, 1); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if _, err := tracker.Record(1, 1, "gpt-4.1", Tokens{Prompt: 5}, 0); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if _, err := tracker.Record(2, 2, "gpt-4.1-nano", Tokens{Prompt: 1}, 1); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	today, _ := tracker.Totals(1, Daily)
+	if today.Requests != 1 || today.TotalTokens() != 35 {
+		t.Errorf("Daily totals = %+v, expected 1 request and 35 tokens", today)
+	}
+
+	month, _ := tracker.Totals(1, Monthly)
+	if month.Requests != 3 || month.TotalTokens() != 185 {
+		t.Errorf("Monthly totals = %+v, expected 3 requests and 185 tokens", month)
+	}
+
+	byModel, _ := tracker.ByModel(1, Daily)
+	if len(byModel) != 2 || byModel["gpt-4.1"].PromptTokens != 5 {
+		t.Errorf("ByModel() = %+v", byModel)
+	}
+
+	users, _ := tracker.ByUser(Daily)
+	if len(users) != 2 || users[0].UserID != 1 {
+		t.Errorf("ByUser() = %+v, expected user 1 first", users)
+	}
+
+	day, reset := tracker.Bounds(Monthly)
+	if day != "2024-03-01" || !reset.Equal(time.Date(2024, 4, 1, 0, 0, 0, 0, tracker.loc)) {
+		t.Errorf("Bounds(Monthly) = %s, %v", day, reset)
+	}
+}
+
+func TestFileStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage", "usage.json")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	store.Add(Record{UserID: 1, ChatID: 1, Model: "m", Day: "2024-03-15", Requests: 1, PromptTokens: 10})
+	store.Add(Record{UserID: 1, ChatID: 1, Model: "m", Day: "2024-03-15", Requests: 1, PromptTokens: 5})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	records, _ := reopened.Query(Filter{UserID: 1})
+	if len(records) != 1 || records[0].Requests != 2 || records[0].PromptTokens != 15 {
+		t.Errorf("Reloaded records = %+v, expected one bucket with 2 requests and 15 tokens", records)
+	}
+}
+
+func TestQuotaWithBoost(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2024, 3, 15, 1, 0, 0, 0, time.UTC)
+	tracker := newTestTracker(t, store, now)
+
+	store.Add(Record{UserID: 1, ChatID: 1, Model: "gpt-4.1-nano", Day: "2024-03-15", Requests: 3, PromptTokens: 800, CompletionTokens: 200})
+	store.Add(Record{UserID: 1, ChatID: 1, Model: "gpt-4.1-nano", Day: "2024-03-01", Requests: 10, PromptTokens: 100})
+
+	limits := config.QuotaLimits{DailyTokens: 1000, MonthlyRequests: 20}
+	status, err := tracker.Quota(1, limits)
+	if err != nil {
+		t.Fatalf("Quota() error = %v", err)
+	}
+	if len(status) != 2 {
+		t.Fatalf("Quota() = %+v, expected 2 limits", status)
+	}
+	exceeded, ok := status.Exceeded()
+	if !ok || exceeded.Kind != LimitTokens || exceeded.Period != Daily {
+		t.Errorf("Exceeded() = %+v, %v, expected daily tokens", exceeded, ok)
+	}
+	if !exceeded.Resets.Equal(time.Date(2024, 3, 16, 0, 0, 0, 0, tracker.loc)) {
+		t.Errorf("Resets = %v, expected midnight in Seoul", exceeded.Resets)
+	}
+	if remaining := status[1].Remaining(); remaining != 7 {
+		t.Errorf("Remaining() = %v, expected 7 monthly requests", remaining)
+	}
+
+	if _, err := tracker.Boost(1, 99, LimitTokens, 500, time.Hour); err != nil {
+		t.Fatalf("Boost() error = %v", err)
+	}
+	if _, err := tracker.Boost(1, 99, LimitTokens, 500, -time.Hour); err == nil {
+		t.Errorf("Boost() with negative duration should fail")
+	}
+
+	status, _ = tracker.Quota(1, limits)
+	if _, ok := status.Exceeded(); ok {
+		t.Errorf("Quota() after boost = %+v, expected no exceeded limit", status)
+	}
+	if status[0].Remaining() != 500 {
+		t.Errorf("Remaining() = %v, expected 500 boosted tokens", status[0].Remaining())
+	}
+
+	tracker.now = func() time.Time { return now.Add(2 * time.Hour) }
+	status, _ = tracker.Quota(1, limits)
+	if _, ok := status.Exceeded(); !ok {
+		t.Errorf("Quota() after boost expired = %+v, expected daily tokens exceeded", status)
+	}
+}
+
+func TestFileStoreBoostsAndLegacyFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	legacy := `[{"user_id": 1, "chat_id": 1, "model": "m", "day": "2024-03-15", "requests": 2}]`
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := store.AddBoost(Boost{UserID: 1, Requests: 5, ExpiresAt: expires}); err != nil {
+		t.Fatalf("AddBoost() error = %v", err)
+	}
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	records, _ := reopened.Query(Filter{UserID: 1})
+	if len(records) != 1 || records[0].Requests != 2 {
+		t.Errorf("Reloaded records = %+v, expected the legacy record", records)
+	}
+	boosts, _ := reopened.Boosts(1, time.Now())
+	if len(boosts) != 1 || boosts[0].Requests != 5 || !boosts[0].ExpiresAt.Equal(expires) {
+		t.Errorf("Reloaded boosts = %+v", boosts)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	store := NewRedisStore(client, "test:")
+
+	store.Add(Record{UserID: 1, ChatID: 1, Model: "m", Day: "2024-03-15", Requests: 1, PromptTokens: 10, Cost: 0.25})
+	store.Add(Record{UserID: 1, ChatID: 1, Model: "m", Day: "2024-03-15", Requests: 1, PromptTokens: 5, Cost: 0.5})
+	store.Add(Record{UserID: 1, ChatID: 1, Model: "m", Day: "2024-02-01", Requests: 3})
+	store.Add(Record{UserID: 2, ChatID: 2, Model: "m", Day: "2024-03-16", Requests: 1})
+
+	records, err := store.Query(Filter{UserID: 1, FromDay: "2024-03-01"})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(records) != 1 || records[0].Requests != 2 || records[0].PromptTokens != 15 || math.Abs(records[0].Cost-0.75) > 1e-9 {
+		t.Errorf("Query() = %+v, expected one bucket with 2 requests, 15 tokens and $0.75", records)
+	}
+
+	if records, _ := store.Query(Filter{ToDay: "2024-03-15"}); len(records) != 2 {
+		t.Errorf("Query(ToDay) = %+v, expected 2 buckets", records)
+	}
+
+	// A tracker on top of another store instance sees the same counters
+	tracker := newTestTracker(t, NewRedisStore(client, "test:"), time.Date(2024, 3, 15, 1, 0, 0, 0, time.UTC))
+	if totals, _ := tracker.Totals(1, Monthly); totals.Requests != 2 {
+		t.Errorf("Totals() = %+v, expected 2 requests", totals)
+	}
+
+	now := time.Now()
+	if err := store.AddBoost(Boost{UserID: 1, Tokens: 100, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("AddBoost() error = %v", err)
+	}
+	if boosts, _ := store.Boosts(1, now); len(boosts) != 1 || boosts[0].Tokens != 100 {
+		t.Errorf("Boosts() = %+v, expected the boost", boosts)
+	}
+	if boosts, _ := store.Boosts(1, now.Add(2*time.Hour)); len(boosts) != 0 {
+		t.Errorf("Boosts() after expiry = %+v, expected none", boosts)
+	}
+
+	// The boost set expires with its last boost
+	server.FastForward(2 * time.Hour)
+	if server.Exists("test:usage:boosts:1") {
+		t.Error("Boost set should have expired")
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	fileStore, err := NewFileStore(filepath.Join(t.TempDir(), "usage.json"))
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	stores := map[string]Store{
+		"memory": NewMemoryStore(),
+		"file":   fileStore,
+		"redis":  NewRedisStore(client, "test:"),
+	}
+
+	now := time.Date(2024, 3, 15, 1, 0, 0, 0, time.UTC)
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			store.Add(Record{UserID: 1, ChatID: 1, Model: "m", Day: "2024-03-14", Requests: 1})
+			store.Add(Record{UserID: 1, ChatID: -5, Model: "m", Day: "2024-03-15", Requests: 1})
+			store.Add(Record{UserID: 2, ChatID: -5, Model: "m", Day: "2024-03-15", Requests: 1})
+			store.AddBoost(Boost{UserID: 1, Tokens: 100, ExpiresAt: time.Now().Add(time.Hour)})
+
+			tracker := newTestTracker(t, store, now)
+			source := tracker.DataSource()
+			if data, err := source.Export(1); err != nil || data == nil {
+				t.Fatalf("Export() = %v, %v, expected the records", data, err)
+			}
+
+			removed, err := store.DeleteUser(1)
+			if err != nil || removed != 2 {
+				t.Fatalf("DeleteUser() = %d, %v, expected 2 records", removed, err)
+			}
+			if records, _ := store.Query(Filter{UserID: 1}); len(records) != 0 {
+				t.Errorf("Query() after DeleteUser() = %+v", records)
+			}
+			if boosts, _ := store.Boosts(1, time.Now()); len(boosts) != 0 {
+				t.Errorf("Boosts() after DeleteUser() = %+v", boosts)
+			}
+			if records, _ := store.Query(Filter{UserID: 2}); len(records) != 1 {
+				t.Errorf("DeleteUser() removed other users' records: %+v", records)
+			}
+			if data, _ := source.Export(1); data != nil {
+				t.Errorf("Export() after DeleteUser() = %+v, expected nil", data)
+			}
+		})
+	}
+
+	// The file no longer holds the user
+	reopened, err := NewFileStore(fileStore.path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	if records, _ := reopened.Query(Filter{UserID: 1}); len(records) != 0 {
+		t.Errorf("reopened store records = %+v", records)
+	}
+}
@@ -0,0 +1,270 @@
+// Package vector is a small file-backed vector index searched by cosine
+// similarity. It keeps every vector in memory and is meant for thousands of
+// items, not millions.
+package vector
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/itswryu/telegpt/pkg/encryption"
+)
+
+// ErrEncrypted is returned when an encrypted index is opened without keys
+var ErrEncrypted = errors.New("index is encrypted but no encryption keys are configured")
+
+// additionalData binds sealed index files to their purpose
+var additionalData = []byte("telegpt vector index")
+
+// Item is a vector together with the text and metadata it was computed from
+type Item struct {
+	ID     string
+	Vector []float32
+	Text   string
+	Meta   map[string]string
+	Time   time.Time
+}
+
+// Result is an item found by a search
+type Result struct {
+	Item
+	// Score is the cosine similarity to the query, from -1 to 1
+	Score float64
+}
+
+// fileContents is the layout of an index file. Items are sealed as a whole
+// when the index is encrypted.
+type fileContents struct {
+	Items  []Item
+	Sealed *encryption.Envelope
+}
+
+// Index holds items in memory and writes them to a file after every change.
+// An index without a path is only kept in memory.
+type Index struct {
+	path    string
+	keyring *encryption.Keyring
+	items   []Item
+	mutex   sync.RWMutex
+}
+
+// Open loads the index at path, creating the file on first write. With a
+// keyring the file is encrypted; unencrypted files are encrypted when they are
+// next written.
+func Open(path string, keyring *encryption.Keyring) (*Index, error) {
+	index := &Index{path: path, keyring: keyring}
+	if path == "" {
+		return index, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading index: %w", err)
+	}
+
+	var contents fileContents
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&contents); err != nil {
+		return nil, fmt.Errorf("error decoding index %s: %w", path, err)
+	}
+	if contents.Sealed != nil {
+		if keyring == nil {
+			return nil, ErrEncrypted
+		}
+		plaintext, err := keyring.Open(contents.Sealed, additionalData)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting index %s: %w", path, err)
+		}
+		if err := gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&contents.Items); err != nil {
+			return nil, fmt.Errorf("error decoding index %s: %w", path, err)
+		}
+	}
+	index.items = contents.Items
+	return index, nil
+}
+
+// Upsert adds items, replacing those with the same ID
+func (x *Index) Upsert(items ...Item) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	positions := make(map[string]int, len(x.items))
+	for i, item := range x.items {
+		positions[item.ID] = i
+	}
+	for _, item := range items {
+		item.Vector = normalize(item.Vector)
+		if i, ok := positions[item.ID]; ok {
+			x.items[i] = item
+			continue
+		}
+		positions[item.ID] = len(x.items)
+		x.items = append(x.items, item)
+	}
+	return x.flush()
+}
+
+// Delete removes the items for which match returns true and returns how many were removed
+func (x *Index) Delete(match func(Item) bool) (int, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	kept := x.items[:0]
+	for _, item := range x.items {
+		if !match(item) {
+			kept = append(kept, item)
+		}
+	}
+	removed := len(x.items) - len(kept)
+	// Clear the tail so that removed vectors can be collected
+	for i := len(kept); i < len(x.items); i++ {
+		x.items[i] = Item{}
+	}
+	x.items = kept
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, x.flush()
+}
+
+// Search returns the k items most similar to query that pass filter, best
+// first. A nil filter passes every item.
+func (x *Index) Search(query []float32, k int, filter func(Item) bool) []Result {
+	query = normalize(query)
+
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	var results []Result
+	for _, item := range x.items {
+		if filter != nil && !filter(item) {
+			continue
+		}
+		if len(item.Vector) != len(query) {
+			continue
+		}
+		results = append(results, Result{Item: item, Score: dot(query, item.Vector)})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// Items returns the items that pass filter in the order they were added
+func (x *Index) Items(filter func(Item) bool) []Item {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	var items []Item
+	for _, item := range x.items {
+		if filter == nil || filter(item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Len returns the number of items
+func (x *Index) Len() int {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	return len(x.items)
+}
+
+// Remove deletes every item together with the file
+func (x *Index) Remove() error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	x.items = nil
+	if x.path == "" {
+		return nil
+	}
+	if err := os.Remove(x.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing index: %w", err)
+	}
+	return nil
+}
+
+// flush atomically replaces the file with the current items. The caller holds the lock.
+func (x *Index) flush() error {
+	if x.path == "" {
+		return nil
+	}
+
+	contents := fileContents{Items: x.items}
+	if x.keyring != nil {
+		var plaintext bytes.Buffer
+		if err := gob.NewEncoder(&plaintext).Encode(x.items); err != nil {
+			return fmt.Errorf("error encoding index: %w", err)
+		}
+		sealed, err := x.keyring.Seal(plaintext.Bytes(), additionalData)
+		if err != nil {
+			return fmt.Errorf("error encrypting index: %w", err)
+		}
+		contents = fileContents{Sealed: sealed}
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(contents); err != nil {
+		return fmt.Errorf("error encoding index: %w", err)
+	}
+	return writeFileAtomic(x.path, data.Bytes())
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error replacing %s: %w", path, err)
+	}
+	return nil
+}
+
+// normalize returns v scaled to unit length, so that the dot product of two
+// normalized vectors is their cosine similarity
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := math.Sqrt(sum)
+	result := make([]float32, len(v))
+	for i, x := range v {
+		result[i] = float32(float64(x) / norm)
+	}
+	return result
+}
+
+// dot returns the dot product of two vectors of equal length
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -0,0 +1,107 @@
+package vector
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itswryu/telegpt/pkg/encryption"
+)
+
+func testKeyring(t *testing.T) *encryption.Keyring {
+	t.Helper()
+	ring, err := encryption.NewKeyring(map[string][]byte{"k1": bytes.Repeat([]byte{7}, 32)}, "k1")
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	return ring
+}
+
+func TestSearch(t *testing.T) {
+	index, _ := Open("", nil)
+	index.Upsert(
+		Item{ID: "a", Vector: []float32{1, 0}, Text: "a"},
+		Item{ID: "b", Vector: []float32{1, 1}, Text: "b"},
+		Item{ID: "c", Vector: []float32{0, 3}, Text: "c"},
+		Item{ID: "d", Vector: []float32{1, 0, 0}, Text: "다른 차원"},
+	)
+
+	results := index.Search([]float32{2, 0}, 2, nil)
+	if len(results) != 2 || results[0].ID != "a" || results[1].ID != "b" {
+		t.Fatalf("Search() = %+v, expected a then b", results)
+	}
+	if results[0].Score < 0.999 || results[1].Score < 0.70 || results[1].Score > 0.71 {
+		t.Errorf("Search() scores = %v, %v", results[0].Score, results[1].Score)
+	}
+
+	results = index.Search([]float32{1, 0}, 10, func(item Item) bool { return item.ID != "a" })
+	if len(results) != 2 || results[0].ID != "b" || results[1].ID != "c" {
+		t.Errorf("Search() with filter = %+v, 차원이 다른 항목과 걸러진 항목은 제외되어야 함", results)
+	}
+}
+
+func TestUpsertAndDelete(t *testing.T) {
+	index, _ := Open("", nil)
+	index.Upsert(Item{ID: "a", Vector: []float32{1, 0}, Text: "old"}, Item{ID: "b", Vector: []float32{0, 1}})
+	index.Upsert(Item{ID: "a", Vector: []float32{0, 1}, Text: "new"})
+
+	items := index.Items(nil)
+	if len(items) != 2 || items[0].ID != "a" || items[0].Text != "new" {
+		t.Fatalf("Items() = %+v, a가 제자리에서 교체되어야 함", items)
+	}
+
+	removed, err := index.Delete(func(item Item) bool { return item.ID == "a" })
+	if err != nil || removed != 1 || index.Len() != 1 {
+		t.Errorf("Delete() = %d, %v, Len() = %d", removed, err, index.Len())
+	}
+	if removed, _ := index.Delete(func(Item) bool { return false }); removed != 0 {
+		t.Errorf("Delete() of nothing = %d", removed)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "index.idx")
+	index, _ := Open(path, nil)
+	if err := index.Upsert(Item{ID: "a", Vector: []float32{3, 4}, Text: "hello", Meta: map[string]string{"k": "v"}}); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+
+	reopened, err := Open(path, nil)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	items := reopened.Items(nil)
+	if len(items) != 1 || items[0].Text != "hello" || items[0].Meta["k"] != "v" || items[0].Vector[0] != 0.6 {
+		t.Errorf("다시 연 색인 = %+v", items)
+	}
+
+	if err := reopened.Remove(); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Remove() 후 파일이 남아 있음: %v", err)
+	}
+}
+
+func TestEncryptedIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.idx")
+	ring := testKeyring(t)
+	index, _ := Open(path, ring)
+	if err := index.Upsert(Item{ID: "a", Vector: []float32{1}, Text: "secret text"}); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte("secret text")) {
+		t.Error("암호화된 색인 파일에 평문이 있음")
+	}
+	if _, err := Open(path, nil); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("Open() without keys error = %v, expected ErrEncrypted", err)
+	}
+	reopened, err := Open(path, ring)
+	if err != nil || reopened.Len() != 1 || reopened.Items(nil)[0].Text != "secret text" {
+		t.Errorf("Open() with keys = %v, %v", reopened, err)
+	}
+}